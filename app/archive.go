@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// archiveRecheckInterval is how often archived chapters are fetched again to spot edits
+	archiveRecheckInterval = 7 * 24 * time.Hour
+	// archiveFetchLimit caps how many chapter pages one archive run fetches
+	archiveFetchLimit = 100
+)
+
+// downloadArchive crawls a newly archived fiction's chapter list and archives its chapters
+func (c *crawler) downloadArchive(ctx context.Context, book Book) {
+	ctx = startCrawlRun(ctx, "archive")
+	c.crawlFictionPages(ctx, []Book{book})
+	if err := c.refreshArchives(ctx, time.Now().UTC()); err != nil {
+		loggerFrom(ctx).Error("Failed to refresh archives", "error", err)
+	}
+}
+
+// ArchivedChapterSummary lists an archived chapter without its content
+type ArchivedChapterSummary struct {
+	ChapterID   string    `json:"chapter_id"`
+	Title       string    `json:"title"`
+	PublishedAt time.Time `json:"published_at"`
+	ContentHash string    `json:"content_hash"`
+	ArchivedAt  time.Time `json:"archived_at"`
+	Revisions   int       `json:"revisions"`
+}
+
+// contentHash fingerprints a chapter body so edits can be told apart from unchanged re-fetches
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateArchivedChapter folds a freshly fetched chapter page into a user's copy. When the body
+// changed, the previous version is kept as a revision; author notes are simply refreshed
+func updateArchivedChapter(existing *ArchivedChapter, userID string, chapter Chapter, page ChapterPage, now time.Time) ArchivedChapter {
+	hash := contentHash(page.Content)
+	if existing == nil {
+		return ArchivedChapter{
+			UserID:      userID,
+			ChapterID:   chapter.ID,
+			FictionID:   chapter.FictionID,
+			Title:       chapter.Title,
+			Link:        chapter.Link,
+			PublishedAt: chapter.PublishedAt,
+			Content:     page.Content,
+			NoteBefore:  page.NoteBefore,
+			NoteAfter:   page.NoteAfter,
+			ContentHash: hash,
+			ArchivedAt:  now,
+			CheckedAt:   now,
+		}
+	}
+
+	archived := *existing
+	archived.Title = chapter.Title
+	archived.NoteBefore = page.NoteBefore
+	archived.NoteAfter = page.NoteAfter
+	archived.CheckedAt = now
+	if hash != existing.ContentHash {
+		archived.Revisions = append(append([]ChapterRevision(nil), existing.Revisions...), ChapterRevision{
+			Content:     existing.Content,
+			NoteBefore:  existing.NoteBefore,
+			NoteAfter:   existing.NoteAfter,
+			ContentHash: existing.ContentHash,
+			ArchivedAt:  existing.ArchivedAt,
+		})
+		archived.Content = page.Content
+		archived.ContentHash = hash
+		archived.ArchivedAt = now
+	}
+	return archived
+}
+
+// refreshArchives downloads the chapters of archived fictions that aren't archived yet and re-checks
+// old copies for edits, revalidating any copy in the page cache. Each chapter page is fetched once
+// however many users archived it.
+// Chapters that fail to fetch, such as stubbed ones, keep their last archived copy
+func (c *crawler) refreshArchives(ctx context.Context, now time.Time) error {
+	c.archiveMu.Lock()
+	defer c.archiveMu.Unlock()
+
+	archives, err := c.store.GetAllArchivedFictions(ctx)
+	if err != nil {
+		return err
+	}
+	var fictionIDs []string
+	archivedBy := make(map[string][]string)
+	for _, archive := range archives {
+		if archivedBy[archive.FictionID] == nil {
+			fictionIDs = append(fictionIDs, archive.FictionID)
+		}
+		archivedBy[archive.FictionID] = append(archivedBy[archive.FictionID], archive.UserID)
+	}
+
+	budget := archiveFetchLimit
+	for _, fictionID := range fictionIDs {
+		chapters, err := c.store.GetChapters(ctx, fictionID)
+		if err != nil {
+			return err
+		}
+		for _, chapter := range chapters {
+			if chapter.Link == "" {
+				continue
+			}
+
+			stale := make(map[string]*ArchivedChapter)
+			recheck := false
+			for _, userID := range archivedBy[fictionID] {
+				existing, err := c.store.GetArchivedChapter(ctx, userID, chapter.ID)
+				if err != nil {
+					return err
+				}
+				if existing == nil || now.Sub(existing.CheckedAt) >= archiveRecheckInterval {
+					stale[userID] = existing
+					recheck = recheck || existing != nil
+				}
+			}
+			if len(stale) == 0 {
+				continue
+			}
+			if budget == 0 {
+				return nil
+			}
+			budget--
+
+			fetchCtx := ctx
+			if recheck {
+				// The page cache keeps chapters longer than the recheck interval, so its copy may predate the edit
+				fetchCtx = revalidating(ctx)
+			}
+			page, err := c.fetchChapter(fetchCtx, chapter.Link)
+			if err != nil {
+				loggerFrom(ctx).Warn("Failed to archive chapter", "chapter_id", chapter.ID, "error", err)
+				continue
+			}
+			for userID, existing := range stale {
+				if err := c.store.SaveArchivedChapter(ctx, updateArchivedChapter(existing, userID, chapter, page, now)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findArchive returns the current user's archive of the fiction in the path, or nil
+func (s *server) findArchive(r *http.Request) (*ArchivedFiction, error) {
+	userID, _ := s.currentUser(r)
+	archives, err := s.store.GetArchivedFictions(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range archives {
+		if archives[i].FictionID == r.PathValue("id") {
+			return &archives[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// archivesHandler lists the archives of the current user
+func (s *server) archivesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	archives, err := s.store.GetArchivedFictions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archives: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if archives == nil {
+		archives = []ArchivedFiction{}
+	}
+	writeJSON(w, archives)
+}
+
+// addArchiveHandler archives a fiction for the current user and starts downloading it in the background
+func (s *server) addArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	fictionID := r.FormValue("fiction_id")
+	if fictionID == "" {
+		http.Error(w, "Missing fiction_id", http.StatusBadRequest)
+		return
+	}
+	if !fictionIDFormat.MatchString(fictionID) {
+		http.Error(w, "Invalid fiction_id", http.StatusBadRequest)
+		return
+	}
+
+	books, err := s.store.GetBooks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load books: %s", err), http.StatusInternalServerError)
+		return
+	}
+	book := Book{FictionID: fictionID, Link: s.crawler.fictionLink(fictionID)}
+	for _, known := range books {
+		if known.FictionID == fictionID {
+			book = known
+		}
+	}
+
+	userID, _ := s.currentUser(r)
+	archive := ArchivedFiction{UserID: userID, FictionID: fictionID, Title: book.Title, CreatedAt: time.Now().UTC()}
+	if err := s.store.SaveArchivedFiction(r.Context(), archive); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save archive: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The download outlives the request
+	go s.downloadArchive(context.WithoutCancel(r.Context()), book)
+
+	writeJSONStatus(w, http.StatusCreated, archive)
+}
+
+// deleteArchiveHandler removes the current user's archive of a fiction
+func (s *server) deleteArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	if err := s.store.DeleteArchivedFiction(r.Context(), userID, r.PathValue("id")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete archive: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadArchive returns the current user's archive of the fiction in the path with its chapters,
+// writing an error response and returning nil when it can't
+func (s *server) loadArchive(w http.ResponseWriter, r *http.Request) (*ArchivedFiction, []ArchivedChapter) {
+	archive, err := s.findArchive(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archive: %s", err), http.StatusInternalServerError)
+		return nil, nil
+	}
+	// Other users' archives don't exist as far as this user can tell
+	if archive == nil {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return nil, nil
+	}
+	chapters, err := s.store.GetArchivedChapters(r.Context(), archive.UserID, archive.FictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archived chapters: %s", err), http.StatusInternalServerError)
+		return nil, nil
+	}
+	return archive, chapters
+}
+
+func summarizeArchivedChapters(chapters []ArchivedChapter) []ArchivedChapterSummary {
+	summaries := make([]ArchivedChapterSummary, len(chapters))
+	for i, chapter := range chapters {
+		summaries[i] = ArchivedChapterSummary{
+			ChapterID:   chapter.ChapterID,
+			Title:       chapter.Title,
+			PublishedAt: chapter.PublishedAt,
+			ContentHash: chapter.ContentHash,
+			ArchivedAt:  chapter.ArchivedAt,
+			Revisions:   len(chapter.Revisions),
+		}
+	}
+	return summaries
+}
+
+// archiveAPIHandler returns an archive and the chapters in it, without their content
+func (s *server) archiveAPIHandler(w http.ResponseWriter, r *http.Request) {
+	archive, chapters := s.loadArchive(w, r)
+	if archive == nil {
+		return
+	}
+	writeJSON(w, struct {
+		Archive  ArchivedFiction          `json:"archive"`
+		Chapters []ArchivedChapterSummary `json:"chapters"`
+	}{*archive, summarizeArchivedChapters(chapters)})
+}
+
+// loadArchivedChapter returns the current user's copy of the chapter in the path,
+// writing an error response and returning nil when it can't
+func (s *server) loadArchivedChapter(w http.ResponseWriter, r *http.Request) *ArchivedChapter {
+	userID, _ := s.currentUser(r)
+	chapter, err := s.store.GetArchivedChapter(r.Context(), userID, r.PathValue("chapter"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archived chapter: %s", err), http.StatusInternalServerError)
+		return nil
+	}
+	if chapter == nil || chapter.FictionID != r.PathValue("id") {
+		http.Error(w, "Chapter not found", http.StatusNotFound)
+		return nil
+	}
+	return chapter
+}
+
+// archivedChapterAPIHandler returns an archived chapter with its content and revisions
+func (s *server) archivedChapterAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if chapter := s.loadArchivedChapter(w, r); chapter != nil {
+		writeJSON(w, chapter)
+	}
+}
+
+// archiveHandler renders the table of contents of an archive
+func (s *server) archiveHandler(w http.ResponseWriter, r *http.Request) {
+	archive, chapters := s.loadArchive(w, r)
+	if archive == nil {
+		return
+	}
+
+	tmpl, err := renderArchivePage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, struct {
+		Archive  ArchivedFiction
+		Chapters []ArchivedChapterSummary
+	}{*archive, summarizeArchivedChapters(chapters)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// archivedChapterHandler renders an archived chapter for reading offline. ?revision=N shows
+// the Nth earlier version instead of the current one
+func (s *server) archivedChapterHandler(w http.ResponseWriter, r *http.Request) {
+	chapter := s.loadArchivedChapter(w, r)
+	if chapter == nil {
+		return
+	}
+
+	version := ChapterRevision{
+		Content:     chapter.Content,
+		NoteBefore:  chapter.NoteBefore,
+		NoteAfter:   chapter.NoteAfter,
+		ContentHash: chapter.ContentHash,
+		ArchivedAt:  chapter.ArchivedAt,
+	}
+	revision := -1
+	if value := r.URL.Query().Get("revision"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n >= len(chapter.Revisions) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		revision = n
+		version = chapter.Revisions[n]
+	}
+
+	tmpl, err := renderArchivedChapterPage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Content was sanitized when it was archived
+	err = tmpl.Execute(w, struct {
+		Chapter    *ArchivedChapter
+		Revision   int
+		Version    ChapterRevision
+		Content    template.HTML
+		NoteBefore template.HTML
+		NoteAfter  template.HTML
+	}{chapter, revision, version, template.HTML(version.Content), template.HTML(version.NoteBefore), template.HTML(version.NoteAfter)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newEditableChapterServer serves one chapter whose body can be edited between requests
+func newEditableChapterServer(t *testing.T, body string) (*httptest.Server, func(string)) {
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><div class="chapter-content">` + body + `</div></body></html>`))
+	}))
+	t.Cleanup(server.Close)
+	return server, func(edited string) {
+		mu.Lock()
+		defer mu.Unlock()
+		body = edited
+	}
+}
+
+func TestUpdateArchivedChapter(t *testing.T) {
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chapter := Chapter{ID: "1", FictionID: "42", Title: "Prologue"}
+
+	archived := updateArchivedChapter(nil, "alice", chapter, ChapterPage{Content: "<p>Draft</p>", NoteAfter: "<p>Hi</p>"}, first)
+	assert.Equal(t, "alice", archived.UserID)
+	assert.Equal(t, contentHash("<p>Draft</p>"), archived.ContentHash)
+	assert.Equal(t, first, archived.ArchivedAt)
+	assert.Empty(t, archived.Revisions)
+
+	// A new author note alone is not a revision
+	second := first.Add(24 * time.Hour)
+	archived = updateArchivedChapter(&archived, "alice", chapter, ChapterPage{Content: "<p>Draft</p>", NoteAfter: "<p>Bye</p>"}, second)
+	assert.Empty(t, archived.Revisions)
+	assert.Equal(t, "<p>Bye</p>", archived.NoteAfter)
+	assert.Equal(t, second, archived.CheckedAt)
+	assert.Equal(t, first, archived.ArchivedAt)
+
+	// An edited body keeps the previous version
+	third := second.Add(24 * time.Hour)
+	archived = updateArchivedChapter(&archived, "alice", chapter, ChapterPage{Content: "<p>Final</p>"}, third)
+	require.Equal(t, 1, len(archived.Revisions))
+	assert.Equal(t, "<p>Draft</p>", archived.Revisions[0].Content)
+	assert.Equal(t, contentHash("<p>Draft</p>"), archived.Revisions[0].ContentHash)
+	assert.Equal(t, first, archived.Revisions[0].ArchivedAt)
+	assert.Equal(t, "<p>Final</p>", archived.Content)
+	assert.Equal(t, third, archived.ArchivedAt)
+}
+
+func TestRefreshArchives(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	server, editChapter := newEditableChapterServer(t, "<p>Draft</p>")
+	require.NoError(t, memory.SaveChapters(ctx, []Chapter{{ID: "1", FictionID: "42", Title: "Prologue", Link: server.URL}}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "42"}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "bob", FictionID: "42"}))
+
+	now := time.Now().UTC()
+	require.NoError(t, s.crawler.refreshArchives(ctx, now))
+	for _, userID := range []string{"alice", "bob"} {
+		archived, err := memory.GetArchivedChapter(ctx, userID, "1")
+		require.NoError(t, err)
+		require.NotNil(t, archived)
+		assert.Equal(t, "<p>Draft</p>", archived.Content)
+	}
+
+	// Fresh copies aren't fetched again until the recheck interval passes
+	editChapter("<p>Final</p>")
+	require.NoError(t, s.crawler.refreshArchives(ctx, now.Add(time.Hour)))
+	archived, err := memory.GetArchivedChapter(ctx, "alice", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Draft</p>", archived.Content)
+
+	require.NoError(t, s.crawler.refreshArchives(ctx, now.Add(archiveRecheckInterval)))
+	archived, err = memory.GetArchivedChapter(ctx, "alice", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Final</p>", archived.Content)
+	require.Equal(t, 1, len(archived.Revisions))
+
+	// A stubbed chapter keeps its archived copy
+	server.Close()
+	require.NoError(t, s.crawler.refreshArchives(ctx, now.Add(2*archiveRecheckInterval)))
+	archived, err = memory.GetArchivedChapter(ctx, "alice", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Final</p>", archived.Content)
+}
+
+func TestRefreshArchives_RevalidatesCachedChapters(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	useCache(s.crawler, newMemoryStore(), map[string]time.Duration{pageTypeOther: 30 * 24 * time.Hour})
+	server, editChapter := newEditableChapterServer(t, "<p>Draft</p>")
+	require.NoError(t, memory.SaveChapters(ctx, []Chapter{{ID: "1", FictionID: "42", Title: "Prologue", Link: server.URL}}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "42"}))
+
+	now := time.Now().UTC()
+	require.NoError(t, s.crawler.refreshArchives(ctx, now))
+	editChapter("<p>Final</p>")
+	require.NoError(t, s.crawler.refreshArchives(ctx, now.Add(archiveRecheckInterval)))
+
+	archived, err := memory.GetArchivedChapter(ctx, "alice", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Final</p>", archived.Content, "the recheck isn't answered by the cached copy")
+}
+
+func archiveMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/archives", s.requireUser(s.archivesHandler))
+	mux.HandleFunc("POST /api/archives", s.requireUser(s.addArchiveHandler))
+	mux.HandleFunc("GET /api/archives/{id}", s.requireUser(s.archiveAPIHandler))
+	mux.HandleFunc("DELETE /api/archives/{id}", s.requireUser(s.deleteArchiveHandler))
+	mux.HandleFunc("GET /api/archives/{id}/chapters/{chapter}", s.requireUser(s.archivedChapterAPIHandler))
+	mux.HandleFunc("GET /archive/{id}", s.requireUser(s.archiveHandler))
+	mux.HandleFunc("GET /archive/{id}/chapter/{chapter}", s.requireUser(s.archivedChapterHandler))
+	return mux
+}
+
+// seedArchive gives alice an archive of fiction 42 with one chapter edited once
+func seedArchive(t *testing.T, memory *memoryStore) {
+	ctx := context.Background()
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "42", Title: "Test Book"}))
+	chapter := Chapter{ID: "1", FictionID: "42", Title: "Prologue"}
+	archived := updateArchivedChapter(nil, "alice", chapter, ChapterPage{Content: "<p>Draft</p>"}, time.Now())
+	archived = updateArchivedChapter(&archived, "alice", chapter, ChapterPage{Content: "<p>Final</p>", NoteBefore: "<p>Welcome</p>"}, time.Now())
+	require.NoError(t, memory.SaveArchivedChapter(ctx, archived))
+}
+
+func TestArchiveHandlers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedArchive(t, memory)
+	mux := archiveMux(s)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/archives/42", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var archive struct {
+		Archive  ArchivedFiction          `json:"archive"`
+		Chapters []ArchivedChapterSummary `json:"chapters"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &archive))
+	require.Equal(t, 1, len(archive.Chapters))
+	assert.Equal(t, 1, archive.Chapters[0].Revisions)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/archives/42/chapters/1", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Final")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/chapter/1", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<aside class="author-note"><p>Welcome</p></aside>`)
+	assert.Contains(t, rr.Body.String(), `<article class="chapter-content"><p>Final</p></article>`)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/chapter/1?revision=0", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `<p>Draft</p>`)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/chapter/1?revision=5", "alice", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `href="/archive/42/chapter/1"`)
+}
+
+func TestArchiveHandlers_OnlyOwner(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedArchive(t, memory)
+	mux := archiveMux(s)
+
+	for _, target := range []string{"/api/archives/42", "/api/archives/42/chapters/1", "/archive/42", "/archive/42/chapter/1"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, newUserRequest(ctx, "GET", target, "bob", nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code, target)
+
+		rr = httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", target, nil).WithContext(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, target)
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/archives", "bob", nil))
+	assert.Equal(t, "[]\n", rr.Body.String())
+}
+
+func TestAddAndDeleteArchive(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveBooks(ctx, []Book{{Title: "Test Book", Link: "https://www.royalroad.com/fiction/42", FictionID: "42"}}))
+	mux := archiveMux(s)
+
+	downloaded := make(chan Book, 1)
+	s.downloadArchive = func(_ context.Context, book Book) { downloaded <- book }
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/archives", "alice", url.Values{"fiction_id": {"42"}}))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	archives, err := memory.GetArchivedFictions(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(archives))
+	assert.Equal(t, "Test Book", archives[0].Title)
+	assert.Equal(t, "42", (<-downloaded).FictionID)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "DELETE", "/api/archives/42", "alice", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	archives, err = memory.GetArchivedFictions(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, archives)
+}
+
+func TestAddArchiveHandler_Validation(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	mux := archiveMux(s)
+	s.downloadArchive = func(context.Context, Book) { t.Error("nothing is downloaded for an invalid fiction") }
+
+	for _, fictionID := range []string{"", "42/../../admin", "abc"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/archives", "alice", url.Values{"fiction_id": {fictionID}}))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, fictionID)
+	}
+	archives, err := memory.GetArchivedFictions(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, archives)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// parseUsers reads a comma separated list of "name:password" pairs
+func parseUsers(spec string) map[string]string {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		name, password, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && name != "" && password != "" {
+			parsed[name] = password
+		}
+	}
+	return parsed
+}
+
+// currentUser returns the user authenticated with HTTP basic auth, if any
+func (s *server) currentUser(r *http.Request) (string, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected, known := s.users[name]
+	if !known || subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return "", false
+	}
+	return name, true
+}
+
+// requireUser rejects requests without valid credentials and asks the browser to log in
+func (s *server) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.currentUser(r); !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="royalroadbot", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loginHandler makes the browser prompt for credentials, then returns to the main page
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testUsers are the users who can sign in during tests
+var testUsers = map[string]string{"alice": "secret", "bob": "hunter2"}
+
+func TestParseUsers(t *testing.T) {
+	parsed := parseUsers("alice:secret, bob:hunter2,broken,:nopass,carol:")
+
+	assert.Equal(t, map[string]string{"alice": "secret", "bob": "hunter2"}, parsed)
+	assert.Empty(t, parseUsers(""))
+}
+
+func TestCurrentUser(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	_, ok := s.currentUser(req)
+	assert.False(t, ok)
+
+	req.SetBasicAuth("alice", "wrong")
+	_, ok = s.currentUser(req)
+	assert.False(t, ok)
+
+	req.SetBasicAuth("alice", "secret")
+	userID, ok := s.currentUser(req)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", userID)
+}
+
+func TestRequireUser(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	handler := s.requireUser(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+
+	// Without credentials the browser is asked to log in
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Basic")
+
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	req.SetBasicAuth("bob", "hunter2")
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "hello", rr.Body.String())
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// trackAuthors links the books of a list crawl to their authors, refreshes the fiction lists
+// of followed authors and notifies their followers of new fictions and list appearances.
+// pages holds the fiction pages already crawled for the books, by fiction ID
+func (c *crawler) trackAuthors(ctx context.Context, list string, books []Book, pages map[string]FictionPage) error {
+	logger := loggerFrom(ctx)
+	authors, err := c.store.GetAuthors(ctx)
+	if err != nil {
+		return err
+	}
+	authorFollows, err := c.store.GetAllAuthorFollows(ctx)
+	if err != nil {
+		return err
+	}
+	followers := make(map[string][]string)
+	for _, follow := range authorFollows {
+		followers[follow.AuthorID] = append(followers[follow.AuthorID], follow.UserID)
+	}
+
+	now := time.Now().UTC()
+	var notifications []Notification
+	refreshed := make(map[string]bool)
+
+	// Discover the authors of listed books we haven't seen before
+	for _, book := range books {
+		if book.FictionID == "" || authorOfFiction(authors, book.FictionID) != nil {
+			continue
+		}
+		page, ok := pages[book.FictionID]
+		if !ok || page.Author.ID == "" {
+			continue
+		}
+		author := page.Author
+		if known := findAuthor(authors, author.ID); known != nil {
+			author = *known
+		}
+		fictions, err := c.fetchAuthorFictions(ctx, author.Link)
+		if err != nil {
+			logger.Warn("Failed to fetch author fictions", "author_id", author.ID, "error", err)
+			fictions = author.Fictions
+		}
+		author.Fictions = withFiction(fictions, AuthorFiction{FictionID: book.FictionID, Title: book.Title, Link: book.Link})
+		author.UpdatedAt = now
+		if err := c.store.SaveAuthor(ctx, author); err != nil {
+			return err
+		}
+		authors = replaceAuthor(authors, author)
+		refreshed[author.ID] = true
+	}
+
+	// Refresh followed authors so we spot fictions they just started
+	for authorID, userIDs := range followers {
+		known := findAuthor(authors, authorID)
+		if known == nil || refreshed[authorID] {
+			continue
+		}
+		author := *known
+		fictions, err := c.fetchAuthorFictions(ctx, author.Link)
+		if err != nil {
+			logger.Warn("Failed to fetch author fictions", "author_id", author.ID, "error", err)
+			continue
+		}
+		for _, fiction := range newFictions(author.Fictions, fictions) {
+			notifications = append(notifications, notifyUsers(userIDs,
+				fmt.Sprintf("%s started a new fiction: %s", author.Name, fiction.Title), fiction.Link, now)...)
+		}
+		author.Fictions = fictions
+		author.UpdatedAt = now
+		if err := c.store.SaveAuthor(ctx, author); err != nil {
+			return err
+		}
+		authors = replaceAuthor(authors, author)
+	}
+
+	// Tell followers when one of their authors' fictions shows up on the list for the first time
+	for _, book := range books {
+		author := authorOfFiction(authors, book.FictionID)
+		if author == nil || len(followers[author.ID]) == 0 {
+			continue
+		}
+		snapshots, err := c.store.GetSnapshots(ctx, book.FictionID)
+		if err != nil {
+			return err
+		}
+		var onList []Snapshot
+		for _, snapshot := range snapshots {
+			if snapshot.List == list {
+				onList = append(onList, snapshot)
+			}
+		}
+		if len(onList) != 1 {
+			continue
+		}
+		notifications = append(notifications, notifyUsers(followers[author.ID],
+			fmt.Sprintf("%s by %s appeared on %s at #%d", book.Title, author.Name, list, onList[0].Rank),
+			"/fiction/"+book.FictionID, now)...)
+	}
+
+	return c.store.AddNotifications(ctx, notifications)
+}
+
+func findAuthor(authors []Author, id string) *Author {
+	for i := range authors {
+		if authors[i].ID == id {
+			return &authors[i]
+		}
+	}
+	return nil
+}
+
+func replaceAuthor(authors []Author, author Author) []Author {
+	for i := range authors {
+		if authors[i].ID == author.ID {
+			authors[i] = author
+			return authors
+		}
+	}
+	return append(authors, author)
+}
+
+// withFiction makes sure the fiction is on the list, which can lag behind on profile pages
+func withFiction(fictions []AuthorFiction, fiction AuthorFiction) []AuthorFiction {
+	for _, existing := range fictions {
+		if existing.FictionID == fiction.FictionID {
+			return fictions
+		}
+	}
+	return append(fictions, fiction)
+}
+
+// newFictions returns the fictions in current that were not in previous
+func newFictions(previous, current []AuthorFiction) []AuthorFiction {
+	known := make(map[string]bool, len(previous))
+	for _, fiction := range previous {
+		known[fiction.FictionID] = true
+	}
+	var added []AuthorFiction
+	for _, fiction := range current {
+		if !known[fiction.FictionID] {
+			added = append(added, fiction)
+		}
+	}
+	return added
+}
+
+// authorAPIHandler returns an author and their fictions as JSON
+func (s *server) authorAPIHandler(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.GetAuthor(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load author: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if author == nil {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, author)
+}
+
+// authorHandler renders an author page with their fictions
+func (s *server) authorHandler(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.GetAuthor(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load author: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if author == nil {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := renderAuthorPage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, author)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// followAuthorHandler follows an author for the current user
+func (s *server) followAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	author, err := s.store.GetAuthor(r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load author: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if author == nil {
+		http.Error(w, "Author not found", http.StatusNotFound)
+		return
+	}
+
+	userID, _ := s.currentUser(r)
+	follow := AuthorFollow{UserID: userID, AuthorID: author.ID, CreatedAt: time.Now().UTC()}
+	if err := s.store.SaveAuthorFollow(r.Context(), follow); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save follow: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONStatus(w, http.StatusCreated, follow)
+}
+
+// unfollowAuthorHandler stops following an author for the current user
+func (s *server) unfollowAuthorHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	if err := s.store.DeleteAuthorFollow(r.Context(), userID, r.PathValue("id")); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete follow: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
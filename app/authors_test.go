@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackAuthors_DiscoversAuthors(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+	books := []Book{{Title: "Test Book", Link: server.URL + "/fiction/42/test-book", FictionID: "42"}}
+
+	require.NoError(t, s.crawler.trackAuthors(ctx, "popular", books, s.crawler.crawlFictionPages(ctx, books)))
+
+	author, err := memory.GetAuthor(ctx, "7")
+	require.NoError(t, err)
+	require.NotNil(t, author)
+	assert.Equal(t, "Test Author", author.Name)
+	assert.Equal(t, 2, len(author.Fictions))
+
+	// Nobody follows the author yet, so nobody is notified
+	assert.Empty(t, memory.notifications)
+}
+
+func TestTrackAuthors_NotifiesFollowers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions+`
+		<div class="fiction-list-item">
+			<h2 class="fiction-title"><a href="/fiction/44/brand-new">Brand New</a></h2>
+		</div>
+	`)
+
+	// The author was known with two fictions before this crawl and alice follows them
+	require.NoError(t, memory.SaveAuthor(ctx, Author{
+		ID:   "7",
+		Name: "Test Author",
+		Link: server.URL + "/profile/7",
+		Fictions: []AuthorFiction{
+			{FictionID: "42", Title: "Test Book"},
+			{FictionID: "43", Title: "Older Book"},
+		},
+	}))
+	require.NoError(t, memory.SaveAuthorFollow(ctx, AuthorFollow{UserID: "alice", AuthorID: "7"}))
+
+	// This crawl is the first time the fiction appears on the list
+	require.NoError(t, memory.SaveSnapshots(ctx, []Snapshot{{FictionID: "42", List: "popular", Rank: 3, CrawledAt: time.Now()}}))
+	books := []Book{{Title: "Test Book", Link: server.URL + "/fiction/42/test-book", FictionID: "42"}}
+
+	require.NoError(t, s.crawler.trackAuthors(ctx, "popular", books, s.crawler.crawlFictionPages(ctx, books)))
+
+	notifications, err := memory.GetNotifications(ctx, "alice")
+	require.NoError(t, err)
+	messages := make([]string, len(notifications))
+	for i, notification := range notifications {
+		messages[i] = notification.Message
+	}
+	assert.ElementsMatch(t, []string{
+		"Test Author started a new fiction: Brand New",
+		"Test Book by Test Author appeared on popular at #3",
+	}, messages)
+
+	author, err := memory.GetAuthor(ctx, "7")
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(author.Fictions))
+
+	// A second crawl has nothing new to report
+	require.NoError(t, memory.SaveSnapshots(ctx, []Snapshot{{FictionID: "42", List: "popular", Rank: 2, CrawledAt: time.Now()}}))
+	require.NoError(t, s.crawler.trackAuthors(ctx, "popular", books, s.crawler.crawlFictionPages(ctx, books)))
+	notifications, err = memory.GetNotifications(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(notifications))
+}
+
+func authorsMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /author/{id}", s.authorHandler)
+	mux.HandleFunc("GET /api/authors/{id}", s.authorAPIHandler)
+	mux.HandleFunc("POST /api/authors/{id}/follow", s.requireUser(s.followAuthorHandler))
+	mux.HandleFunc("DELETE /api/authors/{id}/follow", s.requireUser(s.unfollowAuthorHandler))
+	mux.HandleFunc("GET /api/notifications", s.requireUser(s.notificationsHandler))
+	return mux
+}
+
+func TestAuthorHandlers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveAuthor(ctx, Author{
+		ID:       "7",
+		Name:     "Test Author",
+		Link:     "https://www.royalroad.com/profile/7",
+		Fictions: []AuthorFiction{{FictionID: "42", Title: "Test Book", Link: "https://www.royalroad.com/fiction/42"}},
+	}))
+	mux := authorsMux(s)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/author/7", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "<h1>Test Author</h1>")
+	assert.Contains(t, rr.Body.String(), "Test Book")
+	assert.Contains(t, rr.Body.String(), "hx-post=\"/api/authors/7/follow\"")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/authors/7", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var author Author
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &author))
+	assert.Equal(t, "Test Author", author.Name)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/author/8", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestFollowAuthorHandlers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveAuthor(ctx, Author{ID: "7", Name: "Test Author"}))
+	mux := authorsMux(s)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/authors/7/follow", "alice", nil))
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/authors/8/follow", "alice", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	follows, err := memory.GetAllAuthorFollows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(follows))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "DELETE", "/api/authors/7/follow", "alice", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	follows, err = memory.GetAllAuthorFollows(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, follows)
+}
+
+func TestNotificationsHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	now := time.Now()
+	require.NoError(t, memory.AddNotifications(ctx, notifyUsers([]string{"alice", "bob"}, "Old news", "/", now.Add(-time.Hour))))
+	require.NoError(t, memory.AddNotifications(ctx, notifyUsers([]string{"alice"}, "Fresh news", "/", now)))
+	mux := authorsMux(s)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/notifications", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var notifications []Notification
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &notifications))
+	require.Equal(t, 2, len(notifications))
+	assert.Equal(t, "Fresh news", notifications[0].Message)
+	assert.Equal(t, "Old news", notifications[1].Message)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// challengeScanLimit is how much of a page is searched for challenge markers
+const challengeScanLimit = 64 << 10
+
+// challengeMarkers are found on anti-bot challenge and interstitial pages, and not on the pages
+// RoyalRoad serves to readers. They are matched against the lower-cased page
+var challengeMarkers = []string{
+	"<title>just a moment...</title>",
+	"<title>attention required! | cloudflare</title>",
+	"cf_chl_opt",
+	"cf-browser-verification",
+	"enable javascript and cookies to continue",
+	"checking your browser before accessing",
+}
+
+// BlockedError is returned for a page RoyalRoad didn't serve because it took the crawler for a
+// bot, such as a Cloudflare challenge. It is neither a missing page nor an empty list: the crawl
+// stops and the host is paused until Until
+type BlockedError struct {
+	URL        string
+	Host       string
+	StatusCode int
+	Reason     string
+	Until      time.Time
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("blocked by %s: %s, paused until %s", e.Host, e.Reason, e.Until.Format(time.RFC3339))
+}
+
+// challengeReason tells a challenge or interstitial served instead of the page at u by its
+// headers, its markup or, for RoyalRoad's pages, a content type other than HTML. It returns ""
+// for a real page, whatever its status
+func challengeReason(u *url.URL, resp *http.Response, body []byte) string {
+	if resp.Header.Get("Cf-Mitigated") == "challenge" {
+		return "challenge announced by the cf-mitigated header"
+	}
+	page := bytes.ToLower(body[:min(len(body), challengeScanLimit)])
+	for _, marker := range challengeMarkers {
+		if bytes.Contains(page, []byte(marker)) {
+			return fmt.Sprintf("challenge page marked %q", marker)
+		}
+	}
+	fromCloudflare := resp.Header.Get("Server") == "cloudflare" || resp.Header.Get("Cf-Ray") != ""
+	if fromCloudflare && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusServiceUnavailable) {
+		return fmt.Sprintf("HTTP %d from Cloudflare", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusOK && pageTypeOf(u) != pageTypeOther {
+		contentType := resp.Header.Get("Content-Type")
+		if mediaType, _, err := mime.ParseMediaType(contentType); contentType != "" && (err != nil || mediaType != "text/html") {
+			return fmt.Sprintf("unexpected content type %q", contentType)
+		}
+	}
+	return ""
+}
+
+// blockTransport turns challenges into a BlockedError that pauses their host, and refuses
+// requests to a paused host without sending them. It sits right above the network, below the
+// page cache and the fixtures, so only pages the site actually served can block or unblock a host
+type blockTransport struct {
+	blocks *blockRegistry
+	next   http.RoundTripper
+}
+
+func (t blockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if block, ok := t.blocks.paused(host, time.Now()); ok {
+		return nil, block.err(req.URL.String())
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if reason := challengeReason(req.URL, resp, body); reason != "" {
+		block := t.blocks.block(req.Context(), host, req.URL.String(), resp.StatusCode, reason, time.Now())
+		return nil, block.err(req.URL.String())
+	}
+	t.blocks.clear(req.Context(), host)
+	return resp, nil
+}
+
+// HostBlock is a host that answered with a challenge, paused until Until. Strikes counts the
+// challenges in a row, each doubling the pause
+type HostBlock struct {
+	Host       string    `json:"host"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code"`
+	Reason     string    `json:"reason"`
+	BlockedAt  time.Time `json:"blocked_at"`
+	Until      time.Time `json:"until"`
+	Strikes    int       `json:"strikes"`
+}
+
+func (b HostBlock) err(link string) *BlockedError {
+	return &BlockedError{URL: link, Host: b.Host, StatusCode: b.StatusCode, Reason: b.Reason, Until: b.Until}
+}
+
+// blockRegistry keeps the hosts that challenged the crawler since startup
+type blockRegistry struct {
+	// backoff is how long a host is paused after its first challenge; each challenge in a row
+	// doubles the pause up to backoffMax
+	backoff, backoffMax time.Duration
+	// alertWebhook is where alerts are posted, none when empty
+	alertWebhook string
+
+	mu    sync.RWMutex
+	hosts map[string]HostBlock
+}
+
+// newBlockRegistry returns a registry with no blocked host, pausing hosts and raising alerts as
+// the crawl settings ask
+func newBlockRegistry(cfg CrawlConfig) *blockRegistry {
+	return &blockRegistry{
+		backoff:      time.Duration(cfg.BlockBackoff),
+		backoffMax:   time.Duration(cfg.BlockBackoffMax),
+		alertWebhook: cfg.AlertWebhook,
+		hosts:        make(map[string]HostBlock),
+	}
+}
+
+// block pauses host after a challenge, for longer with every challenge in a row. The first
+// challenge raises an alert
+func (r *blockRegistry) block(ctx context.Context, host, link string, statusCode int, reason string, now time.Time) HostBlock {
+	r.mu.Lock()
+	block, blocked := r.hosts[host]
+	if !blocked {
+		block = HostBlock{Host: host, BlockedAt: now.UTC()}
+	}
+	block.URL, block.StatusCode, block.Reason = link, statusCode, reason
+	block.Strikes++
+	backoff := r.backoff
+	for i := 1; i < block.Strikes && backoff < r.backoffMax; i++ {
+		backoff *= 2
+	}
+	block.Until = now.Add(min(backoff, r.backoffMax)).UTC()
+	r.hosts[host] = block
+	crawlBlockedHosts.Set(float64(len(r.hosts)))
+	r.mu.Unlock()
+
+	crawlBlocks.WithLabelValues(host).Inc()
+	loggerFrom(ctx).Error("Crawler blocked", "host", host, "url", link, "status", statusCode, "reason", reason,
+		"strikes", block.Strikes, "until", block.Until)
+	if !blocked {
+		raiseAlert(ctx, r.alertWebhook, fmt.Sprintf("RoyalRoad crawler blocked by %s: %s. Crawling it is paused until %s",
+			host, reason, block.Until.Format(time.RFC3339)))
+	}
+	return block
+}
+
+// paused returns the block of host while it lasts
+func (r *blockRegistry) paused(host string, now time.Time) (HostBlock, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	block, ok := r.hosts[host]
+	return block, ok && now.Before(block.Until)
+}
+
+// clear forgets the block of a host that served a real page again
+func (r *blockRegistry) clear(ctx context.Context, host string) {
+	r.mu.Lock()
+	block, blocked := r.hosts[host]
+	delete(r.hosts, host)
+	crawlBlockedHosts.Set(float64(len(r.hosts)))
+	r.mu.Unlock()
+	if blocked {
+		loggerFrom(ctx).Info("Crawler unblocked", "host", host, "strikes", block.Strikes)
+		raiseAlert(ctx, r.alertWebhook, fmt.Sprintf("RoyalRoad crawler unblocked by %s after %d challenges", host, block.Strikes))
+	}
+}
+
+// list returns the blocked hosts by name
+func (r *blockRegistry) list() []HostBlock {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	blocks := make([]HostBlock, 0, len(r.hosts))
+	for _, block := range r.hosts {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Host < blocks[j].Host
+	})
+	return blocks
+}
+
+// crawlBlocksHandler returns the hosts that challenged the crawler and until when they are paused
+func (s *server) crawlBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.crawler.blocks.list())
+}
+
+// Alert is the JSON posted to the alert webhook. Text makes it readable by Slack-style webhooks
+type Alert struct {
+	Text     string    `json:"text"`
+	RaisedAt time.Time `json:"raised_at"`
+}
+
+// alertTimeout bounds posting an alert
+const alertTimeout = 10 * time.Second
+
+// raiseAlert logs message as an error and posts it to webhook in the background, if there is one
+func raiseAlert(ctx context.Context, webhook, message string) {
+	logger := loggerFrom(ctx)
+	logger.Error("Alert", "message", message)
+	if webhook == "" {
+		return
+	}
+	body, err := json.Marshal(Alert{Text: message, RaisedAt: time.Now().UTC()})
+	if err != nil {
+		logger.Error("Failed to encode alert", "error", err)
+		return
+	}
+	go postAlert(logger, webhook, body)
+}
+
+func postAlert(logger *slog.Logger, webhook string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		logger.Error("Failed to post alert", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Error("Failed to post alert", "error", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Error("Failed to post alert", "status", resp.StatusCode)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useHostBlocks sets the backoff of c and returns its blocked hosts
+func useHostBlocks(c *crawler, backoff, backoffMax time.Duration) *blockRegistry {
+	blocks := c.blocks
+	blocks.backoff, blocks.backoffMax = backoff, backoffMax
+	return blocks
+}
+
+// challengeServer answers with a Cloudflare challenge while challenging is true, and with a list
+// of one book otherwise
+func challengeServer(t *testing.T, challenging *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if challenging.Load() {
+			w.Header().Set("Server", "cloudflare")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, fakeChallengePage)
+			return
+		}
+		w.Write([]byte(`<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/1/first">First</a></h2></div>`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestChallengeReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		status  int
+		headers map[string]string
+		body    string
+		want    string
+	}{
+		{
+			name: "real list page",
+			link: "https://www.royalroad.com/fictions/active-popular", status: 200,
+			headers: map[string]string{"Content-Type": "text/html; charset=utf-8", "Server": "cloudflare"},
+			body:    `<div class="fiction-list-item"></div>`,
+		},
+		{
+			name: "missing page",
+			link: "https://www.royalroad.com/fiction/42/gone", status: 404,
+			headers: map[string]string{"Content-Type": "text/html", "Server": "cloudflare"},
+		},
+		{
+			name: "challenge header",
+			link: "https://www.royalroad.com/fictions/active-popular", status: 200,
+			headers: map[string]string{"Cf-Mitigated": "challenge"},
+			want:    "cf-mitigated",
+		},
+		{
+			name: "challenge served with 200",
+			link: "https://www.royalroad.com/fictions/active-popular", status: 200,
+			headers: map[string]string{"Content-Type": "text/html"},
+			body:    "<html><head><TITLE>Just a moment...</TITLE></head></html>",
+			want:    "just a moment",
+		},
+		{
+			name: "Cloudflare 503",
+			link: "https://www.royalroad.com/fiction/42/book", status: 503,
+			headers: map[string]string{"Cf-Ray": "8a1b2c3d4e5f-AMS"},
+			want:    "HTTP 503 from Cloudflare",
+		},
+		{
+			name: "JSON instead of a list",
+			link: "https://www.royalroad.com/fictions/active-popular", status: 200,
+			headers: map[string]string{"Content-Type": "application/json"},
+			body:    `{"error": "rate limited"}`,
+			want:    "unexpected content type",
+		},
+		{
+			name: "image outside RoyalRoad's pages",
+			link: "https://www.royalroad.com/favicon.ico", status: 200,
+			headers: map[string]string{"Content-Type": "image/x-icon"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.link)
+			require.NoError(t, err)
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			for key, value := range tt.headers {
+				resp.Header.Set(key, value)
+			}
+			reason := challengeReason(u, resp, []byte(tt.body))
+			if tt.want == "" {
+				assert.Empty(t, reason)
+			} else {
+				assert.Contains(t, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchBooks_Blocked(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	useHostBlocks(s.crawler, time.Hour, 4*time.Hour)
+	var challenging atomic.Bool
+	server := challengeServer(t, &challenging)
+	_, err := s.crawler.fetchBooks(ctx, "blocked-test", server.URL+"/fictions/active-popular", 10)
+	require.NoError(t, err)
+	blocks := testutil.ToFloat64(crawlBlocks.WithLabelValues(server.Listener.Addr().String()))
+
+	challenging.Store(true)
+	books, err := s.crawler.fetchBooks(ctx, "blocked-test", server.URL+"/fictions/active-popular", 10)
+
+	var blocked *BlockedError
+	require.True(t, errors.As(err, &blocked), "a challenge is not an empty list: %v", err)
+	assert.Nil(t, books)
+	assert.Equal(t, http.StatusForbidden, blocked.StatusCode)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), blocked.Until, time.Minute)
+	saved, err := memory.GetBooks(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(saved), "the books saved before are kept")
+	assert.Equal(t, blocks+1, testutil.ToFloat64(crawlBlocks.WithLabelValues(server.Listener.Addr().String())))
+	for _, run := range s.crawler.runs.list() {
+		if run.List == "blocked-test" {
+			assert.Contains(t, run.Error, "blocked by")
+		}
+	}
+	for _, status := range s.crawler.statuses.list() {
+		if status.URL == server.URL+"/fictions/active-popular" {
+			assert.Equal(t, http.StatusForbidden, status.StatusCode)
+		}
+	}
+}
+
+func TestBlockTransport_PausesHost(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	blocks := useHostBlocks(s.crawler, 50*time.Millisecond, 80*time.Millisecond)
+	var requests atomic.Int32
+	var challenging atomic.Bool
+	challenging.Store(true)
+	server := challengeServer(t, &challenging)
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counting.Close()
+	host := counting.Listener.Addr().String()
+
+	_, err := s.crawler.scrapeBooks(ctx, "pause-test", counting.URL, 10)
+	require.Error(t, err)
+	_, err = s.crawler.scrapeBooks(ctx, "pause-test", counting.URL, 10)
+	assert.ErrorContains(t, err, "paused until")
+	assert.Equal(t, int32(1), requests.Load(), "a paused host isn't asked again")
+
+	// Each challenge in a row doubles the pause, up to the maximum
+	time.Sleep(60 * time.Millisecond)
+	_, err = s.crawler.scrapeBooks(ctx, "pause-test", counting.URL, 10)
+	require.Error(t, err)
+	block, paused := blocks.paused(host, time.Now())
+	require.True(t, paused)
+	assert.Equal(t, 2, block.Strikes)
+	assert.Equal(t, 80*time.Millisecond, blocks.block(ctx, host, counting.URL, 403, "test", block.BlockedAt).Until.Sub(block.BlockedAt))
+
+	// A real page clears the block
+	challenging.Store(false)
+	time.Sleep(90 * time.Millisecond)
+	books, err := s.crawler.scrapeBooks(ctx, "pause-test", counting.URL, 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+	assert.Empty(t, blocks.list())
+}
+
+func TestBlockTransport_CacheHitsKeepTheBlock(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	blocks := useHostBlocks(s.crawler, time.Hour, time.Hour)
+	var challenging atomic.Bool
+	server := challengeServer(t, &challenging)
+	useCache(s.crawler, newMemoryStore(), map[string]time.Duration{pageTypeList: time.Hour})
+	link := server.URL + "/fictions/active-popular"
+	host := server.Listener.Addr().String()
+	_, err := s.crawler.scrapeBooks(ctx, "cache-block-test", link, 10)
+	require.NoError(t, err)
+
+	blocks.block(ctx, host, link, http.StatusForbidden, "HTTP 403 from Cloudflare", time.Now())
+	books, err := s.crawler.scrapeBooks(ctx, "cache-block-test", link, 10)
+
+	require.NoError(t, err, "fresh pages are still served from the cache")
+	assert.Equal(t, 1, len(books))
+	block, paused := blocks.paused(host, time.Now())
+	assert.True(t, paused, "a cache hit says nothing about the site")
+	assert.Equal(t, 1, block.Strikes)
+}
+
+func TestCacheTransport_SkipsChallenges(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	useHostBlocks(s.crawler, time.Hour, time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, fakeChallengePage)
+	}))
+	defer server.Close()
+	memory := newMemoryStore()
+	useCache(s.crawler, memory, map[string]time.Duration{pageTypeList: time.Hour})
+
+	_, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL+"/fictions/active-popular", 10)
+
+	assert.True(t, errors.As(err, new(*BlockedError)))
+	cached, err := memory.GetCachedPage(ctx, server.URL+"/fictions/active-popular")
+	require.NoError(t, err)
+	assert.Nil(t, cached, "a challenge isn't cached as the page")
+}
+
+func TestRaiseAlert_Webhook(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	blocks := useHostBlocks(s.crawler, time.Hour, time.Hour)
+	alerts := make(chan Alert, 2)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert Alert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err == nil {
+			alerts <- alert
+		}
+	}))
+	defer webhook.Close()
+	blocks.alertWebhook = webhook.URL
+
+	blocks.block(ctx, "www.royalroad.com", "https://www.royalroad.com/fictions/active-popular", 403, "HTTP 403 from Cloudflare", time.Now())
+	blocks.block(ctx, "www.royalroad.com", "https://www.royalroad.com/fictions/active-popular", 403, "HTTP 403 from Cloudflare", time.Now())
+	blocks.clear(ctx, "www.royalroad.com")
+
+	var texts []string
+	for range 2 {
+		select {
+		case alert := <-alerts:
+			texts = append(texts, alert.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d alerts were posted", len(texts))
+		}
+	}
+	assert.Contains(t, texts, "RoyalRoad crawler unblocked by www.royalroad.com after 2 challenges")
+	assert.Contains(t, fmt.Sprint(texts), "blocked by www.royalroad.com: HTTP 403 from Cloudflare")
+	select {
+	case alert := <-alerts:
+		t.Errorf("only the first challenge in a row raises an alert, got %q", alert.Text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCrawlFictionPages_Blocked(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	blocks := useHostBlocks(s.crawler, time.Hour, time.Hour)
+	useCrawlWorkers(s.crawler, 1, 1)
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 6, Fictions: 5, PageSize: 20, Layout: "current", ChallengeAfter: 1})
+	ctx = startCrawlRun(ctx, "blocked-detail-test")
+
+	pages := s.crawler.crawlFictionPages(ctx, fakeBooks(cfg.BaseURL, site))
+
+	assert.LessOrEqual(t, len(pages), 1)
+	assert.LessOrEqual(t, site.requests.Load(), int64(3), "the crawl stops once the site blocks it")
+	require.Equal(t, 1, len(blocks.list()))
+	for _, run := range s.crawler.progress.list() {
+		if run.List == "blocked-detail-test" {
+			assert.True(t, run.Cancelled)
+			assert.GreaterOrEqual(t, run.FictionsFailed+run.ChaptersFailed, 1)
+		}
+	}
+}
+
+func TestCrawlBlocksHandler(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	blocks := useHostBlocks(s.crawler, time.Hour, time.Hour)
+	blocks.block(ctx, "www.royalroad.com", "https://www.royalroad.com/fictions/active-popular", 403, "HTTP 403 from Cloudflare", time.Now())
+
+	rr := httptest.NewRecorder()
+	s.crawlBlocksHandler(rr, httptest.NewRequest("GET", "/api/crawl/blocks", nil).WithContext(ctx))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"host":"www.royalroad.com"`)
+	assert.Contains(t, rr.Body.String(), `"reason":"HTTP 403 from Cloudflare"`)
+	assert.Contains(t, rr.Body.String(), `"strikes":1`)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// hiatusFactor makes a gap this many times the usual interval a hiatus
+	hiatusFactor = 3
+	// hiatusMinimum is the shortest gap counted as a hiatus, however frequent the releases
+	hiatusMinimum = 14 * 24 * time.Hour
+	// cadenceRecentIntervals is how many recent intervals the prediction is based on
+	cadenceRecentIntervals = 20
+)
+
+// Hiatus is a gap between two chapters much longer than the usual schedule
+type Hiatus struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Days  float64   `json:"days"`
+}
+
+// ReleaseWindow is when the next chapter is expected
+type ReleaseWindow struct {
+	Earliest time.Time `json:"earliest"`
+	Expected time.Time `json:"expected"`
+	Latest   time.Time `json:"latest"`
+}
+
+// Cadence describes how regularly a fiction releases chapters
+type Cadence struct {
+	FictionID            string    `json:"fiction_id"`
+	Chapters             int       `json:"chapters"`
+	FirstRelease         time.Time `json:"first_release"`
+	LastRelease          time.Time `json:"last_release"`
+	AverageIntervalHours float64   `json:"average_interval_hours"`
+	MedianIntervalHours  float64   `json:"median_interval_hours"`
+	// Weekdays counts releases per weekday, Sunday first, and Hours per hour of the day, both in UTC
+	Weekdays []int `json:"weekdays"`
+	Hours    []int `json:"hours"`
+	// Streaks count consecutive weeks with at least one release
+	CurrentStreak int            `json:"current_streak"`
+	LongestStreak int            `json:"longest_streak"`
+	Hiatuses      []Hiatus       `json:"hiatuses"`
+	OnHiatus      bool           `json:"on_hiatus"`
+	NextRelease   *ReleaseWindow `json:"next_release,omitempty"`
+	Overdue       bool           `json:"overdue"`
+	OverdueHours  float64        `json:"overdue_hours,omitempty"`
+	// Status is a one-line summary such as "Overdue by 3 days", ready for notifications
+	Status string `json:"status"`
+}
+
+// buildCadence analyses the release times of a fiction's chapters as of now
+func buildCadence(fictionID string, chapters []Chapter, now time.Time) Cadence {
+	var releases []time.Time
+	for _, chapter := range chapters {
+		if !chapter.PublishedAt.IsZero() {
+			releases = append(releases, chapter.PublishedAt)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Before(releases[j]) })
+
+	cadence := Cadence{
+		FictionID: fictionID,
+		Chapters:  len(releases),
+		Weekdays:  make([]int, 7),
+		Hours:     make([]int, 24),
+		Hiatuses:  []Hiatus{},
+	}
+	if len(releases) == 0 {
+		cadence.Status = "No chapters yet"
+		return cadence
+	}
+	cadence.FirstRelease = releases[0]
+	cadence.LastRelease = releases[len(releases)-1]
+	for _, release := range releases {
+		cadence.Weekdays[release.UTC().Weekday()]++
+		cadence.Hours[release.UTC().Hour()]++
+	}
+	cadence.CurrentStreak, cadence.LongestStreak = weeklyStreaks(releases, now)
+
+	if len(releases) < 3 {
+		cadence.Status = "Not enough chapters to predict the next release"
+		return cadence
+	}
+
+	var intervals []time.Duration
+	for i := 1; i < len(releases); i++ {
+		intervals = append(intervals, releases[i].Sub(releases[i-1]))
+	}
+
+	// Gaps far beyond the usual interval are hiatuses and would skew the schedule
+	threshold := time.Duration(hiatusFactor) * quantile(intervals, 0.5)
+	if threshold < hiatusMinimum {
+		threshold = hiatusMinimum
+	}
+	var regular []time.Duration
+	for i, interval := range intervals {
+		if interval > threshold {
+			cadence.Hiatuses = append(cadence.Hiatuses, Hiatus{
+				Start: releases[i],
+				End:   releases[i+1],
+				Days:  round2(interval.Hours() / 24),
+			})
+			continue
+		}
+		regular = append(regular, interval)
+	}
+	if len(regular) == 0 {
+		regular = intervals
+	}
+
+	var total time.Duration
+	for _, interval := range regular {
+		total += interval
+	}
+	cadence.AverageIntervalHours = round2(total.Hours() / float64(len(regular)))
+
+	// The prediction follows the recent schedule rather than the whole history
+	if len(regular) > cadenceRecentIntervals {
+		regular = regular[len(regular)-cadenceRecentIntervals:]
+	}
+	median := quantile(regular, 0.5)
+	cadence.MedianIntervalHours = round2(median.Hours())
+
+	since := now.Sub(cadence.LastRelease)
+	if since > threshold {
+		cadence.OnHiatus = true
+		cadence.Status = fmt.Sprintf("On hiatus, no chapter for %s", formatDays(since))
+		return cadence
+	}
+
+	earliest := quantile(regular, 0.25)
+	if limit := time.Duration(float64(median) * 0.8); earliest > limit {
+		earliest = limit
+	}
+	latest := quantile(regular, 0.75)
+	if limit := time.Duration(float64(median) * 1.2); latest < limit {
+		latest = limit
+	}
+	window := &ReleaseWindow{
+		Earliest: cadence.LastRelease.Add(earliest),
+		Expected: cadence.LastRelease.Add(median),
+		Latest:   cadence.LastRelease.Add(latest),
+	}
+	cadence.NextRelease = window
+
+	if now.After(window.Latest) {
+		overdue := now.Sub(window.Latest)
+		cadence.Overdue = true
+		cadence.OverdueHours = round2(overdue.Hours())
+		cadence.Status = fmt.Sprintf("Overdue by %s", formatDays(overdue))
+		return cadence
+	}
+	cadence.Status = fmt.Sprintf("Next chapter expected %s – %s",
+		window.Earliest.Format("Mon Jan 2 15:04"), window.Latest.Format("Mon Jan 2 15:04 MST"))
+	return cadence
+}
+
+// quantile returns the q-th quantile of the durations by nearest rank
+func quantile(durations []time.Duration, q float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(math.Round(q*float64(len(sorted)-1)))]
+}
+
+// weeklyStreaks returns the run of consecutive weeks with a release that reaches the current or
+// previous week, and the longest such run
+func weeklyStreaks(releases []time.Time, now time.Time) (current, longest int) {
+	weeks := make(map[int64]bool)
+	for _, release := range releases {
+		weeks[weekNumber(release)] = true
+	}
+	for week := range weeks {
+		if weeks[week-1] {
+			continue
+		}
+		run := 0
+		for weeks[week+int64(run)] {
+			run++
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+
+	// The current week still counts as on schedule until it's over
+	week := weekNumber(now)
+	if !weeks[week] {
+		week--
+	}
+	for weeks[week] {
+		current++
+		week--
+	}
+	return current, longest
+}
+
+// weekNumber counts the weeks since the Unix epoch, starting on Mondays
+func weekNumber(t time.Time) int64 {
+	days := t.Unix() / 86400
+	// The epoch was a Thursday, shift so weeks start on Monday
+	return (days + 3) / 7
+}
+
+// formatDays writes a duration as days, or hours when it is under two days
+func formatDays(d time.Duration) string {
+	if d < 48*time.Hour {
+		hours := int(math.Round(d.Hours()))
+		return fmt.Sprintf("%d %s", hours, plural(hours, "hour", "hours"))
+	}
+	days := int(math.Round(d.Hours() / 24))
+	return fmt.Sprintf("%d %s", days, plural(days, "day", "days"))
+}
+
+// loadCadence computes the release cadence of a fiction from its stored chapters
+func loadCadence(ctx context.Context, store BookStore, fictionID string) (Cadence, error) {
+	chapters, err := store.GetChapters(ctx, fictionID)
+	if err != nil {
+		return Cadence{}, err
+	}
+	return buildCadence(fictionID, chapters, time.Now().UTC()), nil
+}
+
+// cadenceAPIHandler returns the release cadence of a fiction as JSON
+func (s *server) cadenceAPIHandler(w http.ResponseWriter, r *http.Request) {
+	cadence, err := loadCadence(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load chapters: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if cadence.Chapters == 0 {
+		http.Error(w, "No chapters found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, cadence)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// weeklyChapters releases a chapter every Monday at 18:00 UTC, starting on Jan 1, 2024
+func weeklyChapters(n int) []Chapter {
+	start := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	chapters := make([]Chapter, n)
+	for i := range chapters {
+		chapters[i] = Chapter{ID: string(rune('a' + i)), FictionID: "42", PublishedAt: start.Add(time.Duration(i) * 7 * 24 * time.Hour)}
+	}
+	return chapters
+}
+
+func TestBuildCadence_Weekly(t *testing.T) {
+	chapters := weeklyChapters(5)
+	last := chapters[4].PublishedAt
+
+	cadence := buildCadence("42", chapters, last.Add(2*24*time.Hour))
+
+	assert.Equal(t, 5, cadence.Chapters)
+	assert.Equal(t, 168.0, cadence.MedianIntervalHours)
+	assert.Equal(t, 168.0, cadence.AverageIntervalHours)
+	assert.Equal(t, 5, cadence.Weekdays[time.Monday])
+	assert.Equal(t, 5, cadence.Hours[18])
+	assert.Equal(t, 5, cadence.CurrentStreak)
+	assert.Equal(t, 5, cadence.LongestStreak)
+	assert.Empty(t, cadence.Hiatuses)
+	assert.False(t, cadence.Overdue)
+	require.NotNil(t, cadence.NextRelease)
+	assert.Equal(t, last.Add(7*24*time.Hour), cadence.NextRelease.Expected)
+	assert.Contains(t, cadence.Status, "Next chapter expected")
+}
+
+func TestBuildCadence_Overdue(t *testing.T) {
+	chapters := weeklyChapters(5)
+	last := chapters[4].PublishedAt
+
+	// The window closes 1.2 weeks after the last chapter, about 8.4 days
+	cadence := buildCadence("42", chapters, last.Add(11*24*time.Hour+10*time.Hour))
+
+	assert.True(t, cadence.Overdue)
+	assert.Equal(t, "Overdue by 3 days", cadence.Status)
+	// Last week had a chapter, so the streak is still alive this week
+	assert.Equal(t, 5, cadence.CurrentStreak)
+}
+
+func TestBuildCadence_Hiatus(t *testing.T) {
+	chapters := weeklyChapters(4)
+	// Two months off before the author came back
+	chapters = append(chapters, Chapter{ID: "z", FictionID: "42", PublishedAt: chapters[3].PublishedAt.Add(60 * 24 * time.Hour)})
+
+	cadence := buildCadence("42", chapters, chapters[4].PublishedAt.Add(time.Hour))
+
+	require.Equal(t, 1, len(cadence.Hiatuses))
+	assert.Equal(t, 60.0, cadence.Hiatuses[0].Days)
+	assert.Equal(t, 168.0, cadence.AverageIntervalHours)
+	assert.Equal(t, 4, cadence.LongestStreak)
+	assert.Equal(t, 1, cadence.CurrentStreak)
+	assert.False(t, cadence.OnHiatus)
+
+	// Without a new chapter for a month it is on hiatus again
+	cadence = buildCadence("42", chapters, chapters[4].PublishedAt.Add(30*24*time.Hour))
+	assert.True(t, cadence.OnHiatus)
+	assert.False(t, cadence.Overdue)
+	assert.Equal(t, "On hiatus, no chapter for 30 days", cadence.Status)
+}
+
+func TestBuildCadence_TooFewChapters(t *testing.T) {
+	cadence := buildCadence("42", weeklyChapters(2), time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC))
+
+	assert.Equal(t, 2, cadence.Chapters)
+	assert.Nil(t, cadence.NextRelease)
+	assert.Equal(t, "Not enough chapters to predict the next release", cadence.Status)
+
+	cadence = buildCadence("42", nil, time.Now())
+	assert.Equal(t, "No chapters yet", cadence.Status)
+}
+
+func TestFormatDays(t *testing.T) {
+	assert.Equal(t, "1 hour", formatDays(time.Hour))
+	assert.Equal(t, "30 hours", formatDays(30*time.Hour))
+	assert.Equal(t, "3 days", formatDays(70*time.Hour))
+}
+
+func TestCadenceAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveChapters(ctx, weeklyChapters(5)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fictions/{id}/cadence", s.cadenceAPIHandler)
+
+	req := httptest.NewRequest("GET", "/api/fictions/42/cadence", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var cadence Cadence
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &cadence))
+	assert.Equal(t, 5, cadence.Chapters)
+	// The test chapters are long past, so the fiction is on hiatus by now
+	assert.True(t, cadence.OnHiatus)
+
+	req = httptest.NewRequest("GET", "/api/fictions/99/cadence", nil).WithContext(ctx)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,719 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Catalog exports are the crawled data in formats analysts can load into a notebook. Unlike a store
+// dump they cover one table at a time, and imports validate and upsert instead of appending
+
+// Catalog formats
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+)
+
+var catalogFormats = []string{formatJSONL, formatCSV}
+
+// csvListSeparator joins the values of list columns such as tags in CSV
+const csvListSeparator = "|"
+
+// Column types
+const (
+	columnString = "string"
+	columnInt    = "int"
+	columnFloat  = "float"
+	columnTime   = "time"
+	columnList   = "list"
+)
+
+// catalogColumn is one exported field of a record, named after its JSON key
+type catalogColumn struct {
+	Name  string
+	Type  string
+	index int
+}
+
+// catalogTable is one kind of record that can be exported and imported
+type catalogTable struct {
+	name       string
+	collection string
+	typ        reflect.Type
+	columns    []catalogColumn
+	validate   func(record any) error
+	importer   func(s BookStore) catalogImporter
+}
+
+// catalogImporter upserts validated records, saving them in batches
+type catalogImporter interface {
+	add(ctx context.Context, record any) error
+	flush(ctx context.Context) error
+	stats() ImportStats
+}
+
+// ImportStats counts what an import did with its records
+type ImportStats struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+var (
+	bookColumns     = columnsOf(reflect.TypeOf(Book{}))
+	snapshotColumns = columnsOf(reflect.TypeOf(Snapshot{}))
+	chapterColumns  = columnsOf(reflect.TypeOf(Chapter{}))
+)
+
+var catalogTables = []catalogTable{
+	{
+		name:       "books",
+		collection: bookCollectionName,
+		typ:        reflect.TypeOf(Book{}),
+		columns:    bookColumns,
+		validate:   func(record any) error { return validateBook(*record.(*Book)) },
+		importer:   func(s BookStore) catalogImporter { return &bookImporter{store: s} },
+	},
+	{
+		name:       "snapshots",
+		collection: snapshotCollectionName,
+		typ:        reflect.TypeOf(Snapshot{}),
+		columns:    snapshotColumns,
+		validate:   func(record any) error { return validateSnapshot(*record.(*Snapshot)) },
+		importer:   func(s BookStore) catalogImporter { return &snapshotImporter{store: s} },
+	},
+	{
+		name:       "chapters",
+		collection: chapterCollectionName,
+		typ:        reflect.TypeOf(Chapter{}),
+		columns:    chapterColumns,
+		validate:   func(record any) error { return validateChapter(*record.(*Chapter)) },
+		importer:   func(s BookStore) catalogImporter { return &chapterImporter{store: s} },
+	},
+}
+
+func findCatalogTable(name string) (catalogTable, error) {
+	for _, table := range catalogTables {
+		if table.name == name {
+			return table, nil
+		}
+	}
+	return catalogTable{}, fmt.Errorf("unknown table %q, expected books, snapshots or chapters", name)
+}
+
+func checkCatalogFormat(format string) error {
+	for _, known := range catalogFormats {
+		if format == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, expected jsonl or csv", format)
+}
+
+// catalogContentTypes and catalogExtensions describe each format for downloads
+var (
+	catalogContentTypes = map[string]string{
+		formatJSONL: "application/x-ndjson",
+		formatCSV:   "text/csv; charset=utf-8",
+	}
+	catalogExtensions = map[string]string{
+		formatJSONL: ".jsonl",
+		formatCSV:   ".csv",
+	}
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// columnsOf lists the fields of a record type with their JSON names
+func columnsOf(typ reflect.Type) []catalogColumn {
+	var columns []catalogColumn
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		column := catalogColumn{Name: name, index: i}
+		switch {
+		case field.Type == timeType:
+			column.Type = columnTime
+		case field.Type.Kind() == reflect.String:
+			column.Type = columnString
+		case field.Type.Kind() == reflect.Int:
+			column.Type = columnInt
+		case field.Type.Kind() == reflect.Float64:
+			column.Type = columnFloat
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.String:
+			column.Type = columnList
+		default:
+			panic(fmt.Sprintf("unsupported catalog field %s.%s", typ.Name(), field.Name))
+		}
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// formatCell writes a field as CSV text: times in RFC 3339 and lists joined with csvListSeparator
+func formatCell(column catalogColumn, value reflect.Value) string {
+	switch column.Type {
+	case columnTime:
+		t := value.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339Nano)
+	case columnInt:
+		return strconv.FormatInt(value.Int(), 10)
+	case columnFloat:
+		return strconv.FormatFloat(value.Float(), 'f', -1, 64)
+	case columnList:
+		return strings.Join(value.Interface().([]string), csvListSeparator)
+	default:
+		return value.String()
+	}
+}
+
+// parseCell reads a CSV cell into a field; empty cells leave the zero value
+func parseCell(column catalogColumn, text string, field reflect.Value) error {
+	if text == "" {
+		return nil
+	}
+	switch column.Type {
+	case columnTime:
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return fmt.Errorf("%s: invalid time %q", column.Name, text)
+		}
+		field.Set(reflect.ValueOf(t))
+	case columnInt:
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", column.Name, text)
+		}
+		field.SetInt(n)
+	case columnFloat:
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", column.Name, text)
+		}
+		field.SetFloat(f)
+	case columnList:
+		field.Set(reflect.ValueOf(strings.Split(text, csvListSeparator)))
+	default:
+		field.SetString(text)
+	}
+	return nil
+}
+
+// catalogWriter writes the records of one table in one format
+type catalogWriter interface {
+	write(record reflect.Value) error
+	close() error
+}
+
+func newCatalogWriter(w io.Writer, table catalogTable, format string) (catalogWriter, error) {
+	switch format {
+	case formatJSONL:
+		return &jsonlWriter{encoder: json.NewEncoder(w)}, nil
+	case formatCSV:
+		writer := csv.NewWriter(w)
+		header := make([]string, len(table.columns))
+		for i, column := range table.columns {
+			header[i] = column.Name
+		}
+		return &csvWriter{writer: writer, table: table}, writer.Write(header)
+	}
+	return nil, checkCatalogFormat(format)
+}
+
+type jsonlWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonlWriter) write(record reflect.Value) error {
+	return w.encoder.Encode(record.Interface())
+}
+
+func (w *jsonlWriter) close() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+	table  catalogTable
+}
+
+func (w *csvWriter) write(record reflect.Value) error {
+	row := make([]string, len(w.table.columns))
+	for i, column := range w.table.columns {
+		row[i] = formatCell(column, record.Field(column.index))
+	}
+	return w.writer.Write(row)
+}
+
+func (w *csvWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// exportCatalog streams every record of a table from the store to w and returns how many were written
+func exportCatalog(ctx context.Context, w io.Writer, s BookStore, table catalogTable, format string) (int, error) {
+	writer, err := newCatalogWriter(w, table, format)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = s.ExportRecords(ctx, table.collection, func(record any) error {
+		count++
+		return writer.write(reflect.Indirect(reflect.ValueOf(record)))
+	})
+	if err != nil {
+		return count, fmt.Errorf("failed to export %s: %v", table.name, err)
+	}
+	return count, writer.close()
+}
+
+// catalogReader reads the records of one table in one format, returning io.EOF after the last
+type catalogReader interface {
+	read() (reflect.Value, error)
+}
+
+func newCatalogReader(r io.Reader, table catalogTable, format string) (catalogReader, error) {
+	switch format {
+	case formatJSONL:
+		decoder := json.NewDecoder(r)
+		decoder.DisallowUnknownFields()
+		return &jsonlReader{decoder: decoder, table: table}, nil
+	case formatCSV:
+		return newCSVReader(r, table)
+	}
+	return nil, checkCatalogFormat(format)
+}
+
+type jsonlReader struct {
+	decoder *json.Decoder
+	table   catalogTable
+	line    int
+}
+
+func (r *jsonlReader) read() (reflect.Value, error) {
+	record := reflect.New(r.table.typ)
+	r.line++
+	if err := r.decoder.Decode(record.Interface()); err != nil {
+		if err == io.EOF {
+			return reflect.Value{}, err
+		}
+		return reflect.Value{}, fmt.Errorf("record %d: %v", r.line, err)
+	}
+	return record, nil
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	table   catalogTable
+	columns []catalogColumn
+	row     int
+}
+
+// newCSVReader reads the header, which may list the columns in any order and leave some out
+func newCSVReader(r io.Reader, table catalogTable) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns, err := matchColumns(table, header)
+	if err != nil {
+		return nil, err
+	}
+	reader.FieldsPerRecord = len(header)
+	return &csvReader{reader: reader, table: table, columns: columns, row: 1}, nil
+}
+
+// matchColumns finds the table column of each name in a header
+func matchColumns(table catalogTable, names []string) ([]catalogColumn, error) {
+	columns := make([]catalogColumn, len(names))
+	for i, name := range names {
+		found := false
+		for _, column := range table.columns {
+			if column.Name == strings.TrimSpace(name) {
+				columns[i], found = column, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown %s column %q", table.name, name)
+		}
+	}
+	return columns, nil
+}
+
+func (r *csvReader) read() (reflect.Value, error) {
+	row, err := r.reader.Read()
+	r.row++
+	if err != nil {
+		if err == io.EOF {
+			return reflect.Value{}, err
+		}
+		return reflect.Value{}, fmt.Errorf("row %d: %v", r.row, err)
+	}
+	record := reflect.New(r.table.typ)
+	for i, column := range r.columns {
+		if err := parseCell(column, row[i], record.Elem().Field(column.index)); err != nil {
+			return reflect.Value{}, fmt.Errorf("row %d: %v", r.row, err)
+		}
+	}
+	return record, nil
+}
+
+// importCatalog validates every record read from r and upserts it into the store. It stops at the
+// first invalid record or when ctx is cancelled; the records before are kept. With check set
+// nothing is written
+func importCatalog(ctx context.Context, r io.Reader, s BookStore, table catalogTable, format string, check bool) (ImportStats, error) {
+	reader, err := newCatalogReader(r, table, format)
+	if err != nil {
+		return ImportStats{}, err
+	}
+	importer := table.importer(s)
+	for number := 1; ; number++ {
+		if err := ctx.Err(); err != nil {
+			// The records read so far are still saved
+			return importer.stats(), errors.Join(err, importer.flush(ctx))
+		}
+		record, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return importer.stats(), err
+		}
+		normalizeTimes(record.Elem(), table.columns)
+		if err := table.validate(record.Interface()); err != nil {
+			return importer.stats(), fmt.Errorf("invalid %s record %d: %v", table.name, number, err)
+		}
+		if check {
+			continue
+		}
+		if err := importer.add(ctx, record.Interface()); err != nil {
+			return importer.stats(), err
+		}
+	}
+	return importer.stats(), importer.flush(ctx)
+}
+
+// normalizeTimes stores times in UTC at the millisecond precision MongoDB keeps, so reimported
+// records compare equal to the stored ones
+func normalizeTimes(record reflect.Value, columns []catalogColumn) {
+	for _, column := range columns {
+		if column.Type == columnTime {
+			field := record.Field(column.index)
+			t := field.Interface().(time.Time)
+			if !t.IsZero() {
+				field.Set(reflect.ValueOf(t.UTC().Truncate(time.Millisecond)))
+			}
+		}
+	}
+}
+
+// sameRecord compares a stored record with an imported one
+func sameRecord(stored, imported any, columns []catalogColumn) bool {
+	a := reflect.New(reflect.TypeOf(stored)).Elem()
+	a.Set(reflect.ValueOf(stored))
+	normalizeTimes(a, columns)
+	return reflect.DeepEqual(a.Interface(), imported)
+}
+
+var fictionIDFormat = regexp.MustCompile(`^\d+$`)
+
+func validateAbsoluteURL(name, link string) error {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s %q must be an absolute http or https URL", name, link)
+	}
+	return nil
+}
+
+func validateBook(book Book) error {
+	var errs []error
+	if book.Title == "" {
+		errs = append(errs, errors.New("title is required"))
+	}
+	if err := validateAbsoluteURL("link", book.Link); err != nil {
+		errs = append(errs, err)
+	}
+	if book.FictionID != "" && !fictionIDFormat.MatchString(book.FictionID) {
+		errs = append(errs, fmt.Errorf("invalid fiction ID %q", book.FictionID))
+	}
+	if book.Followers < 0 || book.Views < 0 {
+		errs = append(errs, errors.New("followers and views can't be negative"))
+	}
+	if book.Rating < 0 || book.Rating > 5 {
+		errs = append(errs, fmt.Errorf("rating %v must be between 0 and 5", book.Rating))
+	}
+	return errors.Join(errs...)
+}
+
+func validateSnapshot(snapshot Snapshot) error {
+	var errs []error
+	if !fictionIDFormat.MatchString(snapshot.FictionID) {
+		errs = append(errs, fmt.Errorf("invalid fiction ID %q", snapshot.FictionID))
+	}
+	if snapshot.List == "" {
+		errs = append(errs, errors.New("list is required"))
+	}
+	if snapshot.Rank < 1 {
+		errs = append(errs, fmt.Errorf("rank must be at least 1, got %d", snapshot.Rank))
+	}
+	if snapshot.Followers < 0 || snapshot.Views < 0 {
+		errs = append(errs, errors.New("followers and views can't be negative"))
+	}
+	if snapshot.CrawledAt.IsZero() {
+		errs = append(errs, errors.New("crawled_at is required"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateChapter(chapter Chapter) error {
+	var errs []error
+	if chapter.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if !fictionIDFormat.MatchString(chapter.FictionID) {
+		errs = append(errs, fmt.Errorf("invalid fiction ID %q", chapter.FictionID))
+	}
+	if chapter.Title == "" {
+		errs = append(errs, errors.New("title is required"))
+	}
+	if chapter.Link != "" {
+		if err := validateAbsoluteURL("link", chapter.Link); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if chapter.WordCount < 0 {
+		errs = append(errs, errors.New("word count can't be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// bookImporter saves a new copy of a book only when it differs from the latest one stored. Books
+// are matched like the store upserts them: by fiction ID, or by link for books without one
+type bookImporter struct {
+	store       BookStore
+	byFictionID map[string]Book
+	byLink      map[string]Book
+	pending     []Book
+	counts      ImportStats
+}
+
+func (i *bookImporter) add(ctx context.Context, record any) error {
+	if i.byLink == nil {
+		books, err := i.store.GetBooks(ctx)
+		if err != nil {
+			return err
+		}
+		i.byFictionID = make(map[string]Book, len(books))
+		i.byLink = make(map[string]Book, len(books))
+		for _, book := range books {
+			i.remember(book)
+		}
+	}
+	book := *record.(*Book)
+	existing, ok := i.find(book)
+	switch {
+	case ok && sameRecord(existing, book, bookColumns):
+		i.counts.Unchanged++
+		return nil
+	case ok:
+		i.counts.Updated++
+		i.forget(existing)
+	default:
+		i.counts.Inserted++
+	}
+	i.remember(book)
+	i.pending = append(i.pending, book)
+	if len(i.pending) == importBatchSize {
+		return i.flush(ctx)
+	}
+	return nil
+}
+
+// find returns the stored book an imported one replaces
+func (i *bookImporter) find(book Book) (Book, bool) {
+	if book.FictionID != "" {
+		existing, ok := i.byFictionID[book.FictionID]
+		return existing, ok
+	}
+	existing, ok := i.byLink[book.Link]
+	return existing, ok
+}
+
+func (i *bookImporter) remember(book Book) {
+	if book.FictionID != "" {
+		i.byFictionID[book.FictionID] = book
+	}
+	i.byLink[book.Link] = book
+}
+
+func (i *bookImporter) forget(book Book) {
+	delete(i.byFictionID, book.FictionID)
+	delete(i.byLink, book.Link)
+}
+
+func (i *bookImporter) flush(ctx context.Context) error {
+	if len(i.pending) == 0 {
+		return nil
+	}
+	err := i.store.SaveBooks(ctx, i.pending)
+	i.pending = nil
+	return err
+}
+
+func (i *bookImporter) stats() ImportStats {
+	return i.counts
+}
+
+// snapshotImporter skips snapshots already stored for the same fiction, list and crawl time
+type snapshotImporter struct {
+	store   BookStore
+	known   map[string]map[string]bool
+	pending []Snapshot
+	counts  ImportStats
+}
+
+func snapshotKey(snapshot Snapshot) string {
+	return snapshot.List + "/" + snapshot.CrawledAt.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+}
+
+func (i *snapshotImporter) add(ctx context.Context, record any) error {
+	snapshot := *record.(*Snapshot)
+	if i.known == nil {
+		i.known = make(map[string]map[string]bool)
+	}
+	keys, ok := i.known[snapshot.FictionID]
+	if !ok {
+		stored, err := i.store.GetSnapshots(ctx, snapshot.FictionID)
+		if err != nil {
+			return err
+		}
+		keys = make(map[string]bool, len(stored))
+		for _, existing := range stored {
+			keys[snapshotKey(existing)] = true
+		}
+		i.known[snapshot.FictionID] = keys
+	}
+	if keys[snapshotKey(snapshot)] {
+		i.counts.Unchanged++
+		return nil
+	}
+	keys[snapshotKey(snapshot)] = true
+	i.counts.Inserted++
+	i.pending = append(i.pending, snapshot)
+	if len(i.pending) == importBatchSize {
+		return i.flush(ctx)
+	}
+	return nil
+}
+
+func (i *snapshotImporter) flush(ctx context.Context) error {
+	if len(i.pending) == 0 {
+		return nil
+	}
+	err := i.store.SaveSnapshots(ctx, i.pending)
+	i.pending = nil
+	return err
+}
+
+func (i *snapshotImporter) stats() ImportStats {
+	return i.counts
+}
+
+// chapterImporter replaces chapters by ID, which SaveChapters already does
+type chapterImporter struct {
+	store   BookStore
+	known   map[string]map[string]Chapter
+	pending []Chapter
+	counts  ImportStats
+}
+
+func (i *chapterImporter) add(ctx context.Context, record any) error {
+	chapter := *record.(*Chapter)
+	if i.known == nil {
+		i.known = make(map[string]map[string]Chapter)
+	}
+	chapters, ok := i.known[chapter.FictionID]
+	if !ok {
+		stored, err := i.store.GetChapters(ctx, chapter.FictionID)
+		if err != nil {
+			return err
+		}
+		chapters = make(map[string]Chapter, len(stored))
+		for _, existing := range stored {
+			chapters[existing.ID] = existing
+		}
+		i.known[chapter.FictionID] = chapters
+	}
+	existing, ok := chapters[chapter.ID]
+	switch {
+	case ok && sameRecord(existing, chapter, chapterColumns):
+		i.counts.Unchanged++
+		return nil
+	case ok:
+		i.counts.Updated++
+	default:
+		i.counts.Inserted++
+	}
+	chapters[chapter.ID] = chapter
+	i.pending = append(i.pending, chapter)
+	if len(i.pending) == importBatchSize {
+		return i.flush(ctx)
+	}
+	return nil
+}
+
+func (i *chapterImporter) flush(ctx context.Context) error {
+	if len(i.pending) == 0 {
+		return nil
+	}
+	err := i.store.SaveChapters(ctx, i.pending)
+	i.pending = nil
+	return err
+}
+
+func (i *chapterImporter) stats() ImportStats {
+	return i.counts
+}
+
+// catalogExportHandler streams a table as a download: GET /api/export/{table}?format=csv
+func (s *server) catalogExportHandler(w http.ResponseWriter, r *http.Request) {
+	table, err := findCatalogTable(r.PathValue("table"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = formatJSONL
+	}
+	if err := checkCatalogFormat(format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", catalogContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", table.name+catalogExtensions[format]))
+	if _, err := exportCatalog(r.Context(), w, s.store, table, format); err != nil {
+		// The download has started, so the error can only be logged and the response cut short
+		loggerFrom(r.Context()).Error("Failed to export catalog", "table", table.name, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
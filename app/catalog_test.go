@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillCatalog saves books, snapshots and chapters with every column set
+func fillCatalog(t *testing.T, s BookStore) {
+	ctx := context.Background()
+	crawled := time.Date(2025, 5, 1, 12, 30, 15, 250000000, time.UTC)
+	require.NoError(t, s.SaveBooks(ctx, []Book{
+		{Title: "First, with a comma", Link: defaultRoyalRoadURL + "/fiction/1", FictionID: "1", Followers: 1200, Views: 50000,
+			Rating: 4.25, Author: "Writer", Tags: []string{"Fantasy", "LitRPG"}, Synopsis: "Line one\nline \"two\""},
+		{Title: "Second", Link: defaultRoyalRoadURL + "/fiction/2", FictionID: "2"},
+	}))
+	require.NoError(t, s.SaveSnapshots(ctx, []Snapshot{
+		{FictionID: "1", Title: "First, with a comma", List: "popular", Rank: 1, Followers: 1200, Views: 50000, Rating: 4.25, CrawledAt: crawled},
+		{FictionID: "2", Title: "Second", List: "popular", Rank: 2, CrawledAt: crawled},
+	}))
+	require.NoError(t, s.SaveChapters(ctx, []Chapter{
+		{ID: "10", FictionID: "1", Title: "Chapter 1", Link: defaultRoyalRoadURL + "/fiction/1/chapter/10", PublishedAt: crawled, WordCount: 3000},
+	}))
+}
+
+func TestCatalogRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryStore()
+	fillCatalog(t, source)
+
+	for _, format := range catalogFormats {
+		for _, table := range catalogTables {
+			t.Run(format+"/"+table.name, func(t *testing.T) {
+				var exported bytes.Buffer
+				count, err := exportCatalog(ctx, &exported, source, table, format)
+				require.NoError(t, err)
+				assert.NotZero(t, count)
+
+				target := newMemoryStore()
+				stats, err := importCatalog(ctx, bytes.NewReader(exported.Bytes()), target, table, format, false)
+				require.NoError(t, err)
+				assert.Equal(t, ImportStats{Inserted: count}, stats)
+
+				var again bytes.Buffer
+				_, err = exportCatalog(ctx, &again, target, table, format)
+				require.NoError(t, err)
+				assert.Equal(t, exported.String(), again.String())
+
+				// Importing the same file again changes nothing
+				stats, err = importCatalog(ctx, bytes.NewReader(exported.Bytes()), target, table, format, false)
+				require.NoError(t, err)
+				assert.Equal(t, ImportStats{Unchanged: count}, stats)
+			})
+		}
+	}
+}
+
+func TestExportCatalog_CSV(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+	fillCatalog(t, memory)
+	table, err := findCatalogTable("books")
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	_, err = exportCatalog(ctx, &out, memory, table, formatCSV)
+	require.NoError(t, err)
+	lines := strings.SplitN(out.String(), "\n", 2)
+	assert.Equal(t, "title,link,fiction_id,followers,views,rating,author,tags,synopsis", lines[0])
+	assert.Contains(t, out.String(), `"First, with a comma",https://www.royalroad.com/fiction/1,1,1200,50000,4.25,Writer,Fantasy|LitRPG,`)
+}
+
+func TestImportCatalog_Upsert(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+	fillCatalog(t, memory)
+	books, err := findCatalogTable("books")
+	require.NoError(t, err)
+
+	// Columns may be left out or reordered; a record replaces the stored one as a whole
+	csv := "link,title,fiction_id,followers\n" +
+		"https://www.royalroad.com/fiction/2,Second,2,\n" +
+		"https://www.royalroad.com/fiction/1,First,1,1300\n" +
+		"https://www.royalroad.com/fiction/3,Third,3,5\n"
+	stats, err := importCatalog(ctx, strings.NewReader(csv), memory, books, formatCSV, false)
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Inserted: 1, Updated: 1, Unchanged: 1}, stats)
+
+	stored, err := memory.GetBooks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(stored))
+	assert.Equal(t, 1300, stored[0].Followers)
+	assert.Equal(t, "Third", stored[2].Title)
+}
+
+func TestImportCatalog_UpsertByFictionID(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+	fillCatalog(t, memory)
+	books, err := findCatalogTable("books")
+	require.NoError(t, err)
+
+	// A fiction whose link changed is the same book; one without an ID is matched by its link
+	csv := "link,title,fiction_id\n" +
+		"https://www.royalroad.com/fiction/2/second-renamed,Second Renamed,2\n" +
+		"https://www.royalroad.com/fiction/9,Linked,\n" +
+		"https://www.royalroad.com/fiction/9,Linked again,\n"
+	stats, err := importCatalog(ctx, strings.NewReader(csv), memory, books, formatCSV, false)
+	require.NoError(t, err)
+	assert.Equal(t, ImportStats{Inserted: 1, Updated: 2}, stats)
+
+	stored, err := memory.GetBooks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(stored))
+	assert.Equal(t, "Second Renamed", stored[1].Title)
+	assert.Equal(t, "Linked again", stored[2].Title)
+}
+
+func TestImportCatalog_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	memory := newMemoryStore()
+	books, err := findCatalogTable("books")
+	require.NoError(t, err)
+
+	_, err = importCatalog(ctx, strings.NewReader("title,link,fiction_id\nFirst,https://www.royalroad.com/fiction/1,1\n"), memory, books, formatCSV, false)
+	assert.ErrorIs(t, err, context.Canceled)
+	stored, err := memory.GetBooks(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestImportCatalog_Validation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		table, format, input, want string
+	}{
+		{"books", formatCSV, "title,link\nNo link,\n", "invalid books record 1: link"},
+		{"books", formatCSV, "title,pages\nBook,12\n", `unknown books column "pages"`},
+		{"books", formatCSV, "title,link,rating\nBook,https://www.royalroad.com/fiction/1,9\n", "rating 9"},
+		{"books", formatJSONL, `{"title":"Book","link":"https://www.royalroad.com/fiction/1","pages":3}`, "record 1"},
+		{"snapshots", formatJSONL, `{"fiction_id":"abc","list":"popular","rank":0}`, "invalid fiction ID"},
+		{"snapshots", formatCSV, "fiction_id,crawled_at\n1,yesterday\n", `invalid time "yesterday"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.table+"/"+tt.want, func(t *testing.T) {
+			table, err := findCatalogTable(tt.table)
+			require.NoError(t, err)
+			memory := newMemoryStore()
+			_, err = importCatalog(ctx, strings.NewReader(tt.input), memory, table, tt.format, false)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+
+	// A check validates without writing
+	memory := newMemoryStore()
+	chapters, err := findCatalogTable("chapters")
+	require.NoError(t, err)
+	_, err = importCatalog(ctx, strings.NewReader(`{"id":"1","fiction_id":"1","title":"One"}`), memory, chapters, formatJSONL, true)
+	require.NoError(t, err)
+	stored, err := memory.GetChapters(ctx, "1")
+	require.NoError(t, err)
+	assert.Empty(t, stored)
+}
+
+func TestCatalogExportHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	fillCatalog(t, memory)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/export/{table}", s.requireUser(s.catalogExportHandler))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/export/books?format=csv", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	request := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil).WithContext(ctx)
+		req.SetBasicAuth("alice", "secret")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = request("/api/export/books?format=csv")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="books.csv"`, rr.Header().Get("Content-Disposition"))
+	assert.True(t, strings.HasPrefix(rr.Body.String(), "title,link,"))
+
+	rr = request("/api/export/chapters")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"word_count":3000`)
+
+	assert.Equal(t, http.StatusNotFound, request("/api/export/follows").Code)
+	assert.Equal(t, http.StatusBadRequest, request("/api/export/books?format=xlsx").Code)
+}
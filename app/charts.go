@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	chartWidth      = 600
+	chartHeight     = 160
+	chartPadding    = 40
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+// sparkline renders a series as a small inline SVG without axes
+func sparkline(points []Point) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" role="img">`,
+		sparklineWidth, sparklineHeight, sparklineWidth, sparklineHeight)
+	if len(points) > 0 {
+		coords := plotPoints(points, 0, 0, sparklineWidth, sparklineHeight, false)
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="currentColor" stroke-width="1.5" points="%s"/>`, joinCoords(coords))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// lineChart renders a series as an SVG chart with min/max and date labels.
+// With invert set, low values are drawn at the top, which is how ranks read naturally.
+func lineChart(points []Point, invert bool) template.HTML {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="chart" width="100%%" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" role="img">`,
+		chartWidth, chartHeight)
+	if len(points) == 0 {
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" fill="currentColor">No data yet</text></svg>`,
+			chartWidth/2, chartHeight/2)
+		return template.HTML(b.String())
+	}
+
+	left, top := chartPadding, chartPadding/2
+	width, height := chartWidth-chartPadding-chartPadding/2, chartHeight-chartPadding
+	low, high := seriesRange(points)
+	topLabel, bottomLabel := high, low
+	if invert {
+		topLabel, bottomLabel = low, high
+	}
+
+	fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="currentColor" stroke-opacity="0.3"/>`,
+		left, top+height, left+width, top+height)
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" font-size="11" fill="currentColor">%s</text>`,
+		left-5, top+4, formatChartValue(topLabel))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" font-size="11" fill="currentColor">%s</text>`,
+		left-5, top+height, formatChartValue(bottomLabel))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="11" fill="currentColor">%s</text>`,
+		left, chartHeight-4, points[0].Time.Format("2006-01-02"))
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" font-size="11" fill="currentColor">%s</text>`,
+		left+width, chartHeight-4, points[len(points)-1].Time.Format("2006-01-02"))
+
+	coords := plotPoints(points, left, top, width, height, invert)
+	fmt.Fprintf(&b, `<polyline fill="none" stroke="var(--accent-color, #3498db)" stroke-width="2" points="%s"/>`, joinCoords(coords))
+	last := coords[len(coords)-1]
+	fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="var(--accent-color, #3498db)"><title>%s</title></circle>`,
+		last[0], last[1], formatChartValue(points[len(points)-1].Value))
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// plotPoints scales a series into the given box, spacing points evenly by time
+func plotPoints(points []Point, left, top, width, height int, invert bool) [][2]float64 {
+	low, high := seriesRange(points)
+	start, end := points[0].Time, points[len(points)-1].Time
+	span := end.Sub(start).Seconds()
+
+	coords := make([][2]float64, len(points))
+	for i, p := range points {
+		x := float64(left) + float64(width)/2
+		if span > 0 {
+			x = float64(left) + p.Time.Sub(start).Seconds()/span*float64(width)
+		}
+		ratio := 0.5
+		if high > low {
+			ratio = (p.Value - low) / (high - low)
+		}
+		if !invert {
+			ratio = 1 - ratio
+		}
+		coords[i] = [2]float64{x, float64(top) + ratio*float64(height)}
+	}
+	return coords
+}
+
+func seriesRange(points []Point) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		low = math.Min(low, p.Value)
+		high = math.Max(high, p.Value)
+	}
+	return low, high
+}
+
+func joinCoords(coords [][2]float64) string {
+	parts := make([]string, len(coords))
+	for i, c := range coords {
+		parts[i] = fmt.Sprintf("%.1f,%.1f", c[0], c[1])
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatChartValue prints whole numbers without decimals and ratings with two
+func formatChartValue(v float64) string {
+	if v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
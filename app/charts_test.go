@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparkline(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []Point{
+		{Time: start, Value: 10},
+		{Time: start.Add(time.Hour), Value: 20},
+		{Time: start.Add(2 * time.Hour), Value: 15},
+	}
+
+	svg := string(sparkline(points))
+
+	assert.True(t, strings.HasPrefix(svg, "<svg"))
+	assert.Contains(t, svg, "<polyline")
+	// The highest value sits at the top edge, the lowest at the bottom
+	assert.Contains(t, svg, "points=\"0.0,24.0 60.0,0.0 120.0,12.0\"")
+}
+
+func TestSparklineEmpty(t *testing.T) {
+	svg := string(sparkline(nil))
+
+	assert.Contains(t, svg, "<svg")
+	assert.NotContains(t, svg, "<polyline")
+}
+
+func TestLineChart(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []Point{
+		{Time: start, Value: 1},
+		{Time: start.Add(24 * time.Hour), Value: 3},
+	}
+
+	svg := string(lineChart(points, false))
+
+	assert.Contains(t, svg, "2024-01-01")
+	assert.Contains(t, svg, "2024-01-02")
+	assert.Contains(t, svg, "<polyline")
+}
+
+func TestLineChartInvertedForRanks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	points := []Point{
+		{Time: start, Value: 5},
+		{Time: start.Add(time.Hour), Value: 1},
+	}
+
+	coords := plotPoints(points, 0, 0, 100, 100, true)
+
+	// Rank 1 is drawn at the top of an inverted chart
+	assert.Equal(t, 100.0, coords[0][1])
+	assert.Equal(t, 0.0, coords[1][1])
+}
+
+func TestLineChartEmpty(t *testing.T) {
+	svg := string(lineChart(nil, false))
+
+	assert.Contains(t, svg, "No data yet")
+}
+
+func TestFormatChartValue(t *testing.T) {
+	assert.Equal(t, "1200", formatChartValue(1200))
+	assert.Equal(t, "4.57", formatChartValue(4.567))
+}
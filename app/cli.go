@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// shutdownTimeout is how long the web server waits for open requests when stopping
+const shutdownTimeout = 10 * time.Second
+
+// command is a subcommand of the binary
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+// commands lists the subcommands; the unnamed one runs when none is given
+var commands = []command{
+	{"", "crawl the list once, then serve it and crawl again on demand", runDefaultCommand},
+	{"serve", "serve the web pages and API from the store, leaving crawling to a worker", runServeCommand},
+	{"worker", "crawl the list and its fiction pages every crawl interval", runWorkerCommand},
+	{"crawl", "crawl a list and print its books: crawl -list popular -once [-format table|json]", runCrawlCommand},
+	{"export", "dump the store, or one table: export [-table books|snapshots|chapters -format jsonl|csv] [-o file]", runExportCommand},
+	{"import", "load a dump, or validate and upsert one table: import [-table books -format csv] [-check] [file]", runImportCommand},
+	{"migrate", "apply the pending schema migrations to the store: migrate [-status]", runMigrateCommand},
+	{"epub", "export an archive: epub -user alice -fiction 42 [-from N] [-to M] [-o book.epub]", runEPUBCommand},
+	{"fake-royalroad", "serve a fake RoyalRoad to crawl with -base-url: fake-royalroad [-addr :8091] [-seed 1] [-removed 50003]", runFakeRoyalRoadCommand},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: royalroadbot [command] [flags]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		name := cmd.name
+		if name == "" {
+			name = "(none)"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", name, cmd.usage)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run a command with -h for its flags, which include every configuration setting.")
+}
+
+// startCommand loads the configuration with the command's own flags registered on flags and applies
+// it, returning what it built. The returned function closes the store and flushes pending spans
+func startCommand(flags *flag.FlagSet, args []string) (Config, dependencies, func(), error) {
+	cfg, err := loadConfig(flags, args, os.Getenv)
+	if err != nil {
+		return Config{}, dependencies{}, nil, err
+	}
+	deps, shutdown, err := applyConfig(cfg)
+	if err != nil {
+		return Config{}, dependencies{}, nil, err
+	}
+	return cfg, deps, func() { shutdown(context.Background()) }, nil
+}
+
+// signalContext is cancelled on Ctrl-C or SIGTERM so long-running commands stop cleanly
+func signalContext(parent context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(parent, os.Interrupt, syscall.SIGTERM)
+}
+
+func runDefaultCommand(args []string) error {
+	cfg, deps, shutdown, err := startCommand(flag.NewFlagSet("royalroadbot", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	// The background crawls stop with ctx and are done before the store is closed
+	defer func() {
+		stop()
+		deps.crawler.wait()
+	}()
+	if err := autoMigrate(ctx, cfg, deps.store); err != nil {
+		return err
+	}
+
+	// Initialize books on startup
+	books := &bookCache{}
+	initialBooks, err := deps.crawler.fetchPopularBooks(ctx, ctx, cfg.Crawl)
+	if err != nil {
+		loggerFrom(ctx).Warn("Failed to pre-fetch books", "error", err)
+	} else {
+		books.set(initialBooks)
+	}
+	return listenAndServe(ctx, cfg, newServer(cfg, deps, books, crawledBooks(ctx, deps.crawler, cfg.Crawl)).handler())
+}
+
+func runServeCommand(args []string) error {
+	cfg, deps, shutdown, err := startCommand(flag.NewFlagSet("serve", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	if err := autoMigrate(ctx, cfg, deps.store); err != nil {
+		return err
+	}
+
+	// Pick up what the worker crawled
+	books := &bookCache{}
+	load := savedBooks(deps.crawler, cfg.Crawl)
+	go schedule(ctx, time.Duration(cfg.Crawl.Interval), func(ctx context.Context) error {
+		saved, err := load(ctx)
+		if err != nil {
+			return err
+		}
+		books.set(saved)
+		return nil
+	})
+	return listenAndServe(ctx, cfg, newServer(cfg, deps, books, load).handler())
+}
+
+func runWorkerCommand(args []string) error {
+	cfg, deps, shutdown, err := startCommand(flag.NewFlagSet("worker", flag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	if err := autoMigrate(ctx, cfg, deps.store); err != nil {
+		return err
+	}
+
+	loggerFrom(ctx).Info("Starting worker", "interval", time.Duration(cfg.Crawl.Interval).String())
+	schedule(ctx, time.Duration(cfg.Crawl.Interval), func(ctx context.Context) error {
+		for _, list := range rankingLists {
+			if _, err := deps.crawler.crawlList(ctx, list, cfg.Crawl, true); err != nil {
+				return fmt.Errorf("failed to crawl %s: %w", list, err)
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+func runCrawlCommand(args []string) error {
+	flags := flag.NewFlagSet("crawl", flag.ContinueOnError)
+	list := flags.String("list", "popular", "ranking list to crawl")
+	once := flags.Bool("once", false, "crawl once and exit instead of every crawl interval")
+	format := flags.String("format", "table", "output format: table or json")
+	details := flags.Bool("details", true, "also crawl the fiction and author pages of the books")
+	cfg, deps, shutdown, err := startCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	if !slices.Contains(rankingLists, *list) {
+		return fmt.Errorf("unknown list %q, expected one of %v", *list, rankingLists)
+	}
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("invalid format %q, expected table or json", *format)
+	}
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	if err := autoMigrate(ctx, cfg, deps.store); err != nil {
+		return err
+	}
+
+	crawl := func(ctx context.Context) error {
+		books, err := deps.crawler.crawlList(ctx, *list, cfg.Crawl, *details)
+		if err != nil {
+			return err
+		}
+		return printBooks(os.Stdout, books, *format)
+	}
+	if *once {
+		return crawl(ctx)
+	}
+	schedule(ctx, time.Duration(cfg.Crawl.Interval), crawl)
+	return nil
+}
+
+// printBooks writes books as an aligned table or as a JSON array
+func printBooks(w io.Writer, books []Book, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if books == nil {
+			books = []Book{}
+		}
+		return encoder.Encode(books)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tTITLE\tAUTHOR\tFOLLOWERS\tRATING\tLINK")
+	for i, book := range books {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%.2f\t%s\n", i+1, book.Title, book.Author, book.Followers, book.Rating, book.Link)
+	}
+	return tw.Flush()
+}
+
+func runExportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := flags.String("o", "-", "output file, - for stdout")
+	tableName := flags.String("table", "", "table to export: books, snapshots or chapters; the whole store by default")
+	format := flags.String("format", formatJSONL, "table format: jsonl or csv")
+	_, deps, shutdown, err := startCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	var table catalogTable
+	if *tableName != "" {
+		if table, err = findCatalogTable(*tableName); err != nil {
+			return err
+		}
+		if err := checkCatalogFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *tableName != "" {
+		count, err := exportCatalog(ctx, w, deps.store, table, *format)
+		printCounts(os.Stderr, "Exported", map[string]int{table.name: count})
+		return err
+	}
+	counts, err := exportStore(ctx, w, deps.store)
+	printCounts(os.Stderr, "Exported", counts)
+	return err
+}
+
+func runImportCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	tableName := flags.String("table", "", "table to upsert: books, snapshots or chapters; a whole store dump by default")
+	format := flags.String("format", formatJSONL, "table format: jsonl or csv")
+	check := flags.Bool("check", false, "only validate the table, without writing")
+	cfg, deps, shutdown, err := startCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+	if err := autoMigrate(ctx, cfg, deps.store); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("import reads a single file")
+	}
+	var table catalogTable
+	if *tableName != "" {
+		if table, err = findCatalogTable(*tableName); err != nil {
+			return err
+		}
+		if err := checkCatalogFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	r := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if *tableName != "" {
+		stats, err := importCatalog(ctx, r, deps.store, table, *format, *check)
+		fmt.Fprintf(os.Stderr, "Imported %s: %d inserted, %d updated, %d unchanged\n", table.name, stats.Inserted, stats.Updated, stats.Unchanged)
+		return err
+	}
+	counts, err := importStore(ctx, r, deps.store)
+	printCounts(os.Stderr, "Imported", counts)
+	return err
+}
+
+// printCounts reports how many records of each collection were exported or imported
+func printCounts(w io.Writer, verb string, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s %d %s\n", verb, counts[name], name)
+	}
+}
+
+func runMigrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	status := flags.Bool("status", false, "only print the schema version of the store")
+	_, deps, shutdown, err := startCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+
+	if *status {
+		version, err := deps.store.SchemaVersion(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Schema version %d of %d\n", version, latestSchemaVersion())
+		for _, m := range migrations {
+			if m.version > version {
+				fmt.Printf("Pending %d %s\n", m.version, m.name)
+			}
+		}
+		return nil
+	}
+
+	applied, err := runMigrations(ctx, deps.store)
+	for _, name := range applied {
+		fmt.Printf("Applied %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("No migrations to apply")
+	}
+	return nil
+}
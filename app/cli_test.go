@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommand(t *testing.T) {
+	for _, name := range []string{"", "serve", "worker", "crawl", "export", "import", "migrate", "epub", "fake-royalroad"} {
+		cmd, ok := findCommand(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, name, cmd.name)
+	}
+	_, ok := findCommand("deploy")
+	assert.False(t, ok)
+
+	var usage bytes.Buffer
+	printUsage(&usage)
+	assert.Contains(t, usage.String(), "serve")
+	assert.Contains(t, usage.String(), "(none)")
+}
+
+func TestPrintBooks(t *testing.T) {
+	books := []Book{
+		{Title: "First", Link: defaultRoyalRoadURL + "/fiction/1", Author: "Someone", Followers: 1200, Rating: 4.5},
+		{Title: "Second", Link: defaultRoyalRoadURL + "/fiction/2"},
+	}
+
+	var table bytes.Buffer
+	require.NoError(t, printBooks(&table, books, "table"))
+	assert.Contains(t, table.String(), "RANK")
+	assert.Contains(t, table.String(), "1     First")
+	assert.Contains(t, table.String(), "4.50")
+
+	var out bytes.Buffer
+	require.NoError(t, printBooks(&out, books, "json"))
+	var decoded []Book
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, books, decoded)
+
+	out.Reset()
+	require.NoError(t, printBooks(&out, nil, "json"))
+	assert.Equal(t, "[]\n", out.String())
+}
+
+func TestSchedule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		schedule(ctx, 10*time.Millisecond, func(context.Context) error {
+			if runs.Add(1) == 3 {
+				cancel()
+			}
+			return assert.AnError
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule didn't stop when its context was cancelled")
+	}
+	assert.Equal(t, int32(3), runs.Load(), "failed crawls don't stop the schedule")
+}
+
+func TestSchedule_Blocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var runs []time.Time
+	until := time.Now().Add(100 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		schedule(ctx, 10*time.Millisecond, func(context.Context) error {
+			runs = append(runs, time.Now())
+			if len(runs) == 2 {
+				cancel()
+			}
+			return fmt.Errorf("failed to crawl popular: %w", &BlockedError{Host: "www.royalroad.com", Reason: "HTTP 403 from Cloudflare", Until: until})
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("schedule didn't stop when its context was cancelled")
+	}
+	require.Equal(t, 2, len(runs))
+	assert.False(t, runs[1].Before(until), "the schedule waits for the pause of the host to be over")
+}
+
+func TestCrawlList(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/1/first">First</a></h2></div>
+			<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/2/second">Second</a></h2></div>
+			<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/3/third">Third</a></h2></div>
+		</body></html>`))
+	}))
+	t.Cleanup(server.Close)
+
+	cfg := CrawlConfig{URL: server.URL, BookLimit: 2}
+	books, err := s.crawler.crawlList(ctx, "popular", cfg, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(books))
+
+	// A web-only server reads the same list back from the store
+	stored, err := s.crawler.storedBooks(ctx, "popular", 2)
+	require.NoError(t, err)
+	assert.Equal(t, books, stored)
+}
+
+func TestStoredBooks_LatestCrawl(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	require.NoError(t, memory.SaveSnapshots(ctx, []Snapshot{
+		{FictionID: "1", Title: "First", List: "popular", Rank: 1, CrawledAt: now.Add(-time.Hour)},
+		{FictionID: "2", Title: "Second", List: "popular", Rank: 2, CrawledAt: now.Add(-time.Hour)},
+		{FictionID: "2", Title: "Second", List: "popular", Rank: 1, CrawledAt: now},
+		{FictionID: "1", Title: "First", List: "popular", Rank: 2, CrawledAt: now},
+		{FictionID: "9", Title: "Elsewhere", List: "rising", Rank: 1, CrawledAt: now},
+	}))
+	require.NoError(t, memory.SaveBooks(ctx, []Book{{Title: "Second", Link: defaultRoyalRoadURL + "/fiction/2/second", FictionID: "2", Author: "Writer"}}))
+
+	books, err := s.crawler.storedBooks(ctx, "popular", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Book{
+		{Title: "Second", Link: defaultRoyalRoadURL + "/fiction/2/second", FictionID: "2", Author: "Writer"},
+		{Title: "First", Link: defaultRoyalRoadURL + "/fiction/1", FictionID: "1"},
+	}, books)
+}
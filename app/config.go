@@ -0,0 +1,600 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is everything the bot can be configured with. It is loaded from defaults, then a JSON
+// config file, then environment variables, then command-line flags, each overriding the last
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Crawl    CrawlConfig    `json:"crawl"`
+	Network  NetworkConfig  `json:"network"`
+	Database DatabaseConfig `json:"database"`
+	Log      LogConfig      `json:"log"`
+	Tracing  TracingConfig  `json:"tracing"`
+	// Users is a comma separated list of "name:password" pairs allowed to sign in
+	Users string `json:"users"`
+}
+
+// ServerConfig configures the web server
+type ServerConfig struct {
+	Addr string `json:"addr"`
+}
+
+// CrawlConfig configures what is crawled
+type CrawlConfig struct {
+	// BaseURL is where RoyalRoad is crawled from, such as a fake-royalroad server in development
+	BaseURL string `json:"base_url"`
+	// URL is the ranking list shown on the main page, the active popular list on BaseURL when empty
+	URL string `json:"url"`
+	// BookLimit is how many books of the list are shown and saved
+	BookLimit int `json:"book_limit"`
+	// Interval is how often the worker crawls, and how often a web-only server reloads the stored list
+	Interval Duration `json:"interval"`
+	// Fixtures is the directory of recorded pages, see fixtureTransport. It has no default, as a
+	// relative path would depend on where the process starts
+	Fixtures string `json:"fixtures"`
+	// Offline replays the pages recorded in Fixtures instead of fetching RoyalRoad
+	Offline bool `json:"offline"`
+	// RecordFixtures saves every page fetched to Fixtures
+	RecordFixtures bool `json:"record_fixtures"`
+	// PageCache is where crawled pages are cached: "store", "off" or a directory
+	PageCache string `json:"page_cache"`
+	// CacheMaxAge is how long a cached page is used without asking the site, by page type
+	CacheMaxAge map[string]Duration `json:"cache_max_age"`
+	// Workers is how many fiction pages, and how many chapter pages, a detail crawl fetches at once
+	Workers int `json:"workers"`
+	// HostConcurrency caps the requests in flight to one host, however many workers there are
+	HostConcurrency int `json:"host_concurrency"`
+	// BlockBackoff is how long a host that served a challenge is paused, doubled with every
+	// challenge in a row up to BlockBackoffMax
+	BlockBackoff    Duration `json:"block_backoff"`
+	BlockBackoffMax Duration `json:"block_backoff_max"`
+	// AlertWebhook is a URL alerts such as the crawler being blocked are posted to as JSON
+	AlertWebhook string `json:"alert_webhook"`
+}
+
+// ListURL is the ranking list shown on the main page
+func (c CrawlConfig) ListURL() string {
+	if c.URL != "" {
+		return c.URL
+	}
+	return c.BaseURL + "/fictions/active-popular"
+}
+
+// Duration is a time.Duration written as "90s" or "1h" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+// NetworkConfig configures how the crawler reaches RoyalRoad, see newNetworkTransport
+type NetworkConfig struct {
+	// Proxies are http://, https:// or socks5:// URLs requests go through in turn. When empty the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables apply
+	Proxies []string `json:"proxies"`
+	// ProxyCheckInterval is how long a failed proxy is left out before it is checked again
+	ProxyCheckInterval Duration `json:"proxy_check_interval"`
+	// CABundle is a PEM file of certificates trusted besides the system ones
+	CABundle string `json:"ca_bundle"`
+	// DNSOverride maps host names to the address they are dialed at
+	DNSOverride map[string]string `json:"dns_override"`
+	// BindAddress is the local IP address connections are made from
+	BindAddress string `json:"bind_address"`
+}
+
+// memoryDatabaseURI selects the in-process store instead of MongoDB
+const memoryDatabaseURI = "memory://"
+
+// DatabaseConfig configures the store, MongoDB unless URI is memoryDatabaseURI
+type DatabaseConfig struct {
+	URI        string `json:"uri"`
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	// AutoMigrate applies pending schema migrations when a command starts
+	AutoMigrate bool `json:"auto_migrate"`
+}
+
+// LogConfig configures logging, see setupLogging
+type LogConfig struct {
+	Level  string `json:"level"`
+	Format string `json:"format"`
+}
+
+// TracingConfig configures tracing, see setupTracing
+type TracingConfig struct {
+	Exporters string `json:"exporters"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{Addr: ":8090"},
+		Crawl: CrawlConfig{
+			BaseURL:         defaultRoyalRoadURL,
+			BookLimit:       10,
+			Interval:        Duration(time.Hour),
+			PageCache:       pageCacheStore,
+			CacheMaxAge:     defaultCacheMaxAge(),
+			Workers:         4,
+			HostConcurrency: 2,
+			BlockBackoff:    Duration(15 * time.Minute),
+			BlockBackoffMax: Duration(6 * time.Hour),
+		},
+		Network: NetworkConfig{ProxyCheckInterval: Duration(time.Minute)},
+		Database: DatabaseConfig{
+			URI:         "mongodb://localhost:27017",
+			Name:        "royalRoadBooks",
+			Collection:  "books",
+			AutoMigrate: true,
+		},
+		Log: LogConfig{Level: "info", Format: "text"},
+	}
+}
+
+// configSetting is one setting that can be overridden by an environment variable and a flag
+type configSetting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// configSettings lists every setting in the order of the flag help
+var configSettings = []configSetting{
+	{"addr", "ROYALROADBOT_ADDR", "address the web server listens on", func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"base-url", "ROYALROADBOT_BASE_URL", "RoyalRoad or a stand-in for it, such as http://localhost:8091", func(c *Config, v string) error {
+		c.Crawl.BaseURL = strings.TrimSuffix(v, "/")
+		return nil
+	}},
+	{"crawl-url", "ROYALROADBOT_CRAWL_URL", "ranking list shown on the main page, the active popular list of base-url by default", func(c *Config, v string) error {
+		c.Crawl.URL = v
+		return nil
+	}},
+	{"book-limit", "ROYALROADBOT_BOOK_LIMIT", "number of books shown and saved from the list", func(c *Config, v string) error {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid book limit %q", v)
+		}
+		c.Crawl.BookLimit = limit
+		return nil
+	}},
+	{"crawl-interval", "ROYALROADBOT_CRAWL_INTERVAL", "how often the worker crawls, such as 30m or 2h", func(c *Config, v string) error {
+		if err := c.Crawl.Interval.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid crawl interval %q", v)
+		}
+		return nil
+	}},
+	{"offline", "ROYALROADBOT_OFFLINE", "replay the recorded pages instead of fetching RoyalRoad", func(c *Config, v string) error {
+		offline, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Crawl.Offline = offline
+		return nil
+	}},
+	{"record-fixtures", "ROYALROADBOT_RECORD_FIXTURES", "save every page fetched as a fixture", func(c *Config, v string) error {
+		record, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Crawl.RecordFixtures = record
+		return nil
+	}},
+	{"fixtures", "ROYALROADBOT_FIXTURES", "directory of the recorded pages", func(c *Config, v string) error {
+		c.Crawl.Fixtures = v
+		return nil
+	}},
+	{"page-cache", "ROYALROADBOT_PAGE_CACHE", `where crawled pages are cached: "store", "off" or a directory`, func(c *Config, v string) error {
+		c.Crawl.PageCache = v
+		return nil
+	}},
+	{"cache-max-age", "ROYALROADBOT_CACHE_MAX_AGE", "how long cached pages are used by page type, such as list=10m,chapter=720h", func(c *Config, v string) error {
+		if c.Crawl.CacheMaxAge == nil {
+			c.Crawl.CacheMaxAge = make(map[string]Duration)
+		}
+		for _, pair := range strings.Split(v, ",") {
+			pageType, age, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			var maxAge Duration
+			if !ok || maxAge.UnmarshalText([]byte(age)) != nil {
+				return fmt.Errorf("invalid max-age %q, expected type=duration", pair)
+			}
+			c.Crawl.CacheMaxAge[pageType] = maxAge
+		}
+		return nil
+	}},
+	{"crawl-workers", "ROYALROADBOT_CRAWL_WORKERS", "number of fiction and chapter pages a detail crawl fetches at once", func(c *Config, v string) error {
+		workers, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid number of workers %q", v)
+		}
+		c.Crawl.Workers = workers
+		return nil
+	}},
+	{"host-concurrency", "ROYALROADBOT_HOST_CONCURRENCY", "most requests in flight to one host", func(c *Config, v string) error {
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid host concurrency %q", v)
+		}
+		c.Crawl.HostConcurrency = limit
+		return nil
+	}},
+	{"block-backoff", "ROYALROADBOT_BLOCK_BACKOFF", "how long a host that served a challenge is paused, doubled with every challenge in a row", func(c *Config, v string) error {
+		if err := c.Crawl.BlockBackoff.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid block backoff %q", v)
+		}
+		return nil
+	}},
+	{"block-backoff-max", "ROYALROADBOT_BLOCK_BACKOFF_MAX", "longest pause of a host that keeps serving challenges", func(c *Config, v string) error {
+		if err := c.Crawl.BlockBackoffMax.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid maximum block backoff %q", v)
+		}
+		return nil
+	}},
+	{"alert-webhook", "ROYALROADBOT_ALERT_WEBHOOK", "URL alerts are posted to as JSON, such as a Slack incoming webhook", func(c *Config, v string) error {
+		c.Crawl.AlertWebhook = v
+		return nil
+	}},
+	{"proxy", "ROYALROADBOT_PROXY", "comma-separated proxies used in turn, such as http://proxy:3128,socks5://proxy:1080", func(c *Config, v string) error {
+		c.Network.Proxies = nil
+		for _, proxy := range strings.Split(v, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				c.Network.Proxies = append(c.Network.Proxies, proxy)
+			}
+		}
+		return nil
+	}},
+	{"proxy-check-interval", "ROYALROADBOT_PROXY_CHECK_INTERVAL", "how long a failed proxy is left out before it is checked again", func(c *Config, v string) error {
+		if err := c.Network.ProxyCheckInterval.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid proxy check interval %q", v)
+		}
+		return nil
+	}},
+	{"ca-bundle", "ROYALROADBOT_CA_BUNDLE", "PEM file of certificates trusted besides the system ones", func(c *Config, v string) error {
+		c.Network.CABundle = v
+		return nil
+	}},
+	{"dns-override", "ROYALROADBOT_DNS_OVERRIDE", "addresses to dial hosts at, such as www.royalroad.com=203.0.113.7", func(c *Config, v string) error {
+		if c.Network.DNSOverride == nil {
+			c.Network.DNSOverride = make(map[string]string)
+		}
+		for _, pair := range strings.Split(v, ",") {
+			host, address, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || host == "" || address == "" {
+				return fmt.Errorf("invalid DNS override %q, expected host=address", pair)
+			}
+			c.Network.DNSOverride[host] = address
+		}
+		return nil
+	}},
+	{"bind-address", "ROYALROADBOT_BIND_ADDRESS", "local IP address the crawler connects from", func(c *Config, v string) error {
+		c.Network.BindAddress = v
+		return nil
+	}},
+	{"mongodb-uri", "MONGODB_URI", "MongoDB connection string, or " + memoryDatabaseURI + " to keep everything in memory until exit", func(c *Config, v string) error {
+		c.Database.URI = v
+		return nil
+	}},
+	{"db-name", "ROYALROADBOT_DB_NAME", "MongoDB database name", func(c *Config, v string) error {
+		c.Database.Name = v
+		return nil
+	}},
+	{"db-collection", "ROYALROADBOT_DB_COLLECTION", "MongoDB collection of crawled books", func(c *Config, v string) error {
+		c.Database.Collection = v
+		return nil
+	}},
+	{"auto-migrate", "ROYALROADBOT_AUTO_MIGRATE", "apply pending schema migrations at startup: true or false", func(c *Config, v string) error {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", v)
+		}
+		c.Database.AutoMigrate = migrate
+		return nil
+	}},
+	{"log-level", "ROYALROADBOT_LOG_LEVEL", "log level: debug, info, warn or error", func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"log-format", "ROYALROADBOT_LOG_FORMAT", "log format: text or json", func(c *Config, v string) error {
+		c.Log.Format = v
+		return nil
+	}},
+	{"trace-exporters", "ROYALROADBOT_TRACE_EXPORTERS", "comma-separated trace exporters: otlp, stdout", func(c *Config, v string) error {
+		c.Tracing.Exporters = v
+		return nil
+	}},
+	{"users", "ROYALROADBOT_USERS", `comma-separated "name:password" pairs allowed to sign in`, func(c *Config, v string) error {
+		c.Users = v
+		return nil
+	}},
+}
+
+// booleanSettings can be given as a bare flag, such as -offline
+var booleanSettings = map[string]bool{"offline": true, "record-fixtures": true, "auto-migrate": true}
+
+// boolFlag holds the value of a boolean setting's flag, which the setting parses like any other
+type boolFlag struct{ value string }
+
+func (f *boolFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *boolFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+func (f *boolFlag) IsBoolFlag() bool { return true }
+
+// minCrawlInterval keeps the worker from hammering RoyalRoad
+const minCrawlInterval = time.Minute
+
+// configFileEnv names the config file when the -config flag isn't given
+const configFileEnv = "ROYALROADBOT_CONFIG"
+
+// loadConfig registers the configuration flags on fs, parses args and layers defaults, the config file,
+// the environment read with getenv and the flags that were set. The result is validated. Callers can
+// register their own flags on fs first and read the remaining arguments with fs.Args
+func loadConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (Config, error) {
+	path := fs.String("config", "", "JSON config file ($"+configFileEnv+")")
+	for _, setting := range configSettings {
+		if booleanSettings[setting.flag] {
+			fs.Var(&boolFlag{}, setting.flag, setting.usage+" ($"+setting.env+")")
+		} else {
+			fs.String(setting.flag, "", setting.usage+" ($"+setting.env+")")
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := defaultConfig()
+	if *path == "" {
+		*path = getenv(configFileEnv)
+	}
+	if *path != "" {
+		if err := readConfigFile(*path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, setting := range configSettings {
+		if value := getenv(setting.env); value != "" {
+			if err := setting.set(&cfg, value); err != nil {
+				return Config{}, fmt.Errorf("%s: %v", setting.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, setting := range configSettings {
+			if setting.flag == f.Name && flagErr == nil {
+				if err := setting.set(&cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", setting.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, flagErr
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// readConfigFile overlays the settings in a JSON file on cfg. Unknown keys are rejected so typos don't go unnoticed
+func readConfigFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c Config) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server address %q: %v", c.Server.Addr, err))
+	}
+	if !isAbsoluteURL(c.Crawl.BaseURL) {
+		errs = append(errs, fmt.Errorf("base URL %q must be an absolute http or https URL", c.Crawl.BaseURL))
+	}
+	if c.Crawl.URL != "" && !isAbsoluteURL(c.Crawl.URL) {
+		errs = append(errs, fmt.Errorf("crawl URL %q must be an absolute http or https URL", c.Crawl.URL))
+	}
+	if c.Crawl.BookLimit < 1 {
+		errs = append(errs, fmt.Errorf("book limit must be at least 1, got %d", c.Crawl.BookLimit))
+	}
+	if time.Duration(c.Crawl.Interval) < minCrawlInterval {
+		errs = append(errs, fmt.Errorf("crawl interval must be at least %s, got %s", minCrawlInterval, time.Duration(c.Crawl.Interval)))
+	}
+	if c.Crawl.Offline && c.Crawl.RecordFixtures {
+		errs = append(errs, errors.New("offline replays the recorded pages, so it can't record them too"))
+	}
+	if (c.Crawl.Offline || c.Crawl.RecordFixtures) && c.Crawl.Fixtures == "" {
+		errs = append(errs, errors.New("fixtures directory must be set to replay or record pages"))
+	}
+	if c.Crawl.PageCache == "" {
+		errs = append(errs, errors.New(`page cache must be "store", "off" or a directory`))
+	}
+	for pageType, maxAge := range c.Crawl.CacheMaxAge {
+		if !slices.Contains(pageTypes, pageType) {
+			errs = append(errs, fmt.Errorf("unknown page type %q in cache max-age, expected one of %v", pageType, pageTypes))
+		} else if maxAge < 0 {
+			errs = append(errs, fmt.Errorf("cache max-age of %s pages can't be negative", pageType))
+		}
+	}
+	if c.Crawl.Workers < 1 {
+		errs = append(errs, fmt.Errorf("crawl workers must be at least 1, got %d", c.Crawl.Workers))
+	}
+	if c.Crawl.HostConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("host concurrency must be at least 1, got %d", c.Crawl.HostConcurrency))
+	}
+	if c.Crawl.BlockBackoff <= 0 || c.Crawl.BlockBackoffMax < c.Crawl.BlockBackoff {
+		errs = append(errs, fmt.Errorf("block backoff must be positive and at most the maximum backoff, got %s and %s",
+			time.Duration(c.Crawl.BlockBackoff), time.Duration(c.Crawl.BlockBackoffMax)))
+	}
+	if c.Crawl.AlertWebhook != "" && !isAbsoluteURL(c.Crawl.AlertWebhook) {
+		errs = append(errs, errors.New("alert webhook must be an absolute http or https URL"))
+	}
+	for _, proxy := range c.Network.Proxies {
+		if u, err := url.Parse(proxy); err != nil || !slices.Contains(proxySchemes, u.Scheme) || u.Host == "" {
+			errs = append(errs, fmt.Errorf("proxy %q must be a URL with one of the schemes %v", redactURL(proxy), proxySchemes))
+		}
+	}
+	if len(c.Network.Proxies) > 0 && c.Network.ProxyCheckInterval <= 0 {
+		errs = append(errs, errors.New("proxy check interval must be positive"))
+	}
+	for host, address := range c.Network.DNSOverride {
+		if _, _, err := net.SplitHostPort(address); err == nil || address == "" {
+			errs = append(errs, fmt.Errorf("DNS override of %s must be a host or IP address without a port, got %q", host, address))
+		}
+	}
+	if c.Network.BindAddress != "" && net.ParseIP(c.Network.BindAddress) == nil {
+		errs = append(errs, fmt.Errorf("bind address %q must be an IP address", c.Network.BindAddress))
+	}
+	if c.Database.URI != memoryDatabaseURI && !strings.HasPrefix(c.Database.URI, "mongodb://") && !strings.HasPrefix(c.Database.URI, "mongodb+srv://") {
+		errs = append(errs, errors.New("MongoDB URI must start with mongodb:// or mongodb+srv://, or be "+memoryDatabaseURI))
+	}
+	if c.Database.Name == "" || strings.ContainsAny(c.Database.Name, `/\. "$`) {
+		errs = append(errs, fmt.Errorf("invalid database name %q", c.Database.Name))
+	}
+	if c.Database.Collection == "" || strings.Contains(c.Database.Collection, "$") {
+		errs = append(errs, fmt.Errorf("invalid collection name %q", c.Database.Collection))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("invalid log level %q", c.Log.Level))
+	}
+	if format := strings.ToLower(c.Log.Format); format != "text" && format != "json" {
+		errs = append(errs, fmt.Errorf("invalid log format %q", c.Log.Format))
+	}
+	for _, exporter := range strings.Split(c.Tracing.Exporters, ",") {
+		switch strings.ToLower(strings.TrimSpace(exporter)) {
+		case "", "none", "otlp", "stdout":
+		default:
+			errs = append(errs, fmt.Errorf("invalid trace exporter %q", exporter))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isAbsoluteURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Redacted returns a copy safe to show: the MongoDB and proxy passwords, the alert webhook and the
+// user passwords are masked
+func (c Config) Redacted() Config {
+	c.Database.URI = redactURL(c.Database.URI)
+	proxies := make([]string, len(c.Network.Proxies))
+	for i, proxy := range c.Network.Proxies {
+		proxies[i] = redactURL(proxy)
+	}
+	c.Network.Proxies = proxies
+	if c.Crawl.AlertWebhook != "" {
+		// Webhook URLs carry their secret in the path
+		c.Crawl.AlertWebhook = "xxxxx"
+	}
+	names := make([]string, 0)
+	for name := range parseUsers(c.Users) {
+		names = append(names, name+":xxxxx")
+	}
+	sort.Strings(names)
+	c.Users = strings.Join(names, ",")
+	return c
+}
+
+// redactURL masks the password of a URL, or all of it when it can't be parsed
+func redactURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "xxxxx"
+	}
+	return u.Redacted()
+}
+
+// dependencies are what the configuration builds once for a command: the store, the crawler and
+// the users. Commands hand them to the crawls and the server
+type dependencies struct {
+	store   BookStore
+	crawler *crawler
+	users   map[string]string
+}
+
+// applyConfig sets up logging and tracing from cfg and builds the store, the crawler and the users
+// it asks for. The returned function closes the store and flushes pending spans on shutdown
+func applyConfig(cfg Config) (dependencies, func(context.Context) error, error) {
+	if err := setupLogging(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		return dependencies{}, nil, err
+	}
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing.Exporters, os.Stdout)
+	if err != nil {
+		return dependencies{}, nil, err
+	}
+	store, closeStore, err := openStore(cfg.Database)
+	if err != nil {
+		shutdownTracing(context.Background())
+		return dependencies{}, nil, err
+	}
+	shutdown := func(ctx context.Context) error {
+		return errors.Join(closeStore(ctx), shutdownTracing(ctx))
+	}
+	c, err := newCrawler(cfg, store)
+	if err != nil {
+		shutdown(context.Background())
+		return dependencies{}, nil, err
+	}
+	return dependencies{store: store, crawler: c, users: parseUsers(cfg.Users)}, shutdown, nil
+}
+
+// openStore builds the store config asks for and the function closing it. The in-memory store
+// has nothing to close, and its data is lost on exit
+func openStore(config DatabaseConfig) (BookStore, func(context.Context) error, error) {
+	if config.URI == memoryDatabaseURI {
+		return newMemoryStore(), func(context.Context) error { return nil }, nil
+	}
+	mongo, err := newMongoStore(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mongo, mongo.Close, nil
+}
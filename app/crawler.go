@@ -1,53 +1,541 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gocolly/colly/v2"
 )
 
-// fetchPopularBooks scrapes the RoyalRoad website for popular books
-// and returns the top 10 books with their titles and links
-func fetchPopularBooks() ([]Book, error) {
-	// Map of kinds and their corresponding URLs
-	crawlURLs := map[string]string{
-		"popular": "https://www.royalroad.com/fictions/active-popular",
+// defaultRoyalRoadURL is the real site
+const defaultRoyalRoadURL = "https://www.royalroad.com"
+
+// rankingLists names the lists we track; where each is crawled from is configured in CrawlConfig
+var rankingLists = []string{"popular"}
+
+var fictionIDPattern = regexp.MustCompile(`^/fiction/(\d+)`)
+
+// listPageLimit caps how many pages of a ranking list one crawl follows
+const listPageLimit = 20
+
+// crawlDelay is the minimum time between two requests to RoyalRoad, shared by every collector
+const crawlDelay = time.Second
+
+// crawler is what every crawl shares, built once from the configuration
+type crawler struct {
+	// store keeps what the crawls find
+	store BookStore
+	// baseURL is where every crawled page lives
+	baseURL string
+	// transport carries the requests of every collector through the fixtures or the page cache,
+	// then past the block check to the network
+	transport http.RoundTripper
+	// blocks keeps the hosts that challenged the crawler
+	blocks *blockRegistry
+	// limiter spaces out requests across all collectors so detail crawls stay polite
+	limiter *rateLimiter
+	// workers is how many fiction pages, and how many chapter pages, a detail crawl fetches at once
+	workers int
+	// hosts caps the requests in flight to each host across every collector, whatever the
+	// number of workers, so a wide crawl doesn't get us banned
+	hosts *hostLimiter
+
+	// statuses keeps the last fetch status of every URL crawled since startup
+	statuses *statusRegistry
+	// runs keeps the last crawl of every ranking list since startup
+	runs *crawlRunRegistry
+	// progress keeps the last detail crawl of every list since startup
+	progress *progressRegistry
+	// archiveMu keeps the crawl and newly requested archives from fetching the same chapters at once
+	archiveMu sync.Mutex
+	// active holds the lists being crawled, so a list is only crawled once at a time
+	active *activeCrawls
+	// detached waits for the detail crawls left running in the background
+	detached sync.WaitGroup
+}
+
+// newCrawler builds the crawler the crawl and network settings ask for, saving what it finds to store
+func newCrawler(cfg Config, store BookStore) (*crawler, error) {
+	network, _, err := newNetworkTransport(cfg.Network, cfg.Crawl.BaseURL)
+	if err != nil {
+		return nil, err
 	}
-	return fetchBooks(crawlURLs["popular"])
+	delay := crawlDelay
+	if cfg.Crawl.Offline {
+		// Replayed pages come from disk, so there is no one to be polite to
+		delay = 0
+	}
+	blocks := newBlockRegistry(cfg.Crawl)
+	return &crawler{
+		store:     store,
+		baseURL:   cfg.Crawl.BaseURL,
+		transport: newCrawlTransport(cfg.Crawl, store, blockTransport{blocks: blocks, next: network}),
+		blocks:    blocks,
+		limiter:   &rateLimiter{delay: delay},
+		workers:   cfg.Crawl.Workers,
+		hosts:     newHostLimiter(cfg.Crawl.HostConcurrency),
+		statuses:  &statusRegistry{statuses: make(map[string]FetchStatus)},
+		runs:      &crawlRunRegistry{runs: make(map[string]CrawlRun)},
+		progress:  &progressRegistry{runs: make(map[string]CrawlProgress)},
+		active:    &activeCrawls{lists: make(map[string]bool)},
+	}, nil
 }
 
-func fetchBooks(crawlUrl string) ([]Book, error) {
-	c := colly.NewCollector()
+// fictionLink returns the page of a fiction on the crawled site
+func (c *crawler) fictionLink(fictionID string) string {
+	return c.baseURL + "/fiction/" + fictionID
+}
 
-	var books []Book
+// rateLimiter hands out request slots at least delay apart
+type rateLimiter struct {
+	delay time.Duration
 
-	c.OnHTML(".fiction-list-item", func(e *colly.HTMLElement) {
-		title := e.ChildText(".fiction-title")
-		link := e.ChildAttr(".fiction-title a", "href")
-		if title != "" && link != "" {
-			books = append(books, Book{
-				Title: title,
-				Link:  "https://www.royalroad.com" + link,
-			})
+	mu   sync.Mutex
+	next time.Time
+}
+
+// Wait blocks until the caller's slot comes up
+func (l *rateLimiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.delay)
+	l.mu.Unlock()
+	time.Sleep(time.Until(slot))
+}
+
+// newCollector returns a collector whose requests go through the crawler's transport, its rate
+// limiter and its per-host limits, are cancelled with ctx and are logged with the crawl run of ctx.
+// Challenge pages fail with a BlockedError
+func (c *crawler) newCollector(ctx context.Context) *colly.Collector {
+	logger := loggerFrom(ctx)
+	collector := colly.NewCollector()
+	collector.WithTransport(limitedTransport{ctx: ctx, hosts: c.hosts, next: c.transport})
+	collector.OnRequest(func(r *colly.Request) {
+		if ctx.Err() != nil {
+			r.Abort()
+			return
 		}
+		c.limiter.Wait()
+		logger.Debug("Fetching page", "url", r.URL.String())
+	})
+	// Registered after the rate limiter so spans time the fetch, not the wait
+	traceFetches(ctx, collector)
+	collector.OnResponse(func(r *colly.Response) {
+		logger.Debug("Fetched page", "url", r.Request.URL.String(), "status", r.StatusCode, "bytes", len(r.Body))
+		recordPageFetch(r.StatusCode)
+	})
+	collector.OnError(func(r *colly.Response, err error) {
+		recordPageFetch(r.StatusCode)
+		logger.Warn("Failed to fetch page", "url", r.Request.URL.String(), "status", r.StatusCode, "error", err)
 	})
+	return collector
+}
+
+// FetchStatus is the outcome of fetching one URL. StatusCode is 0 when no response arrived
+type FetchStatus struct {
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	FetchedAt  time.Time `json:"fetched_at"`
+}
+
+type statusRegistry struct {
+	mu       sync.RWMutex
+	statuses map[string]FetchStatus
+}
+
+func (s *statusRegistry) record(status FetchStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[status.URL] = status
+}
 
-	err := c.Visit(crawlUrl)
+// list returns the statuses by URL
+func (s *statusRegistry) list() []FetchStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	statuses := make([]FetchStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URL < statuses[j].URL
+	})
+	return statuses
+}
+
+// CrawlRun is the outcome of the last crawl of a ranking list
+type CrawlRun struct {
+	List       string    `json:"list"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Books      int       `json:"books"`
+	Error      string    `json:"error,omitempty"`
+}
+
+type crawlRunRegistry struct {
+	mu   sync.RWMutex
+	runs map[string]CrawlRun
+}
+
+func (c *crawlRunRegistry) record(run CrawlRun) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.runs[run.List] = run
+}
+
+// list returns the last run of every list by list name
+func (c *crawlRunRegistry) list() []CrawlRun {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	runs := make([]CrawlRun, 0, len(c.runs))
+	for _, run := range c.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].List < runs[j].List
+	})
+	return runs
+}
+
+// visit fetches link with collector and reports its HTTP status, so callers can tell a missing page
+// from a network failure. A challenge served instead of the page is returned as a *BlockedError
+func (c *crawler) visit(collector *colly.Collector, link string) (FetchStatus, error) {
+	status := FetchStatus{URL: link}
+	collector.OnResponse(func(r *colly.Response) {
+		status.StatusCode = r.StatusCode
+	})
+	collector.OnError(func(r *colly.Response, err error) {
+		if r != nil {
+			status.StatusCode = r.StatusCode
+		}
+	})
+	err := collector.Visit(link)
+	var blocked *BlockedError
+	if errors.As(err, &blocked) {
+		status.StatusCode = blocked.StatusCode
+		err = blocked
+	}
 	if err != nil {
+		status.Error = err.Error()
+	}
+	status.FetchedAt = time.Now().UTC()
+	c.statuses.record(status)
+	return status, err
+}
+
+// crawlStatusHandler returns the last HTTP status of every crawled URL, optionally only
+// the failed ones with ?failed=true
+func (s *server) crawlStatusHandler(w http.ResponseWriter, r *http.Request) {
+	failedOnly := r.URL.Query().Get("failed") == "true"
+	statuses := []FetchStatus{}
+	for _, status := range s.crawler.statuses.list() {
+		if !failedOnly || status.Error != "" {
+			statuses = append(statuses, status)
+		}
+	}
+	writeJSON(w, statuses)
+}
+
+// errCrawlRunning is returned when a list is crawled again before its last crawl is done
+var errCrawlRunning = errors.New("a crawl of this list is already running")
+
+// activeCrawls holds the lists being crawled
+type activeCrawls struct {
+	mu    sync.Mutex
+	lists map[string]bool
+}
+
+// start marks list as being crawled, or reports false when it already is
+func (a *activeCrawls) start(list string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.lists[list] {
+		return false
+	}
+	a.lists[list] = true
+	return true
+}
+
+func (a *activeCrawls) finish(list string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.lists, list)
+}
+
+// fetchPopularBooks scrapes the configured ranking list for popular books
+// and returns the top books with their titles and links. Their fiction and author pages are then
+// crawled under background, and the list isn't crawled again until they are done
+func (c *crawler) fetchPopularBooks(ctx, background context.Context, cfg CrawlConfig) ([]Book, error) {
+	if !c.active.start("popular") {
+		return nil, errCrawlRunning
+	}
+	ctx = startCrawlRun(ctx, "popular")
+	books, err := c.fetchBooks(ctx, "popular", cfg.ListURL(), cfg.BookLimit)
+	if err != nil {
+		c.active.finish("popular")
 		return nil, err
 	}
 
-	if len(books) > 10 {
-		books = books[:10]
+	// Fiction and author pages are crawled in the background so the list is served right away.
+	// The crawl outlives the request that started it but not the process
+	c.detached.Add(1)
+	go func() {
+		defer c.detached.Done()
+		defer c.active.finish("popular")
+		c.crawlDetails(detachCrawlRun(background, ctx), "popular", books)
+	}()
+	return books, nil
+}
+
+// wait waits for the detail crawls left running in the background
+func (c *crawler) wait() {
+	c.detached.Wait()
+}
+
+// crawlDetails crawls the fiction and author pages of the books found on a list and refreshes the archives
+func (c *crawler) crawlDetails(ctx context.Context, list string, books []Book) {
+	defer observeCrawl(list, "details", time.Now())
+	logger := loggerFrom(ctx)
+	tracked, err := c.withTrackedFictions(ctx, books)
+	if err != nil {
+		logger.Error("Failed to load tracked fictions", "error", err)
+		tracked = books
+	}
+	pages := c.crawlFictionPages(ctx, tracked)
+	if ctx.Err() != nil {
+		logger.Warn("Crawl cancelled", "fictions", len(pages))
+		return
 	}
+	if err := c.trackAuthors(ctx, list, books, pages); err != nil {
+		logger.Error("Failed to track authors", "error", err)
+	}
+	if err := c.refreshArchives(ctx, time.Now().UTC()); err != nil {
+		logger.Error("Failed to refresh archives", "error", err)
+	}
+	logger.Info("Finished crawl", "fictions", len(pages))
+}
+
+// storedListAge is how far back storedBooks looks for the last crawl of a list
+const storedListAge = 7 * 24 * time.Hour
 
-	// Save fetched books to MongoDB
-	err = saveBooksWithMetadata(books)
+// storedBooks returns the top limit books of the last crawl of a list saved in the store, for
+// servers that leave crawling to a worker
+func (c *crawler) storedBooks(ctx context.Context, list string, limit int) ([]Book, error) {
+	snapshots, err := c.store.GetSnapshotsSince(ctx, time.Now().Add(-storedListAge))
 	if err != nil {
-		log.Printf("Failed to save books: %v", err)
+		return nil, err
+	}
+	var latest []Snapshot
+	for _, snapshot := range snapshots {
+		if snapshot.List != list {
+			continue
+		}
+		if len(latest) > 0 && snapshot.CrawledAt.After(latest[0].CrawledAt) {
+			latest = latest[:0]
+		}
+		if len(latest) == 0 || snapshot.CrawledAt.Equal(latest[0].CrawledAt) {
+			latest = append(latest, snapshot)
+		}
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].Rank < latest[j].Rank })
+	if len(latest) > limit {
+		latest = latest[:limit]
+	}
+
+	saved, err := c.store.GetBooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Book)
+	for _, book := range saved {
+		byID[book.FictionID] = book
+	}
+	books := make([]Book, 0, len(latest))
+	for _, snapshot := range latest {
+		book, ok := byID[snapshot.FictionID]
+		if !ok {
+			book = Book{Title: snapshot.Title, Link: c.fictionLink(snapshot.FictionID), FictionID: snapshot.FictionID}
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// fetchBooks scrapes one ranking list, records a snapshot of every position crawled
+// and returns the top limit books
+func (c *crawler) fetchBooks(ctx context.Context, list, crawlUrl string, limit int) ([]Book, error) {
+	defer observeCrawl(list, "list", time.Now())
+	logger := loggerFrom(ctx)
+
+	books, err := c.scrapeBooks(ctx, list, crawlUrl, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot every position crawled, not just the top 10, so rank history covers them
+	err = c.store.SaveSnapshots(ctx, snapshotsFromBooks(list, books, time.Now().UTC()))
+	if err != nil {
+		logger.Error("Failed to save snapshots", "error", err)
+	}
+
+	if len(books) > limit {
+		books = books[:limit]
+	}
+
+	// Save fetched books to the store
+	err = c.store.SaveBooks(ctx, books)
+	if err != nil {
+		logger.Error("Failed to save books", "error", err)
 	} else {
-		log.Println("Books saved successfully!")
+		logger.Info("Saved books", "books", len(books))
 	}
 
 	return books, nil
 }
+
+// scrapeBooks fetches one ranking list and returns its books in list order, without saving
+// anything. It follows the list's pagination until it has at least limit books, the list ends or
+// listPageLimit pages were fetched. The outcome is recorded as the last run of the list
+func (c *crawler) scrapeBooks(ctx context.Context, list, crawlUrl string, limit int) ([]Book, error) {
+	start := time.Now()
+	var books []Book
+	var err error
+	link := crawlUrl
+	for page := 1; link != "" && page <= listPageLimit; page++ {
+		var found []Book
+		found, link, err = c.scrapeListPage(ctx, link)
+		books = append(books, found...)
+		if err != nil || len(books) >= limit {
+			break
+		}
+	}
+
+	run := CrawlRun{List: list, StartedAt: start.UTC(), FinishedAt: time.Now().UTC(), Books: len(books)}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	c.runs.record(run)
+	if err != nil {
+		return nil, err
+	}
+	return books, nil
+}
+
+// scrapeListPage fetches one page of a ranking list and returns its books and the link to the next
+// page, "" on the last one
+func (c *crawler) scrapeListPage(ctx context.Context, pageUrl string) ([]Book, string, error) {
+	collector := c.newCollector(ctx)
+
+	var books []Book
+	var next string
+
+	collector.OnHTML(".fiction-list-item", func(e *colly.HTMLElement) {
+		title := e.ChildText(".fiction-title")
+		link := e.ChildAttr(".fiction-title a", "href")
+		if title == "" || link == "" {
+			crawlParseFailures.WithLabelValues("book").Inc()
+		} else {
+			crawlItems.WithLabelValues("book").Inc()
+			book := Book{
+				Title:     title,
+				Link:      c.baseURL + link,
+				FictionID: fictionIDFromLink(link),
+			}
+			parseListStats(e, &book)
+			parseListMetadata(e, &book)
+			books = append(books, book)
+		}
+	})
+	// Page links carry their number, the next one being one past the page shown
+	collector.OnHTML(".pagination a[data-page]", func(e *colly.HTMLElement) {
+		current, err := strconv.Atoi(e.Request.URL.Query().Get("page"))
+		if err != nil {
+			current = 1
+		}
+		if e.Attr("data-page") == strconv.Itoa(current+1) {
+			next = e.Request.AbsoluteURL(e.Attr("href"))
+		}
+	})
+
+	if _, err := c.visit(collector, pageUrl); err != nil {
+		return nil, "", err
+	}
+	return books, next, nil
+}
+
+// parseListStats reads the followers, views and rating shown under a list entry
+func parseListStats(e *colly.HTMLElement, book *Book) {
+	e.ForEach(".stats span", func(_ int, span *colly.HTMLElement) {
+		text := strings.TrimSpace(span.Text)
+		switch {
+		case strings.HasSuffix(text, "Followers"):
+			book.Followers = parseCount(strings.TrimSuffix(text, "Followers"))
+		case strings.HasSuffix(text, "Views"):
+			book.Views = parseCount(strings.TrimSuffix(text, "Views"))
+		}
+	})
+	if rating, err := strconv.ParseFloat(e.ChildAttr(".stats .star", "title"), 64); err == nil {
+		book.Rating = rating
+	}
+}
+
+// parseListMetadata reads the tags and synopsis of a list entry
+func parseListMetadata(e *colly.HTMLElement, book *Book) {
+	e.ForEach(".tags .fiction-tag", func(_ int, tag *colly.HTMLElement) {
+		if name := strings.TrimSpace(tag.Text); name != "" {
+			book.Tags = append(book.Tags, name)
+		}
+	})
+	book.Synopsis = strings.TrimSpace(e.ChildText("div[id^='description-']"))
+}
+
+// parseCount turns a RoyalRoad counter such as "12,345" into an int, returning 0 when unreadable
+func parseCount(text string) int {
+	n, err := strconv.Atoi(strings.ReplaceAll(strings.TrimSpace(text), ",", ""))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// fictionIDFromLink extracts the numeric fiction ID from a "/fiction/{id}/{slug}" path
+func fictionIDFromLink(link string) string {
+	match := fictionIDPattern.FindStringSubmatch(link)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// snapshotsFromBooks ranks books in list order, skipping entries without a fiction ID
+func snapshotsFromBooks(list string, books []Book, crawledAt time.Time) []Snapshot {
+	var snapshots []Snapshot
+	for i, book := range books {
+		if book.FictionID == "" {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			FictionID: book.FictionID,
+			Title:     book.Title,
+			List:      list,
+			Rank:      i + 1,
+			Followers: book.Followers,
+			Views:     book.Views,
+			Rating:    book.Rating,
+			CrawledAt: crawledAt,
+		})
+	}
+	return snapshots
+}
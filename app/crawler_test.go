@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -20,8 +20,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// testCrawler is a crawler of the real site saving to store, without a page cache. Tests crawl
+// local servers, so there is no need to space out requests
+func testCrawler(t *testing.T, store BookStore) *crawler {
+	cfg := defaultConfig()
+	cfg.Crawl.PageCache = pageCacheOff
+	c, err := newCrawler(cfg, store)
+	require.NoError(t, err)
+	c.limiter.delay = 0
+	return c
+}
+
 // Setup function for tests with MongoDB testcontainer
-func setupTestWithMongoDB(t *testing.T) (*mongodb.MongoDBContainer, *mongo.Client, func()) {
+func setupTestWithMongoDB(t *testing.T) (context.Context, *crawler, *mongo.Client, func()) {
 
 	// Create MongoDB container
 	ctx := context.Background()
@@ -39,8 +50,8 @@ func setupTestWithMongoDB(t *testing.T) (*mongodb.MongoDBContainer, *mongo.Clien
 	mongodbURI, err := mongodbContainer.ConnectionString(ctx)
 	require.NoError(t, err)
 
-	// Override environment variable
-	os.Setenv("MONGODB_URI", mongodbURI)
+	// Crawl into a store on the container
+	c := testCrawler(t, testMongoStore(t, mongodbURI))
 
 	// Create a new client directly for tests
 	clientOptions := options.Client().ApplyURI(mongodbURI)
@@ -48,25 +59,22 @@ func setupTestWithMongoDB(t *testing.T) (*mongodb.MongoDBContainer, *mongo.Clien
 	require.NoError(t, err)
 
 	// Clear the test database
-	err = testClient.Database(dbName).Drop(ctx)
+	err = testClient.Database(defaultConfig().Database.Name).Drop(ctx)
 	require.NoError(t, err)
 
 	// Return a cleanup function
 	cleanup := func() {
 		testClient.Disconnect(ctx)
 		mongodbContainer.Terminate(ctx)
-
-		// Clear environment variable
-		os.Unsetenv("MONGODB_URI")
 	}
 
-	return mongodbContainer, testClient, cleanup
+	return ctx, c, testClient, cleanup
 }
 
 // Helper function to verify saved books in MongoDB
 func verifyBooksInMongoDB(t *testing.T, testClient *mongo.Client, expectedBooks []Book) {
 	ctx := context.Background()
-	collection := testClient.Database(dbName).Collection(collectionName)
+	collection := testClient.Database(defaultConfig().Database.Name).Collection(defaultConfig().Database.Collection)
 
 	var savedBooks []Book
 	cursor, err := collection.Find(ctx, bson.M{})
@@ -96,7 +104,7 @@ func verifyBooksInMongoDB(t *testing.T, testClient *mongo.Client, expectedBooks
 
 // Test successfully fetching books (happy path)
 func TestFetchBooks_Success(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
+	ctx, c, testClient, cleanup := setupTestWithMongoDB(t)
 	defer cleanup()
 
 	// Create a test server with mock HTML content
@@ -119,7 +127,7 @@ func TestFetchBooks_Success(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := c.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// Assertions
 	assert.NoError(t, err)
@@ -135,7 +143,7 @@ func TestFetchBooks_Success(t *testing.T) {
 
 // Test that we only return a maximum of 10 books
 func TestFetchBooks_MaximumTenBooks(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
+	ctx, c, testClient, cleanup := setupTestWithMongoDB(t)
 	defer cleanup()
 
 	// Create HTML with 15 books
@@ -160,7 +168,7 @@ func TestFetchBooks_MaximumTenBooks(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := c.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// Assertions
 	assert.NoError(t, err)
@@ -176,7 +184,7 @@ func TestFetchBooks_MaximumTenBooks(t *testing.T) {
 
 // Test handling empty response still works
 func TestFetchBooks_EmptyResponse(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
+	ctx, c, testClient, cleanup := setupTestWithMongoDB(t)
 	defer cleanup()
 
 	// Create a test server with empty HTML
@@ -187,15 +195,14 @@ func TestFetchBooks_EmptyResponse(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := c.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// Assertions
 	assert.NoError(t, err) // No error should be returned
 	assert.Empty(t, books) // Should return empty slice
 
 	// Verify no books were saved in MongoDB
-	ctx := context.Background()
-	collection := testClient.Database(dbName).Collection(collectionName)
+	collection := testClient.Database(defaultConfig().Database.Name).Collection(defaultConfig().Database.Collection)
 	count, err := collection.CountDocuments(ctx, bson.M{})
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), count)
@@ -203,7 +210,7 @@ func TestFetchBooks_EmptyResponse(t *testing.T) {
 
 // Test that we handle and filter items with missing data correctly
 func TestFetchBooks_MissingData(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
+	ctx, c, testClient, cleanup := setupTestWithMongoDB(t)
 	defer cleanup()
 
 	// Create a test server with some items missing titles or links
@@ -232,7 +239,7 @@ func TestFetchBooks_MissingData(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := c.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// Assertions
 	assert.NoError(t, err)
@@ -244,32 +251,127 @@ func TestFetchBooks_MissingData(t *testing.T) {
 	verifyBooksInMongoDB(t, testClient, books)
 }
 
+// Test that list stats and metadata are parsed and every position on the list is snapshotted
+func TestFetchBooks_StatsAndSnapshots(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+
+	// Build a list longer than the 10 books we return
+	var htmlBuilder strings.Builder
+	htmlBuilder.WriteString(`<!DOCTYPE html><html><body>`)
+	for i := 1; i <= 12; i++ {
+		htmlBuilder.WriteString(fmt.Sprintf(`
+			<div class="fiction-list-item">
+				<h2 class="fiction-title"><a href="/fiction/%d/test-book-%d">Test Book %d</a></h2>
+				<span class="tags"><a class="fiction-tag">Fantasy</a><a class="fiction-tag">LitRPG</a></span>
+				<div class="row stats">
+					<div class="col-sm-6"><span>%d,000 Followers</span></div>
+					<div class="col-sm-6"><span title="4.5%d" class="star"></span></div>
+					<div class="col-sm-6"><span>1,234,567 Views</span></div>
+				</div>
+				<div id="description-%d" class="hidden-content"><p>A synopsis.</p></div>
+			</div>
+		`, i, i, i, i, i%10, i))
+	}
+	htmlBuilder.WriteString(`</body></html>`)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(htmlBuilder.String()))
+	}))
+	defer server.Close()
+
+	books, err := s.crawler.fetchBooks(ctx, "popular", server.URL, 10)
+
+	assert.NoError(t, err)
+	require.Equal(t, 10, len(books))
+	assert.Equal(t, "1", books[0].FictionID)
+	assert.Equal(t, 1000, books[0].Followers)
+	assert.Equal(t, 1234567, books[0].Views)
+	assert.Equal(t, 4.51, books[0].Rating)
+	assert.Equal(t, []string{"Fantasy", "LitRPG"}, books[0].Tags)
+	assert.Equal(t, "A synopsis.", books[0].Synopsis)
+
+	// Positions beyond the top 10 are still recorded in the history
+	snapshots, err := memory.GetSnapshots(ctx, "12")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshots))
+	assert.Equal(t, "popular", snapshots[0].List)
+	assert.Equal(t, 12, snapshots[0].Rank)
+	assert.Equal(t, 12000, snapshots[0].Followers)
+}
+
+// Test parsing the recorded list without a store
+func TestScrapeBooks_Fixtures(t *testing.T) {
+	ctx := context.Background()
+	c := testCrawler(t, newMemoryStore())
+	replayFixtures(c)
+
+	books, err := c.scrapeBooks(ctx, "popular", defaultConfig().Crawl.ListURL(), 10)
+
+	require.NoError(t, err)
+	require.Equal(t, 3, len(books))
+	assert.Equal(t, Book{
+		Title:     "The Clockwork Archive",
+		Link:      "https://www.royalroad.com/fiction/41001/the-clockwork-archive",
+		FictionID: "41001",
+		Followers: 12345,
+		Views:     1234567,
+		Rating:    4.72,
+		Tags:      []string{"Fantasy", "Mystery", "Progression"},
+		Synopsis:  "A junior archivist finds a ledger that rewrites itself every night, and the city it describes starts to follow.",
+	}, books[0])
+	assert.Equal(t, "Ashen Crown", books[1].Title)
+	assert.Equal(t, "41003", books[2].FictionID)
+}
+
+// Test crawling the recorded list and its detail pages end to end
+func TestCrawlList_Fixtures(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	replayFixtures(s.crawler)
+
+	books, err := s.crawler.crawlList(ctx, "popular", defaultConfig().Crawl, true)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+	snapshots, err := memory.GetSnapshots(ctx, "41002")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(snapshots))
+	assert.Equal(t, 2, snapshots[0].Rank)
+	reviews, err := memory.GetReviews(ctx, "41001")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reviews))
+	chapters, err := memory.GetChapters(ctx, "41003")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(chapters))
+	assert.Equal(t, 24, chapters[0].WordCount, "chapter pages are measured")
+}
+
 // Negative Tests
 
 // Test handling invalid URLs
 func TestFetchBooks_InvalidURL(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
-	defer cleanup()
+	s, memory := testServer(t)
+	ctx := context.Background()
 
 	// Call with an invalid URL
-	books, err := fetchBooks("not-a-valid-url")
+	books, err := s.crawler.fetchBooks(ctx, "popular", "not-a-valid-url", 10)
 
 	// Assertions
 	assert.Error(t, err)
 	assert.Nil(t, books)
 
-	// Verify no books were saved in MongoDB
-	ctx := context.Background()
-	collection := testClient.Database(dbName).Collection(collectionName)
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	// Verify no books were saved
+	saved, err := memory.GetBooks(ctx)
 	require.NoError(t, err)
-	assert.Equal(t, int64(0), count)
+	assert.Empty(t, saved)
 }
 
 // Test handling server errors
 func TestFetchBooks_ServerError(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
-	defer cleanup()
+	s, memory := testServer(t)
+	ctx := context.Background()
 
 	// Create a test server that returns an error
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -278,7 +380,7 @@ func TestFetchBooks_ServerError(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := s.crawler.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// The colly library doesn't always return an error for HTTP status codes,
 	// so we may just get an empty slice
@@ -289,18 +391,16 @@ func TestFetchBooks_ServerError(t *testing.T) {
 		assert.Empty(t, books)
 	}
 
-	// Verify no books were saved in MongoDB
-	ctx := context.Background()
-	collection := testClient.Database(dbName).Collection(collectionName)
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	// Verify no books were saved
+	saved, err := memory.GetBooks(ctx)
 	require.NoError(t, err)
-	assert.Equal(t, int64(0), count)
+	assert.Empty(t, saved)
 }
 
 // Test that the function doesn't crash with malformed HTML
 func TestFetchBooks_MalformedHTML(t *testing.T) {
-	_, testClient, cleanup := setupTestWithMongoDB(t)
-	defer cleanup()
+	s, memory := testServer(t)
+	ctx := context.Background()
 
 	// Create a test server with malformed HTML
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -310,16 +410,73 @@ func TestFetchBooks_MalformedHTML(t *testing.T) {
 	defer server.Close()
 
 	// Call the function we're testing
-	books, err := fetchBooks(server.URL)
+	books, err := s.crawler.fetchBooks(ctx, "popular", server.URL, 10)
 
 	// Assertions - should handle malformed HTML gracefully
 	assert.NoError(t, err)
 	assert.Empty(t, books) // Either empty or nil books
 
-	// Verify no books were saved in MongoDB
-	ctx := context.Background()
-	collection := testClient.Database(dbName).Collection(collectionName)
-	count, err := collection.CountDocuments(ctx, bson.M{})
+	// Verify no books were saved
+	saved, err := memory.GetBooks(ctx)
 	require.NoError(t, err)
-	assert.Equal(t, int64(0), count)
+	assert.Empty(t, saved)
+}
+
+// Test that the shared limiter spaces out request slots
+func TestRateLimiter(t *testing.T) {
+	limiter := &rateLimiter{delay: 20 * time.Millisecond}
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		limiter.Wait()
+	}
+
+	// The first slot is immediate, the next two wait one delay each
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+// stalledDetailsSite serves the lists of a fake site and holds its fiction pages until their
+// request is cancelled, so a detail crawl runs until it is stopped. It counts the list requests
+func stalledDetailsSite(t *testing.T, c *crawler) (CrawlConfig, *atomic.Int32) {
+	site := newFakeSite(fakeSiteOptions{Seed: 1, Fictions: 3, PageSize: 20, Layout: "current"})
+	var lists atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fiction/") {
+			<-r.Context().Done()
+			return
+		}
+		lists.Add(1)
+		site.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	c.baseURL = server.URL
+
+	cfg := defaultConfig().Crawl
+	cfg.BaseURL = server.URL
+	return cfg, &lists
+}
+
+// Test that a list isn't crawled again while its detail crawl runs, and that the detail crawl
+// stops with the background context rather than the request
+func TestFetchPopularBooks_OneCrawlAtATime(t *testing.T) {
+	s, _ := testServer(t)
+	cfg, lists := stalledDetailsSite(t, s.crawler)
+	request, cancelRequest := context.WithCancel(context.Background())
+	background, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	books, err := s.crawler.fetchPopularBooks(request, background, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+	cancelRequest()
+
+	_, err = s.crawler.fetchPopularBooks(context.Background(), background, cfg)
+	assert.ErrorIs(t, err, errCrawlRunning)
+	assert.Equal(t, int32(1), lists.Load(), "the list isn't fetched again")
+
+	stop()
+	s.crawler.wait()
+	_, err = s.crawler.fetchPopularBooks(context.Background(), background, cfg)
+	assert.NoError(t, err, "the list can be crawled once the last crawl is done")
+	s.crawler.wait()
 }
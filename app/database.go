@@ -3,8 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -13,73 +11,623 @@ import (
 )
 
 const (
-	dbName         = "royalRoadBooks"
-	collectionName = "books"
+	snapshotCollectionName = "snapshots"
+	followCollectionName   = "follows"
+
+	authorCollectionName       = "authors"
+	authorFollowCollectionName = "author_follows"
+	notificationCollectionName = "notifications"
+	reviewCollectionName       = "reviews"
+	chapterCollectionName      = "chapters"
+	progressCollectionName     = "reading_progress"
+
+	archiveCollectionName         = "archives"
+	archivedChapterCollectionName = "archived_chapters"
+	statusEventCollectionName     = "status_events"
+
+	pageCacheCollectionName = "page_cache"
 )
 
-var client *mongo.Client
+// operationTimeout bounds every database operation but exports
+const operationTimeout = 10 * time.Second
 
-func ConnectDB() {
-	mongoURI := os.Getenv("MONGODB_URI")
+// exportTimeout bounds streaming a whole collection out for a dump
+const exportTimeout = time.Hour
+
+// pingTimeout bounds health checks, which must answer quickly even when MongoDB is down
+const pingTimeout = 3 * time.Second
+
+// mongoStore is the BookStore backed by MongoDB
+type mongoStore struct {
+	config DatabaseConfig
+	// client is shared by every operation and copy of the store. It pools its own connections,
+	// so concurrent callers can use it safely
+	client *mongo.Client
+}
 
-	var err error
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err = mongo.Connect(ctx, clientOptions)
+// newMongoStore creates the client of the store. The client connects lazily, so a server that is
+// down only fails the operations, which report it
+func newMongoStore(config DatabaseConfig) (mongoStore, error) {
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(config.URI))
 	if err != nil {
-		log.Fatal(err)
+		return mongoStore{}, fmt.Errorf("failed to connect to MongoDB: %v", err)
 	}
-	err = client.Ping(context.TODO(), nil)
+	return mongoStore{config: config, client: client}, nil
+}
+
+// Close disconnects the client, waiting for the operations in progress until ctx is done
+func (s mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+// withDatabase runs fn on the store's database. The operation names fn in the database metrics
+// and in its span under ctx. fn passes ctx to every driver call, so the operation
+// stops at operationTimeout or when ctx is cancelled
+func (s mongoStore) withDatabase(ctx context.Context, operation string, fn func(ctx context.Context, db *mongo.Database) error) error {
+	return s.withDatabaseTimeout(ctx, operation, operationTimeout, fn)
+}
+
+// writeDatabase is withDatabase for writes, which aren't cancelled with ctx so they finish even
+// when the request that made them is gone. They still stop at operationTimeout
+func (s mongoStore) writeDatabase(ctx context.Context, operation string, fn func(ctx context.Context, db *mongo.Database) error) error {
+	return s.withDatabaseTimeout(context.WithoutCancel(ctx), operation, operationTimeout, fn)
+}
+
+// withDatabaseTimeout is withDatabase with a longer time limit for operations over a whole collection
+func (s mongoStore) withDatabaseTimeout(ctx context.Context, operation string, timeout time.Duration, fn func(ctx context.Context, db *mongo.Database) error) error {
+	return traceDB(ctx, s.config.Name, operation, func(ctx context.Context) error {
+		return observeDB(operation, func() error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return fn(ctx, s.client.Database(s.config.Name))
+		})
+	})
+}
+
+// storedBook is a book as written to MongoDB, tagged with the schema it was written with
+type storedBook struct {
+	Book          `bson:",inline"`
+	SchemaVersion int `bson:"schema_version"`
+}
+
+// bookFilter matches the document of a book: by fiction ID, or by link for books without one
+func bookFilter(book Book) bson.M {
+	if book.FictionID != "" {
+		return bson.M{"fiction_id": book.FictionID}
+	}
+	return bson.M{"link": book.Link}
+}
+
+// saveBooksWithMetadata upserts one document per fiction with the latest metadata. The crawl
+// history of each fiction is kept in its snapshots
+func (s mongoStore) saveBooksWithMetadata(ctx context.Context, books []Book) error {
+	return s.writeDatabase(ctx, "saveBooksWithMetadata", func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(s.config.Collection)
+		for _, book := range books {
+			doc := storedBook{Book: book, SchemaVersion: bookSchemaVersion}
+			_, err := collection.ReplaceOne(ctx, bookFilter(book), doc, options.Replace().SetUpsert(true))
+			if err != nil {
+				return fmt.Errorf("failed to save book: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// getBooksWithMetadata retrieves every saved book with its metadata
+func (s mongoStore) getBooksWithMetadata(ctx context.Context) ([]Book, error) {
+	var books []Book
+	err := s.withDatabase(ctx, "getBooksWithMetadata", func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(s.config.Collection).Find(ctx, bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to find books: %v", err)
+		}
+		if err = cursor.All(ctx, &books); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	fmt.Println("Connected to MongoDB!")
+	return books, nil
+}
+
+func (s mongoStore) SaveBooks(ctx context.Context, books []Book) error {
+	return s.saveBooksWithMetadata(ctx, books)
 }
 
-// isInTestEnvironment checks if we're running in a test environment
-func isInTestEnvironment() bool {
-	for _, arg := range os.Args {
-		if arg == "-test.v" || arg == "-test.run" {
-			return true
+func (s mongoStore) GetBooks(ctx context.Context) ([]Book, error) {
+	var books []Book
+	err := s.withDatabase(ctx, "GetBooks", func(ctx context.Context, db *mongo.Database) error {
+		// Object IDs grow with insertion time, so books come in the order they were first saved.
+		// Stores not migrated yet may still hold a copy per crawl, the last being the latest metadata
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+		cursor, err := db.Collection(s.config.Collection).Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find books: %v", err)
 		}
+		if err = cursor.All(ctx, &books); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return false
+	return latestBooks(books), nil
+}
+
+func (s mongoStore) Ping(ctx context.Context) error {
+	return s.withDatabase(ctx, "Ping", func(ctx context.Context, db *mongo.Database) error {
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+		if err := db.Client().Ping(ctx, nil); err != nil {
+			return fmt.Errorf("failed to ping MongoDB: %v", err)
+		}
+		return nil
+	})
 }
 
-func saveBooksWithMetadata(books []Book) error {
-	ConnectDB()
-	// Only disconnect if not in a test environment
-	if !isInTestEnvironment() {
-		defer client.Disconnect(context.TODO())
+func (s mongoStore) SaveSnapshots(ctx context.Context, snapshots []Snapshot) error {
+	if len(snapshots) == 0 {
+		return nil
 	}
+	return s.writeDatabase(ctx, "SaveSnapshots", func(ctx context.Context, db *mongo.Database) error {
+		docs := make([]interface{}, len(snapshots))
+		for i, snapshot := range snapshots {
+			docs[i] = snapshot
+		}
+		if _, err := db.Collection(snapshotCollectionName).InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("failed to insert snapshots: %v", err)
+		}
+		return nil
+	})
+}
 
-	collection := client.Database(dbName).Collection(collectionName)
-	for _, book := range books {
-		_, err := collection.InsertOne(context.TODO(), bson.M{
-			"title": book.Title,
-			"link":  book.Link,
-		})
+func (s mongoStore) GetSnapshots(ctx context.Context, fictionID string) ([]Snapshot, error) {
+	return s.findSnapshots(ctx, bson.M{"fiction_id": fictionID})
+}
+
+func (s mongoStore) GetSnapshotsSince(ctx context.Context, since time.Time) ([]Snapshot, error) {
+	return s.findSnapshots(ctx, bson.M{"crawled_at": bson.M{"$gte": since}})
+}
+
+func (s mongoStore) findSnapshots(ctx context.Context, filter bson.M) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	err := s.withDatabase(ctx, "findSnapshots", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "crawled_at", Value: 1}})
+		cursor, err := db.Collection(snapshotCollectionName).Find(ctx, filter, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find snapshots: %v", err)
+		}
+		if err = cursor.All(ctx, &snapshots); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return snapshots, err
+}
+
+func (s mongoStore) SaveFollow(ctx context.Context, follow Follow) error {
+	return s.writeDatabase(ctx, "SaveFollow", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": follow.UserID, "fiction_id": follow.FictionID, "kind": follow.Kind}
+		update := bson.M{"$setOnInsert": follow}
+		_, err := db.Collection(followCollectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save follow: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) DeleteFollow(ctx context.Context, userID, fictionID, kind string) error {
+	return s.writeDatabase(ctx, "DeleteFollow", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": userID, "fiction_id": fictionID, "kind": kind}
+		if _, err := db.Collection(followCollectionName).DeleteOne(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete follow: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetFollows(ctx context.Context, userID string) ([]Follow, error) {
+	return s.findFollows(ctx, bson.M{"user_id": userID})
+}
+
+func (s mongoStore) GetAllFollows(ctx context.Context) ([]Follow, error) {
+	return s.findFollows(ctx, bson.M{})
+}
+
+func (s mongoStore) findFollows(ctx context.Context, filter bson.M) ([]Follow, error) {
+	var follows []Follow
+	err := s.withDatabase(ctx, "findFollows", func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(followCollectionName).Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to find follows: %v", err)
+		}
+		if err = cursor.All(ctx, &follows); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return follows, err
+}
+
+func (s mongoStore) SaveAuthorFollow(ctx context.Context, follow AuthorFollow) error {
+	return s.writeDatabase(ctx, "SaveAuthorFollow", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": follow.UserID, "author_id": follow.AuthorID}
+		update := bson.M{"$setOnInsert": follow}
+		_, err := db.Collection(authorFollowCollectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 		if err != nil {
-			return fmt.Errorf("failed to insert book: %v", err)
+			return fmt.Errorf("failed to save author follow: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) DeleteAuthorFollow(ctx context.Context, userID, authorID string) error {
+	return s.writeDatabase(ctx, "DeleteAuthorFollow", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": userID, "author_id": authorID}
+		if _, err := db.Collection(authorFollowCollectionName).DeleteOne(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete author follow: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetAllAuthorFollows(ctx context.Context) ([]AuthorFollow, error) {
+	var follows []AuthorFollow
+	err := s.withDatabase(ctx, "GetAllAuthorFollows", func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(authorFollowCollectionName).Find(ctx, bson.M{})
+		if err != nil {
+			return fmt.Errorf("failed to find author follows: %v", err)
+		}
+		if err = cursor.All(ctx, &follows); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return follows, err
+}
+
+func (s mongoStore) SaveAuthor(ctx context.Context, author Author) error {
+	return s.writeDatabase(ctx, "SaveAuthor", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(authorCollectionName).ReplaceOne(ctx, bson.M{"_id": author.ID}, author, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save author: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetAuthor(ctx context.Context, id string) (*Author, error) {
+	var author *Author
+	err := s.withDatabase(ctx, "GetAuthor", func(ctx context.Context, db *mongo.Database) error {
+		var found Author
+		err := db.Collection(authorCollectionName).FindOne(ctx, bson.M{"_id": id}).Decode(&found)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find author: %v", err)
+		}
+		author = &found
+		return nil
+	})
+	return author, err
+}
+
+func (s mongoStore) GetAuthors(ctx context.Context) ([]Author, error) {
+	var authors []Author
+	err := s.withDatabase(ctx, "GetAuthors", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+		cursor, err := db.Collection(authorCollectionName).Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find authors: %v", err)
+		}
+		if err = cursor.All(ctx, &authors); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
 		}
+		return nil
+	})
+	return authors, err
+}
+
+func (s mongoStore) AddNotifications(ctx context.Context, notifications []Notification) error {
+	if len(notifications) == 0 {
+		return nil
 	}
-	return nil
+	return s.writeDatabase(ctx, "AddNotifications", func(ctx context.Context, db *mongo.Database) error {
+		docs := make([]interface{}, len(notifications))
+		for i, notification := range notifications {
+			docs[i] = notification
+		}
+		if _, err := db.Collection(notificationCollectionName).InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("failed to insert notifications: %v", err)
+		}
+		return nil
+	})
 }
 
-// getBooksWithMetadata retrieves the list of books with their metadata from MongoDB database
-func getBooksWithMetadata() ([]Book, error) {
-	ConnectDB()
-	defer client.Disconnect(context.TODO())
+func (s mongoStore) GetNotifications(ctx context.Context, userID string) ([]Notification, error) {
+	var notifications []Notification
+	err := s.withDatabase(ctx, "GetNotifications", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+		cursor, err := db.Collection(notificationCollectionName).Find(ctx, bson.M{"user_id": userID}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find notifications: %v", err)
+		}
+		if err = cursor.All(ctx, &notifications); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return notifications, err
+}
 
-	var books []Book
-	collection := client.Database(dbName).Collection(collectionName)
-	cursor, err := collection.Find(context.TODO(), bson.M{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to find books: %v", err)
+func (s mongoStore) SaveReviews(ctx context.Context, reviews []Review) error {
+	if len(reviews) == 0 {
+		return nil
 	}
-	if err = cursor.All(context.TODO(), &books); err != nil {
-		return nil, fmt.Errorf("error decoding into struct: %v", err)
+	return s.writeDatabase(ctx, "SaveReviews", func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(reviewCollectionName)
+		for _, review := range reviews {
+			_, err := collection.ReplaceOne(ctx, bson.M{"_id": review.ID}, review, options.Replace().SetUpsert(true))
+			if err != nil {
+				return fmt.Errorf("failed to save review: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetReviews(ctx context.Context, fictionID string) ([]Review, error) {
+	var reviews []Review
+	err := s.withDatabase(ctx, "GetReviews", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "posted_at", Value: -1}, {Key: "_id", Value: 1}})
+		cursor, err := db.Collection(reviewCollectionName).Find(ctx, bson.M{"fiction_id": fictionID}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find reviews: %v", err)
+		}
+		if err = cursor.All(ctx, &reviews); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return reviews, err
+}
+
+func (s mongoStore) SaveChapters(ctx context.Context, chapters []Chapter) error {
+	if len(chapters) == 0 {
+		return nil
 	}
-	return books, nil
+	return s.writeDatabase(ctx, "SaveChapters", func(ctx context.Context, db *mongo.Database) error {
+		collection := db.Collection(chapterCollectionName)
+		for _, chapter := range chapters {
+			_, err := collection.ReplaceOne(ctx, bson.M{"_id": chapter.ID}, chapter, options.Replace().SetUpsert(true))
+			if err != nil {
+				return fmt.Errorf("failed to save chapter: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetChapters(ctx context.Context, fictionID string) ([]Chapter, error) {
+	var chapters []Chapter
+	err := s.withDatabase(ctx, "GetChapters", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "published_at", Value: 1}, {Key: "_id", Value: 1}})
+		cursor, err := db.Collection(chapterCollectionName).Find(ctx, bson.M{"fiction_id": fictionID}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find chapters: %v", err)
+		}
+		if err = cursor.All(ctx, &chapters); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return chapters, err
+}
+
+func (s mongoStore) SaveProgress(ctx context.Context, progress ReadingProgress) error {
+	return s.writeDatabase(ctx, "SaveProgress", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": progress.UserID, "fiction_id": progress.FictionID}
+		_, err := db.Collection(progressCollectionName).ReplaceOne(ctx, filter, progress, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save reading progress: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetProgress(ctx context.Context, userID string) ([]ReadingProgress, error) {
+	var progress []ReadingProgress
+	err := s.withDatabase(ctx, "GetProgress", func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(progressCollectionName).Find(ctx, bson.M{"user_id": userID})
+		if err != nil {
+			return fmt.Errorf("failed to find reading progress: %v", err)
+		}
+		if err = cursor.All(ctx, &progress); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return progress, err
+}
+
+func (s mongoStore) SaveArchivedFiction(ctx context.Context, archive ArchivedFiction) error {
+	return s.writeDatabase(ctx, "SaveArchivedFiction", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": archive.UserID, "fiction_id": archive.FictionID}
+		update := bson.M{"$setOnInsert": archive}
+		_, err := db.Collection(archiveCollectionName).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save archive: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) DeleteArchivedFiction(ctx context.Context, userID, fictionID string) error {
+	return s.writeDatabase(ctx, "DeleteArchivedFiction", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": userID, "fiction_id": fictionID}
+		if _, err := db.Collection(archiveCollectionName).DeleteOne(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete archive: %v", err)
+		}
+		if _, err := db.Collection(archivedChapterCollectionName).DeleteMany(ctx, filter); err != nil {
+			return fmt.Errorf("failed to delete archived chapters: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetArchivedFictions(ctx context.Context, userID string) ([]ArchivedFiction, error) {
+	return s.findArchives(ctx, bson.M{"user_id": userID})
+}
+
+func (s mongoStore) GetAllArchivedFictions(ctx context.Context) ([]ArchivedFiction, error) {
+	return s.findArchives(ctx, bson.M{})
+}
+
+func (s mongoStore) findArchives(ctx context.Context, filter bson.M) ([]ArchivedFiction, error) {
+	var archives []ArchivedFiction
+	err := s.withDatabase(ctx, "findArchives", func(ctx context.Context, db *mongo.Database) error {
+		cursor, err := db.Collection(archiveCollectionName).Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("failed to find archives: %v", err)
+		}
+		if err = cursor.All(ctx, &archives); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return archives, err
+}
+
+func (s mongoStore) SaveArchivedChapter(ctx context.Context, chapter ArchivedChapter) error {
+	return s.writeDatabase(ctx, "SaveArchivedChapter", func(ctx context.Context, db *mongo.Database) error {
+		filter := bson.M{"user_id": chapter.UserID, "chapter_id": chapter.ChapterID}
+		_, err := db.Collection(archivedChapterCollectionName).ReplaceOne(ctx, filter, chapter, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save archived chapter: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetArchivedChapters(ctx context.Context, userID, fictionID string) ([]ArchivedChapter, error) {
+	var chapters []ArchivedChapter
+	err := s.withDatabase(ctx, "GetArchivedChapters", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "published_at", Value: 1}, {Key: "chapter_id", Value: 1}})
+		filter := bson.M{"user_id": userID, "fiction_id": fictionID}
+		cursor, err := db.Collection(archivedChapterCollectionName).Find(ctx, filter, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find archived chapters: %v", err)
+		}
+		if err = cursor.All(ctx, &chapters); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return chapters, err
+}
+
+func (s mongoStore) GetArchivedChapter(ctx context.Context, userID, chapterID string) (*ArchivedChapter, error) {
+	var chapter *ArchivedChapter
+	err := s.withDatabase(ctx, "GetArchivedChapter", func(ctx context.Context, db *mongo.Database) error {
+		var found ArchivedChapter
+		filter := bson.M{"user_id": userID, "chapter_id": chapterID}
+		err := db.Collection(archivedChapterCollectionName).FindOne(ctx, filter).Decode(&found)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find archived chapter: %v", err)
+		}
+		chapter = &found
+		return nil
+	})
+	return chapter, err
+}
+
+func (s mongoStore) AddStatusEvent(ctx context.Context, event StatusEvent) error {
+	return s.writeDatabase(ctx, "AddStatusEvent", func(ctx context.Context, db *mongo.Database) error {
+		if _, err := db.Collection(statusEventCollectionName).InsertOne(ctx, event); err != nil {
+			return fmt.Errorf("failed to insert status event: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) GetStatusEvents(ctx context.Context, fictionID string) ([]StatusEvent, error) {
+	var events []StatusEvent
+	err := s.withDatabase(ctx, "GetStatusEvents", func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+		cursor, err := db.Collection(statusEventCollectionName).Find(ctx, bson.M{"fiction_id": fictionID}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find status events: %v", err)
+		}
+		if err = cursor.All(ctx, &events); err != nil {
+			return fmt.Errorf("error decoding into struct: %v", err)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// ExportRecords streams a collection with a cursor in insertion order, decoding one record at a time
+func (s mongoStore) ExportRecords(ctx context.Context, collection string, fn func(record any) error) error {
+	dump, ok := findDumpCollection(collection)
+	if !ok {
+		return fmt.Errorf("unknown collection %q", collection)
+	}
+	name := collection
+	if collection == bookCollectionName {
+		name = s.config.Collection
+	}
+	return s.withDatabaseTimeout(ctx, "ExportRecords", exportTimeout, func(ctx context.Context, db *mongo.Database) error {
+		opts := options.Find().SetSort(bson.D{{Key: "$natural", Value: 1}})
+		cursor, err := db.Collection(name).Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to find %s: %v", collection, err)
+		}
+		defer cursor.Close(ctx)
+		for cursor.Next(ctx) {
+			record := dump.newRecord()
+			if err := cursor.Decode(record); err != nil {
+				return fmt.Errorf("error decoding into struct: %v", err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return cursor.Err()
+	})
+}
+
+func (s mongoStore) GetCachedPage(ctx context.Context, url string) (*CachedPage, error) {
+	var page *CachedPage
+	err := s.withDatabase(ctx, "GetCachedPage", func(ctx context.Context, db *mongo.Database) error {
+		var found CachedPage
+		err := db.Collection(pageCacheCollectionName).FindOne(ctx, bson.M{"_id": url}).Decode(&found)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find cached page: %v", err)
+		}
+		page = &found
+		return nil
+	})
+	return page, err
+}
+
+func (s mongoStore) SaveCachedPage(ctx context.Context, page CachedPage) error {
+	return s.writeDatabase(ctx, "SaveCachedPage", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(pageCacheCollectionName).ReplaceOne(ctx, bson.M{"_id": page.URL}, page, options.Replace().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to save cached page: %v", err)
+		}
+		return nil
+	})
 }
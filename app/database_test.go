@@ -5,19 +5,29 @@ import (
 	"testing"
 	"time"
 
-	"os"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/mongodb"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// setupTestDatabase starts a MongoDB container and returns a cleanup function
-func setupTestDatabase(t *testing.T) (func(), string) {
+// testMongoStore is a store on the default database at uri, closed when the test ends
+func testMongoStore(t *testing.T, uri string) mongoStore {
+	config := defaultConfig().Database
+	config.URI = uri
+	s, err := newMongoStore(config)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s
+}
+
+// setupTestDatabase starts a MongoDB container and returns a store on it and a cleanup function
+func setupTestDatabase(t *testing.T) (mongoStore, func(), string) {
 	// Create MongoDB container request
 	ctx := context.Background()
 	mongodbContainer, err := mongodb.RunContainer(ctx,
@@ -32,26 +42,22 @@ func setupTestDatabase(t *testing.T) (func(), string) {
 	connectionURI, err := mongodbContainer.ConnectionString(ctx)
 	require.NoError(t, err, "Failed to get MongoDB connection string")
 
-	// Set environment variable for MongoDB URI
-	os.Setenv("MONGODB_URI", connectionURI)
-
 	// Return cleanup function
 	cleanup := func() {
-		// Reset the global client to nil to ensure tests don't interfere with each other
-		client = nil
 		// Terminate the container
 		if err := mongodbContainer.Terminate(ctx); err != nil {
 			t.Fatalf("Failed to terminate MongoDB container: %v", err)
 		}
 	}
 
-	return cleanup, connectionURI
+	return testMongoStore(t, connectionURI), cleanup, connectionURI
 }
 
 // TestSaveAndGetBooks tests the save and retrieve functionality with a real MongoDB instance
 func TestSaveAndGetBooks(t *testing.T) {
+	ctx := context.Background()
 	// Set up test database
-	cleanup, _ := setupTestDatabase(t)
+	backend, cleanup, _ := setupTestDatabase(t)
 	defer cleanup()
 
 	// Create test data
@@ -62,11 +68,11 @@ func TestSaveAndGetBooks(t *testing.T) {
 
 	// Save books using the real implementation
 	// This should use the actual database connection
-	err := saveBooksWithMetadata(books)
+	err := backend.saveBooksWithMetadata(ctx, books)
 	assert.NoError(t, err)
 
 	// Retrieve books using the real implementation
-	retrievedBooks, err := getBooksWithMetadata()
+	retrievedBooks, err := backend.getBooksWithMetadata(ctx)
 	assert.NoError(t, err)
 
 	// Verify the books were saved correctly
@@ -80,7 +86,7 @@ func TestSaveAndGetBooks(t *testing.T) {
 // TestDatabaseConnection tests the connection to the MongoDB database
 func TestDatabaseConnection(t *testing.T) {
 	// Set up test database
-	cleanup, connectionURI := setupTestDatabase(t)
+	_, cleanup, connectionURI := setupTestDatabase(t)
 	defer cleanup()
 
 	// Test direct connection
@@ -95,3 +101,309 @@ func TestDatabaseConnection(t *testing.T) {
 	err = testClient.Ping(ctx, nil)
 	require.NoError(t, err, "Failed to ping MongoDB")
 }
+
+// TestSaveAndGetSnapshots tests snapshot persistence with a real MongoDB instance
+func TestSaveAndGetSnapshots(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	snapshots := []Snapshot{
+		{FictionID: "1", Title: "Test Book 1", List: "popular", Rank: 1, Followers: 10, CrawledAt: now.Add(-48 * time.Hour)},
+		{FictionID: "1", Title: "Test Book 1", List: "popular", Rank: 2, Followers: 20, CrawledAt: now},
+		{FictionID: "2", Title: "Test Book 2", List: "popular", Rank: 1, Followers: 30, CrawledAt: now},
+	}
+
+	err := backend.SaveSnapshots(ctx, snapshots)
+	require.NoError(t, err)
+
+	// Snapshots of one fiction come back oldest first
+	fictionSnapshots, err := backend.GetSnapshots(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, snapshots[:2], fictionSnapshots)
+
+	// Only snapshots inside the window are returned
+	recent, err := backend.GetSnapshotsSince(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(recent))
+}
+
+// TestSaveAndGetFollows tests follow persistence with a real MongoDB instance
+func TestSaveAndGetFollows(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	follow := Follow{UserID: "alice", FictionID: "42", Kind: FollowKindFollow, CreatedAt: createdAt}
+
+	// Saving the same follow twice keeps a single document with the original time
+	require.NoError(t, backend.SaveFollow(ctx, follow))
+	require.NoError(t, backend.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "42", Kind: FollowKindFollow, CreatedAt: createdAt.Add(time.Hour)}))
+	require.NoError(t, backend.SaveFollow(ctx, Follow{UserID: "bob", FictionID: "42", Kind: FollowKindFavorite, CreatedAt: createdAt}))
+
+	follows, err := backend.GetFollows(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []Follow{follow}, follows)
+
+	all, err := backend.GetAllFollows(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(all))
+
+	require.NoError(t, backend.DeleteFollow(ctx, "alice", "42", FollowKindFollow))
+	follows, err = backend.GetFollows(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, follows)
+}
+
+// TestSaveAndGetReviews tests review persistence with a real MongoDB instance
+func TestSaveAndGetReviews(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	postedAt := time.Now().UTC().Truncate(time.Millisecond)
+	reviews := []Review{
+		{ID: "1", FictionID: "42", Reviewer: "alice", Title: "Old", PostedAt: postedAt.Add(-time.Hour), Scores: map[string]float64{"overall": 4}},
+		{ID: "2", FictionID: "42", Reviewer: "bob", Title: "New", PostedAt: postedAt, Scores: map[string]float64{"overall": 5}},
+		{ID: "3", FictionID: "43", Reviewer: "bob", Title: "Other", PostedAt: postedAt, Scores: map[string]float64{"overall": 3}},
+	}
+	require.NoError(t, backend.SaveReviews(ctx, reviews))
+
+	// Saving a review again replaces it instead of duplicating it
+	reviews[1].Text = "Edited"
+	require.NoError(t, backend.SaveReviews(ctx, reviews[1:2]))
+
+	stored, err := backend.GetReviews(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, []Review{reviews[1], reviews[0]}, stored)
+}
+
+// TestSaveAndGetChapters tests chapter persistence with a real MongoDB instance
+func TestSaveAndGetChapters(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	publishedAt := time.Now().UTC().Truncate(time.Millisecond)
+	chapters := []Chapter{
+		{ID: "2", FictionID: "42", Title: "Chapter Two", PublishedAt: publishedAt},
+		{ID: "1", FictionID: "42", Title: "Chapter One", PublishedAt: publishedAt.Add(-24 * time.Hour)},
+		{ID: "3", FictionID: "43", Title: "Other", PublishedAt: publishedAt},
+	}
+	require.NoError(t, backend.SaveChapters(ctx, chapters))
+	require.NoError(t, backend.SaveChapters(ctx, chapters[:1]))
+
+	stored, err := backend.GetChapters(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, []Chapter{chapters[1], chapters[0]}, stored)
+}
+
+// TestSaveAndGetProgress tests reading progress persistence with a real MongoDB instance
+func TestSaveAndGetProgress(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	updatedAt := time.Now().UTC().Truncate(time.Millisecond)
+	require.NoError(t, backend.SaveProgress(ctx, ReadingProgress{UserID: "alice", FictionID: "42", ChapterID: "1", UpdatedAt: updatedAt}))
+	require.NoError(t, backend.SaveProgress(ctx, ReadingProgress{UserID: "alice", FictionID: "42", ChapterID: "2", UpdatedAt: updatedAt}))
+	require.NoError(t, backend.SaveProgress(ctx, ReadingProgress{UserID: "bob", FictionID: "42", ChapterID: "1", UpdatedAt: updatedAt}))
+
+	progress, err := backend.GetProgress(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []ReadingProgress{{UserID: "alice", FictionID: "42", ChapterID: "2", UpdatedAt: updatedAt}}, progress)
+}
+
+// TestSaveAndGetArchives tests archive persistence with a real MongoDB instance
+func TestSaveAndGetArchives(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	require.NoError(t, backend.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "42", Title: "Test Book", CreatedAt: now}))
+	require.NoError(t, backend.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "42", Title: "Test Book", CreatedAt: now.Add(time.Hour)}))
+	require.NoError(t, backend.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "bob", FictionID: "42", Title: "Test Book", CreatedAt: now}))
+
+	chapter := ArchivedChapter{UserID: "alice", ChapterID: "1", FictionID: "42", Title: "Prologue", Content: "<p>Final</p>", ArchivedAt: now, CheckedAt: now,
+		Revisions: []ChapterRevision{{Content: "<p>Draft</p>", ArchivedAt: now.Add(-time.Hour)}}}
+	require.NoError(t, backend.SaveArchivedChapter(ctx, chapter))
+
+	archives, err := backend.GetArchivedFictions(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []ArchivedFiction{{UserID: "alice", FictionID: "42", Title: "Test Book", CreatedAt: now}}, archives)
+	all, err := backend.GetAllArchivedFictions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(all))
+
+	// Chapters are only found for the user who archived them
+	stored, err := backend.GetArchivedChapter(ctx, "alice", "1")
+	require.NoError(t, err)
+	assert.Equal(t, &chapter, stored)
+	stored, err = backend.GetArchivedChapter(ctx, "bob", "1")
+	require.NoError(t, err)
+	assert.Nil(t, stored)
+
+	require.NoError(t, backend.DeleteArchivedFiction(ctx, "alice", "42"))
+	chapters, err := backend.GetArchivedChapters(ctx, "alice", "42")
+	require.NoError(t, err)
+	assert.Empty(t, chapters)
+}
+
+// TestAddAndGetStatusEvents tests status event persistence with a real MongoDB instance
+func TestAddAndGetStatusEvents(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	removed := StatusEvent{FictionID: "42", Title: "Test Book", Status: FictionStatusRemoved, Previous: FictionStatusStubbed,
+		Reason: "Fiction page returned HTTP 404", StatusCode: 404, CreatedAt: now}
+	stubbed := StatusEvent{FictionID: "42", Title: "Test Book", Status: FictionStatusStubbed, Previous: FictionStatusActive,
+		Reason: "Chapters dropped from 10 to 2", StatusCode: 200, CreatedAt: now.Add(-time.Hour)}
+	require.NoError(t, backend.AddStatusEvent(ctx, removed))
+	require.NoError(t, backend.AddStatusEvent(ctx, stubbed))
+	require.NoError(t, backend.AddStatusEvent(ctx, StatusEvent{FictionID: "7", Status: FictionStatusRemoved, CreatedAt: now}))
+
+	events, err := backend.GetStatusEvents(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, []StatusEvent{stubbed, removed}, events)
+}
+
+// TestSaveAndGetCachedPages tests the crawler's page cache with a real MongoDB instance
+func TestSaveAndGetCachedPages(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	page := CachedPage{URL: "https://www.royalroad.com/fiction/42/test-book", ContentType: "text/html", ETag: `"v1"`,
+		Body: []byte("<html></html>"), FetchedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	require.NoError(t, backend.SaveCachedPage(ctx, page))
+	page.ETag = `"v2"`
+	require.NoError(t, backend.SaveCachedPage(ctx, page))
+
+	cached, err := backend.GetCachedPage(ctx, page.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &page, cached)
+
+	missing, err := backend.GetCachedPage(ctx, "https://www.royalroad.com/fiction/7")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+// TestPing tests the health check against a real MongoDB instance and an unreachable one
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	// Set up test database
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	assert.NoError(t, backend.Ping(ctx))
+
+	assert.Error(t, testMongoStore(t, "mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=500").Ping(ctx))
+}
+
+// TestCancelledContext checks that a cancelled request stops reads but not writes
+func TestCancelledContext(t *testing.T) {
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, backend.SaveBooks(ctx, []Book{{Title: "Test Book", Link: "https://www.royalroad.com/fiction/1", FictionID: "1"}}))
+	_, err := backend.GetBooks(ctx)
+	assert.ErrorContains(t, err, context.Canceled.Error())
+
+	books, err := backend.GetBooks(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(books))
+}
+
+// TestSchemaLock takes the migration lock on a store with and without a schema marker
+func TestSchemaLock(t *testing.T) {
+	ctx := context.Background()
+	backend, cleanup, _ := setupTestDatabase(t)
+	defer cleanup()
+
+	require.NoError(t, backend.LockSchema(ctx, "first", time.Minute))
+	assert.ErrorIs(t, backend.LockSchema(ctx, "second", time.Minute), errSchemaLocked)
+	require.NoError(t, backend.UnlockSchema(ctx, "second"), "only the owner releases the lock")
+	assert.ErrorIs(t, backend.LockSchema(ctx, "second", time.Minute), errSchemaLocked)
+
+	require.NoError(t, backend.UnlockSchema(ctx, "first"))
+	require.NoError(t, backend.LockSchema(ctx, "second", -time.Second))
+	require.NoError(t, backend.LockSchema(ctx, "third", time.Minute), "an expired lock is taken over")
+
+	waiting, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := runMigrations(waiting, backend)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "the migrations wait for the lock")
+}
+
+// TestMigrations migrates books saved with a copy per crawl and no schema version
+func TestMigrations(t *testing.T) {
+	// Set up test database
+	backend, cleanup, connectionURI := setupTestDatabase(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testClient, err := mongo.Connect(ctx, options.Client().ApplyURI(connectionURI))
+	require.NoError(t, err)
+	defer testClient.Disconnect(ctx)
+	db := testClient.Database(defaultConfig().Database.Name)
+	books := db.Collection(defaultConfig().Database.Collection)
+	_, err = books.InsertMany(ctx, []interface{}{
+		bson.M{"title": "First", "link": "https://www.royalroad.com/fiction/1/first", "fiction_id": "1", "followers": 10},
+		bson.M{"title": "Second", "link": "https://www.royalroad.com/fiction/2/second", "fiction_id": "2"},
+		bson.M{"title": "First", "link": "https://www.royalroad.com/fiction/1/first", "fiction_id": "1", "followers": 20},
+		bson.M{"title": "No ID", "link": "https://example.com/book"},
+		bson.M{"title": "No ID", "link": "https://example.com/book"},
+	})
+	require.NoError(t, err)
+
+	applied, err := runMigrations(ctx, backend)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(applied))
+	version, err := backend.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	saved, err := backend.getBooksWithMetadata(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(saved))
+	assert.Equal(t, "First", saved[0].Title)
+	assert.Equal(t, 20, saved[0].Followers)
+	count, err := books.CountDocuments(ctx, bson.M{"schema_version": 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Saving a fiction again updates its document, and the unique index rejects a second one
+	require.NoError(t, backend.SaveBooks(ctx, []Book{{Title: "First", Link: "https://www.royalroad.com/fiction/1/first", FictionID: "1", Followers: 30}}))
+	_, err = books.InsertOne(ctx, bson.M{"title": "Copy", "fiction_id": "1"})
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+
+	cursor, err := db.Collection(snapshotCollectionName).Indexes().List(ctx)
+	require.NoError(t, err)
+	var indexes []bson.M
+	require.NoError(t, cursor.All(ctx, &indexes))
+	names := make([]string, 0, len(indexes))
+	for _, index := range indexes {
+		names = append(names, index["name"].(string))
+	}
+	assert.Contains(t, names, "crawled_at")
+
+	// Every migration can run again
+	for _, m := range migrations {
+		assert.NoError(t, backend.ApplyMigration(ctx, m), m.name)
+	}
+}
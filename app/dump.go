@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// bookCollectionName names the books in dumps; in MongoDB they live in the configured collection
+const bookCollectionName = "books"
+
+// importBatchSize is how many records are saved at once when loading a dump
+const importBatchSize = 500
+
+// dumpCollection is one kind of record in a store dump
+type dumpCollection struct {
+	name string
+	// newRecord returns a pointer to decode one record into
+	newRecord func() any
+	// save writes decoded records to the store
+	save func(s BookStore, ctx context.Context, records []any) error
+}
+
+// dumpOf describes a collection of T saved in batches with save
+func dumpOf[T any](name string, save func(s BookStore, ctx context.Context, records []T) error) dumpCollection {
+	return dumpCollection{
+		name:      name,
+		newRecord: func() any { return new(T) },
+		save: func(s BookStore, ctx context.Context, records []any) error {
+			typed := make([]T, len(records))
+			for i, record := range records {
+				typed[i] = *record.(*T)
+			}
+			return save(s, ctx, typed)
+		},
+	}
+}
+
+// saveEach adapts a store method saving one record to dumpOf
+func saveEach[T any](save func(s BookStore, ctx context.Context, record T) error) func(s BookStore, ctx context.Context, records []T) error {
+	return func(s BookStore, ctx context.Context, records []T) error {
+		for _, record := range records {
+			if err := save(s, ctx, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// dumpCollections lists every collection in a store dump. Authors come before the records
+// pointing at them so an import is consistent at every point
+var dumpCollections = []dumpCollection{
+	dumpOf(bookCollectionName, BookStore.SaveBooks),
+	dumpOf(snapshotCollectionName, BookStore.SaveSnapshots),
+	dumpOf(authorCollectionName, saveEach(BookStore.SaveAuthor)),
+	dumpOf(reviewCollectionName, BookStore.SaveReviews),
+	dumpOf(chapterCollectionName, BookStore.SaveChapters),
+	dumpOf(statusEventCollectionName, saveEach(BookStore.AddStatusEvent)),
+	dumpOf(followCollectionName, saveEach(BookStore.SaveFollow)),
+	dumpOf(authorFollowCollectionName, saveEach(BookStore.SaveAuthorFollow)),
+	dumpOf(notificationCollectionName, BookStore.AddNotifications),
+	dumpOf(progressCollectionName, saveEach(BookStore.SaveProgress)),
+	dumpOf(archiveCollectionName, saveEach(BookStore.SaveArchivedFiction)),
+	dumpOf(archivedChapterCollectionName, saveEach(BookStore.SaveArchivedChapter)),
+}
+
+func findDumpCollection(name string) (dumpCollection, bool) {
+	for _, collection := range dumpCollections {
+		if collection.name == name {
+			return collection, true
+		}
+	}
+	return dumpCollection{}, false
+}
+
+// dumpLine is one record of a dump, written as a line of JSON
+type dumpLine struct {
+	Collection string          `json:"collection"`
+	Record     json.RawMessage `json:"record"`
+}
+
+// exportStore writes every record of the store to w as JSON lines, one collection after another.
+// Records are streamed from the store rather than loaded at once. It returns the count per collection
+func exportStore(ctx context.Context, w io.Writer, s BookStore) (map[string]int, error) {
+	counts := make(map[string]int)
+	encoder := json.NewEncoder(w)
+	for _, collection := range dumpCollections {
+		err := s.ExportRecords(ctx, collection.name, func(record any) error {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			counts[collection.name]++
+			return encoder.Encode(dumpLine{Collection: collection.name, Record: data})
+		})
+		if err != nil {
+			return counts, fmt.Errorf("failed to export %s: %v", collection.name, err)
+		}
+	}
+	return counts, nil
+}
+
+// importStore loads a dump written by exportStore into the store, saving records in batches.
+// It returns the count per collection. Cancelling ctx stops it once the records read are saved
+func importStore(ctx context.Context, r io.Reader, s BookStore) (map[string]int, error) {
+	counts := make(map[string]int)
+	var pending []any
+	var current dumpCollection
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := current.save(s, ctx, pending); err != nil {
+			return fmt.Errorf("failed to import %s: %v", current.name, err)
+		}
+		counts[current.name] += len(pending)
+		pending = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	// Archived chapters hold whole chapter texts with their revisions
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		if err := ctx.Err(); err != nil {
+			return counts, errors.Join(err, flush())
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var line dumpLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return counts, fmt.Errorf("line %d: %v", number, err)
+		}
+		if line.Collection != current.name || len(pending) == importBatchSize {
+			if err := flush(); err != nil {
+				return counts, err
+			}
+		}
+		collection, ok := findDumpCollection(line.Collection)
+		if !ok {
+			return counts, fmt.Errorf("line %d: unknown collection %q", number, line.Collection)
+		}
+		current = collection
+		record := collection.newRecord()
+		if err := json.Unmarshal(line.Record, record); err != nil {
+			return counts, fmt.Errorf("line %d: invalid %s record: %v", number, line.Collection, err)
+		}
+		pending = append(pending, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return counts, err
+	}
+	return counts, flush()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillStore saves one record of every kind
+func fillStore(t *testing.T, s BookStore) {
+	ctx := context.Background()
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveBooks(ctx, []Book{{Title: "First", Link: defaultRoyalRoadURL + "/fiction/1", FictionID: "1", Tags: []string{"Fantasy"}}}))
+	require.NoError(t, s.SaveSnapshots(ctx, []Snapshot{{FictionID: "1", Title: "First", List: "popular", Rank: 1, CrawledAt: now}}))
+	require.NoError(t, s.SaveAuthor(ctx, Author{ID: "7", Name: "Author", Fictions: []AuthorFiction{{FictionID: "1", Title: "First"}}, UpdatedAt: now}))
+	require.NoError(t, s.SaveReviews(ctx, []Review{{ID: "r1", FictionID: "1", Scores: map[string]float64{"overall": 4.5}, PostedAt: now}}))
+	require.NoError(t, s.SaveChapters(ctx, []Chapter{{ID: "c1", FictionID: "1", Title: "Chapter 1", PublishedAt: now}}))
+	require.NoError(t, s.AddStatusEvent(ctx, StatusEvent{FictionID: "1", Title: "First", CreatedAt: now}))
+	require.NoError(t, s.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "1", Kind: FollowKindFollow, CreatedAt: now}))
+	require.NoError(t, s.SaveAuthorFollow(ctx, AuthorFollow{UserID: "alice", AuthorID: "7", CreatedAt: now}))
+	require.NoError(t, s.AddNotifications(ctx, []Notification{{UserID: "alice", Message: "New fiction", CreatedAt: now}}))
+	require.NoError(t, s.SaveProgress(ctx, ReadingProgress{UserID: "alice", FictionID: "1", ChapterID: "c1", UpdatedAt: now}))
+	require.NoError(t, s.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "1", Title: "First", CreatedAt: now}))
+	require.NoError(t, s.SaveArchivedChapter(ctx, ArchivedChapter{UserID: "alice", ChapterID: "c1", FictionID: "1", Content: "<p>Text</p>", ArchivedAt: now}))
+}
+
+func TestExportImportStore(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryStore()
+	fillStore(t, source)
+
+	var dump bytes.Buffer
+	exported, err := exportStore(ctx, &dump, source)
+	require.NoError(t, err)
+	assert.Equal(t, len(dumpCollections), len(exported))
+	for _, collection := range dumpCollections {
+		assert.Equal(t, 1, exported[collection.name], collection.name)
+	}
+	assert.Equal(t, len(dumpCollections), strings.Count(dump.String(), "\n"))
+
+	target := newMemoryStore()
+	imported, err := importStore(ctx, bytes.NewReader(dump.Bytes()), target)
+	require.NoError(t, err)
+	assert.Equal(t, exported, imported)
+
+	var again bytes.Buffer
+	_, err = exportStore(ctx, &again, target)
+	require.NoError(t, err)
+	assert.Equal(t, dump.String(), again.String())
+
+	chapter, err := target.GetArchivedChapter(ctx, "alice", "c1")
+	require.NoError(t, err)
+	require.NotNil(t, chapter)
+	assert.Equal(t, "<p>Text</p>", chapter.Content)
+}
+
+func TestImportStore_Batches(t *testing.T) {
+	ctx := context.Background()
+	var dump strings.Builder
+	for i := 0; i < importBatchSize+1; i++ {
+		dump.WriteString(`{"collection":"snapshots","record":{"fiction_id":"1","list":"popular"}}` + "\n")
+	}
+	target := newMemoryStore()
+	counts, err := importStore(ctx, strings.NewReader(dump.String()), target)
+	require.NoError(t, err)
+	assert.Equal(t, importBatchSize+1, counts["snapshots"])
+	snapshots, err := target.GetSnapshots(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, importBatchSize+1, len(snapshots))
+}
+
+func TestImportStore_Errors(t *testing.T) {
+	ctx := context.Background()
+	for name, dump := range map[string]string{
+		"not JSON":           "{",
+		"unknown collection": `{"collection":"movies","record":{}}`,
+		"invalid record":     `{"collection":"books","record":{"title":42}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := importStore(ctx, strings.NewReader(dump), newMemoryStore())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "line 1")
+		})
+	}
+}
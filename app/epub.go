@@ -0,0 +1,510 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// errArchiveNotFound is returned when the user has no archive of the fiction
+	errArchiveNotFound = errors.New("archive not found")
+	// errChapterRange is returned when the requested chapters aren't in the archive
+	errChapterRange = errors.New("chapter range out of bounds")
+
+	slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// EPUBBook is everything that goes into an EPUB file
+type EPUBBook struct {
+	ID          string
+	Title       string
+	Author      string
+	Description string
+	Source      string
+	Language    string
+	Modified    time.Time
+	Chapters    []EPUBChapter
+}
+
+// EPUBChapter is one chapter of an EPUB, with sanitized HTML content
+type EPUBChapter struct {
+	Title      string
+	Content    string
+	NoteBefore string
+	NoteAfter  string
+}
+
+// buildEPUBBook assembles an EPUB from archived chapters, crediting the fiction's page at source.
+// from and to are 1-based chapter numbers, with zero meaning the first and last chapter
+func buildEPUBBook(archive ArchivedFiction, source string, chapters []ArchivedChapter, from, to int, author *Author, synopsis string) (EPUBBook, error) {
+	if from == 0 {
+		from = 1
+	}
+	if to == 0 {
+		to = len(chapters)
+	}
+	if from < 1 || to > len(chapters) || from > to {
+		return EPUBBook{}, fmt.Errorf("%w: chapters %d–%d of %d", errChapterRange, from, to, len(chapters))
+	}
+
+	title := archive.Title
+	if title == "" {
+		title = "Fiction " + archive.FictionID
+	}
+	book := EPUBBook{
+		ID:          "urn:royalroad:fiction:" + archive.FictionID,
+		Title:       title,
+		Description: synopsis,
+		Source:      source,
+		Language:    "en",
+	}
+	if author != nil {
+		book.Author = author.Name
+	}
+	// Partial exports get their own identity so readers don't confuse them with the full book
+	if from != 1 || to != len(chapters) {
+		book.ID += fmt.Sprintf(":%d-%d", from, to)
+		book.Title += fmt.Sprintf(" (Chapters %d–%d)", from, to)
+	}
+	for _, chapter := range chapters[from-1 : to] {
+		book.Chapters = append(book.Chapters, EPUBChapter{
+			Title:      chapter.Title,
+			Content:    chapter.Content,
+			NoteBefore: chapter.NoteBefore,
+			NoteAfter:  chapter.NoteAfter,
+		})
+		if chapter.ArchivedAt.After(book.Modified) {
+			book.Modified = chapter.ArchivedAt
+		}
+	}
+	if book.Modified.IsZero() {
+		book.Modified = time.Now()
+	}
+	return book, nil
+}
+
+// loadEPUBBook builds an EPUB of a user's archive of a fiction from the store of deps
+func loadEPUBBook(ctx context.Context, deps dependencies, userID, fictionID string, from, to int) (EPUBBook, error) {
+	archives, err := deps.store.GetArchivedFictions(ctx, userID)
+	if err != nil {
+		return EPUBBook{}, err
+	}
+	var archive *ArchivedFiction
+	for i := range archives {
+		if archives[i].FictionID == fictionID {
+			archive = &archives[i]
+		}
+	}
+	if archive == nil {
+		return EPUBBook{}, errArchiveNotFound
+	}
+
+	chapters, err := deps.store.GetArchivedChapters(ctx, userID, fictionID)
+	if err != nil {
+		return EPUBBook{}, err
+	}
+	authors, err := deps.store.GetAuthors(ctx)
+	if err != nil {
+		return EPUBBook{}, err
+	}
+	books, err := deps.store.GetBooks(ctx)
+	if err != nil {
+		return EPUBBook{}, err
+	}
+	var synopsis string
+	for _, book := range books {
+		if book.FictionID == fictionID {
+			synopsis = book.Synopsis
+		}
+	}
+	return buildEPUBBook(*archive, deps.crawler.fictionLink(fictionID), chapters, from, to, authorOfFiction(authors, fictionID), synopsis)
+}
+
+// writeEPUB writes book as an EPUB 3 file, with an NCX table of contents for older readers
+func writeEPUB(w io.Writer, book EPUBBook) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype must come first and uncompressed
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"META-INF/container.xml", epubContainer},
+		{"OEBPS/style.css", epubStyle},
+		{"OEBPS/cover.svg", epubCoverImage(book)},
+		{"OEBPS/cover.xhtml", epubPage(book.Language, "Cover", `<div class="cover"><img src="cover.svg" alt="`+html.EscapeString(book.Title)+`"/></div>`)},
+		{"OEBPS/nav.xhtml", epubNav(book)},
+		{"OEBPS/toc.ncx", epubNCX(book)},
+		{"OEBPS/content.opf", epubPackage(book)},
+	}
+	for i, chapter := range book.Chapters {
+		body, err := epubChapterBody(chapter)
+		if err != nil {
+			return fmt.Errorf("failed to convert chapter %q: %v", chapter.Title, err)
+		}
+		files = append(files, struct {
+			name    string
+			content string
+		}{"OEBPS/" + epubChapterFile(i), epubPage(book.Language, chapter.Title, body)})
+	}
+
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, file.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.5; }
+h1 { text-align: center; }
+.author-note { margin: 1em 0; padding: 0.5em 1em; border-left: 3px solid #888; font-size: 0.9em; }
+.cover { text-align: center; }
+.cover img { max-width: 100%; max-height: 100%; }
+`
+
+func epubChapterFile(i int) string {
+	return fmt.Sprintf("chapter-%04d.xhtml", i+1)
+}
+
+// epubPage wraps a body in an XHTML document
+func epubPage(language, title, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="` + language + `" lang="` + language + `">
+<head>
+  <meta charset="UTF-8"/>
+  <title>` + html.EscapeString(title) + `</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+` + body + `
+</body>
+</html>
+`
+}
+
+// epubChapterBody lays out a chapter with its author notes
+func epubChapterBody(chapter EPUBChapter) (string, error) {
+	var b strings.Builder
+	b.WriteString("<h1>" + html.EscapeString(chapter.Title) + "</h1>\n")
+	sections := []struct{ class, content string }{
+		{"author-note", chapter.NoteBefore},
+		{"chapter", chapter.Content},
+		{"author-note", chapter.NoteAfter},
+	}
+	for _, section := range sections {
+		if section.content == "" {
+			continue
+		}
+		xhtml, err := toXHTML(section.content)
+		if err != nil {
+			return "", err
+		}
+		tag := "section"
+		if section.class == "author-note" {
+			tag = "aside"
+		}
+		fmt.Fprintf(&b, "<%s class=\"%s\">%s</%s>\n", tag, section.class, xhtml, tag)
+	}
+	return b.String(), nil
+}
+
+// toXHTML re-renders sanitized HTML as well-formed XHTML. Remote images aren't allowed in
+// EPUB files, so they are replaced by their alt text
+func toXHTML(fragment string) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, node := range nodes {
+		if err := renderXHTML(&b, node); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func renderXHTML(b *strings.Builder, node *html.Node) error {
+	switch node.Type {
+	case html.TextNode:
+		b.WriteString(html.EscapeString(node.Data))
+		return nil
+	case html.ElementNode:
+	default:
+		return nil
+	}
+
+	if node.DataAtom == atom.Img {
+		for _, attr := range node.Attr {
+			if attr.Key == "alt" {
+				b.WriteString(html.EscapeString(attr.Val))
+			}
+		}
+		return nil
+	}
+
+	b.WriteString("<" + node.Data)
+	for _, attr := range node.Attr {
+		fmt.Fprintf(b, ` %s="%s"`, attr.Key, html.EscapeString(attr.Val))
+	}
+	if node.FirstChild == nil {
+		b.WriteString("/>")
+		return nil
+	}
+	b.WriteString(">")
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if err := renderXHTML(b, child); err != nil {
+			return err
+		}
+	}
+	b.WriteString("</" + node.Data + ">")
+	return nil
+}
+
+// epubCoverImage draws a plain cover with the title and author
+func epubCoverImage(book EPUBBook) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="600" height="900" viewBox="0 0 600 900">
+  <rect width="600" height="900" fill="#2c3e50"/>
+  <rect x="30" y="30" width="540" height="840" fill="none" stroke="#ecf0f1" stroke-width="4"/>
+`)
+	for i, line := range wrapText(book.Title, 22) {
+		fmt.Fprintf(&b, `  <text x="300" y="%d" font-family="serif" font-size="44" fill="#ecf0f1" text-anchor="middle">%s</text>
+`, 300+i*56, html.EscapeString(line))
+	}
+	if book.Author != "" {
+		fmt.Fprintf(&b, `  <text x="300" y="780" font-family="serif" font-size="30" fill="#bdc3c7" text-anchor="middle">%s</text>
+`, html.EscapeString(book.Author))
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// wrapText breaks text into lines of at most width characters, at word boundaries
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// epubNav is the EPUB 3 navigation document
+func epubNav(book EPUBBook) string {
+	var b strings.Builder
+	b.WriteString("<nav epub:type=\"toc\" id=\"toc\">\n<h1>Contents</h1>\n<ol>\n")
+	for i, chapter := range book.Chapters {
+		fmt.Fprintf(&b, "<li><a href=\"%s\">%s</a></li>\n", epubChapterFile(i), html.EscapeString(chapter.Title))
+	}
+	b.WriteString("</ol>\n</nav>\n")
+	b.WriteString("<nav epub:type=\"landmarks\" id=\"landmarks\" hidden=\"\">\n<ol>\n")
+	b.WriteString("<li><a epub:type=\"cover\" href=\"cover.xhtml\">Cover</a></li>\n")
+	if len(book.Chapters) > 0 {
+		fmt.Fprintf(&b, "<li><a epub:type=\"bodymatter\" href=\"%s\">Start</a></li>\n", epubChapterFile(0))
+	}
+	b.WriteString("</ol>\n</nav>")
+	return epubPage(book.Language, "Contents", b.String())
+}
+
+// epubNCX is the EPUB 2 table of contents, still read by some e-readers
+func epubNCX(book EPUBBook) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+`, html.EscapeString(book.ID), html.EscapeString(book.Title))
+	for i, chapter := range book.Chapters {
+		fmt.Fprintf(&b, `    <navPoint id="nav-%d" playOrder="%d"><navLabel><text>%s</text></navLabel><content src="%s"/></navPoint>
+`, i+1, i+1, html.EscapeString(chapter.Title), epubChapterFile(i))
+	}
+	b.WriteString("  </navMap>\n</ncx>\n")
+	return b.String()
+}
+
+// epubPackage is the OPF package document with the metadata, manifest and reading order
+func epubPackage(book EPUBBook) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">%s</dc:identifier>
+    <dc:title>%s</dc:title>
+    <dc:language>%s</dc:language>
+    <dc:source>%s</dc:source>
+    <meta property="dcterms:modified">%s</meta>
+    <meta name="cover" content="cover-image"/>
+`, html.EscapeString(book.ID), html.EscapeString(book.Title), book.Language, html.EscapeString(book.Source),
+		book.Modified.UTC().Format("2006-01-02T15:04:05Z"))
+	if book.Author != "" {
+		fmt.Fprintf(&b, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(book.Author))
+	}
+	if book.Description != "" {
+		fmt.Fprintf(&b, "    <dc:description>%s</dc:description>\n", html.EscapeString(book.Description))
+	}
+	b.WriteString(`  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    <item id="cover-image" href="cover.svg" media-type="image/svg+xml" properties="cover-image"/>
+    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+`)
+	for i := range book.Chapters {
+		fmt.Fprintf(&b, "    <item id=\"chapter-%d\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", i+1, epubChapterFile(i))
+	}
+	b.WriteString("  </manifest>\n  <spine toc=\"ncx\">\n    <itemref idref=\"cover\" linear=\"no\"/>\n    <itemref idref=\"nav\"/>\n")
+	for i := range book.Chapters {
+		fmt.Fprintf(&b, "    <itemref idref=\"chapter-%d\"/>\n", i+1)
+	}
+	b.WriteString("  </spine>\n</package>\n")
+	return b.String()
+}
+
+// epubFilename names the download after the book title
+func epubFilename(book EPUBBook) string {
+	slug := strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(book.Title), "-"), "-")
+	if slug == "" {
+		slug = "book"
+	}
+	return slug + ".epub"
+}
+
+// parseChapterRange reads the optional from and to chapter numbers of an export
+func parseChapterRange(from, to string) (int, int, error) {
+	var start, end int
+	var err error
+	if from != "" {
+		if start, err = strconv.Atoi(from); err != nil {
+			return 0, 0, fmt.Errorf("invalid from: %q", from)
+		}
+	}
+	if to != "" {
+		if end, err = strconv.Atoi(to); err != nil {
+			return 0, 0, fmt.Errorf("invalid to: %q", to)
+		}
+	}
+	return start, end, nil
+}
+
+// epubHandler downloads the current user's archive of a fiction as an EPUB. ?from=N&to=M
+// exports only chapters N to M for catching up
+func (s *server) epubHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseChapterRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := s.currentUser(r)
+	book, err := loadEPUBBook(r.Context(), s.dependencies, userID, r.PathValue("id"), from, to)
+	switch {
+	case errors.Is(err, errArchiveNotFound):
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errChapterRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("Failed to load archive: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", epubFilename(book)))
+	if err := writeEPUB(w, book); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write EPUB: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// runEPUBCommand exports an archive from the command line:
+//
+//	royalroadbot epub -user alice -fiction 42 [-from N] [-to M] [-o book.epub]
+func runEPUBCommand(args []string) error {
+	flags := flag.NewFlagSet("epub", flag.ContinueOnError)
+	userID := flags.String("user", "", "user whose archive is exported")
+	fictionID := flags.String("fiction", "", "fiction ID to export")
+	from := flags.Int("from", 0, "first chapter to export, counting from 1")
+	to := flags.Int("to", 0, "last chapter to export")
+	output := flags.String("o", "", "output file, named after the title by default")
+	_, deps, shutdown, err := startCommand(flags, args)
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+	if *userID == "" || *fictionID == "" {
+		return errors.New("-user and -fiction are required")
+	}
+
+	book, err := loadEPUBBook(context.Background(), deps, *userID, *fictionID, *from, *to)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		*output = epubFilename(book)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := writeEPUB(f, book); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d chapters to %s\n", len(book.Chapters), *output)
+	return nil
+}
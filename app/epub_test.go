@@ -0,0 +1,164 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archivedChapters makes n archived chapters of fiction 42 for alice
+func archivedChapters(n int) []ArchivedChapter {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chapters := make([]ArchivedChapter, n)
+	for i := range chapters {
+		chapters[i] = ArchivedChapter{
+			UserID:     "alice",
+			ChapterID:  string(rune('1' + i)),
+			FictionID:  "42",
+			Title:      "Chapter " + string(rune('1'+i)),
+			Content:    "<p>Part " + string(rune('1'+i)) + "</p>",
+			ArchivedAt: start.Add(time.Duration(i) * 24 * time.Hour),
+		}
+	}
+	return chapters
+}
+
+// readEPUB unzips an EPUB into its file names, in order, and contents
+func readEPUB(t *testing.T, data []byte) ([]string, map[string]string) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	var names []string
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		names = append(names, f.Name)
+		files[f.Name] = string(content)
+	}
+	require.NotEmpty(t, zr.File)
+	assert.Equal(t, zip.Store, zr.File[0].Method, "mimetype must not be compressed")
+	return names, files
+}
+
+func TestBuildEPUBBook_Range(t *testing.T) {
+	archive := ArchivedFiction{UserID: "alice", FictionID: "42", Title: "Test Book"}
+	chapters := archivedChapters(5)
+
+	book, err := buildEPUBBook(archive, defaultRoyalRoadURL+"/fiction/42", chapters, 0, 0, &Author{Name: "Ann"}, "A synopsis.")
+	require.NoError(t, err)
+	assert.Equal(t, "urn:royalroad:fiction:42", book.ID)
+	assert.Equal(t, "Test Book", book.Title)
+	assert.Equal(t, "Ann", book.Author)
+	assert.Equal(t, 5, len(book.Chapters))
+	assert.Equal(t, chapters[4].ArchivedAt, book.Modified)
+
+	book, err = buildEPUBBook(archive, defaultRoyalRoadURL+"/fiction/42", chapters, 2, 3, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, "urn:royalroad:fiction:42:2-3", book.ID)
+	assert.Equal(t, "Test Book (Chapters 2–3)", book.Title)
+	require.Equal(t, 2, len(book.Chapters))
+	assert.Equal(t, "Chapter 2", book.Chapters[0].Title)
+	assert.Equal(t, "Chapter 3", book.Chapters[1].Title)
+	assert.Equal(t, chapters[2].ArchivedAt, book.Modified)
+
+	for _, bounds := range [][2]int{{0, 6}, {4, 2}, {-1, 0}} {
+		_, err = buildEPUBBook(archive, defaultRoyalRoadURL+"/fiction/42", chapters, bounds[0], bounds[1], nil, "")
+		assert.ErrorIs(t, err, errChapterRange, bounds)
+	}
+}
+
+func TestWriteEPUB(t *testing.T) {
+	book := EPUBBook{
+		ID:          "urn:royalroad:fiction:42",
+		Title:       "Swords & Sorcery",
+		Author:      "Ann",
+		Description: "A <synopsis>.",
+		Source:      "https://www.royalroad.com/fiction/42",
+		Language:    "en",
+		Modified:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Chapters: []EPUBChapter{
+			{Title: "Prologue", Content: `<p>Hello<br>world <img src="https://example.com/a.png" alt="[map]"></p>`, NoteBefore: "<p>Welcome</p>"},
+			{Title: "Chapter 1", Content: "<p>Onward</p>"},
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeEPUB(&buf, book))
+
+	names, files := readEPUB(t, buf.Bytes())
+	assert.Equal(t, "mimetype", names[0])
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["META-INF/container.xml"], `full-path="OEBPS/content.opf"`)
+
+	opf := files["OEBPS/content.opf"]
+	assert.Contains(t, opf, "<dc:title>Swords &amp; Sorcery</dc:title>")
+	assert.Contains(t, opf, "<dc:creator>Ann</dc:creator>")
+	assert.Contains(t, opf, "<dc:description>A &lt;synopsis&gt;.</dc:description>")
+	assert.Contains(t, opf, `<meta property="dcterms:modified">2024-01-02T03:04:05Z</meta>`)
+	assert.Contains(t, opf, `properties="cover-image"`)
+	assert.Contains(t, opf, `<itemref idref="chapter-2"/>`)
+
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<a href="chapter-0002.xhtml">Chapter 1</a>`)
+	assert.Contains(t, files["OEBPS/toc.ncx"], `<content src="chapter-0001.xhtml"/>`)
+	assert.Contains(t, files["OEBPS/cover.svg"], "Swords &amp; Sorcery")
+
+	prologue := files["OEBPS/chapter-0001.xhtml"]
+	assert.Contains(t, prologue, `<aside class="author-note"><p>Welcome</p></aside>`)
+	assert.Contains(t, prologue, `<p>Hello<br/>world [map]</p>`)
+	assert.NotContains(t, prologue, "example.com")
+
+	// Every XML document in the book must be well-formed
+	for name, content := range files {
+		if name == "mimetype" || strings.HasSuffix(name, ".css") {
+			continue
+		}
+		decoder := xml.NewDecoder(strings.NewReader(content))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err, name)
+		}
+	}
+}
+
+func TestEPUBHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedArchive(t, memory)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /archive/{id}/epub", s.requireUser(s.epubHandler))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/epub", "alice", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/epub+zip", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="test-book.epub"`, rr.Header().Get("Content-Disposition"))
+	_, files := readEPUB(t, rr.Body.Bytes())
+	assert.Contains(t, files["OEBPS/chapter-0001.xhtml"], "<p>Final</p>")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/epub?from=2", "alice", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/epub?to=x", "alice", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/archive/42/epub", "bob", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"html/template"
+	"math/rand"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fakeLayouts are the page layouts the fake site can serve. "redesign" renames the classes the
+// crawler looks for, as a RoyalRoad redesign would
+var fakeLayouts = []string{"current", "redesign"}
+
+// fakeSiteOptions seed the fake site's fictions and set how it misbehaves
+type fakeSiteOptions struct {
+	// Seed and Fictions decide what is generated; the same seed always gives the same site
+	Seed     int64
+	Fictions int
+	// PageSize is how many fictions a ranking list page shows
+	PageSize int
+	// RateLimitEvery answers every nth request with 429 Too Many Requests; 0 never does
+	RateLimitEvery int
+	// Delay slows down every response
+	Delay  time.Duration
+	Layout string
+	// Removed are the fiction IDs whose pages answer 404 and that no longer appear on any list
+	Removed []string
+	// ChallengeAfter answers every request after the first n with a Cloudflare-style challenge, as
+	// if the crawler had been taken for a bot; 0 never does
+	ChallengeAfter int
+}
+
+func defaultFakeSiteOptions() fakeSiteOptions {
+	return fakeSiteOptions{Seed: 1, Fictions: 50, PageSize: 20, Layout: "current"}
+}
+
+// fakeFiction is a generated fiction with everything its pages show
+type fakeFiction struct {
+	ID        string
+	Slug      string
+	Title     string
+	Author    fakeAuthor
+	Tags      []string
+	Synopsis  string
+	Followers int
+	Views     int
+	Rating    float64
+	Chapters  []fakeChapter
+	Reviews   []fakeReview
+}
+
+type fakeAuthor struct {
+	ID   string
+	Name string
+}
+
+type fakeChapter struct {
+	ID          string
+	Slug        string
+	Title       string
+	PublishedAt time.Time
+	Paragraphs  []string
+}
+
+type fakeReview struct {
+	ID       string
+	Reviewer fakeAuthor
+	Title    string
+	Text     string
+	Overall  float64
+	Style    float64
+	PostedAt time.Time
+}
+
+// Link is the path of a fiction's page
+func (f fakeFiction) Link() string {
+	return "/fiction/" + f.ID + "/" + f.Slug
+}
+
+// ChapterLink is the path of one of the fiction's chapters
+func (f fakeFiction) ChapterLink(c fakeChapter) string {
+	return f.Link() + "/chapter/" + c.ID + "/" + c.Slug
+}
+
+// fakeSite is a stand-in for RoyalRoad serving ranking lists, fiction, chapter and author pages
+// generated from a seed, so the crawlers can be developed and tested without the real site
+type fakeSite struct {
+	options  fakeSiteOptions
+	fictions []fakeFiction
+	requests atomic.Int64
+	handler  http.Handler
+}
+
+var (
+	fakeTitleStarts = []string{"The", "A Tale of", "Return of the", "Chronicles of the", "Ballad of the", "Rise of the", "Ashes of the", "Song of the"}
+	fakeTitleWords  = []string{"Clockwork", "Silent", "Hollow", "Crimson", "Last", "Wandering", "Iron", "Glass", "Salt", "Ember", "Sunken", "Paper"}
+	fakeTitleEnds   = []string{"Archive", "Crown", "Gate", "Dungeon", "Circuit", "Tower", "Garden", "Oath", "Lantern", "Engine", "Sea", "Witch"}
+	fakeFirstNames  = []string{"Ilse", "Tomas", "Mara", "Ode", "Kenji", "Ria", "Bram", "Sola", "Vee", "Anselm"}
+	fakeLastNames   = []string{"Varga", "Reyes", "Okafor", "Lind", "Sato", "Quill", "Marsh", "Holt", "Ferro", "Wynn"}
+	fakeTags        = []string{"Fantasy", "Sci-fi", "LitRPG", "Progression", "Mystery", "Romance", "Adventure", "Portal Fantasy / Isekai", "Slice of Life", "War and Military"}
+	fakeSentences   = []string{
+		"The lantern guttered as the door creaked open.",
+		"Nobody in the city remembered the old road.",
+		"She counted the coins twice and still came up short.",
+		"Somewhere below, the engine began to hum again.",
+		"He had promised himself he would not go back.",
+		"The map was wrong, and that was the point.",
+		"Rain hammered on the roof of the archive all night.",
+		"It ends on a question.",
+	}
+	fakeReviewTitles = []string{"Quietly brilliant", "Slow start", "Great worldbuilding", "Lost me halfway", "Hidden gem"}
+	fakeReviewTexts  = []string{"Really engaging characters, loved it.", "The pacing drags but the prose is good.", "Boring and slow.", "One of the best systems I have read."}
+)
+
+// fakeChallengePage is the interstitial served instead of a page once the site challenges the crawler
+const fakeChallengePage = `<!DOCTYPE html>
+<html lang="en-US">
+<head><title>Just a moment...</title></head>
+<body>
+<div class="main-wrapper"><h1>www.royalroad.com</h1><p>Verifying you are human. Enable JavaScript and cookies to continue.</p></div>
+<script>window._cf_chl_opt = {cType: 'managed'};</script>
+</body>
+</html>
+`
+
+// fakeEpoch is when the generated fictions start publishing, so pages don't change with the clock
+var fakeEpoch = time.Date(2026, time.January, 5, 12, 0, 0, 0, time.UTC)
+
+// newFakeSite generates the fictions of options.Seed and returns the site serving them
+func newFakeSite(options fakeSiteOptions) *fakeSite {
+	r := rand.New(rand.NewSource(options.Seed))
+	authors := make([]fakeAuthor, max(1, options.Fictions*2/3))
+	for i := range authors {
+		authors[i] = fakeAuthor{
+			ID:   strconv.Itoa(70001 + i),
+			Name: fakeFirstNames[r.Intn(len(fakeFirstNames))] + " " + fakeLastNames[r.Intn(len(fakeLastNames))],
+		}
+	}
+
+	site := &fakeSite{options: options}
+	for i := 0; i < options.Fictions; i++ {
+		title := fmt.Sprintf("%s %s %s", fakeTitleStarts[r.Intn(len(fakeTitleStarts))],
+			fakeTitleWords[r.Intn(len(fakeTitleWords))], fakeTitleEnds[r.Intn(len(fakeTitleEnds))])
+		fiction := fakeFiction{
+			ID:        strconv.Itoa(50001 + i),
+			Slug:      fakeSlug(title),
+			Title:     title,
+			Author:    authors[i%len(authors)],
+			Synopsis:  fakeParagraph(r, 2),
+			Followers: 100 + r.Intn(20000),
+			Views:     1000 + r.Intn(2000000),
+			Rating:    float64(300+r.Intn(201)) / 100,
+		}
+		for _, tag := range r.Perm(len(fakeTags))[:1+r.Intn(3)] {
+			fiction.Tags = append(fiction.Tags, fakeTags[tag])
+		}
+		published := fakeEpoch.Add(time.Duration(r.Intn(24*90)) * time.Hour)
+		chapters, reviews := 3+r.Intn(10), r.Intn(4)
+		for j := 0; j < chapters; j++ {
+			chapterTitle := fmt.Sprintf("Chapter %d", j+1)
+			paragraphs := make([]string, 3+r.Intn(5))
+			for k := range paragraphs {
+				paragraphs[k] = fakeParagraph(r, 1+r.Intn(4))
+			}
+			fiction.Chapters = append(fiction.Chapters, fakeChapter{
+				ID:          strconv.Itoa((50001+i)*100 + j),
+				Slug:        fakeSlug(chapterTitle),
+				Title:       chapterTitle,
+				PublishedAt: published,
+				Paragraphs:  paragraphs,
+			})
+			published = published.Add(time.Duration(5+r.Intn(5)) * 24 * time.Hour)
+		}
+		for j := 0; j < reviews; j++ {
+			fiction.Reviews = append(fiction.Reviews, fakeReview{
+				ID:       strconv.Itoa((50001+i)*10 + j),
+				Reviewer: authors[r.Intn(len(authors))],
+				Title:    fakeReviewTitles[r.Intn(len(fakeReviewTitles))],
+				Text:     fakeReviewTexts[r.Intn(len(fakeReviewTexts))],
+				Overall:  float64(2+r.Intn(7)) / 2,
+				Style:    float64(2+r.Intn(7)) / 2,
+				PostedAt: published.Add(time.Duration(r.Intn(72)) * time.Hour),
+			})
+		}
+		site.fictions = append(site.fictions, fiction)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fictions/{list}", site.listHandler)
+	mux.HandleFunc("GET /fiction/{id}/{slug}", site.fictionHandler)
+	mux.HandleFunc("GET /fiction/{id}/{slug}/chapter/{chapter}/{chapterSlug}", site.chapterHandler)
+	mux.HandleFunc("GET /profile/{id}/fictions", site.profileHandler)
+	site.handler = site.misbehave(mux)
+	return site
+}
+
+func fakeSlug(title string) string {
+	return strings.ToLower(strings.Join(strings.FieldsFunc(title, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}), "-"))
+}
+
+func fakeParagraph(r *rand.Rand, sentences int) string {
+	parts := make([]string, sentences)
+	for i := range parts {
+		parts[i] = fakeSentences[r.Intn(len(fakeSentences))]
+	}
+	return strings.Join(parts, " ")
+}
+
+// fakeCount writes a counter the way RoyalRoad does, such as 12,345
+func fakeCount(n int) string {
+	digits := strconv.Itoa(n)
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+func (s *fakeSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// misbehave slows down every response and rate limits every nth request, as configured
+func (s *fakeSite) misbehave(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.requests.Add(1)
+		if s.options.Delay > 0 {
+			select {
+			case <-time.After(s.options.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if after := int64(s.options.ChallengeAfter); after > 0 && n > after {
+			w.Header().Set("Server", "cloudflare")
+			w.Header().Set("Cf-Mitigated", "challenge")
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, fakeChallengePage)
+			return
+		}
+		if every := int64(s.options.RateLimitEvery); every > 0 && n%every == 0 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listed returns the fictions not removed, ordered for a ranking list, or false for an unknown list
+func (s *fakeSite) listed(list string) ([]fakeFiction, bool) {
+	var fictions []fakeFiction
+	for _, fiction := range s.fictions {
+		if !slices.Contains(s.options.Removed, fiction.ID) {
+			fictions = append(fictions, fiction)
+		}
+	}
+	var less func(a, b fakeFiction) bool
+	switch list {
+	case "active-popular", "weekly-popular":
+		less = func(a, b fakeFiction) bool { return a.Followers > b.Followers }
+	case "best-rated":
+		less = func(a, b fakeFiction) bool { return a.Rating > b.Rating }
+	case "trending":
+		less = func(a, b fakeFiction) bool { return a.Views > b.Views }
+	case "latest-updates":
+		less = func(a, b fakeFiction) bool {
+			return a.Chapters[len(a.Chapters)-1].PublishedAt.After(b.Chapters[len(b.Chapters)-1].PublishedAt)
+		}
+	default:
+		return nil, false
+	}
+	sort.SliceStable(fictions, func(i, j int) bool { return less(fictions[i], fictions[j]) })
+	return fictions, true
+}
+
+func (s *fakeSite) find(id string) (fakeFiction, bool) {
+	if slices.Contains(s.options.Removed, id) {
+		return fakeFiction{}, false
+	}
+	for _, fiction := range s.fictions {
+		if fiction.ID == id {
+			return fiction, true
+		}
+	}
+	return fakeFiction{}, false
+}
+
+func (s *fakeSite) listHandler(w http.ResponseWriter, r *http.Request) {
+	fictions, ok := s.listed(r.PathValue("list"))
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	pageSize := max(1, s.options.PageSize)
+	pages := max(1, (len(fictions)+pageSize-1)/pageSize)
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	start := min((page-1)*pageSize, len(fictions))
+	end := min(start+pageSize, len(fictions))
+	numbers := make([]int, pages)
+	for i := range numbers {
+		numbers[i] = i + 1
+	}
+	previous, next := page-1, page+1
+	if next > pages {
+		next = 0
+	}
+	s.render(w, r, http.StatusOK, "fake_list.html", map[string]any{
+		"Title":       strings.ReplaceAll(r.PathValue("list"), "-", " "),
+		"Fictions":    fictions[start:end],
+		"Page":        page,
+		"PageNumbers": numbers,
+		"Previous":    previous,
+		"Next":        next,
+	})
+}
+
+func (s *fakeSite) fictionHandler(w http.ResponseWriter, r *http.Request) {
+	fiction, ok := s.find(r.PathValue("id"))
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	s.render(w, r, http.StatusOK, "fake_fiction.html", map[string]any{"Title": fiction.Title, "Fiction": fiction})
+}
+
+func (s *fakeSite) chapterHandler(w http.ResponseWriter, r *http.Request) {
+	fiction, ok := s.find(r.PathValue("id"))
+	if !ok {
+		s.notFound(w, r)
+		return
+	}
+	for _, chapter := range fiction.Chapters {
+		if chapter.ID == r.PathValue("chapter") {
+			s.render(w, r, http.StatusOK, "fake_chapter.html", map[string]any{
+				"Title":   chapter.Title + " - " + fiction.Title,
+				"Fiction": fiction,
+				"Chapter": chapter,
+			})
+			return
+		}
+	}
+	s.notFound(w, r)
+}
+
+func (s *fakeSite) profileHandler(w http.ResponseWriter, r *http.Request) {
+	var author fakeAuthor
+	var fictions []fakeFiction
+	for _, fiction := range s.fictions {
+		if fiction.Author.ID == r.PathValue("id") && !slices.Contains(s.options.Removed, fiction.ID) {
+			author = fiction.Author
+			fictions = append(fictions, fiction)
+		}
+	}
+	if author.ID == "" {
+		s.notFound(w, r)
+		return
+	}
+	s.render(w, r, http.StatusOK, "fake_profile.html", map[string]any{"Title": author.Name + "'s Fictions", "Fictions": fictions})
+}
+
+func (s *fakeSite) notFound(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, http.StatusNotFound, "fake_not_found.html", map[string]any{"Title": "Not Found"})
+}
+
+// render writes a page of the fake site in its configured layout. Pages carry an ETag of their
+// content, and a request already holding that version gets 304 Not Modified
+func (s *fakeSite) render(w http.ResponseWriter, r *http.Request, status int, page string, data map[string]any) {
+	data["Redesign"] = s.options.Layout == "redesign"
+	tmpl, err := template.New(page).Funcs(template.FuncMap{
+		"unix":  func(t time.Time) int64 { return t.Unix() },
+		"count": fakeCount,
+	}).ParseFS(templateFS, "templates/fake/"+page, "templates/fake/fake_layout.html")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), 500)
+		return
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to render page: %s", err), 500)
+		return
+	}
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if status == http.StatusOK {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// parseFakeSiteFlags registers the options of the fake site on flags
+func parseFakeSiteFlags(flags *flag.FlagSet, args []string) (fakeSiteOptions, error) {
+	options := defaultFakeSiteOptions()
+	flags.Int64Var(&options.Seed, "seed", options.Seed, "seed of the generated fictions")
+	flags.IntVar(&options.Fictions, "fictions", options.Fictions, "number of fictions generated")
+	flags.IntVar(&options.PageSize, "page-size", options.PageSize, "fictions per ranking list page")
+	flags.IntVar(&options.RateLimitEvery, "rate-limit-every", 0, "answer every nth request with 429 Too Many Requests")
+	flags.DurationVar(&options.Delay, "delay", 0, "delay before every response, such as 2s")
+	flags.StringVar(&options.Layout, "layout", options.Layout, "page layout: current, or redesign to break the crawler's selectors")
+	flags.IntVar(&options.ChallengeAfter, "challenge-after", 0, "answer every request after the first n with a Cloudflare-style challenge")
+	removed := flags.String("removed", "", "comma-separated fiction IDs whose pages answer 404")
+	if err := flags.Parse(args); err != nil {
+		return fakeSiteOptions{}, err
+	}
+	if !slices.Contains(fakeLayouts, options.Layout) {
+		return fakeSiteOptions{}, fmt.Errorf("unknown layout %q, expected one of %v", options.Layout, fakeLayouts)
+	}
+	if options.Fictions < 1 || options.PageSize < 1 {
+		return fakeSiteOptions{}, fmt.Errorf("fictions and page size must be at least 1")
+	}
+	for _, id := range strings.Split(*removed, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			options.Removed = append(options.Removed, id)
+		}
+	}
+	return options, nil
+}
+
+func runFakeRoyalRoadCommand(args []string) error {
+	flags := flag.NewFlagSet("fake-royalroad", flag.ContinueOnError)
+	addr := flags.String("addr", ":8091", "address the fake site listens on")
+	options, err := parseFakeSiteFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+
+	site := newFakeSite(options)
+	loggerFrom(ctx).Info("Serving a fake RoyalRoad", "fictions", len(site.fictions), "layout", options.Layout,
+		"removed", len(options.Removed))
+	return listenAndServe(ctx, Config{Server: ServerConfig{Addr: *addr}}, site)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeSite serves a fake RoyalRoad and points c at it
+func startFakeSite(t *testing.T, c *crawler, options fakeSiteOptions) (*fakeSite, CrawlConfig) {
+	site := newFakeSite(options)
+	server := httptest.NewServer(site)
+	t.Cleanup(server.Close)
+
+	c.baseURL = server.URL
+
+	cfg := defaultConfig().Crawl
+	cfg.BaseURL = server.URL
+	return site, cfg
+}
+
+func fetchFake(t *testing.T, cfg CrawlConfig, path string) (int, string) {
+	resp, err := http.Get(cfg.BaseURL + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestFakeSite_Seeded(t *testing.T) {
+	first := newFakeSite(defaultFakeSiteOptions())
+	again := newFakeSite(defaultFakeSiteOptions())
+	other := newFakeSite(fakeSiteOptions{Seed: 2, Fictions: 50, PageSize: 20, Layout: "current"})
+
+	assert.Equal(t, first.fictions, again.fictions, "the same seed generates the same fictions")
+	assert.NotEqual(t, first.fictions[0].Title, other.fictions[0].Title)
+	assert.Equal(t, 50, len(first.fictions))
+}
+
+func TestFakeSite_CrawlList(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 12, PageSize: 5, Layout: "current"})
+
+	books, err := s.crawler.crawlList(ctx, "popular", cfg, true)
+
+	require.NoError(t, err)
+	require.Equal(t, cfg.BookLimit, len(books), "list pages are followed up to the book limit")
+	ranked, _ := site.listed("active-popular")
+	top := ranked[0]
+	assert.Equal(t, top.Title, books[0].Title)
+	assert.Equal(t, cfg.BaseURL+top.Link(), books[0].Link)
+	assert.Equal(t, top.Followers, books[0].Followers)
+	assert.Equal(t, top.Tags, books[0].Tags)
+
+	chapters, err := memory.GetChapters(ctx, top.ID)
+	require.NoError(t, err)
+	require.Equal(t, len(top.Chapters), len(chapters))
+	assert.Positive(t, chapters[0].WordCount, "chapter pages are measured")
+	author, err := memory.GetAuthor(ctx, top.Author.ID)
+	require.NoError(t, err)
+	assert.Equal(t, top.Author.Name, author.Name)
+}
+
+func TestFakeSite_Pagination(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 12, PageSize: 5, Layout: "current"})
+
+	first, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL(), 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), site.requests.Load(), "a page holding enough books is the only one fetched")
+	last, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL()+"?page=3", 5)
+	require.NoError(t, err)
+
+	assert.Equal(t, 5, len(first))
+	assert.Equal(t, 2, len(last))
+	assert.GreaterOrEqual(t, first[4].Followers, last[0].Followers)
+	_, body := fetchFake(t, cfg, "/fictions/active-popular?page=2")
+	assert.Contains(t, body, `href="?page=3"`)
+	assert.Contains(t, body, `href="?page=1"`)
+}
+
+func TestFetchBooks_FollowsPagination(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 12, PageSize: 5, Layout: "current"})
+
+	books, err := s.crawler.fetchBooks(ctx, "popular", cfg.ListURL(), 7)
+	require.NoError(t, err)
+	require.Equal(t, 7, len(books))
+	assert.Equal(t, int64(2), site.requests.Load())
+	listed, _ := site.listed("active-popular")
+	for i, book := range books {
+		assert.Equal(t, listed[i].ID, book.FictionID, "books keep their rank across pages")
+	}
+	snapshots, err := memory.GetSnapshotsSince(ctx, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 10, len(snapshots), "every position crawled is snapshotted")
+
+	all, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 12, len(all), "the crawl stops at the last page")
+}
+
+func TestFakeSite_RemovedFiction(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 6, PageSize: 20, Layout: "current"})
+	removed := site.fictions[0]
+	site.options.Removed = []string{removed.ID}
+
+	books, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(books))
+	for _, book := range books {
+		assert.NotEqual(t, removed.ID, book.FictionID)
+	}
+
+	status, _ := fetchFake(t, cfg, removed.Link())
+	assert.Equal(t, http.StatusNotFound, status)
+	s.crawler.crawlFictionPages(ctx, []Book{{FictionID: removed.ID, Link: cfg.BaseURL + removed.Link()}})
+	events, err := memory.GetStatusEvents(ctx, removed.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, FictionStatusRemoved, events[0].Status)
+}
+
+func TestFakeSite_RateLimit(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 3, PageSize: 20, RateLimitEvery: 2, Layout: "current"})
+
+	status, _ := fetchFake(t, cfg, "/fictions/active-popular")
+	assert.Equal(t, http.StatusOK, status)
+	_, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL(), 10)
+	require.Error(t, err)
+	status, _ = fetchFake(t, cfg, "/fictions/active-popular")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestFakeSite_Delay(t *testing.T) {
+	s, _ := testServer(t)
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 3, PageSize: 20, Delay: 50 * time.Millisecond, Layout: "current"})
+
+	start := time.Now()
+	status, _ := fetchFake(t, cfg, "/fictions/best-rated")
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestFakeSite_Redesign(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 7, Fictions: 3, PageSize: 20, Layout: "redesign"})
+
+	books, err := s.crawler.scrapeBooks(ctx, "popular", cfg.ListURL(), 10)
+
+	require.NoError(t, err)
+	assert.Empty(t, books, "the crawler's selectors no longer match")
+	_, body := fetchFake(t, cfg, "/fictions/active-popular")
+	assert.Contains(t, body, "fiction-card")
+}
+
+func TestFakeSite_NotFound(t *testing.T) {
+	s, _ := testServer(t)
+	_, cfg := startFakeSite(t, s.crawler, defaultFakeSiteOptions())
+
+	for _, path := range []string{"/fictions/unknown-list", "/fiction/1/missing", "/profile/1/fictions", "/fiction/50001/slug/chapter/1/missing"} {
+		status, body := fetchFake(t, cfg, path)
+		assert.Equal(t, http.StatusNotFound, status, path)
+		assert.Contains(t, body, "You're lost", path)
+	}
+}
+
+func TestParseFakeSiteFlags(t *testing.T) {
+	options, err := parseFakeSiteFlags(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-seed", "3", "-removed", "50002, 50004", "-rate-limit-every", "5", "-delay", "2s", "-layout", "redesign", "-challenge-after", "40"})
+	require.NoError(t, err)
+	assert.Equal(t, fakeSiteOptions{Seed: 3, Fictions: 50, PageSize: 20, RateLimitEvery: 5, Delay: 2 * time.Second,
+		Layout: "redesign", Removed: []string{"50002", "50004"}, ChallengeAfter: 40}, options)
+
+	_, err = parseFakeSiteFlags(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-layout", "retro"})
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "retro"))
+}
+
+func TestFakeCount(t *testing.T) {
+	assert.Equal(t, "999", fakeCount(999))
+	assert.Equal(t, "12,345", fakeCount(12345))
+	assert.Equal(t, "1,234,567", fakeCount(1234567))
+}
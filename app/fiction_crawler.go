@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly/v2"
+)
+
+var (
+	authorIDPattern  = regexp.MustCompile(`/profile/(\d+)`)
+	reviewIDPattern  = regexp.MustCompile(`^review-(\d+)$`)
+	chapterIDPattern = regexp.MustCompile(`/chapter/(\d+)`)
+	numberPattern    = regexp.MustCompile(`\d+(\.\d+)?`)
+)
+
+// FictionPage is what we read from a fiction's own page
+type FictionPage struct {
+	FictionID string
+	Title     string
+	Author    Author
+	Reviews   []Review
+	Chapters  []Chapter
+	// StubNotice is the text of a notice saying the fiction was stubbed
+	StubNotice string
+	Status     FetchStatus
+}
+
+// fetchFictionPage scrapes a fiction page for the details the ranking lists don't show
+func (c *crawler) fetchFictionPage(ctx context.Context, link string) (FictionPage, error) {
+	collector := c.newCollector(ctx)
+
+	page := FictionPage{FictionID: fictionIDFromURL(link)}
+
+	collector.OnHTML(".fic-header", func(e *colly.HTMLElement) {
+		page.Title = strings.TrimSpace(e.ChildText("h1"))
+		e.ForEachWithBreak("a[href*='/profile/']", func(_ int, a *colly.HTMLElement) bool {
+			href := a.Attr("href")
+			page.Author = Author{
+				ID:   authorIDFromLink(href),
+				Name: strings.TrimSpace(a.Text),
+				Link: a.Request.AbsoluteURL(href),
+			}
+			return false
+		})
+	})
+
+	collector.OnHTML(".review", func(e *colly.HTMLElement) {
+		review, ok := parseReview(e, page.FictionID)
+		if !ok {
+			crawlParseFailures.WithLabelValues("review").Inc()
+			return
+		}
+		crawlItems.WithLabelValues("review").Inc()
+		page.Reviews = append(page.Reviews, review)
+	})
+
+	collector.OnHTML("#chapters tr.chapter-row", func(e *colly.HTMLElement) {
+		href := e.ChildAttr("td a[href*='/chapter/']", "href")
+		match := chapterIDPattern.FindStringSubmatch(href)
+		if match == nil {
+			crawlParseFailures.WithLabelValues("chapter").Inc()
+			return
+		}
+		crawlItems.WithLabelValues("chapter").Inc()
+		chapter := Chapter{
+			ID:        match[1],
+			FictionID: page.FictionID,
+			Title:     strings.TrimSpace(e.ChildText("td:first-child a")),
+			Link:      e.Request.AbsoluteURL(href),
+		}
+		if unix, err := strconv.ParseInt(e.ChildAttr("time", "unixtime"), 10, 64); err == nil {
+			chapter.PublishedAt = time.Unix(unix, 0).UTC()
+		}
+		page.Chapters = append(page.Chapters, chapter)
+	})
+
+	collector.OnHTML(".alert, .description", func(e *colly.HTMLElement) {
+		if page.StubNotice == "" {
+			page.StubNotice = stubNotice(e.Text)
+		}
+	})
+
+	status, err := c.visit(collector, link)
+	if err != nil {
+		return FictionPage{FictionID: page.FictionID, Status: status}, fmt.Errorf("failed to fetch %s: %w", link, err)
+	}
+	page.Status = status
+	return page, nil
+}
+
+// chapterMeasureLimit caps how many chapter pages one crawl fetches to count words,
+// so a long backlog is worked through over several crawls
+const chapterMeasureLimit = 100
+
+// crawlFictionPages fetches the page of each book once, saving the reviews and chapters found on it,
+// records status changes such as stubs and removals, and measures chapters not counted yet.
+// It returns the pages by fiction ID. Fiction pages are crawled by a pool of the crawler's workers,
+// which hand the chapters they find to a second pool measuring them. A fiction that fails is logged
+// and recorded in the progress of the crawl, so the others are still crawled. Cancelling ctx, or
+// the site blocking the crawler, stops the crawl and returns the pages crawled so far
+func (c *crawler) crawlFictionPages(ctx context.Context, books []Book) map[string]FictionPage {
+	logger := loggerFrom(ctx)
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	unique := make(chan Book, len(books))
+	seen := make(map[string]bool)
+	for _, book := range books {
+		if book.FictionID == "" || book.Link == "" || seen[book.FictionID] {
+			continue
+		}
+		seen[book.FictionID] = true
+		unique <- book
+	}
+	close(unique)
+	progress := c.progress.start(ctx, crawlListFrom(ctx), len(unique))
+
+	var mu sync.Mutex
+	pages := make(map[string]FictionPage)
+	var measureBudget atomic.Int64
+	measureBudget.Store(chapterMeasureLimit)
+
+	chapters := make(chan Chapter)
+	measured := make(chan struct{})
+	go func() {
+		defer close(measured)
+		forEach(ctx, c.workers, chapters, func(chapter Chapter) error {
+			return c.measureChapter(ctx, chapter)
+		}, func(chapter Chapter, err error) {
+			if err != nil {
+				logger.Warn("Failed to measure chapter", "chapter_id", chapter.ID, "error", err)
+			}
+			progress.chapterDone(err)
+			if errors.As(err, new(*BlockedError)) {
+				stop()
+			}
+		})
+	}()
+
+	forEach(ctx, c.workers, unique, func(book Book) error {
+		page, unmeasured, err := c.crawlFictionPage(ctx, book)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		pages[book.FictionID] = page
+		mu.Unlock()
+		for _, chapter := range unmeasured {
+			if measureBudget.Add(-1) < 0 {
+				break
+			}
+			progress.chapterQueued()
+			chapters <- chapter
+		}
+		return nil
+	}, func(book Book, err error) {
+		if err != nil {
+			logger.Warn("Failed to crawl fiction page", "fiction_id", book.FictionID, "error", err)
+		}
+		progress.fictionDone(book.FictionID, err)
+		if errors.As(err, new(*BlockedError)) {
+			// The other pages would be refused until the host is unpaused
+			stop()
+		}
+	})
+	close(chapters)
+	<-measured
+
+	result := progress.finish()
+	logger.Info("Crawled fiction pages", "fictions", result.Fictions, "done", result.FictionsDone, "failed", result.FictionsFailed,
+		"chapters", result.ChaptersDone, "cancelled", result.Cancelled)
+	return pages
+}
+
+// crawlFictionPage fetches the page of one book and saves what is on it, returning the page and
+// the chapters whose words are still to be counted
+func (c *crawler) crawlFictionPage(ctx context.Context, book Book) (FictionPage, []Chapter, error) {
+	logger := loggerFrom(ctx)
+	page, err := c.fetchFictionPage(ctx, book.Link)
+	if err := c.checkFictionStatus(ctx, book, page, time.Now().UTC()); err != nil {
+		logger.Error("Failed to check fiction status", "fiction_id", book.FictionID, "error", err)
+	}
+	if err != nil {
+		return FictionPage{}, nil, err
+	}
+	if err := c.store.SaveReviews(ctx, page.Reviews); err != nil {
+		logger.Error("Failed to save reviews", "fiction_id", book.FictionID, "error", err)
+	}
+	chapters, err := c.mergeChapters(ctx, page.Chapters)
+	if err != nil {
+		logger.Error("Failed to load chapters", "fiction_id", book.FictionID, "error", err)
+		return page, nil, nil
+	}
+	if err := c.store.SaveChapters(ctx, chapters); err != nil {
+		logger.Error("Failed to save chapters", "fiction_id", book.FictionID, "error", err)
+	}
+	logger.Debug("Crawled fiction page", "fiction_id", book.FictionID, "reviews", len(page.Reviews), "chapters", len(chapters))
+	var unmeasured []Chapter
+	for _, chapter := range chapters {
+		if chapter.WordCount == 0 && chapter.Link != "" {
+			unmeasured = append(unmeasured, chapter)
+		}
+	}
+	return page, unmeasured, nil
+}
+
+// mergeChapters keeps what was measured on chapters we already stored, as fiction pages don't show it
+func (c *crawler) mergeChapters(ctx context.Context, chapters []Chapter) ([]Chapter, error) {
+	if len(chapters) == 0 {
+		return chapters, nil
+	}
+	stored, err := c.store.GetChapters(ctx, chapters[0].FictionID)
+	if err != nil {
+		return nil, err
+	}
+	wordCounts := make(map[string]int, len(stored))
+	for _, chapter := range stored {
+		wordCounts[chapter.ID] = chapter.WordCount
+	}
+	merged := make([]Chapter, len(chapters))
+	for i, chapter := range chapters {
+		chapter.WordCount = wordCounts[chapter.ID]
+		merged[i] = chapter
+	}
+	return merged, nil
+}
+
+// measureChapter counts the words of a chapter and saves it
+func (c *crawler) measureChapter(ctx context.Context, chapter Chapter) error {
+	page, err := c.fetchChapter(ctx, chapter.Link)
+	if err != nil {
+		return err
+	}
+	chapter.WordCount = page.Words
+	if err := c.store.SaveChapters(ctx, []Chapter{chapter}); err != nil {
+		loggerFrom(ctx).Error("Failed to save chapter", "chapter_id", chapter.ID, "error", err)
+	}
+	return nil
+}
+
+// ChapterPage is what we read from a chapter's own page
+type ChapterPage struct {
+	// Content is the sanitized HTML of the chapter body
+	Content    string
+	NoteBefore string
+	NoteAfter  string
+	Words      int
+}
+
+// fetchChapter scrapes a chapter page for its body and the author's notes around it
+func (c *crawler) fetchChapter(ctx context.Context, link string) (ChapterPage, error) {
+	collector := c.newCollector(ctx)
+
+	var page ChapterPage
+	var sanitizeErr error
+	seenContent := false
+	// The stylesheets are read first, as callbacks run in the order they are registered
+	hidden := make(map[string]bool)
+	collector.OnHTML("style", func(e *colly.HTMLElement) {
+		maps.Copy(hidden, hiddenClasses(e.Text))
+	})
+	// One selector so the notes and the body are visited in page order
+	collector.OnHTML(".chapter-content, .author-note", func(e *colly.HTMLElement) {
+		raw, err := e.DOM.Html()
+		if err != nil {
+			sanitizeErr = err
+			return
+		}
+		clean, err := sanitizeHTML(raw, hidden)
+		if err != nil {
+			sanitizeErr = err
+			return
+		}
+
+		switch {
+		case e.DOM.HasClass("chapter-content"):
+			words, err := countWords(clean)
+			if err != nil {
+				sanitizeErr = err
+				return
+			}
+			crawlItems.WithLabelValues("chapter_content").Inc()
+			seenContent = true
+			page.Content = clean
+			page.Words = words
+		case seenContent:
+			page.NoteAfter = clean
+		default:
+			page.NoteBefore = clean
+		}
+	})
+
+	if _, err := c.visit(collector, link); err != nil {
+		return ChapterPage{}, fmt.Errorf("failed to fetch %s: %w", link, err)
+	}
+	if sanitizeErr != nil {
+		crawlParseFailures.WithLabelValues("chapter_content").Inc()
+		return ChapterPage{}, fmt.Errorf("failed to sanitize %s: %v", link, sanitizeErr)
+	}
+	return page, nil
+}
+
+// countWords counts the words of a sanitized chapter body, so hidden paragraphs aren't counted.
+// Paragraph text runs together in the text of the body, so paragraphs are counted one by one
+func countWords(content string) (int, error) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(content))
+	if err != nil {
+		return 0, err
+	}
+	words := 0
+	paragraphs := doc.Find("p").Each(func(_ int, p *goquery.Selection) {
+		words += len(strings.Fields(p.Text()))
+	})
+	if paragraphs.Length() == 0 {
+		words = len(strings.Fields(doc.Text()))
+	}
+	return words, nil
+}
+
+// withTrackedFictions adds the fictions users follow or archived to the listed books, so their pages
+// keep being crawled after they drop off the lists
+func (c *crawler) withTrackedFictions(ctx context.Context, books []Book) ([]Book, error) {
+	follows, err := c.store.GetAllFollows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	archives, err := c.store.GetAllArchivedFictions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var fictionIDs []string
+	for _, follow := range follows {
+		fictionIDs = append(fictionIDs, follow.FictionID)
+	}
+	for _, archive := range archives {
+		fictionIDs = append(fictionIDs, archive.FictionID)
+	}
+
+	listed := make(map[string]bool, len(books))
+	for _, book := range books {
+		listed[book.FictionID] = true
+	}
+	tracked := append([]Book(nil), books...)
+	for _, fictionID := range fictionIDs {
+		if listed[fictionID] {
+			continue
+		}
+		listed[fictionID] = true
+		tracked = append(tracked, Book{FictionID: fictionID, Link: c.fictionLink(fictionID)})
+	}
+	return tracked, nil
+}
+
+// parseReview reads one review block: reviewer, date, sub-scores and text
+func parseReview(e *colly.HTMLElement, fictionID string) (Review, bool) {
+	match := reviewIDPattern.FindStringSubmatch(e.Attr("id"))
+	if match == nil {
+		return Review{}, false
+	}
+
+	review := Review{
+		ID:        match[1],
+		FictionID: fictionID,
+		Reviewer:  strings.TrimSpace(e.ChildText(".review-meta a[href*='/profile/']")),
+		Title:     strings.TrimSpace(e.ChildText(".review-title")),
+		Text:      strings.TrimSpace(e.ChildText(".review-content")),
+		Scores:    make(map[string]float64),
+	}
+	if unix, err := strconv.ParseInt(e.ChildAttr(".review-meta time", "unixtime"), 10, 64); err == nil {
+		review.PostedAt = time.Unix(unix, 0).UTC()
+	}
+
+	// Scores are star widgets labelled "Overall Score", "Style Score" and so on
+	e.ForEach(".review-side [data-original-title][aria-label]", func(_ int, score *colly.HTMLElement) {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(score.Attr("data-original-title"), "Score")))
+		value, err := strconv.ParseFloat(numberPattern.FindString(score.Attr("aria-label")), 64)
+		if name != "" && err == nil {
+			review.Scores[name] = value
+		}
+	})
+	return review, true
+}
+
+// fetchAuthorFictions scrapes the fictions listed on an author's profile
+func (c *crawler) fetchAuthorFictions(ctx context.Context, profileLink string) ([]AuthorFiction, error) {
+	collector := c.newCollector(ctx)
+
+	var fictions []AuthorFiction
+	collector.OnHTML(".fiction-list-item", func(e *colly.HTMLElement) {
+		title := e.ChildText(".fiction-title")
+		href := e.ChildAttr(".fiction-title a", "href")
+		if title == "" || href == "" {
+			return
+		}
+		fictions = append(fictions, AuthorFiction{
+			FictionID: fictionIDFromURL(href),
+			Title:     title,
+			Link:      e.Request.AbsoluteURL(href),
+		})
+	})
+
+	link := strings.TrimSuffix(profileLink, "/") + "/fictions"
+	if _, err := c.visit(collector, link); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", link, err)
+	}
+	return fictions, nil
+}
+
+// fictionIDFromURL extracts the fiction ID from an absolute or relative fiction URL
+func fictionIDFromURL(link string) string {
+	if i := strings.Index(link, "/fiction/"); i >= 0 {
+		return fictionIDFromLink(link[i:])
+	}
+	return ""
+}
+
+// authorIDFromLink extracts the numeric profile ID from a "/profile/{id}" link
+func authorIDFromLink(link string) string {
+	match := authorIDPattern.FindStringSubmatch(link)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
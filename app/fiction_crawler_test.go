@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeRoyalRoad serves a fiction page and its author's profile
+func newFakeRoyalRoad(t *testing.T, authorFictions string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fiction/42/test-book", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`
+			<!DOCTYPE html>
+			<html>
+			<body>
+				<div class="fic-header">
+					<div class="fic-title">
+						<h1 class="font-white">Test Book</h1>
+						<h4 class="font-white"><span>by </span><span><a href="/profile/7" class="font-white">Test Author</a></span></h4>
+					</div>
+				</div>
+				<table id="chapters">
+					<tbody>
+						<tr class="chapter-row">
+							<td><a href="/fiction/42/test-book/chapter/1001/prologue">Prologue</a></td>
+							<td><a href="/fiction/42/test-book/chapter/1001/prologue"><time unixtime="1699000000">2 weeks ago</time></a></td>
+						</tr>
+						<tr class="chapter-row">
+							<td><a href="/fiction/42/test-book/chapter/1002/chapter-one">Chapter One</a></td>
+							<td><a href="/fiction/42/test-book/chapter/1002/chapter-one"><time unixtime="1699600000">1 week ago</time></a></td>
+						</tr>
+					</tbody>
+				</table>
+				<div class="review" id="review-501">
+					<div class="review-side">
+						<div data-original-title="Overall Score" aria-label="4.5 stars"></div>
+						<div data-original-title="Style Score" aria-label="4 stars"></div>
+					</div>
+					<div class="review-inner">
+						<div class="review-title">Great start</div>
+						<div class="review-meta"><a href="/profile/8">Reader One</a> <time unixtime="1700000000">Nov 14, 2023</time></div>
+						<div class="review-content"><p>Really engaging characters, loved it.</p></div>
+					</div>
+				</div>
+				<div class="review" id="review-502">
+					<div class="review-inner">
+						<div class="review-title">Dropped it</div>
+						<div class="review-meta"><a href="/profile/9">Reader Two</a></div>
+						<div class="review-content"><p>Boring and slow.</p></div>
+					</div>
+				</div>
+			</body>
+			</html>
+		`))
+	})
+	mux.HandleFunc("/fiction/42/test-book/chapter/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`
+			<!DOCTYPE html>
+			<html>
+			<head><style>.cnWzE { display: none; speak: never; }</style></head>
+			<body>
+				<div class="portlet"><div class="author-note"><p>Welcome back!</p></div></div>
+				<div class="chapter-inner chapter-content"><p>It was a dark</p><p class="cnWzE">Stolen from Royal Road</p><p>and stormy night.</p><script>track()</script></div>
+				<div class="portlet"><div class="author-note"><p>Thanks for reading!</p></div></div>
+			</body>
+			</html>
+		`))
+	})
+	mux.HandleFunc("/profile/7/fictions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<!DOCTYPE html><html><body>` + authorFictions + `</body></html>`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+const twoAuthorFictions = `
+	<div class="fiction-list-item">
+		<h2 class="fiction-title"><a href="/fiction/42/test-book">Test Book</a></h2>
+	</div>
+	<div class="fiction-list-item">
+		<h2 class="fiction-title"><a href="/fiction/43/older-book">Older Book</a></h2>
+	</div>
+`
+
+func TestFetchFictionPage(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+
+	page, err := s.crawler.fetchFictionPage(ctx, server.URL+"/fiction/42/test-book")
+
+	require.NoError(t, err)
+	assert.Equal(t, "42", page.FictionID)
+	assert.Equal(t, "Test Book", page.Title)
+	assert.Equal(t, Author{ID: "7", Name: "Test Author", Link: server.URL + "/profile/7"}, page.Author)
+	assert.Equal(t, []Review{
+		{
+			ID:        "501",
+			FictionID: "42",
+			Reviewer:  "Reader One",
+			Title:     "Great start",
+			PostedAt:  time.Unix(1700000000, 0).UTC(),
+			Scores:    map[string]float64{"overall": 4.5, "style": 4},
+			Text:      "Really engaging characters, loved it.",
+		},
+		{
+			ID:        "502",
+			FictionID: "42",
+			Reviewer:  "Reader Two",
+			Title:     "Dropped it",
+			Scores:    map[string]float64{},
+			Text:      "Boring and slow.",
+		},
+	}, page.Reviews)
+	assert.Equal(t, []Chapter{
+		{ID: "1001", FictionID: "42", Title: "Prologue", Link: server.URL + "/fiction/42/test-book/chapter/1001/prologue", PublishedAt: time.Unix(1699000000, 0).UTC()},
+		{ID: "1002", FictionID: "42", Title: "Chapter One", Link: server.URL + "/fiction/42/test-book/chapter/1002/chapter-one", PublishedAt: time.Unix(1699600000, 0).UTC()},
+	}, page.Chapters)
+}
+
+func TestFetchFictionPage_Fixtures(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	replayFixtures(s.crawler)
+
+	page, err := s.crawler.fetchFictionPage(ctx, "https://www.royalroad.com/fiction/41001/the-clockwork-archive")
+
+	require.NoError(t, err)
+	assert.Equal(t, "The Clockwork Archive", page.Title)
+	assert.Equal(t, Author{ID: "300101", Name: "Ilse Varga", Link: "https://www.royalroad.com/profile/300101"}, page.Author)
+	require.Equal(t, 2, len(page.Reviews))
+	assert.Equal(t, map[string]float64{"overall": 5, "style": 4.5, "story": 5}, page.Reviews[0].Scores)
+	assert.Equal(t, "Tern", page.Reviews[1].Reviewer)
+	require.Equal(t, 2, len(page.Chapters))
+	assert.Equal(t, Chapter{
+		ID:          "2000002",
+		FictionID:   "41001",
+		Title:       "1. The Ledger",
+		Link:        "https://www.royalroad.com/fiction/41001/the-clockwork-archive/chapter/2000002/1-the-ledger",
+		PublishedAt: time.Unix(1789600000, 0).UTC(),
+	}, page.Chapters[1])
+	assert.Empty(t, page.StubNotice)
+	assert.Equal(t, 200, page.Status.StatusCode)
+}
+
+func TestCrawlFictionPages_SavesReviews(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+	books := []Book{
+		{Title: "Test Book", Link: server.URL + "/fiction/42/test-book", FictionID: "42"},
+		{Title: "Missing", Link: server.URL + "/fiction/99/missing", FictionID: "99"},
+	}
+
+	pages := s.crawler.crawlFictionPages(ctx, books)
+
+	assert.Equal(t, 1, len(pages))
+	assert.Equal(t, "Test Book", pages["42"].Title)
+	reviews, err := memory.GetReviews(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(reviews))
+	chapters, err := memory.GetChapters(ctx, "42")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(chapters))
+	assert.Equal(t, 7, chapters[0].WordCount)
+
+	// A later crawl keeps the word counts the fiction page doesn't show
+	s.crawler.crawlFictionPages(ctx, books[:1])
+	chapters, err = memory.GetChapters(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, 7, chapters[1].WordCount)
+}
+
+func TestFetchChapter(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+
+	page, err := s.crawler.fetchChapter(ctx, server.URL+"/fiction/42/test-book/chapter/1001/prologue")
+
+	require.NoError(t, err)
+	assert.Equal(t, ChapterPage{
+		Content:    "<p>It was a dark</p><p>and stormy night.</p>",
+		NoteBefore: "<p>Welcome back!</p>",
+		NoteAfter:  "<p>Thanks for reading!</p>",
+		Words:      7,
+	}, page)
+}
+
+func TestFetchChapter_Fixtures(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	replayFixtures(s.crawler)
+
+	page, err := s.crawler.fetchChapter(ctx, "https://www.royalroad.com/fiction/41001/the-clockwork-archive/chapter/2000001/prologue")
+
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Thanks for reading! New chapters every Monday.</p>", page.NoteBefore)
+	assert.Contains(t, page.Content, "<p>It ends on a question.</p>")
+	assert.Empty(t, page.NoteAfter)
+	assert.Equal(t, 23, page.Words)
+}
+
+func TestWithTrackedFictions(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "42", Kind: FollowKindFollow}))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "bob", FictionID: "77", Kind: FollowKindFavorite}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "bob", FictionID: "88"}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "77"}))
+	books := []Book{{Title: "Test Book", Link: "https://www.royalroad.com/fiction/42/test-book", FictionID: "42"}}
+
+	tracked, err := s.crawler.withTrackedFictions(ctx, books)
+
+	require.NoError(t, err)
+	assert.Equal(t, []Book{
+		books[0],
+		{FictionID: "77", Link: "https://www.royalroad.com/fiction/77"},
+		{FictionID: "88", Link: "https://www.royalroad.com/fiction/88"},
+	}, tracked)
+}
+
+func TestFetchFictionPage_NotFound(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+
+	_, err := s.crawler.fetchFictionPage(ctx, server.URL+"/fiction/99/missing")
+
+	assert.Error(t, err)
+}
+
+func TestFetchAuthorFictions(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+
+	fictions, err := s.crawler.fetchAuthorFictions(ctx, server.URL+"/profile/7")
+
+	require.NoError(t, err)
+	assert.Equal(t, []AuthorFiction{
+		{FictionID: "42", Title: "Test Book", Link: server.URL + "/fiction/42/test-book"},
+		{FictionID: "43", Title: "Older Book", Link: server.URL + "/fiction/43/older-book"},
+	}, fictions)
+}
+
+func TestIDsFromLinks(t *testing.T) {
+	assert.Equal(t, "42", fictionIDFromURL("https://www.royalroad.com/fiction/42/slug"))
+	assert.Equal(t, "42", fictionIDFromURL("/fiction/42"))
+	assert.Equal(t, "", fictionIDFromURL("https://www.royalroad.com/profile/7"))
+	assert.Equal(t, "7", authorIDFromLink("https://www.royalroad.com/profile/7"))
+	assert.Equal(t, "", authorIDFromLink("/fiction/42"))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errNoFixture is returned when replaying a page that was never recorded
+var errNoFixture = errors.New("no fixture recorded")
+
+// fixtureNamePattern matches the characters not kept in fixture file names
+var fixtureNamePattern = regexp.MustCompile(`[^A-Za-z0-9._=-]+`)
+
+// fixture describes a recorded response. The body is kept next to it in a file of its own so
+// recorded pages can be read and edited as HTML
+type fixture struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	RecordedAt time.Time   `json:"recorded_at"`
+}
+
+// fixtureTransport saves every response fetched through next to dir, or with replay set serves
+// the saved responses without touching the network
+type fixtureTransport struct {
+	dir    string
+	replay bool
+	next   http.RoundTripper
+}
+
+// newFixtureTransport returns the transport the crawl settings ask for, recording the pages fetched
+// through next, or nil to fetch live pages
+func newFixtureTransport(cfg CrawlConfig, next http.RoundTripper) http.RoundTripper {
+	switch {
+	case cfg.Offline:
+		return fixtureTransport{dir: cfg.Fixtures, replay: true}
+	case cfg.RecordFixtures:
+		return fixtureTransport{dir: cfg.Fixtures, next: next}
+	}
+	return nil
+}
+
+func (t fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := fixturePath(t.dir, req.URL)
+	if t.replay {
+		return replayFixture(path, req)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFixture(path, req.URL, resp, body); err != nil {
+		return nil, fmt.Errorf("failed to record %s: %v", req.URL, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// fixturePath names the files of a URL: a directory per host and the path and query flattened
+// into a file name, such as www.royalroad.com/fiction_42_some-book
+func fixturePath(dir string, u *url.URL) string {
+	name := strings.Trim(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		name += "?" + u.RawQuery
+	}
+	name = strings.Trim(fixtureNamePattern.ReplaceAllString(name, "_"), "_")
+	if name == "" {
+		name = "index"
+	}
+	return filepath.Join(dir, fixtureNamePattern.ReplaceAllString(u.Host, "_"), name)
+}
+
+// writeFixture saves a response, leaving out the cookies the site set
+func writeFixture(path string, u *url.URL, resp *http.Response, body []byte) error {
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	data, err := json.MarshalIndent(fixture{
+		URL:        u.String(),
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		RecordedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".json", append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".html", body, 0o644)
+}
+
+// replayFixture answers req with the response saved at path. A page that wasn't recorded fails like
+// a network error, so the crawl treats it as unreachable rather than removed
+func replayFixture(path string, req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return nil, fmt.Errorf("can't replay %s %s, only GET requests are recorded", req.Method, req.URL)
+	}
+	data, err := os.ReadFile(path + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %s", errNoFixture, req.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recorded fixture
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %v", path, err)
+	}
+	if recorded.URL != req.URL.String() {
+		return nil, fmt.Errorf("fixture %s was recorded for %s, not %s", path, recorded.URL, req.URL)
+	}
+	body, err := os.ReadFile(path + ".html")
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayFixtures makes the collectors of c replay the pages recorded in testdata/fixtures
+func replayFixtures(c *crawler) {
+	useCrawlTransport(c, fixtureTransport{dir: filepath.Join("testdata", "fixtures"), replay: true})
+}
+
+func useCrawlTransport(c *crawler, transport http.RoundTripper) {
+	c.transport = transport
+}
+
+func TestFixturePath(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.royalroad.com/fictions/active-popular", "www.royalroad.com/fictions_active-popular"},
+		{"https://www.royalroad.com/fiction/42/some-book/", "www.royalroad.com/fiction_42_some-book"},
+		{"https://www.royalroad.com/fictions/active-popular?page=2&genre=fantasy", "www.royalroad.com/fictions_active-popular_page=2_genre=fantasy"},
+		{"http://127.0.0.1:8080/", "127.0.0.1_8080/index"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join("fixtures", tt.want), fixturePath("fixtures", u), tt.url)
+	}
+}
+
+func TestFixtureTransport_RecordThenReplay(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Set-Cookie", "session=secret")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte(`<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/7/recorded">Recorded</a></h2></div>`))
+	}))
+	dir := t.TempDir()
+
+	useCrawlTransport(s.crawler, fixtureTransport{dir: dir, next: http.DefaultTransport})
+	books, err := s.crawler.scrapeBooks(ctx, "fixture-test", server.URL+"/list", 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(books))
+	status, err := s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/missing")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, status.StatusCode)
+
+	u, err := url.Parse(server.URL + "/list")
+	require.NoError(t, err)
+	recorded, err := os.ReadFile(fixturePath(dir, u) + ".json")
+	require.NoError(t, err)
+	assert.NotContains(t, string(recorded), "secret", "cookies are not recorded")
+
+	// Replayed pages don't need the server
+	server.Close()
+	useCrawlTransport(s.crawler, fixtureTransport{dir: dir, replay: true})
+	replayed, err := s.crawler.scrapeBooks(ctx, "fixture-test", server.URL+"/list", 10)
+	require.NoError(t, err)
+	assert.Equal(t, books, replayed)
+	status, err = s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/missing")
+	require.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, status.StatusCode, "error statuses are replayed too")
+}
+
+func TestFixtureTransport_NotRecorded(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	useCrawlTransport(s.crawler, fixtureTransport{dir: t.TempDir(), replay: true})
+
+	status, err := s.crawler.visit(s.crawler.newCollector(ctx), "https://www.royalroad.com/fiction/404")
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errNoFixture)
+	assert.Equal(t, 0, status.StatusCode, "a page never recorded is unreachable, not missing")
+}
+
+func TestNewFixtureTransport(t *testing.T) {
+	cfg := defaultConfig().Crawl
+	assert.Nil(t, newFixtureTransport(cfg, http.DefaultTransport))
+
+	cfg.Offline = true
+	assert.Equal(t, fixtureTransport{dir: cfg.Fixtures, replay: true}, newFixtureTransport(cfg, http.DefaultTransport))
+
+	cfg.Offline, cfg.RecordFixtures = false, true
+	assert.Equal(t, fixtureTransport{dir: cfg.Fixtures, next: http.DefaultTransport}, newFixtureTransport(cfg, http.DefaultTransport))
+}
+
+func TestOfflineReplay_MemoryStore(t *testing.T) {
+	original := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(original) })
+	cfg, err := loadConfig(flag.NewFlagSet("test", flag.ContinueOnError), []string{
+		"-offline", "-fixtures", filepath.Join("testdata", "fixtures"), "-mongodb-uri", memoryDatabaseURI, "-book-limit", "3",
+	}, envFrom(nil))
+	require.NoError(t, err)
+	deps, shutdown, err := applyConfig(cfg)
+	require.NoError(t, err)
+	defer shutdown(context.Background())
+
+	ctx := context.Background()
+	books, err := deps.crawler.fetchPopularBooks(ctx, ctx, cfg.Crawl)
+	require.NoError(t, err)
+	require.Len(t, books, 3)
+	deps.crawler.wait()
+
+	stored, err := deps.store.GetBooks(ctx)
+	require.NoError(t, err)
+	assert.Len(t, stored, 3, "the replayed list is saved without MongoDB")
+	authors, err := deps.store.GetAuthors(ctx)
+	require.NoError(t, err)
+	assert.Len(t, authors, 3, "the replayed fiction and profile pages are crawled too")
+}
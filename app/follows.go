@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// followsHandler lists the follows of the current user
+func (s *server) followsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	follows, err := s.store.GetFollows(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load follows: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if follows == nil {
+		follows = []Follow{}
+	}
+	writeJSON(w, follows)
+}
+
+// addFollowHandler follows or favorites a fiction for the current user
+func (s *server) addFollowHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	kind, ok := followKind(r.FormValue("kind"))
+	if !ok {
+		http.Error(w, "Unknown follow kind", http.StatusBadRequest)
+		return
+	}
+	fictionID := r.FormValue("fiction_id")
+	if fictionID == "" {
+		http.Error(w, "Missing fiction_id", http.StatusBadRequest)
+		return
+	}
+	if !fictionIDFormat.MatchString(fictionID) {
+		http.Error(w, "Invalid fiction_id", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := s.currentUser(r)
+	follow := Follow{UserID: userID, FictionID: fictionID, Kind: kind, CreatedAt: time.Now().UTC()}
+	if err := s.store.SaveFollow(r.Context(), follow); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save follow: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONStatus(w, http.StatusCreated, follow)
+}
+
+// deleteFollowHandler removes a follow or favorite of the current user
+func (s *server) deleteFollowHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := followKind(r.URL.Query().Get("kind"))
+	if !ok {
+		http.Error(w, "Unknown follow kind", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := s.currentUser(r)
+	if err := s.store.DeleteFollow(r.Context(), userID, r.PathValue("id"), kind); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete follow: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// followKind validates a follow kind, defaulting to a plain follow
+func followKind(kind string) (string, bool) {
+	switch kind {
+	case "", FollowKindFollow:
+		return FollowKindFollow, true
+	case FollowKindFavorite:
+		return FollowKindFavorite, true
+	}
+	return "", false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUserRequest builds a request authenticated as the given test user
+func newUserRequest(ctx context.Context, method, target, userID string, form url.Values) *http.Request {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode())).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, target, nil).WithContext(ctx)
+	}
+	req.SetBasicAuth(userID, testUsers[userID])
+	return req
+}
+
+func followsMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/follows", s.requireUser(s.followsHandler))
+	mux.HandleFunc("POST /api/follows", s.requireUser(s.addFollowHandler))
+	mux.HandleFunc("DELETE /api/follows/{id}", s.requireUser(s.deleteFollowHandler))
+	return mux
+}
+
+func TestFollowLifecycle(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	mux := followsMux(s)
+
+	// Follow and favorite the same fiction; following twice is a no-op
+	for _, kind := range []string{"follow", "favorite", "follow"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/follows", "alice", url.Values{"fiction_id": {"42"}, "kind": {kind}}))
+		assert.Equal(t, http.StatusCreated, rr.Code)
+		assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/follows", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var follows []Follow
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &follows))
+	assert.Equal(t, 2, len(follows))
+
+	// Follows are per user
+	bobFollows, err := memory.GetFollows(ctx, "bob")
+	require.NoError(t, err)
+	assert.Empty(t, bobFollows)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "DELETE", "/api/follows/42?kind=favorite", "alice", nil))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	follows, err = memory.GetFollows(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(follows))
+	assert.Equal(t, FollowKindFollow, follows[0].Kind)
+}
+
+func TestAddFollowHandler_Validation(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	mux := followsMux(s)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/follows", "alice", url.Values{"fiction_id": {"42"}, "kind": {"stalk"}}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/follows", "alice", url.Values{"kind": {"follow"}}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/follows", "alice", url.Values{"fiction_id": {"42?x=1"}, "kind": {"follow"}}))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	follows, err := s.store.GetFollows(ctx, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, follows)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/follows", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+)
+
+// readyCrawlAge is how recent a successful crawl must be for an instance with an empty cache to be ready
+const readyCrawlAge = time.Hour
+
+// statusFailureLimit caps the crawl errors shown on the status page
+const statusFailureLimit = 20
+
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// startedAt is when the process started, for the uptime shown on the status page
+var startedAt = time.Now()
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// buildInfo reads the version and the VCS details Go embeds in the binary
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.BuildTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// StoreStatus is the outcome of pinging the store
+type StoreStatus struct {
+	OK      bool          `json:"ok"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+func checkStore(ctx context.Context, store BookStore) StoreStatus {
+	start := time.Now()
+	err := store.Ping(ctx)
+	status := StoreStatus{OK: err == nil, Latency: time.Since(start).Round(time.Microsecond)}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// CacheStatus describes the books cached for the main page
+type CacheStatus struct {
+	Books     int       `json:"books"`
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+func (s *server) cacheStatus() CacheStatus {
+	status := CacheStatus{Books: len(s.books.get())}
+	if updated := cacheUpdatedAt.Load(); updated != 0 {
+		status.UpdatedAt = time.Unix(0, updated)
+	}
+	return status
+}
+
+// lastSuccessfulCrawl returns when the most recent crawl without errors finished
+func lastSuccessfulCrawl(runs []CrawlRun) (time.Time, bool) {
+	var last time.Time
+	for _, run := range runs {
+		if run.Error == "" && run.FinishedAt.After(last) {
+			last = run.FinishedAt
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// Readiness is the answer of /readyz
+type Readiness struct {
+	Ready bool   `json:"ready"`
+	Store string `json:"store"`
+	Books string `json:"books"`
+}
+
+// assessReadiness requires a reachable store and either cached books or a recent successful crawl
+func assessReadiness(store StoreStatus, cache CacheStatus, runs []CrawlRun, now time.Time) Readiness {
+	readiness := Readiness{Store: "ok", Books: "ok"}
+	if !store.OK {
+		readiness.Store = store.Error
+	}
+
+	if cache.Books == 0 {
+		last, ok := lastSuccessfulCrawl(runs)
+		switch {
+		case !ok:
+			readiness.Books = "no books cached and no successful crawl yet"
+		case now.Sub(last) > readyCrawlAge:
+			readiness.Books = fmt.Sprintf("no books cached and the last successful crawl was %s ago", now.Sub(last).Round(time.Second))
+		}
+	}
+
+	readiness.Ready = readiness.Store == "ok" && readiness.Books == "ok"
+	return readiness
+}
+
+// healthzHandler reports that the process is up
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler reports whether the instance can serve traffic, with 503 when it can't
+func (s *server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	readiness := assessReadiness(checkStore(r.Context(), s.store), s.cacheStatus(), s.crawler.runs.list(), time.Now())
+	if !readiness.Ready {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writeJSON(w, readiness)
+}
+
+// ServiceStatus is everything shown on the status page
+type ServiceStatus struct {
+	Build     BuildInfo
+	StartedAt time.Time
+	Uptime    time.Duration
+	Store     StoreStatus
+	Cache     CacheStatus
+	Ready     Readiness
+	Runs      []CrawlRun
+	Progress  []CrawlProgress
+	Blocks    []HostBlock
+	Failures  []FetchStatus
+}
+
+// loadServiceStatus gathers the status page, with the most recent crawl errors first
+func (s *server) loadServiceStatus(ctx context.Context, now time.Time) ServiceStatus {
+	var failures []FetchStatus
+	for _, status := range s.crawler.statuses.list() {
+		if status.Error != "" {
+			failures = append(failures, status)
+		}
+	}
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].FetchedAt.After(failures[j].FetchedAt)
+	})
+	if len(failures) > statusFailureLimit {
+		failures = failures[:statusFailureLimit]
+	}
+
+	status := ServiceStatus{
+		Build:     buildInfo(),
+		StartedAt: startedAt,
+		Uptime:    now.Sub(startedAt).Round(time.Second),
+		Store:     checkStore(ctx, s.store),
+		Cache:     s.cacheStatus(),
+		Runs:      s.crawler.runs.list(),
+		Progress:  s.crawler.progress.list(),
+		Blocks:    s.crawler.blocks.list(),
+		Failures:  failures,
+	}
+	status.Ready = assessReadiness(status.Store, status.Cache, status.Runs, now)
+	return status
+}
+
+// serviceStatusHandler renders the human-readable status page, with the configuration shown redacted
+func (s *server) serviceStatusHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := json.MarshalIndent(s.cfg.Redacted(), "", "  ")
+	if err != nil {
+		config = []byte(err.Error())
+	}
+	status := s.loadServiceStatus(r.Context(), time.Now())
+
+	tmpl, err := renderStatusPage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, struct {
+		ServiceStatus
+		Config string
+	}{status, string(config)})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
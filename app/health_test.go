@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStore is a store that can't be reached
+type failingStore struct {
+	*memoryStore
+}
+
+func (failingStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestAssessReadiness(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	up := StoreStatus{OK: true}
+	down := StoreStatus{Error: "connection refused"}
+	recent := []CrawlRun{{List: "popular", FinishedAt: now.Add(-10 * time.Minute)}}
+	stale := []CrawlRun{{List: "popular", FinishedAt: now.Add(-2 * time.Hour)}}
+	failed := []CrawlRun{{List: "popular", FinishedAt: now, Error: "Service Unavailable"}}
+
+	tests := []struct {
+		name  string
+		store StoreStatus
+		cache CacheStatus
+		runs  []CrawlRun
+		ready Readiness
+	}{
+		{"cached books", up, CacheStatus{Books: 10}, nil, Readiness{Ready: true, Store: "ok", Books: "ok"}},
+		{"recent crawl", up, CacheStatus{}, recent, Readiness{Ready: true, Store: "ok", Books: "ok"}},
+		{"store down", down, CacheStatus{Books: 10}, nil, Readiness{Store: "connection refused", Books: "ok"}},
+		{"nothing crawled", up, CacheStatus{}, nil, Readiness{Store: "ok", Books: "no books cached and no successful crawl yet"}},
+		{"failed crawl", up, CacheStatus{}, failed, Readiness{Store: "ok", Books: "no books cached and no successful crawl yet"}},
+		{"stale crawl", up, CacheStatus{}, stale, Readiness{Store: "ok", Books: "no books cached and the last successful crawl was 2h0m0s ago"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ready, assessReadiness(tt.store, tt.cache, tt.runs, now))
+		})
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	setupCachedBooksForTest(s)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok\n", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// An unreachable store makes the instance unready but still alive
+	s.store = failingStore{memory}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var readiness Readiness
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &readiness))
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, "connection refused", readiness.Store)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestServiceStatusHandler(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	setupCachedBooksForTest(s)
+	now := time.Now().UTC()
+	s.crawler.runs.record(CrawlRun{List: "popular", StartedAt: now.Add(-time.Second), FinishedAt: now, Books: 10})
+	s.crawler.statuses.record(FetchStatus{URL: "https://www.royalroad.com/fiction/99", StatusCode: http.StatusNotFound, Error: "Not Found", FetchedAt: now})
+	s.crawler.statuses.record(FetchStatus{URL: "https://www.royalroad.com/fiction/1", StatusCode: http.StatusOK, FetchedAt: now})
+	blocks := useHostBlocks(s.crawler, time.Hour, time.Hour)
+	blocks.block(ctx, "www.royalroad.com", "https://www.royalroad.com/fictions/active-popular", 403, "HTTP 403 from Cloudflare", now)
+
+	status := s.loadServiceStatus(ctx, now)
+	assert.True(t, status.Ready.Ready)
+	assert.True(t, status.Store.OK)
+	assert.Equal(t, 3, status.Cache.Books)
+	assert.Equal(t, version, status.Build.Version)
+	require.Equal(t, 1, len(status.Failures))
+	assert.Equal(t, http.StatusNotFound, status.Failures[0].StatusCode)
+
+	rr := httptest.NewRecorder()
+	s.cfg.Users = "alice:secret"
+	s.serviceStatusHandler(rr, httptest.NewRequest("GET", "/status", nil).WithContext(ctx))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Version dev")
+	assert.Contains(t, body, "popular: 10 books")
+	assert.Contains(t, body, "https://www.royalroad.com/fiction/99")
+	assert.NotContains(t, body, "https://www.royalroad.com/fiction/1<")
+	assert.NotContains(t, body, "Not ready")
+	assert.Contains(t, body, "Blocked by www.royalroad.com: HTTP 403 from Cloudflare")
+	assert.Contains(t, body, "royalRoadBooks")
+	assert.Contains(t, body, "alice:xxxxx")
+	assert.NotContains(t, body, "secret")
+}
+
+func TestFetchBooks_RecordsCrawlRun(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := s.crawler.fetchBooks(ctx, "popular", server.URL, 10)
+	require.Error(t, err)
+
+	runs := s.crawler.runs.list()
+	require.Equal(t, 1, len(runs))
+	assert.Equal(t, "popular", runs[0].List)
+	assert.NotEmpty(t, runs[0].Error)
+	assert.False(t, runs[0].FinishedAt.Before(runs[0].StartedAt))
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Point is a single value of a time series
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// FictionHistory holds the time series built from a fiction's ranking snapshots
+type FictionHistory struct {
+	FictionID string             `json:"fiction_id"`
+	Title     string             `json:"title"`
+	Ranks     map[string][]Point `json:"ranks"`
+	Followers []Point            `json:"followers"`
+	Views     []Point            `json:"views"`
+	Rating    []Point            `json:"rating"`
+}
+
+// buildHistory turns snapshots (oldest first) into per-list rank series and stat series.
+// A fiction can appear on several lists in one crawl, so stats are recorded once per crawl time.
+func buildHistory(fictionID string, snapshots []Snapshot) FictionHistory {
+	history := FictionHistory{
+		FictionID: fictionID,
+		Ranks:     make(map[string][]Point),
+	}
+	seen := make(map[time.Time]bool)
+	for _, snapshot := range snapshots {
+		history.Title = snapshot.Title
+		history.Ranks[snapshot.List] = append(history.Ranks[snapshot.List], Point{snapshot.CrawledAt, float64(snapshot.Rank)})
+		if seen[snapshot.CrawledAt] {
+			continue
+		}
+		seen[snapshot.CrawledAt] = true
+		history.Followers = append(history.Followers, Point{snapshot.CrawledAt, float64(snapshot.Followers)})
+		history.Views = append(history.Views, Point{snapshot.CrawledAt, float64(snapshot.Views)})
+		history.Rating = append(history.Rating, Point{snapshot.CrawledAt, snapshot.Rating})
+	}
+	return history
+}
+
+func loadHistory(ctx context.Context, store BookStore, fictionID string) (FictionHistory, error) {
+	snapshots, err := store.GetSnapshots(ctx, fictionID)
+	if err != nil {
+		return FictionHistory{}, err
+	}
+	return buildHistory(fictionID, snapshots), nil
+}
+
+// historyAPIHandler returns a fiction's rank and stats time series as JSON
+func (s *server) historyAPIHandler(w http.ResponseWriter, r *http.Request) {
+	history, err := loadHistory(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load history: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if len(history.Followers) == 0 {
+		http.Error(w, "Fiction not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, history)
+}
+
+// fictionHandler renders the fiction page with its history charts
+func (s *server) fictionHandler(w http.ResponseWriter, r *http.Request) {
+	history, err := loadHistory(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load history: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if len(history.Followers) == 0 {
+		http.Error(w, "Fiction not found", http.StatusNotFound)
+		return
+	}
+
+	authors, err := s.store.GetAuthors(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load authors: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	summary, reviews, err := loadReviews(r.Context(), s.store, history.FictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load reviews: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if len(reviews) > latestReviewCount {
+		reviews = reviews[:latestReviewCount]
+	}
+
+	cadence, err := loadCadence(r.Context(), s.store, history.FictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load chapters: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	words, chapters, err := loadWordStats(r.Context(), s.store, history.FictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load chapters: %s", err), http.StatusInternalServerError)
+		return
+	}
+	status, err := loadFictionStatus(r.Context(), s.store, history.FictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load status: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Signed-in readers also see how much they have left to read
+	var unread *UnreadContent
+	archived := false
+	if userID, ok := s.currentUser(r); ok {
+		if len(chapters) > 0 {
+			progress, err := s.store.GetProgress(r.Context(), userID)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Failed to load reading progress: %s", err), http.StatusInternalServerError)
+				return
+			}
+			content := buildUnreadContent(chapters, words, findProgress(progress, history.FictionID))
+			unread = &content
+		}
+		archives, err := s.store.GetArchivedFictions(r.Context(), userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load archives: %s", err), http.StatusInternalServerError)
+			return
+		}
+		for _, archive := range archives {
+			archived = archived || archive.FictionID == history.FictionID
+		}
+	}
+
+	tmpl, err := renderFictionPage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, struct {
+		FictionHistory
+		Author        *Author
+		ReviewSummary ReviewSummary
+		LatestReviews []ScoredReview
+		Cadence       Cadence
+		Words         WordStats
+		Unread        *UnreadContent
+		Archived      bool
+		Status        FictionStatus
+	}{history, authorOfFiction(authors, history.FictionID), summary, reviews, cadence, words, unread, archived, status})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seedSnapshots(t *testing.T, memory *memoryStore) time.Time {
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := memory.SaveSnapshots(ctx, []Snapshot{
+		{FictionID: "42", Title: "Test Book", List: "popular", Rank: 3, Followers: 100, Views: 1000, Rating: 4.5, CrawledAt: start},
+		{FictionID: "42", Title: "Test Book", List: "trending", Rank: 7, Followers: 100, Views: 1000, Rating: 4.5, CrawledAt: start},
+		{FictionID: "42", Title: "Test Book", List: "popular", Rank: 1, Followers: 150, Views: 1800, Rating: 4.6, CrawledAt: start.Add(24 * time.Hour)},
+	})
+	require.NoError(t, err)
+	return start
+}
+
+func TestBuildHistory(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+	start := seedSnapshots(t, memory)
+	snapshots, err := memory.GetSnapshots(ctx, "42")
+	require.NoError(t, err)
+
+	history := buildHistory("42", snapshots)
+
+	assert.Equal(t, "Test Book", history.Title)
+	assert.Equal(t, []Point{{start, 3}, {start.Add(24 * time.Hour), 1}}, history.Ranks["popular"])
+	assert.Equal(t, []Point{{start, 7}}, history.Ranks["trending"])
+
+	// Stats are recorded once per crawl even when the fiction is on several lists
+	assert.Equal(t, []Point{{start, 100}, {start.Add(24 * time.Hour), 150}}, history.Followers)
+	assert.Equal(t, 2, len(history.Views))
+	assert.Equal(t, 4.6, history.Rating[1].Value)
+}
+
+func TestHistoryAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fictions/{id}/history", s.historyAPIHandler)
+
+	req := httptest.NewRequest("GET", "/api/fictions/42/history", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var history FictionHistory
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &history))
+	assert.Equal(t, "42", history.FictionID)
+	assert.Equal(t, 2, len(history.Ranks["popular"]))
+}
+
+func TestHistoryAPIHandler_NotFound(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fictions/{id}/history", s.historyAPIHandler)
+
+	req := httptest.NewRequest("GET", "/api/fictions/999/history", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestFictionHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+	require.NoError(t, memory.SaveAuthor(ctx, Author{ID: "7", Name: "Test Author", Fictions: []AuthorFiction{{FictionID: "42"}}}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+
+	req := httptest.NewRequest("GET", "/fiction/42", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "<title>Royal Road - Test Book</title>")
+	assert.Contains(t, body, "<a class=\"back-link\" href=\"/author/7\">Test Author</a>")
+	assert.Contains(t, body, "Rank on popular")
+	assert.Contains(t, body, "Rank on trending")
+	assert.Contains(t, body, "class=\"sparkline\"")
+	assert.Contains(t, body, "class=\"chart\"")
+	// Charts are rendered server-side, HTMX is the only script pulled in
+	assert.Equal(t, 1, strings.Count(body, "<script src="))
+	assert.Contains(t, body, "hx-post=\"/api/follows\"")
+}
+
+func TestFictionHandler_Reviews(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+	require.NoError(t, memory.SaveReviews(ctx, []Review{
+		{ID: "1", FictionID: "42", Reviewer: "Reader One", Title: "Great start", Text: "Loved the magic system", Scores: map[string]float64{"overall": 4.5}},
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+
+	req := httptest.NewRequest("GET", "/fiction/42", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "1 reviews: 1 positive, 0 neutral, 0 negative")
+	assert.Contains(t, body, "Latest reviews")
+	assert.Contains(t, body, "by Reader One")
+}
+
+func TestFictionHandler_Cadence(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+	require.NoError(t, memory.SaveChapters(ctx, weeklyChapters(5)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+
+	req := httptest.NewRequest("GET", "/fiction/42", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Release schedule")
+	assert.Contains(t, body, "5 chapters since Jan 1, 2024")
+	assert.Contains(t, body, "Mon 5")
+}
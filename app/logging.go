@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	crawlListKey
+	revalidateKey
+)
+
+// requestIDHeader carries the request ID in and out, so a proxy's ID is kept when there is one
+const requestIDHeader = "X-Request-ID"
+
+// setupLogging makes slog's default logger write at the given level ("debug", "info", "warn"
+// or "error", info by default) in the given format ("text" by default, or "json").
+// The standard log package goes through it too
+func setupLogging(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// withLogger returns a context whose log lines go through logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// loggerFrom returns the logger of a request or crawl run, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// newID returns a random ID for a request or crawl run
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// startCrawlRun tags the log lines of one crawl with a run ID. It keeps the request ID of the
+// request that started it, so a /refresh click can be followed into the crawl
+func startCrawlRun(ctx context.Context, list string) context.Context {
+	logger := loggerFrom(ctx).With("crawl_id", newID(), "list", list)
+	logger.Info("Starting crawl")
+	return withLogger(context.WithValue(ctx, crawlListKey, list), logger)
+}
+
+// detachCrawlRun returns background carrying the crawl run of ctx, for the part of a crawl that
+// outlives the request that started it
+func detachCrawlRun(background, ctx context.Context) context.Context {
+	return withLogger(context.WithValue(background, crawlListKey, crawlListFrom(ctx)), loggerFrom(ctx))
+}
+
+// crawlListFrom returns the list of the crawl run of ctx, or "" outside a crawl run
+func crawlListFrom(ctx context.Context) string {
+	list, _ := ctx.Value(crawlListKey).(string)
+	return list
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// withRequestID gives every request an ID, attaches a logger carrying it to the request context
+// and logs the request once it is served
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		logger := slog.Default().With("request_id", id)
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(withLogger(r.Context(), logger)))
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(r.Context(), level, "Served request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs sends JSON logs at the given level to a buffer for the rest of the test
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	original := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(original) })
+	var buf bytes.Buffer
+	require.NoError(t, setupLogging(&buf, level, "json"))
+	return &buf
+}
+
+// logLines decodes the JSON log lines written so far
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), line)
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestSetupLogging(t *testing.T) {
+	buf := captureLogs(t, "warn")
+	slog.Info("hidden")
+	slog.Warn("shown", "count", 3)
+
+	lines := logLines(t, buf)
+	require.Equal(t, 1, len(lines))
+	assert.Equal(t, "WARN", lines[0]["level"])
+	assert.Equal(t, "shown", lines[0]["msg"])
+	assert.Equal(t, float64(3), lines[0]["count"])
+
+	assert.Error(t, setupLogging(&bytes.Buffer{}, "loud", "json"))
+	assert.Error(t, setupLogging(&bytes.Buffer{}, "info", "xml"))
+	assert.NoError(t, setupLogging(&bytes.Buffer{}, "", ""))
+}
+
+func TestWithRequestID(t *testing.T) {
+	buf := captureLogs(t, "info")
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loggerFrom(r.Context()).Info("Handling")
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/refresh", nil))
+	id := rr.Header().Get(requestIDHeader)
+	assert.Len(t, id, 16)
+
+	lines := logLines(t, buf)
+	require.Equal(t, 2, len(lines))
+	assert.Equal(t, id, lines[0]["request_id"])
+	assert.Equal(t, "Served request", lines[1]["msg"])
+	assert.Equal(t, "ERROR", lines[1]["level"])
+	assert.Equal(t, id, lines[1]["request_id"])
+	assert.Equal(t, "/refresh", lines[1]["path"])
+	assert.Equal(t, float64(http.StatusInternalServerError), lines[1]["status"])
+
+	// An incoming request ID is kept
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestIDHeader, "from-proxy")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, "from-proxy", rr.Header().Get(requestIDHeader))
+}
+
+func TestCrawlRunLogging(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	buf := captureLogs(t, "debug")
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+
+	ctx = withLogger(ctx, slog.Default().With("request_id", "req-1"))
+	_, err := s.crawler.fetchFictionPage(startCrawlRun(ctx, "popular"), server.URL+"/fiction/42/test-book")
+	require.NoError(t, err)
+
+	lines := logLines(t, buf)
+	require.GreaterOrEqual(t, len(lines), 3)
+	crawlID := lines[0]["crawl_id"]
+	assert.NotEmpty(t, crawlID)
+	var messages []interface{}
+	for _, line := range lines {
+		assert.Equal(t, "req-1", line["request_id"], line["msg"])
+		assert.Equal(t, crawlID, line["crawl_id"], line["msg"])
+		messages = append(messages, line["msg"])
+	}
+	assert.Equal(t, []interface{}{"Starting crawl", "Fetching page", "Fetched page"}, messages)
+}
@@ -1,60 +1,110 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strings"
 	"sync"
 )
 
-var (
-	cachedBooks []Book
-	booksMutex  sync.RWMutex
-)
+// server serves the pages and APIs from the store, the crawler and the users it was built with
+type server struct {
+	dependencies
+	cfg Config
+	// books is what the main page shows
+	books *bookCache
+	// load loads the main page books when nothing is cached or on /refresh
+	load bookLoader
+	// downloadArchive fetches a newly archived fiction in the background
+	downloadArchive func(ctx context.Context, book Book)
+}
 
-func booksHandler(w http.ResponseWriter, r *http.Request) {
-	var err error
-	
-	// If no cached books, fetch them
-	booksMutex.RLock()
-	needsFetch := len(cachedBooks) == 0
-	booksMutex.RUnlock()
-	
-	if needsFetch {
-		booksMutex.Lock()
-		// Double-check after acquiring write lock
-		if len(cachedBooks) == 0 {
-			cachedBooks, err = fetchPopularBooks()
-			if err != nil {
-				booksMutex.Unlock()
-				http.Error(w, fmt.Sprintf("Failed to fetch books: %s", err), http.StatusInternalServerError)
-				return
-			}
+// bookCache keeps the books served on the main page
+type bookCache struct {
+	mu    sync.RWMutex
+	books []Book
+}
+
+// get returns a copy of the cached books
+func (c *bookCache) get() []Book {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]Book(nil), c.books...)
+}
+
+// set replaces the cached books
+func (c *bookCache) set(books []Book) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.books = books
+	recordCacheUpdate(books)
+}
+
+// getOrLoad returns a copy of the cached books, loading them first with load when nothing is cached yet
+func (c *bookCache) getOrLoad(ctx context.Context, load bookLoader) ([]Book, error) {
+	if books := c.get(); len(books) > 0 {
+		return books, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Double-check after acquiring write lock
+	if len(c.books) == 0 {
+		books, err := load(ctx)
+		if err != nil {
+			return nil, err
 		}
-		booksMutex.Unlock()
+		c.books = books
+		recordCacheUpdate(books)
+	}
+	return append([]Book(nil), c.books...), nil
+}
+
+// bookLoader loads the books shown on the main page
+type bookLoader func(ctx context.Context) ([]Book, error)
+
+// crawledBooks crawls the configured list with c, leaving its detail pages to a crawl under background
+func crawledBooks(background context.Context, c *crawler, crawl CrawlConfig) bookLoader {
+	return func(ctx context.Context) ([]Book, error) {
+		return c.fetchPopularBooks(ctx, background, crawl)
 	}
+}
 
-	booksMutex.RLock()
-	booksCopy := make([]Book, len(cachedBooks))
-	copy(booksCopy, cachedBooks)
-	booksMutex.RUnlock()
+// savedBooks reads the last crawl of the configured list from the store of c
+func savedBooks(c *crawler, crawl CrawlConfig) bookLoader {
+	return func(ctx context.Context) ([]Book, error) {
+		return c.storedBooks(ctx, "popular", crawl.BookLimit)
+	}
+}
+
+// booksHandler serves the main page, loading the books first when nothing is cached yet
+func (s *server) booksHandler(w http.ResponseWriter, r *http.Request) {
+	books, err := s.books.getOrLoad(r.Context(), s.load)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch books: %s", err), http.StatusInternalServerError)
+		return
+	}
 
-	tmpl, err := renderPage(booksCopy)
+	tmpl, err := renderPage(r.Context(), books)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
 		return
 	}
 
 	// Execute the template with the books data
-	err = tmpl.Execute(w, booksCopy)
+	err = executeTemplate(r.Context(), w, tmpl, books)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
 		return
 	}
 }
 
-func searchHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) searchHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -63,83 +113,173 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	searchQuery := strings.ToLower(r.FormValue("search"))
 	
 	// Filter the cached books based on the search query
-	booksMutex.RLock()
-	var filteredBooks []Book
+	filteredBooks := s.books.get()
 	if searchQuery != "" {
-		for _, book := range cachedBooks {
+		var matching []Book
+		for _, book := range filteredBooks {
 			if strings.Contains(strings.ToLower(book.Title), searchQuery) {
-				filteredBooks = append(filteredBooks, book)
+				matching = append(matching, book)
 			}
 		}
-	} else {
-		filteredBooks = make([]Book, len(cachedBooks))
-		copy(filteredBooks, cachedBooks)
+		filteredBooks = matching
 	}
-	booksMutex.RUnlock()
 	
 	// Render just the book list part
-	tmpl, err := renderBookList(filteredBooks)
+	tmpl, err := renderBookList(r.Context(), filteredBooks)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
 		return
 	}
 	
-	err = tmpl.Execute(w, filteredBooks)
+	err = executeTemplate(r.Context(), w, tmpl, filteredBooks)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
 		return
 	}
 }
 
-func refreshHandler(w http.ResponseWriter, r *http.Request) {
-	var err error
-	
+// refreshHandler loads the books again and serves the new book list. While the list is still
+// being crawled, the cached books are served instead of starting another crawl
+func (s *server) refreshHandler(w http.ResponseWriter, r *http.Request) {
 	// Refetch books from the source
-	newBooks, err := fetchPopularBooks()
-	if err != nil {
+	books, err := s.load(r.Context())
+	if errors.Is(err, errCrawlRunning) {
+		books = s.books.get()
+	} else if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to fetch books: %s", err), http.StatusInternalServerError)
 		return
+	} else {
+		s.books.set(books)
 	}
-	
-	booksMutex.Lock()
-	cachedBooks = newBooks
-	booksCopy := make([]Book, len(cachedBooks))
-	copy(booksCopy, cachedBooks)
-	booksMutex.Unlock()
-	
+
 	// Render just the book list part
-	tmpl, err := renderBookList(booksCopy)
+	tmpl, err := renderBookList(r.Context(), books)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
 		return
 	}
-	
-	err = tmpl.Execute(w, booksCopy)
+
+	err = executeTemplate(r.Context(), w, tmpl, books)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
 		return
 	}
 }
 
-func main() {
-	// Initialize books on startup
-	var err error
-	initialBooks, err := fetchPopularBooks()
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus encodes v as the JSON response body with a status such as 201 Created. The
+// headers are only written once v is encoded, so a failure can still be reported as a 500
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
 	if err != nil {
-		log.Printf("Warning: Failed to pre-fetch books: %s", err)
-	} else {
-		booksMutex.Lock()
-		cachedBooks = initialBooks
-		booksMutex.Unlock()
+		http.Error(w, fmt.Sprintf("Failed to encode response: %s", err), http.StatusInternalServerError)
+		return
 	}
-	
-	// Register routes
-	http.HandleFunc("/", booksHandler)
-	http.HandleFunc("/search", searchHandler)
-	http.HandleFunc("/refresh", refreshHandler)
-	
-	fmt.Println("Starting server on :8090")
-	if err := http.ListenAndServe(":8090", nil); err != nil {
-		log.Fatalf("Could not start server: %s\n", err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// newServer returns a server of the store, the crawler and the users of deps, loading the main
+// page books with load into books
+func newServer(cfg Config, deps dependencies, books *bookCache, load bookLoader) *server {
+	return &server{
+		dependencies:    deps,
+		cfg:             cfg,
+		books:           books,
+		load:            load,
+		downloadArchive: deps.crawler.downloadArchive,
+	}
+}
+
+// handler routes every page and API
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.booksHandler)
+	mux.HandleFunc("/search", s.searchHandler)
+	mux.HandleFunc("/refresh", s.refreshHandler)
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/history", s.historyAPIHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/reviews", s.reviewsAPIHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/cadence", s.cadenceAPIHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/words", s.wordsAPIHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/status", s.statusAPIHandler)
+	mux.HandleFunc("GET /api/crawl/status", s.crawlStatusHandler)
+	mux.HandleFunc("GET /api/crawl/progress", s.crawlProgressHandler)
+	mux.HandleFunc("GET /api/crawl/blocks", s.crawlBlocksHandler)
+	mux.HandleFunc("POST /api/fictions/{id}/progress", s.requireUser(s.saveProgressHandler))
+	mux.HandleFunc("GET /api/unread", s.requireUser(s.unreadHandler))
+	mux.HandleFunc("GET /movers", s.moversHandler)
+	mux.HandleFunc("GET /api/movers", s.moversAPIHandler)
+	mux.HandleFunc("GET /api/movers/digest", s.moversDigestHandler)
+	mux.HandleFunc("GET /login", s.requireUser(loginHandler))
+	mux.HandleFunc("GET /recommendations", s.recommendationsHandler)
+	mux.HandleFunc("GET /api/recommendations", s.requireUser(s.recommendationsAPIHandler))
+	mux.HandleFunc("GET /api/follows", s.requireUser(s.followsHandler))
+	mux.HandleFunc("POST /api/follows", s.requireUser(s.addFollowHandler))
+	mux.HandleFunc("DELETE /api/follows/{id}", s.requireUser(s.deleteFollowHandler))
+	mux.HandleFunc("GET /author/{id}", s.authorHandler)
+	mux.HandleFunc("GET /api/authors/{id}", s.authorAPIHandler)
+	mux.HandleFunc("POST /api/authors/{id}/follow", s.requireUser(s.followAuthorHandler))
+	mux.HandleFunc("DELETE /api/authors/{id}/follow", s.requireUser(s.unfollowAuthorHandler))
+	mux.HandleFunc("GET /api/notifications", s.requireUser(s.notificationsHandler))
+	mux.HandleFunc("GET /api/archives", s.requireUser(s.archivesHandler))
+	mux.HandleFunc("POST /api/archives", s.requireUser(s.addArchiveHandler))
+	mux.HandleFunc("GET /api/archives/{id}", s.requireUser(s.archiveAPIHandler))
+	mux.HandleFunc("DELETE /api/archives/{id}", s.requireUser(s.deleteArchiveHandler))
+	mux.HandleFunc("GET /api/archives/{id}/chapters/{chapter}", s.requireUser(s.archivedChapterAPIHandler))
+	mux.HandleFunc("GET /archive/{id}", s.requireUser(s.archiveHandler))
+	mux.HandleFunc("GET /archive/{id}/chapter/{chapter}", s.requireUser(s.archivedChapterHandler))
+	mux.HandleFunc("GET /archive/{id}/epub", s.requireUser(s.epubHandler))
+	mux.HandleFunc("GET /api/export/{table}", s.requireUser(s.catalogExportHandler))
+	mux.HandleFunc("GET /opds", s.opdsRootHandler)
+	mux.HandleFunc("GET /opds/lists/{list}", s.opdsListHandler)
+	mux.HandleFunc("GET /opds/follows", s.requireUser(s.opdsFollowsHandler))
+	mux.HandleFunc("GET /opds/archives", s.requireUser(s.opdsArchivesHandler))
+	mux.Handle("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
+	mux.HandleFunc("GET /status", s.serviceStatusHandler)
+
+	return withRequestID(withTracing(withMetrics(mux)))
+}
+
+// listenAndServe serves handler on the configured address until ctx is cancelled
+func listenAndServe(ctx context.Context, cfg Config, handler http.Handler) error {
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	slog.Info("Starting server", "addr", cfg.Server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not start server: %v", err)
+	}
+	return nil
+}
+
+func main() {
+	name, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		slog.Error("Command failed", "command", cmd.name, "error", err)
+		os.Exit(1)
 	}
 }
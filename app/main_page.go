@@ -1,17 +1,35 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"html/template"
+	"time"
 )
 
 //go:embed templates/*
 var templateFS embed.FS
 
-func renderPage(books []Book) (*template.Template, error) {
-	// Parse the main HTML template from embedded filesystem
-	tmpl, err := template.ParseFS(templateFS, "templates/main.html")
+// templateFuncs are the helpers available to every page template
+var templateFuncs = template.FuncMap{
+	"sparkline": sparkline,
+	"lineChart": lineChart,
+	"weekday":   shortWeekday,
+}
+
+// shortWeekday names a weekday counted from Sunday, as in "Mon"
+func shortWeekday(i int) string {
+	return time.Weekday(i).String()[:3]
+}
+
+func renderPage(ctx context.Context, books []Book) (*template.Template, error) {
+	span := startTemplateSpan(ctx, "parse", "main.html")
+	defer span.End()
+
+	// Parse the main HTML template and the shared layout from embedded filesystem
+	tmpl, err := template.ParseFS(templateFS, "templates/main.html", "templates/layout.html")
 	if err != nil {
+		failSpan(span, err)
 		return nil, err
 	}
 
@@ -19,12 +37,86 @@ func renderPage(books []Book) (*template.Template, error) {
 }
 
 // renderBookList renders just the book list for HTMX partial updates
-func renderBookList(books []Book) (*template.Template, error) {
+func renderBookList(ctx context.Context, books []Book) (*template.Template, error) {
+	span := startTemplateSpan(ctx, "parse", "book_list.html")
+	defer span.End()
+
 	// Parse the partial book list template from embedded filesystem
 	tmpl, err := template.ParseFS(templateFS, "templates/book_list.html")
+	if err != nil {
+		failSpan(span, err)
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderFictionPage renders a single fiction with its history charts
+func renderFictionPage() (*template.Template, error) {
+	tmpl, err := template.New("fiction.html").Funcs(templateFuncs).ParseFS(templateFS, "templates/fiction.html", "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderMoversPage renders the movers and shakers report
+func renderMoversPage() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/movers.html", "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderRecommendations renders the "Recommended for you" section for HTMX
+func renderRecommendations() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/recommendations.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderAuthorPage renders an author with their fictions
+func renderAuthorPage() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/author.html", "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderArchivePage renders the table of contents of an archived fiction
+func renderArchivePage() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/archive.html", "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderArchivedChapterPage renders an archived chapter for offline reading
+func renderArchivedChapterPage() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/archived_chapter.html", "templates/layout.html")
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// renderStatusPage renders the service status page
+func renderStatusPage() (*template.Template, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/status.html", "templates/layout.html")
 	if err != nil {
 		return nil, err
 	}
 
 	return tmpl, nil
-}
\ No newline at end of file
+}
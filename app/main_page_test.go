@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -15,7 +16,7 @@ func TestRenderPage(t *testing.T) {
 	}
 
 	// Get the template
-	tmpl, err := renderPage(books)
+	tmpl, err := renderPage(context.Background(), books)
 
 	// Verify no error occurred
 	assert.NoError(t, err)
@@ -75,7 +76,7 @@ func TestRenderBookList(t *testing.T) {
 	}
 
 	// Get the template
-	tmpl, err := renderBookList(books)
+	tmpl, err := renderBookList(context.Background(), books)
 
 	// Verify no error occurred
 	assert.NoError(t, err)
@@ -109,7 +110,7 @@ func TestEmptyBookList(t *testing.T) {
 	var books []Book
 	
 	// Get the template
-	tmpl, err := renderBookList(books)
+	tmpl, err := renderBookList(context.Background(), books)
 	
 	// Verify no error occurred
 	assert.NoError(t, err)
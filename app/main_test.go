@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,28 +12,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-// Test setup helper function to initialize and later restore the global cached books
-func setupCachedBooksForTest(t *testing.T) func() {
-	// Store original cached books
-	originalCachedBooks := cachedBooks
-
-	// Setup test data
-	cachedBooks = []Book{
+// Test setup helper function to cache the test books on the main page of s
+func setupCachedBooksForTest(s *server) {
+	s.books.set([]Book{
 		{Title: "Test Book 1", Link: "https://example.com/book1"},
 		{Title: "Test Book 2", Link: "https://example.com/book2"},
 		{Title: "Another Test", Link: "https://example.com/another"},
-	}
-
-	// Return a cleanup function to restore the original state
-	return func() {
-		cachedBooks = originalCachedBooks
-	}
+	})
 }
 
 func TestBooksHandler(t *testing.T) {
-	// Setup test data and defer cleanup
-	cleanup := setupCachedBooksForTest(t)
-	defer cleanup()
+	// Setup test data
+	s, _ := testServer(t)
+	setupCachedBooksForTest(s)
 
 	// Create a request to pass to our handler
 	req, err := http.NewRequest("GET", "/", nil)
@@ -41,7 +34,7 @@ func TestBooksHandler(t *testing.T) {
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(booksHandler)
+	handler := http.HandlerFunc(s.booksHandler)
 
 	// Call the handler
 	handler.ServeHTTP(rr, req)
@@ -60,9 +53,9 @@ func TestBooksHandler(t *testing.T) {
 }
 
 func TestSearchHandler(t *testing.T) {
-	// Setup test data and defer cleanup
-	cleanup := setupCachedBooksForTest(t)
-	defer cleanup()
+	// Setup test data
+	s, _ := testServer(t)
+	setupCachedBooksForTest(s)
 
 	// Test cases
 	testCases := []struct {
@@ -113,7 +106,7 @@ func TestSearchHandler(t *testing.T) {
 
 			// Create a ResponseRecorder
 			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc(searchHandler)
+			handler := http.HandlerFunc(s.searchHandler)
 
 			// Call the handler
 			handler.ServeHTTP(rr, req)
@@ -134,8 +127,7 @@ func TestSearchHandler(t *testing.T) {
 }
 
 func TestRefreshHandler(t *testing.T) {
-	// Store the original cached books to restore later
-	originalCachedBooks := cachedBooks
+	s, _ := testServer(t)
 
 	// Set up initial test books
 	testBooks := []Book{
@@ -144,12 +136,7 @@ func TestRefreshHandler(t *testing.T) {
 	}
 	
 	// Setup initial state
-	cachedBooks = []Book{{Title: "Initial Book", Link: "https://example.com/initial"}}
-	
-	// After the test, restore original state
-	defer func() {
-		cachedBooks = originalCachedBooks
-	}()
+	s.books.set([]Book{{Title: "Initial Book", Link: "https://example.com/initial"}})
 	
 	// Create a request to the refresh endpoint
 	req, err := http.NewRequest("GET", "/refresh", nil)
@@ -160,21 +147,21 @@ func TestRefreshHandler(t *testing.T) {
 	// Create a ResponseRecorder
 	rr := httptest.NewRecorder()
 	
-	// Create a custom handler that simulates the refreshHandler behavior
+	// Create a custom handler that simulates the s.refreshHandler behavior
 	// but uses our test books instead of calling fetchPopularBooks
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Simulate updating the cached books
-		cachedBooks = testBooks
+		s.books.set(testBooks)
 		
 		// Render the book list just like the real handler
-		tmpl, err := renderBookList(cachedBooks)
+		tmpl, err := renderBookList(r.Context(), s.books.get())
 		if err != nil {
 			http.Error(w, "Template error", http.StatusInternalServerError)
 			return
 		}
 		
 		// Execute the template
-		err = tmpl.Execute(w, cachedBooks)
+		err = tmpl.Execute(w, s.books.get())
 		if err != nil {
 			http.Error(w, "Template execution error", http.StatusInternalServerError)
 			return
@@ -193,10 +180,33 @@ func TestRefreshHandler(t *testing.T) {
 	assert.Contains(t, body, "Test Book 2")
 	
 	// Verify the cached books were updated
+	cachedBooks := s.books.get()
 	assert.Equal(t, 2, len(cachedBooks))
 	assert.Equal(t, "Test Book 1", cachedBooks[0].Title)
 	assert.Equal(t, "Test Book 2", cachedBooks[1].Title)
 	
 	// Verify the old book is gone
 	assert.NotContains(t, body, "Initial Book")
-}
\ No newline at end of file
+}
+func TestRefreshHandler_CrawlRunning(t *testing.T) {
+	s, _ := testServer(t)
+	setupCachedBooksForTest(s)
+	cfg, lists := stalledDetailsSite(t, s.crawler)
+	background, stop := context.WithCancel(context.Background())
+	defer s.crawler.wait()
+	defer stop()
+	s.load = crawledBooks(background, s.crawler, cfg)
+
+	// The first refresh crawls the list and leaves its detail crawl running
+	rr := httptest.NewRecorder()
+	s.refreshHandler(rr, httptest.NewRequest("GET", "/refresh", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "Another Test")
+
+	// The next one serves what the running crawl found
+	rr = httptest.NewRecorder()
+	s.refreshHandler(rr, httptest.NewRequest("GET", "/refresh", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int32(1), lists.Load())
+	assert.Contains(t, rr.Body.String(), template.HTMLEscapeString(s.books.get()[0].Title))
+}
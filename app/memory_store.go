@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is the in-process BookStore selected by memoryDatabaseURI and used by tests. Nothing
+// is persisted, so everything is lost when the process exits
+type memoryStore struct {
+	mu        sync.RWMutex
+	books     []Book
+	snapshots []Snapshot
+	follows   []Follow
+
+	authorFollows []AuthorFollow
+	authors       map[string]Author
+	notifications []Notification
+	reviews       map[string]Review
+	chapters      map[string]Chapter
+	progress      []ReadingProgress
+
+	archives         []ArchivedFiction
+	archivedChapters map[string]ArchivedChapter
+
+	statusEvents []StatusEvent
+
+	schemaVersion int
+	lockedBy      string
+	lockedUntil   time.Time
+	pages         map[string]CachedPage
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		authors:  make(map[string]Author),
+		reviews:  make(map[string]Review),
+		chapters: make(map[string]Chapter),
+
+		archivedChapters: make(map[string]ArchivedChapter),
+		pages:            make(map[string]CachedPage),
+	}
+}
+
+func (m *memoryStore) SaveBooks(ctx context.Context, books []Book) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, book := range books {
+		i := slices.IndexFunc(m.books, func(existing Book) bool { return sameBook(existing, book) })
+		if i < 0 {
+			m.books = append(m.books, book)
+		} else {
+			m.books[i] = book
+		}
+	}
+	return nil
+}
+
+// sameBook reports whether two books are the same fiction, like bookFilter does in MongoDB
+func sameBook(a, b Book) bool {
+	if b.FictionID != "" {
+		return a.FictionID == b.FictionID
+	}
+	return a.Link == b.Link
+}
+
+func (m *memoryStore) GetBooks(ctx context.Context) ([]Book, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return latestBooks(m.books), nil
+}
+
+func (m *memoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memoryStore) SaveSnapshots(ctx context.Context, snapshots []Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = append(m.snapshots, snapshots...)
+	return nil
+}
+
+func (m *memoryStore) GetSnapshots(ctx context.Context, fictionID string) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.filterSnapshots(func(snapshot Snapshot) bool {
+		return snapshot.FictionID == fictionID
+	}), nil
+}
+
+func (m *memoryStore) GetSnapshotsSince(ctx context.Context, since time.Time) ([]Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.filterSnapshots(func(snapshot Snapshot) bool {
+		return !snapshot.CrawledAt.Before(since)
+	}), nil
+}
+
+// filterSnapshots returns matching snapshots oldest first; callers hold the lock
+func (m *memoryStore) filterSnapshots(match func(Snapshot) bool) []Snapshot {
+	var snapshots []Snapshot
+	for _, snapshot := range m.snapshots {
+		if match(snapshot) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].CrawledAt.Before(snapshots[j].CrawledAt)
+	})
+	return snapshots
+}
+
+func (m *memoryStore) SaveFollow(ctx context.Context, follow Follow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.follows {
+		if existing.UserID == follow.UserID && existing.FictionID == follow.FictionID && existing.Kind == follow.Kind {
+			return nil
+		}
+	}
+	m.follows = append(m.follows, follow)
+	return nil
+}
+
+func (m *memoryStore) DeleteFollow(ctx context.Context, userID, fictionID, kind string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.follows[:0]
+	for _, follow := range m.follows {
+		if follow.UserID != userID || follow.FictionID != fictionID || follow.Kind != kind {
+			kept = append(kept, follow)
+		}
+	}
+	m.follows = kept
+	return nil
+}
+
+func (m *memoryStore) GetFollows(ctx context.Context, userID string) ([]Follow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var follows []Follow
+	for _, follow := range m.follows {
+		if follow.UserID == userID {
+			follows = append(follows, follow)
+		}
+	}
+	return follows, nil
+}
+
+func (m *memoryStore) GetAllFollows(ctx context.Context) ([]Follow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Follow(nil), m.follows...), nil
+}
+
+func (m *memoryStore) SaveAuthorFollow(ctx context.Context, follow AuthorFollow) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.authorFollows {
+		if existing.UserID == follow.UserID && existing.AuthorID == follow.AuthorID {
+			return nil
+		}
+	}
+	m.authorFollows = append(m.authorFollows, follow)
+	return nil
+}
+
+func (m *memoryStore) DeleteAuthorFollow(ctx context.Context, userID, authorID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.authorFollows[:0]
+	for _, follow := range m.authorFollows {
+		if follow.UserID != userID || follow.AuthorID != authorID {
+			kept = append(kept, follow)
+		}
+	}
+	m.authorFollows = kept
+	return nil
+}
+
+func (m *memoryStore) GetAllAuthorFollows(ctx context.Context) ([]AuthorFollow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]AuthorFollow(nil), m.authorFollows...), nil
+}
+
+func (m *memoryStore) SaveAuthor(ctx context.Context, author Author) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authors[author.ID] = author
+	return nil
+}
+
+func (m *memoryStore) GetAuthor(ctx context.Context, id string) (*Author, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	author, ok := m.authors[id]
+	if !ok {
+		return nil, nil
+	}
+	return &author, nil
+}
+
+func (m *memoryStore) GetAuthors(ctx context.Context) ([]Author, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	authors := make([]Author, 0, len(m.authors))
+	for _, author := range m.authors {
+		authors = append(authors, author)
+	}
+	sort.Slice(authors, func(i, j int) bool { return authors[i].ID < authors[j].ID })
+	return authors, nil
+}
+
+func (m *memoryStore) AddNotifications(ctx context.Context, notifications []Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifications = append(m.notifications, notifications...)
+	return nil
+}
+
+func (m *memoryStore) GetNotifications(ctx context.Context, userID string) ([]Notification, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var notifications []Notification
+	for _, notification := range m.notifications {
+		if notification.UserID == userID {
+			notifications = append(notifications, notification)
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].CreatedAt.After(notifications[j].CreatedAt)
+	})
+	return notifications, nil
+}
+
+func (m *memoryStore) SaveReviews(ctx context.Context, reviews []Review) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, review := range reviews {
+		m.reviews[review.ID] = review
+	}
+	return nil
+}
+
+func (m *memoryStore) GetReviews(ctx context.Context, fictionID string) ([]Review, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var reviews []Review
+	for _, review := range m.reviews {
+		if review.FictionID == fictionID {
+			reviews = append(reviews, review)
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		if !reviews[i].PostedAt.Equal(reviews[j].PostedAt) {
+			return reviews[i].PostedAt.After(reviews[j].PostedAt)
+		}
+		return reviews[i].ID < reviews[j].ID
+	})
+	return reviews, nil
+}
+
+func (m *memoryStore) SaveChapters(ctx context.Context, chapters []Chapter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, chapter := range chapters {
+		m.chapters[chapter.ID] = chapter
+	}
+	return nil
+}
+
+func (m *memoryStore) GetChapters(ctx context.Context, fictionID string) ([]Chapter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var chapters []Chapter
+	for _, chapter := range m.chapters {
+		if chapter.FictionID == fictionID {
+			chapters = append(chapters, chapter)
+		}
+	}
+	sort.Slice(chapters, func(i, j int) bool {
+		if !chapters[i].PublishedAt.Equal(chapters[j].PublishedAt) {
+			return chapters[i].PublishedAt.Before(chapters[j].PublishedAt)
+		}
+		return chapters[i].ID < chapters[j].ID
+	})
+	return chapters, nil
+}
+
+func (m *memoryStore) SaveProgress(ctx context.Context, progress ReadingProgress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.progress {
+		if existing.UserID == progress.UserID && existing.FictionID == progress.FictionID {
+			m.progress[i] = progress
+			return nil
+		}
+	}
+	m.progress = append(m.progress, progress)
+	return nil
+}
+
+func (m *memoryStore) GetProgress(ctx context.Context, userID string) ([]ReadingProgress, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var progress []ReadingProgress
+	for _, existing := range m.progress {
+		if existing.UserID == userID {
+			progress = append(progress, existing)
+		}
+	}
+	return progress, nil
+}
+
+func (m *memoryStore) SaveArchivedFiction(ctx context.Context, archive ArchivedFiction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.archives {
+		if existing.UserID == archive.UserID && existing.FictionID == archive.FictionID {
+			return nil
+		}
+	}
+	m.archives = append(m.archives, archive)
+	return nil
+}
+
+func (m *memoryStore) DeleteArchivedFiction(ctx context.Context, userID, fictionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.archives[:0]
+	for _, archive := range m.archives {
+		if archive.UserID != userID || archive.FictionID != fictionID {
+			kept = append(kept, archive)
+		}
+	}
+	m.archives = kept
+	for key, chapter := range m.archivedChapters {
+		if chapter.UserID == userID && chapter.FictionID == fictionID {
+			delete(m.archivedChapters, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetArchivedFictions(ctx context.Context, userID string) ([]ArchivedFiction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var archives []ArchivedFiction
+	for _, archive := range m.archives {
+		if archive.UserID == userID {
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
+
+func (m *memoryStore) GetAllArchivedFictions(ctx context.Context) ([]ArchivedFiction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]ArchivedFiction(nil), m.archives...), nil
+}
+
+func (m *memoryStore) SaveArchivedChapter(ctx context.Context, chapter ArchivedChapter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.archivedChapters[chapter.UserID+"/"+chapter.ChapterID] = chapter
+	return nil
+}
+
+func (m *memoryStore) GetArchivedChapters(ctx context.Context, userID, fictionID string) ([]ArchivedChapter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var chapters []ArchivedChapter
+	for _, chapter := range m.archivedChapters {
+		if chapter.UserID == userID && chapter.FictionID == fictionID {
+			chapters = append(chapters, chapter)
+		}
+	}
+	sort.Slice(chapters, func(i, j int) bool {
+		if !chapters[i].PublishedAt.Equal(chapters[j].PublishedAt) {
+			return chapters[i].PublishedAt.Before(chapters[j].PublishedAt)
+		}
+		return chapters[i].ChapterID < chapters[j].ChapterID
+	})
+	return chapters, nil
+}
+
+func (m *memoryStore) GetArchivedChapter(ctx context.Context, userID, chapterID string) (*ArchivedChapter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chapter, ok := m.archivedChapters[userID+"/"+chapterID]
+	if !ok {
+		return nil, nil
+	}
+	return &chapter, nil
+}
+
+func (m *memoryStore) AddStatusEvent(ctx context.Context, event StatusEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statusEvents = append(m.statusEvents, event)
+	return nil
+}
+
+func (m *memoryStore) GetStatusEvents(ctx context.Context, fictionID string) ([]StatusEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var events []StatusEvent
+	for _, event := range m.statusEvents {
+		if event.FictionID == fictionID {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+	return events, nil
+}
+
+func (m *memoryStore) ExportRecords(ctx context.Context, collection string, fn func(record any) error) error {
+	m.mu.RLock()
+	var records []any
+	switch collection {
+	case bookCollectionName:
+		records = recordsOf(m.books)
+	case snapshotCollectionName:
+		records = recordsOf(m.snapshots)
+	case authorCollectionName:
+		records = recordsOf(sortedValues(m.authors))
+	case reviewCollectionName:
+		records = recordsOf(sortedValues(m.reviews))
+	case chapterCollectionName:
+		records = recordsOf(sortedValues(m.chapters))
+	case statusEventCollectionName:
+		records = recordsOf(m.statusEvents)
+	case followCollectionName:
+		records = recordsOf(m.follows)
+	case authorFollowCollectionName:
+		records = recordsOf(m.authorFollows)
+	case notificationCollectionName:
+		records = recordsOf(m.notifications)
+	case progressCollectionName:
+		records = recordsOf(m.progress)
+	case archiveCollectionName:
+		records = recordsOf(m.archives)
+	case archivedChapterCollectionName:
+		records = recordsOf(sortedValues(m.archivedChapters))
+	default:
+		m.mu.RUnlock()
+		return fmt.Errorf("unknown collection %q", collection)
+	}
+	// fn runs without the lock so it may use the store
+	m.mu.RUnlock()
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recordsOf[T any](values []T) []any {
+	records := make([]any, len(values))
+	for i, value := range values {
+		records[i] = value
+	}
+	return records
+}
+
+// sortedValues returns the values of a map keyed by ID in ID order, so exports are stable
+func sortedValues[T any](values map[string]T) []T {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make([]T, len(keys))
+	for i, key := range keys {
+		sorted[i] = values[key]
+	}
+	return sorted
+}
+
+func (m *memoryStore) SchemaVersion(ctx context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.schemaVersion, nil
+}
+
+// ApplyMigration only records the version, since records in memory are always in the current schema
+func (m *memoryStore) ApplyMigration(ctx context.Context, migration migration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schemaVersion = migration.version
+	return nil
+}
+
+func (m *memoryStore) LockSchema(ctx context.Context, owner string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.lockedBy != "" && now.Before(m.lockedUntil) {
+		return errSchemaLocked
+	}
+	m.lockedBy, m.lockedUntil = owner, now.Add(ttl)
+	return nil
+}
+
+func (m *memoryStore) UnlockSchema(ctx context.Context, owner string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lockedBy == owner {
+		m.lockedBy, m.lockedUntil = "", time.Time{}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetCachedPage(ctx context.Context, url string) (*CachedPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	page, ok := m.pages[url]
+	if !ok {
+		return nil, nil
+	}
+	return &page, nil
+}
+
+func (m *memoryStore) SaveCachedPage(ctx context.Context, page CachedPage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pages[page.URL] = page
+	return nil
+}
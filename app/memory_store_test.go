@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer returns a server of an empty memory store, a crawler for local test servers and the
+// test users, loading no books. The memory store is returned to seed and inspect
+func testServer(t *testing.T) (*server, *memoryStore) {
+	memory := newMemoryStore()
+	deps := dependencies{store: memory, crawler: testCrawler(t, memory), users: testUsers}
+	return newServer(defaultConfig(), deps, &bookCache{}, func(context.Context) ([]Book, error) { return nil, nil }), memory
+}
+
+func TestMemoryStoreSnapshots(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+	now := time.Now()
+
+	err := memory.SaveSnapshots(ctx, []Snapshot{
+		{FictionID: "1", Rank: 2, CrawledAt: now},
+		{FictionID: "2", Rank: 1, CrawledAt: now},
+		{FictionID: "1", Rank: 5, CrawledAt: now.Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+
+	snapshots, err := memory.GetSnapshots(ctx, "1")
+	require.NoError(t, err)
+
+	// Snapshots come back for the requested fiction only, oldest first
+	require.Equal(t, 2, len(snapshots))
+	assert.Equal(t, 5, snapshots[0].Rank)
+	assert.Equal(t, 2, snapshots[1].Rank)
+}
+
+func TestMemoryStoreBooksOnePerFiction(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+
+	require.NoError(t, memory.SaveBooks(ctx, []Book{
+		{Title: "Old Title", Link: "https://www.royalroad.com/fiction/1/old-title", FictionID: "1"},
+		{Title: "Second", Link: "https://www.royalroad.com/fiction/2/second", FictionID: "2"},
+	}))
+	// A renamed fiction keeps its ID but gets a new link
+	require.NoError(t, memory.SaveBooks(ctx, []Book{
+		{Title: "New Title", Link: "https://www.royalroad.com/fiction/1/new-title", FictionID: "1"},
+	}))
+
+	books, err := memory.GetBooks(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(books))
+	assert.Equal(t, "New Title", books[0].Title)
+	assert.Equal(t, "Second", books[1].Title)
+}
+
+func TestMemoryStoreBooksKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+
+	require.NoError(t, memory.SaveBooks(ctx, []Book{
+		{Title: "Test Book 1", Link: "https://example.com/book1", Followers: 10},
+		{Title: "Test Book 2", Link: "https://example.com/book2"},
+	}))
+	require.NoError(t, memory.SaveBooks(ctx, []Book{
+		{Title: "Test Book 1", Link: "https://example.com/book1", Followers: 20},
+	}))
+
+	books, err := memory.GetBooks(ctx)
+	require.NoError(t, err)
+
+	// Each book appears once, in first-seen order, with its latest metadata
+	require.Equal(t, 2, len(books))
+	assert.Equal(t, 20, books[0].Followers)
+	assert.Equal(t, "Test Book 2", books[1].Title)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds every metric served at /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var metricsFactory = promauto.With(metricsRegistry)
+
+var (
+	httpRequests = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_http_requests_total",
+		Help: "HTTP requests served, by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+	httpDuration = metricsFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "royalroadbot_http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests, by route pattern and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	crawlDuration = metricsFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "royalroadbot_crawl_duration_seconds",
+		Help:    "Time taken by crawls, by list and stage: the list itself, then fiction details in the background.",
+		Buckets: []float64{1, 2, 5, 10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"list", "stage"})
+	crawlPages = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_pages_fetched_total",
+		Help: "Pages fetched from Royal Road, by HTTP status code, or \"error\" when no response arrived.",
+	}, []string{"code"})
+	crawlCacheLookups = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_cache_lookups_total",
+		Help: "Pages requested through the crawler's HTTP cache, by page type and result: hit when served from the cache, revalidated when the site answered 304 Not Modified, or miss when downloaded.",
+	}, []string{"page_type", "result"})
+	crawlDetailPages = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_detail_pages_total",
+		Help: "Fiction and chapter pages worked through by detail crawls, by stage and result: ok, or failed when the page could not be crawled.",
+	}, []string{"stage", "result"})
+	crawlProxyFailures = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_proxy_failures_total",
+		Help: "Times a crawl proxy failed and was taken out of the rotation, by proxy.",
+	}, []string{"proxy"})
+	crawlBlocks = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_blocks_total",
+		Help: "Challenge or interstitial pages served instead of the page asked for, by host.",
+	}, []string{"host"})
+	crawlBlockedHosts = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "royalroadbot_crawl_blocked_hosts",
+		Help: "Hosts that answered the crawler with a challenge and haven't served a real page since.",
+	})
+	crawlProxiesHealthy = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "royalroadbot_crawl_proxies_healthy",
+		Help: "Crawl proxies in the rotation, or -1 when no proxy is configured.",
+	})
+	crawlItems = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_items_parsed_total",
+		Help: "Items parsed from crawled pages, by kind.",
+	}, []string{"kind"})
+	crawlParseFailures = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_crawl_parse_failures_total",
+		Help: "Items found on crawled pages that could not be parsed, by kind.",
+	}, []string{"kind"})
+
+	dbDuration = metricsFactory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "royalroadbot_db_operation_duration_seconds",
+		Help:    "Time taken by database operations, by operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	dbErrors = metricsFactory.NewCounterVec(prometheus.CounterOpts{
+		Name: "royalroadbot_db_errors_total",
+		Help: "Failed database operations, by operation.",
+	}, []string{"operation"})
+
+	cacheBooks = metricsFactory.NewGauge(prometheus.GaugeOpts{
+		Name: "royalroadbot_cache_books",
+		Help: "Books in the cache served by the main page.",
+	})
+	// cacheUpdatedAt is when the cache was last filled, in Unix nanoseconds. It is kept apart from
+	// booksMutex so scrapes don't wait for a crawl holding the lock
+	cacheUpdatedAt atomic.Int64
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	metricsFactory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "royalroadbot_cache_age_seconds",
+		Help: "Seconds since the book cache was last filled, or -1 before the first crawl.",
+	}, func() float64 {
+		updated := cacheUpdatedAt.Load()
+		if updated == 0 {
+			return -1
+		}
+		return time.Since(time.Unix(0, updated)).Seconds()
+	})
+	crawlProxiesHealthy.Set(-1)
+}
+
+// recordCacheUpdate tracks the size and age of the book cache; callers hold the lock of the cache
+func recordCacheUpdate(books []Book) {
+	cacheBooks.Set(float64(len(books)))
+	cacheUpdatedAt.Store(time.Now().UnixNano())
+}
+
+// observeDB times a database operation and counts it as failed when it returns an error
+func observeDB(operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	dbDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dbErrors.WithLabelValues(operation).Inc()
+	}
+	return err
+}
+
+// observeCrawl records how long a crawl stage took since start
+func observeCrawl(list, stage string, start time.Time) {
+	crawlDuration.WithLabelValues(list, stage).Observe(time.Since(start).Seconds())
+}
+
+// recordPageFetch counts a fetched page by its status code, 0 meaning no response arrived
+func recordPageFetch(statusCode int) {
+	code := "error"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+	crawlPages.WithLabelValues(code).Inc()
+}
+
+// withMetrics counts and times requests by the route pattern that served them. It must wrap
+// the ServeMux directly, as the mux sets the pattern on the request it is given
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+var metricsHandler = promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
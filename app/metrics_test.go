@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestWithMetrics_RouteLabels(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "missing", http.StatusNotFound)
+	})
+	handler := withMetrics(mux)
+
+	found := testutil.ToFloat64(httpRequests.WithLabelValues("GET /fiction/{id}", "GET", "404"))
+	unmatched := testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "GET", "404"))
+
+	// Different IDs share the route label instead of adding a series each
+	for _, target := range []string{"/fiction/1", "/fiction/2", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+	}
+
+	assert.Equal(t, found+2, testutil.ToFloat64(httpRequests.WithLabelValues("GET /fiction/{id}", "GET", "404")))
+	assert.Equal(t, unmatched+1, testutil.ToFloat64(httpRequests.WithLabelValues("unmatched", "GET", "404")))
+}
+
+func TestNewServer_RouteLabels(t *testing.T) {
+	recorder := recordSpans(t)
+	memory := newMemoryStore()
+	deps := dependencies{store: memory, crawler: testCrawler(t, memory), users: testUsers}
+	handler := newServer(defaultConfig(), deps, &bookCache{}, func(context.Context) ([]Book, error) { return nil, nil }).handler()
+	healthz := testutil.ToFloat64(httpRequests.WithLabelValues("GET /healthz", "GET", "200"))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, healthz+1, testutil.ToFloat64(httpRequests.WithLabelValues("GET /healthz", "GET", "200")),
+		"the whole middleware chain still sees the route")
+	span, ok := spansByName(recorder)["GET /healthz"]
+	require.True(t, ok)
+	assert.Contains(t, span.Attributes(), semconv.HTTPRoute("GET /healthz"))
+}
+
+func TestObserveDB(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(dbErrors.WithLabelValues("testOperation"))
+
+	assert.NoError(t, observeDB("testOperation", func() error { return nil }))
+	failure := errors.New("boom")
+	assert.Equal(t, failure, observeDB("testOperation", func() error { return failure }))
+
+	assert.Equal(t, errorsBefore+1, testutil.ToFloat64(dbErrors.WithLabelValues("testOperation")))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	recordPageFetch(http.StatusOK)
+	recordPageFetch(0)
+	recordCacheUpdate([]Book{{Title: "First"}, {Title: "Second"}})
+
+	rr := httptest.NewRecorder()
+	metricsHandler.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `royalroadbot_crawl_pages_fetched_total{code="200"}`)
+	assert.Contains(t, body, `royalroadbot_crawl_pages_fetched_total{code="error"}`)
+	assert.Contains(t, body, "royalroadbot_cache_books 2")
+	assert.Contains(t, body, "royalroadbot_cache_age_seconds")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func TestFetchBooks_Metrics(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/1/first">First</a></h2></div>
+			<div class="fiction-list-item"><h2 class="fiction-title">No link</h2></div>
+		</body></html>`))
+	}))
+	t.Cleanup(server.Close)
+	parsed := testutil.ToFloat64(crawlItems.WithLabelValues("book"))
+	failed := testutil.ToFloat64(crawlParseFailures.WithLabelValues("book"))
+	fetched := testutil.ToFloat64(crawlPages.WithLabelValues("200"))
+
+	_, err := s.crawler.fetchBooks(ctx, "metrics-test", server.URL, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, parsed+1, testutil.ToFloat64(crawlItems.WithLabelValues("book")))
+	assert.Equal(t, failed+1, testutil.ToFloat64(crawlParseFailures.WithLabelValues("book")))
+	assert.Equal(t, fetched+1, testutil.ToFloat64(crawlPages.WithLabelValues("200")))
+	assert.Equal(t, 1, testutil.CollectAndCount(crawlDuration.WithLabelValues("metrics-test", "list").(prometheus.Histogram)))
+}
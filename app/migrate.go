@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bookSchemaVersion is written on every book document. Bump it with a migration whenever Book
+// changes in a way old documents can't be decoded into
+const bookSchemaVersion = 1
+
+// schemaCollectionName holds the schema_version marker of the store
+const schemaCollectionName = "schema"
+
+// schemaMarkerID is the ID of the marker document
+const schemaMarkerID = "royalroadbot"
+
+// migrationTimeout bounds a single migration, which may rewrite a whole collection
+const migrationTimeout = 10 * time.Minute
+
+// migrationLockRetry is how often an instance checks whether another one is done migrating
+const migrationLockRetry = time.Second
+
+// migration is one numbered change to the documents already stored. Applying it again must be
+// harmless, since a migration interrupted before its version is recorded runs again
+type migration struct {
+	version int
+	name    string
+	// mongo changes the documents and indexes of a MongoDB store. The memory store is always current
+	mongo func(ctx context.Context, s mongoStore, db *mongo.Database) error
+}
+
+// migrations are applied in order of version, the highest being the schema this build expects
+var migrations = []migration{
+	{1, "book_schema_version", tagBookSchemaVersion},
+	{2, "one_book_per_fiction", mergeBookCopies},
+	{3, "snapshot_time_indexes", createSnapshotIndexes},
+	{4, "book_search_indexes", createBookSearchIndexes},
+}
+
+// latestSchemaVersion is the schema this build reads and writes
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// errSchemaTooNew is returned for a store migrated by a newer build, which this one may corrupt
+var errSchemaTooNew = errors.New("the store was migrated by a newer version")
+
+// errMigrationFailed is returned when a migration couldn't be applied, leaving the store behind
+var errMigrationFailed = errors.New("migration failed")
+
+// errSchemaLocked is returned while another instance holds the migration lock
+var errSchemaLocked = errors.New("another instance is migrating the store")
+
+// AppliedMigration records a migration in the schema marker
+type AppliedMigration struct {
+	Version   int       `bson:"version" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// runMigrations applies the migrations newer than the store's schema version and returns the names
+// of those applied. They are applied under the migration lock of the store, so instances starting
+// together don't run them at the same time
+func runMigrations(ctx context.Context, s BookStore) ([]string, error) {
+	pending, err := pendingMigrations(ctx, s)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	owner := newID()
+	if err := lockSchema(ctx, s, owner, time.Duration(len(pending))*migrationTimeout); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.UnlockSchema(context.WithoutCancel(ctx), owner); err != nil {
+			loggerFrom(ctx).Warn("Failed to release the migration lock", "error", err)
+		}
+	}()
+	// Another instance may have migrated the store while we waited for the lock
+	if pending, err = pendingMigrations(ctx, s); err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, m := range pending {
+		if err := s.ApplyMigration(ctx, m); err != nil {
+			return applied, fmt.Errorf("%w: %d %s: %v", errMigrationFailed, m.version, m.name, err)
+		}
+		applied = append(applied, m.name)
+	}
+	return applied, nil
+}
+
+// pendingMigrations returns the migrations newer than the store's schema version
+func pendingMigrations(ctx context.Context, s BookStore) ([]migration, error) {
+	current, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if current > latestSchemaVersion() {
+		return nil, fmt.Errorf("%w: schema version %d, this build knows %d", errSchemaTooNew, current, latestSchemaVersion())
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// lockSchema takes the migration lock of the store for owner, waiting while another instance holds it
+func lockSchema(ctx context.Context, s BookStore, owner string, ttl time.Duration) error {
+	for {
+		err := s.LockSchema(ctx, owner, ttl)
+		if !errors.Is(err, errSchemaLocked) {
+			return err
+		}
+		loggerFrom(ctx).Info("Waiting for another instance to migrate the store")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(migrationLockRetry):
+		}
+	}
+}
+
+// autoMigrate brings the store up to date when a command starts, unless disabled. A store that
+// can't be reached is only logged so the web server can still start and report it on /readyz,
+// but a failed migration or a store from a newer build stops the command
+func autoMigrate(ctx context.Context, cfg Config, s BookStore) error {
+	if !cfg.Database.AutoMigrate {
+		return nil
+	}
+	applied, err := runMigrations(ctx, s)
+	for _, name := range applied {
+		loggerFrom(ctx).Info("Applied migration", "migration", name)
+	}
+	if errors.Is(err, errSchemaTooNew) || errors.Is(err, errMigrationFailed) {
+		return err
+	}
+	if err != nil {
+		loggerFrom(ctx).Error("Failed to migrate the store", "error", err)
+	}
+	return nil
+}
+
+func tagBookSchemaVersion(ctx context.Context, s mongoStore, db *mongo.Database) error {
+	_, err := db.Collection(s.config.Collection).UpdateMany(ctx,
+		bson.M{"schema_version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"schema_version": 1}})
+	return err
+}
+
+// mergeBookCopies folds the copy saved by every crawl into one document per fiction, keeping the
+// first document's position with the latest copy's metadata, so a fiction ID can be unique
+func mergeBookCopies(ctx context.Context, s mongoStore, db *mongo.Database) error {
+	collection := db.Collection(s.config.Collection)
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	type copies struct {
+		first  primitive.ObjectID
+		latest bson.M
+		others []primitive.ObjectID
+	}
+	byBook := make(map[string]*copies)
+	var order []string
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		id, _ := doc["_id"].(primitive.ObjectID)
+		key, _ := doc["fiction_id"].(string)
+		if key == "" {
+			link, _ := doc["link"].(string)
+			key = "link:" + link
+		}
+		book, ok := byBook[key]
+		if !ok {
+			byBook[key] = &copies{first: id, latest: doc}
+			order = append(order, key)
+			continue
+		}
+		book.others = append(book.others, id)
+		book.latest = doc
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range order {
+		book := byBook[key]
+		if len(book.others) == 0 {
+			continue
+		}
+		delete(book.latest, "_id")
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": book.first}, book.latest); err != nil {
+			return err
+		}
+		if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": book.others}}); err != nil {
+			return err
+		}
+	}
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "fiction_id", Value: 1}},
+		Options: options.Index().SetName("fiction_id_unique").SetUnique(true).
+			SetPartialFilterExpression(bson.M{"fiction_id": bson.M{"$type": "string"}}),
+	})
+	return err
+}
+
+func createSnapshotIndexes(ctx context.Context, s mongoStore, db *mongo.Database) error {
+	_, err := db.Collection(snapshotCollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "crawled_at", Value: 1}}, Options: options.Index().SetName("crawled_at")},
+		{Keys: bson.D{{Key: "fiction_id", Value: 1}, {Key: "crawled_at", Value: 1}}, Options: options.Index().SetName("fiction_id_crawled_at")},
+	})
+	return err
+}
+
+func createBookSearchIndexes(ctx context.Context, s mongoStore, db *mongo.Database) error {
+	_, err := db.Collection(s.config.Collection).Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "tags", Value: 1}}, Options: options.Index().SetName("tags")},
+		{
+			Keys:    bson.D{{Key: "title", Value: "text"}, {Key: "synopsis", Value: "text"}, {Key: "author", Value: "text"}},
+			Options: options.Index().SetName("text").SetWeights(bson.D{{Key: "title", Value: 10}, {Key: "author", Value: 5}, {Key: "synopsis", Value: 1}}),
+		},
+	})
+	return err
+}
+
+// schemaMarker is the document holding the schema_version of a MongoDB store
+type schemaMarker struct {
+	ID         string             `bson:"_id"`
+	Version    int                `bson:"schema_version"`
+	Migrations []AppliedMigration `bson:"migrations"`
+}
+
+func (s mongoStore) SchemaVersion(ctx context.Context) (int, error) {
+	var marker schemaMarker
+	err := s.withDatabase(ctx, "SchemaVersion", func(ctx context.Context, db *mongo.Database) error {
+		err := db.Collection(schemaCollectionName).FindOne(ctx, bson.M{"_id": schemaMarkerID}).Decode(&marker)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the schema version: %v", err)
+		}
+		return nil
+	})
+	return marker.Version, err
+}
+
+// LockSchema takes the lock with findAndModify on the schema marker, which only matches while the
+// lock is free or expired. When the marker is locked, the upsert collides with its ID instead
+func (s mongoStore) LockSchema(ctx context.Context, owner string, ttl time.Duration) error {
+	return s.writeDatabase(ctx, "LockSchema", func(ctx context.Context, db *mongo.Database) error {
+		now := time.Now().UTC()
+		filter := bson.M{"_id": schemaMarkerID, "$or": bson.A{
+			bson.M{"locked_until": bson.M{"$exists": false}},
+			bson.M{"locked_until": bson.M{"$lt": now}},
+		}}
+		update := bson.M{"$set": bson.M{"locked_by": owner, "locked_until": now.Add(ttl)}}
+		err := db.Collection(schemaCollectionName).FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			return errSchemaLocked
+		case err == mongo.ErrNoDocuments:
+			// The marker was created with the lock
+			return nil
+		case err != nil:
+			return fmt.Errorf("failed to lock the schema: %v", err)
+		}
+		return nil
+	})
+}
+
+func (s mongoStore) UnlockSchema(ctx context.Context, owner string) error {
+	return s.writeDatabase(ctx, "UnlockSchema", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(schemaCollectionName).UpdateOne(ctx,
+			bson.M{"_id": schemaMarkerID, "locked_by": owner},
+			bson.M{"$unset": bson.M{"locked_by": "", "locked_until": ""}})
+		if err != nil {
+			return fmt.Errorf("failed to unlock the schema: %v", err)
+		}
+		return nil
+	})
+}
+
+// ApplyMigration isn't cancelled with ctx, so a migration is never left half applied
+func (s mongoStore) ApplyMigration(ctx context.Context, m migration) error {
+	return s.withDatabaseTimeout(context.WithoutCancel(ctx), "ApplyMigration", migrationTimeout, func(ctx context.Context, db *mongo.Database) error {
+		if err := m.mongo(ctx, s, db); err != nil {
+			return err
+		}
+		applied := AppliedMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().UTC()}
+		_, err := db.Collection(schemaCollectionName).UpdateOne(ctx,
+			bson.M{"_id": schemaMarkerID},
+			bson.M{"$set": bson.M{"schema_version": m.version}, "$push": bson.M{"migrations": applied}},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to record the schema version: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsAreNumbered(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.version, m.name)
+		assert.NotNil(t, m.mongo, m.name)
+	}
+}
+
+func TestRunMigrations(t *testing.T) {
+	ctx := context.Background()
+	memory := newMemoryStore()
+
+	applied, err := runMigrations(ctx, memory)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(applied))
+	version, err := memory.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	// Nothing is left to apply the second time
+	applied, err = runMigrations(ctx, memory)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	// A store from a newer build is refused
+	require.NoError(t, memory.ApplyMigration(ctx, migration{version: latestSchemaVersion() + 1}))
+	_, err = runMigrations(ctx, memory)
+	assert.True(t, errors.Is(err, errSchemaTooNew))
+}
+
+func TestAutoMigrate(t *testing.T) {
+	memory := newMemoryStore()
+	ctx := context.Background()
+	cfg := defaultConfig()
+
+	cfg.Database.AutoMigrate = false
+	require.NoError(t, autoMigrate(ctx, cfg, memory))
+	version, _ := memory.SchemaVersion(ctx)
+	assert.Equal(t, 0, version)
+
+	cfg.Database.AutoMigrate = true
+	require.NoError(t, autoMigrate(ctx, cfg, memory))
+	version, _ = memory.SchemaVersion(ctx)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	require.NoError(t, memory.ApplyMigration(ctx, migration{version: latestSchemaVersion() + 1}))
+	assert.Error(t, autoMigrate(ctx, cfg, memory))
+}
+
+// brokenMigrationStore fails every migration
+type brokenMigrationStore struct {
+	*memoryStore
+}
+
+func (brokenMigrationStore) ApplyMigration(ctx context.Context, m migration) error {
+	return errors.New("index build failed")
+}
+
+// unreachableStore can't be reached
+type unreachableStore struct {
+	*memoryStore
+}
+
+func (unreachableStore) SchemaVersion(ctx context.Context) (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestAutoMigrate_Failures(t *testing.T) {
+	ctx := context.Background()
+	cfg := defaultConfig()
+	cfg.Database.AutoMigrate = true
+
+	// A migration that fails stops the command, and the lock is released for the next attempt
+	broken := brokenMigrationStore{newMemoryStore()}
+	err := autoMigrate(ctx, cfg, broken)
+	assert.ErrorIs(t, err, errMigrationFailed)
+	assert.NoError(t, broken.LockSchema(ctx, "next", time.Minute))
+
+	// A store that can't be reached is left for /readyz to report
+	assert.NoError(t, autoMigrate(ctx, cfg, unreachableStore{newMemoryStore()}))
+}
+
+func TestRunMigrations_Lock(t *testing.T) {
+	memory := newMemoryStore()
+	require.NoError(t, memory.LockSchema(context.Background(), "other", time.Minute))
+
+	// Another instance holds the lock, so nothing is applied while waiting for it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := runMigrations(ctx, memory)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	version, _ := memory.SchemaVersion(context.Background())
+	assert.Equal(t, 0, version)
+
+	// Once the other instance is done, the migrations it left are applied
+	done := make(chan []string)
+	go func() {
+		applied, err := runMigrations(context.Background(), memory)
+		assert.NoError(t, err)
+		done <- applied
+	}()
+	require.NoError(t, memory.ApplyMigration(context.Background(), migrations[0]))
+	require.NoError(t, memory.UnlockSchema(context.Background(), "other"))
+	assert.Equal(t, len(migrations)-1, len(<-done))
+
+	// A lock left by a crashed instance doesn't block once expired
+	crashed := newMemoryStore()
+	require.NoError(t, crashed.LockSchema(context.Background(), "crashed", -time.Second))
+	applied, err := runMigrations(context.Background(), crashed)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), len(applied))
+}
@@ -1,7 +1,168 @@
 package main
 
+import "time"
+
 // Book represents a book entry from Royal Road
 type Book struct {
-	Title string
-	Link  string
-}
\ No newline at end of file
+	Title     string   `bson:"title" json:"title"`
+	Link      string   `bson:"link" json:"link"`
+	FictionID string   `bson:"fiction_id,omitempty" json:"fiction_id,omitempty"`
+	Followers int      `bson:"followers,omitempty" json:"followers,omitempty"`
+	Views     int      `bson:"views,omitempty" json:"views,omitempty"`
+	Rating    float64  `bson:"rating,omitempty" json:"rating,omitempty"`
+	Author    string   `bson:"author,omitempty" json:"author,omitempty"`
+	Tags      []string `bson:"tags,omitempty" json:"tags,omitempty"`
+	Synopsis  string   `bson:"synopsis,omitempty" json:"synopsis,omitempty"`
+}
+
+// Snapshot records where a fiction stood on a ranking list during one crawl
+type Snapshot struct {
+	FictionID string    `bson:"fiction_id" json:"fiction_id"`
+	Title     string    `bson:"title" json:"title"`
+	List      string    `bson:"list" json:"list"`
+	Rank      int       `bson:"rank" json:"rank"`
+	Followers int       `bson:"followers" json:"followers"`
+	Views     int       `bson:"views" json:"views"`
+	Rating    float64   `bson:"rating" json:"rating"`
+	CrawledAt time.Time `bson:"crawled_at" json:"crawled_at"`
+}
+
+// Follow kinds a user can attach to a fiction
+const (
+	FollowKindFollow   = "follow"
+	FollowKindFavorite = "favorite"
+)
+
+// Follow records that a user follows or favorited a fiction
+type Follow struct {
+	UserID    string    `bson:"user_id" json:"user_id"`
+	FictionID string    `bson:"fiction_id" json:"fiction_id"`
+	Kind      string    `bson:"kind" json:"kind"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Author is a RoyalRoad author profile and the fictions listed on it
+type Author struct {
+	ID        string          `bson:"_id" json:"id"`
+	Name      string          `bson:"name" json:"name"`
+	Link      string          `bson:"link" json:"link"`
+	Fictions  []AuthorFiction `bson:"fictions" json:"fictions"`
+	UpdatedAt time.Time       `bson:"updated_at" json:"updated_at"`
+}
+
+// AuthorFiction is one fiction listed on an author's profile
+type AuthorFiction struct {
+	FictionID string `bson:"fiction_id" json:"fiction_id"`
+	Title     string `bson:"title" json:"title"`
+	Link      string `bson:"link" json:"link"`
+}
+
+// AuthorFollow records that a user follows an author
+type AuthorFollow struct {
+	UserID    string    `bson:"user_id" json:"user_id"`
+	AuthorID  string    `bson:"author_id" json:"author_id"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Notification is a message for a user about something they follow
+type Notification struct {
+	UserID    string    `bson:"user_id" json:"user_id"`
+	Message   string    `bson:"message" json:"message"`
+	Link      string    `bson:"link" json:"link"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Review is a reader review scraped from a fiction page
+type Review struct {
+	ID        string             `bson:"_id" json:"id"`
+	FictionID string             `bson:"fiction_id" json:"fiction_id"`
+	Reviewer  string             `bson:"reviewer" json:"reviewer"`
+	Title     string             `bson:"title" json:"title"`
+	PostedAt  time.Time          `bson:"posted_at" json:"posted_at"`
+	Scores    map[string]float64 `bson:"scores" json:"scores"`
+	Text      string             `bson:"text" json:"text"`
+}
+
+// Chapter is a chapter listed on a fiction page
+type Chapter struct {
+	ID          string    `bson:"_id" json:"id"`
+	FictionID   string    `bson:"fiction_id" json:"fiction_id"`
+	Title       string    `bson:"title" json:"title"`
+	Link        string    `bson:"link" json:"link"`
+	PublishedAt time.Time `bson:"published_at" json:"published_at"`
+	// WordCount is measured from the chapter page, zero until then
+	WordCount int `bson:"word_count,omitempty" json:"word_count,omitempty"`
+}
+
+// ReadingProgress records the last chapter of a fiction a user has read
+type ReadingProgress struct {
+	UserID    string    `bson:"user_id" json:"user_id"`
+	FictionID string    `bson:"fiction_id" json:"fiction_id"`
+	ChapterID string    `bson:"chapter_id" json:"chapter_id"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// ArchivedFiction records that a user keeps an offline copy of a fiction
+type ArchivedFiction struct {
+	UserID    string    `bson:"user_id" json:"user_id"`
+	FictionID string    `bson:"fiction_id" json:"fiction_id"`
+	Title     string    `bson:"title" json:"title"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// ArchivedChapter is a user's offline copy of a chapter, with the versions it replaced
+type ArchivedChapter struct {
+	UserID      string    `bson:"user_id" json:"user_id"`
+	ChapterID   string    `bson:"chapter_id" json:"chapter_id"`
+	FictionID   string    `bson:"fiction_id" json:"fiction_id"`
+	Title       string    `bson:"title" json:"title"`
+	Link        string    `bson:"link" json:"link"`
+	PublishedAt time.Time `bson:"published_at" json:"published_at"`
+	// Content is the sanitized chapter body, kept apart from the author's notes around it
+	Content     string            `bson:"content" json:"content"`
+	NoteBefore  string            `bson:"note_before,omitempty" json:"note_before,omitempty"`
+	NoteAfter   string            `bson:"note_after,omitempty" json:"note_after,omitempty"`
+	ContentHash string            `bson:"content_hash" json:"content_hash"`
+	ArchivedAt  time.Time         `bson:"archived_at" json:"archived_at"`
+	CheckedAt   time.Time         `bson:"checked_at" json:"checked_at"`
+	Revisions   []ChapterRevision `bson:"revisions,omitempty" json:"revisions,omitempty"`
+}
+
+// ChapterRevision is an earlier version of an archived chapter
+type ChapterRevision struct {
+	Content     string    `bson:"content" json:"content"`
+	NoteBefore  string    `bson:"note_before,omitempty" json:"note_before,omitempty"`
+	NoteAfter   string    `bson:"note_after,omitempty" json:"note_after,omitempty"`
+	ContentHash string    `bson:"content_hash" json:"content_hash"`
+	ArchivedAt  time.Time `bson:"archived_at" json:"archived_at"`
+}
+
+// Fiction statuses detected while crawling
+const (
+	FictionStatusActive  = "active"
+	FictionStatusStubbed = "stubbed"
+	FictionStatusRemoved = "removed"
+)
+
+// StatusEvent records that a fiction changed status, for example when it was stubbed
+type StatusEvent struct {
+	FictionID string `bson:"fiction_id" json:"fiction_id"`
+	Title     string `bson:"title" json:"title"`
+	Status    string `bson:"status" json:"status"`
+	Previous  string `bson:"previous" json:"previous"`
+	// Reason explains the change, such as "Chapters dropped from 40 to 5"
+	Reason     string    `bson:"reason" json:"reason"`
+	StatusCode int       `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// CachedPage is a crawled page kept by the crawler's HTTP cache
+type CachedPage struct {
+	URL          string `bson:"_id" json:"url"`
+	ContentType  string `bson:"content_type" json:"content_type"`
+	ETag         string `bson:"etag,omitempty" json:"etag,omitempty"`
+	LastModified string `bson:"last_modified,omitempty" json:"last_modified,omitempty"`
+	Body         []byte `bson:"body" json:"body"`
+	// FetchedAt is when the page was downloaded or last confirmed unchanged
+	FetchedAt time.Time `bson:"fetched_at" json:"fetched_at"`
+}
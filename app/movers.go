@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const moversLimit = 10
+
+// moversWindows are the report windows offered in the UI
+var moversWindows = []string{"24h", "7d", "30d"}
+
+// RankChange describes how a fiction moved on a list between two crawls.
+// A rank of 0 means the fiction was not on the list at that crawl.
+type RankChange struct {
+	FictionID string `json:"fiction_id"`
+	Title     string `json:"title"`
+	OldRank   int    `json:"old_rank"`
+	NewRank   int    `json:"new_rank"`
+	Change    int    `json:"change"`
+}
+
+// ListMovers is the movement on one ranking list over the report window
+type ListMovers struct {
+	List    string       `json:"list"`
+	From    time.Time    `json:"from"`
+	To      time.Time    `json:"to"`
+	Entered []RankChange `json:"entered"`
+	Left    []RankChange `json:"left"`
+	Gainers []RankChange `json:"gainers"`
+	Losers  []RankChange `json:"losers"`
+}
+
+// FollowerGrowth is the follower change of a fiction over the report window
+type FollowerGrowth struct {
+	FictionID    string  `json:"fiction_id"`
+	Title        string  `json:"title"`
+	OldFollowers int     `json:"old_followers"`
+	NewFollowers int     `json:"new_followers"`
+	Gain         int     `json:"gain"`
+	PerDay       float64 `json:"per_day"`
+}
+
+// MoversReport answers "what's newly hot" for a window of crawls
+type MoversReport struct {
+	Window         string           `json:"window"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+	Lists          []ListMovers     `json:"lists"`
+	FollowerGrowth []FollowerGrowth `json:"follower_growth"`
+}
+
+// Digest is a plain-text summary of a report, ready to be sent as a notification
+type Digest struct {
+	Subject     string    `json:"subject"`
+	Body        string    `json:"body"`
+	Window      string    `json:"window"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// parseWindow accepts Go durations ("24h") as well as whole days ("7d")
+func parseWindow(window string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(window, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid window %q", window)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", window)
+	}
+	return d, nil
+}
+
+// buildMoversReport compares the first and last crawl of each list within the snapshots,
+// which are expected to already be limited to the report window and sorted oldest first
+func buildMoversReport(window string, snapshots []Snapshot, now time.Time) MoversReport {
+	report := MoversReport{Window: window, GeneratedAt: now}
+
+	byList := make(map[string][]Snapshot)
+	for _, snapshot := range snapshots {
+		byList[snapshot.List] = append(byList[snapshot.List], snapshot)
+	}
+	lists := make([]string, 0, len(byList))
+	for list := range byList {
+		lists = append(lists, list)
+	}
+	sort.Strings(lists)
+	for _, list := range lists {
+		if movers, ok := compareListCrawls(list, byList[list]); ok {
+			report.Lists = append(report.Lists, movers)
+		}
+	}
+
+	report.FollowerGrowth = followerGrowth(snapshots)
+	return report
+}
+
+func compareListCrawls(list string, snapshots []Snapshot) (ListMovers, bool) {
+	from, to := snapshots[0].CrawledAt, snapshots[len(snapshots)-1].CrawledAt
+	if !from.Before(to) {
+		return ListMovers{}, false
+	}
+
+	oldRanks := make(map[string]Snapshot)
+	newRanks := make(map[string]Snapshot)
+	for _, snapshot := range snapshots {
+		switch {
+		case snapshot.CrawledAt.Equal(from):
+			oldRanks[snapshot.FictionID] = snapshot
+		case snapshot.CrawledAt.Equal(to):
+			newRanks[snapshot.FictionID] = snapshot
+		}
+	}
+
+	movers := ListMovers{List: list, From: from, To: to}
+	for id, current := range newRanks {
+		previous, ok := oldRanks[id]
+		if !ok {
+			movers.Entered = append(movers.Entered, RankChange{FictionID: id, Title: current.Title, NewRank: current.Rank})
+			continue
+		}
+		change := RankChange{FictionID: id, Title: current.Title, OldRank: previous.Rank, NewRank: current.Rank, Change: previous.Rank - current.Rank}
+		if change.Change > 0 {
+			movers.Gainers = append(movers.Gainers, change)
+		} else if change.Change < 0 {
+			movers.Losers = append(movers.Losers, change)
+		}
+	}
+	for id, previous := range oldRanks {
+		if _, ok := newRanks[id]; !ok {
+			movers.Left = append(movers.Left, RankChange{FictionID: id, Title: previous.Title, OldRank: previous.Rank})
+		}
+	}
+
+	sort.Slice(movers.Entered, func(i, j int) bool { return movers.Entered[i].NewRank < movers.Entered[j].NewRank })
+	sort.Slice(movers.Left, func(i, j int) bool { return movers.Left[i].OldRank < movers.Left[j].OldRank })
+	sort.Slice(movers.Gainers, func(i, j int) bool { return rankChangeLess(movers.Gainers[i], movers.Gainers[j], true) })
+	sort.Slice(movers.Losers, func(i, j int) bool { return rankChangeLess(movers.Losers[i], movers.Losers[j], false) })
+	movers.Gainers = limitChanges(movers.Gainers)
+	movers.Losers = limitChanges(movers.Losers)
+	return movers, true
+}
+
+// rankChangeLess orders the biggest moves first, breaking ties by current rank
+func rankChangeLess(a, b RankChange, gains bool) bool {
+	if a.Change != b.Change {
+		if gains {
+			return a.Change > b.Change
+		}
+		return a.Change < b.Change
+	}
+	return a.NewRank < b.NewRank
+}
+
+func limitChanges(changes []RankChange) []RankChange {
+	if len(changes) > moversLimit {
+		return changes[:moversLimit]
+	}
+	return changes
+}
+
+// followerGrowth compares each fiction's earliest and latest follower count in the window
+func followerGrowth(snapshots []Snapshot) []FollowerGrowth {
+	first := make(map[string]Snapshot)
+	last := make(map[string]Snapshot)
+	for _, snapshot := range snapshots {
+		if _, ok := first[snapshot.FictionID]; !ok {
+			first[snapshot.FictionID] = snapshot
+		}
+		last[snapshot.FictionID] = snapshot
+	}
+
+	var growth []FollowerGrowth
+	for id, latest := range last {
+		earliest := first[id]
+		elapsed := latest.CrawledAt.Sub(earliest.CrawledAt)
+		gain := latest.Followers - earliest.Followers
+		if elapsed <= 0 || gain <= 0 {
+			continue
+		}
+		growth = append(growth, FollowerGrowth{
+			FictionID:    id,
+			Title:        latest.Title,
+			OldFollowers: earliest.Followers,
+			NewFollowers: latest.Followers,
+			Gain:         gain,
+			PerDay:       float64(gain) / elapsed.Hours() * 24,
+		})
+	}
+
+	sort.Slice(growth, func(i, j int) bool {
+		if growth[i].PerDay != growth[j].PerDay {
+			return growth[i].PerDay > growth[j].PerDay
+		}
+		return growth[i].FictionID < growth[j].FictionID
+	})
+	if len(growth) > moversLimit {
+		growth = growth[:moversLimit]
+	}
+	return growth
+}
+
+// Digest summarises the report for notifications
+func (r MoversReport) Digest() Digest {
+	var b strings.Builder
+	for _, list := range r.Lists {
+		fmt.Fprintf(&b, "%s (%s → %s)\n", list.List, list.From.Format("Jan 2 15:04"), list.To.Format("Jan 2 15:04"))
+		for _, c := range list.Entered {
+			fmt.Fprintf(&b, "  NEW #%d %s\n", c.NewRank, c.Title)
+		}
+		for _, c := range list.Gainers {
+			fmt.Fprintf(&b, "  ▲%d #%d %s (was #%d)\n", c.Change, c.NewRank, c.Title, c.OldRank)
+		}
+		for _, c := range list.Losers {
+			fmt.Fprintf(&b, "  ▼%d #%d %s (was #%d)\n", -c.Change, c.NewRank, c.Title, c.OldRank)
+		}
+		for _, c := range list.Left {
+			fmt.Fprintf(&b, "  OUT %s (was #%d)\n", c.Title, c.OldRank)
+		}
+	}
+	if len(r.FollowerGrowth) > 0 {
+		b.WriteString("Fastest follower growth\n")
+		for _, g := range r.FollowerGrowth {
+			fmt.Fprintf(&b, "  +%d %s (%.0f/day)\n", g.Gain, g.Title, g.PerDay)
+		}
+	}
+
+	body := b.String()
+	if body == "" {
+		body = "Not enough crawls in this window to compare yet.\n"
+	}
+
+	entered := 0
+	for _, list := range r.Lists {
+		entered += len(list.Entered)
+	}
+	return Digest{
+		Subject:     fmt.Sprintf("Royal Road movers (%s): %d new entries", r.Window, entered),
+		Body:        body,
+		Window:      r.Window,
+		GeneratedAt: r.GeneratedAt,
+	}
+}
+
+// loadMoversReport reads the window from the request and builds the report from stored snapshots
+func (s *server) loadMoversReport(r *http.Request) (MoversReport, int, error) {
+	window := r.URL.Query().Get("window")
+	if window == "" {
+		window = moversWindows[0]
+	}
+	d, err := parseWindow(window)
+	if err != nil {
+		return MoversReport{}, http.StatusBadRequest, err
+	}
+
+	now := time.Now().UTC()
+	snapshots, err := s.store.GetSnapshotsSince(r.Context(), now.Add(-d))
+	if err != nil {
+		return MoversReport{}, http.StatusInternalServerError, fmt.Errorf("failed to load snapshots: %s", err)
+	}
+	return buildMoversReport(window, snapshots, now), http.StatusOK, nil
+}
+
+// moversAPIHandler returns the movers report as JSON
+func (s *server) moversAPIHandler(w http.ResponseWriter, r *http.Request) {
+	report, status, err := s.loadMoversReport(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, report)
+}
+
+// moversDigestHandler returns the notification digest for the movers report
+func (s *server) moversDigestHandler(w http.ResponseWriter, r *http.Request) {
+	report, status, err := s.loadMoversReport(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeJSON(w, report.Digest())
+}
+
+// moversHandler renders the movers and shakers page
+func (s *server) moversHandler(w http.ResponseWriter, r *http.Request) {
+	report, status, err := s.loadMoversReport(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	tmpl, err := renderMoversPage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, struct {
+		MoversReport
+		Windows []string
+	}{report, moversWindows})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func moversSnapshots(from, to time.Time) []Snapshot {
+	return []Snapshot{
+		{FictionID: "1", Title: "Climber", List: "popular", Rank: 5, Followers: 100, CrawledAt: from},
+		{FictionID: "2", Title: "Faller", List: "popular", Rank: 1, Followers: 500, CrawledAt: from},
+		{FictionID: "3", Title: "Leaver", List: "popular", Rank: 2, Followers: 300, CrawledAt: from},
+		{FictionID: "1", Title: "Climber", List: "popular", Rank: 1, Followers: 400, CrawledAt: to},
+		{FictionID: "2", Title: "Faller", List: "popular", Rank: 3, Followers: 510, CrawledAt: to},
+		{FictionID: "4", Title: "Newcomer", List: "popular", Rank: 2, Followers: 50, CrawledAt: to},
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	d, err := parseWindow("24h")
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, d)
+
+	d, err = parseWindow("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	_, err = parseWindow("-1d")
+	assert.Error(t, err)
+
+	_, err = parseWindow("soon")
+	assert.Error(t, err)
+}
+
+func TestBuildMoversReport(t *testing.T) {
+	to := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-7 * 24 * time.Hour)
+
+	report := buildMoversReport("7d", moversSnapshots(from, to), to)
+
+	require.Equal(t, 1, len(report.Lists))
+	list := report.Lists[0]
+	assert.Equal(t, "popular", list.List)
+	assert.Equal(t, []RankChange{{FictionID: "4", Title: "Newcomer", NewRank: 2}}, list.Entered)
+	assert.Equal(t, []RankChange{{FictionID: "3", Title: "Leaver", OldRank: 2}}, list.Left)
+	assert.Equal(t, []RankChange{{FictionID: "1", Title: "Climber", OldRank: 5, NewRank: 1, Change: 4}}, list.Gainers)
+	assert.Equal(t, []RankChange{{FictionID: "2", Title: "Faller", OldRank: 1, NewRank: 3, Change: -2}}, list.Losers)
+
+	// Newcomer has a single snapshot so it has no growth to report
+	require.Equal(t, 2, len(report.FollowerGrowth))
+	assert.Equal(t, "1", report.FollowerGrowth[0].FictionID)
+	assert.Equal(t, 300, report.FollowerGrowth[0].Gain)
+	assert.InDelta(t, 300.0/7, report.FollowerGrowth[0].PerDay, 0.001)
+	assert.Equal(t, "2", report.FollowerGrowth[1].FictionID)
+}
+
+func TestBuildMoversReport_SingleCrawl(t *testing.T) {
+	now := time.Now()
+	snapshots := []Snapshot{{FictionID: "1", Title: "Only", List: "popular", Rank: 1, CrawledAt: now}}
+
+	report := buildMoversReport("24h", snapshots, now)
+
+	assert.Empty(t, report.Lists)
+	assert.Empty(t, report.FollowerGrowth)
+	assert.Contains(t, report.Digest().Body, "Not enough crawls")
+}
+
+func TestMoversDigest(t *testing.T) {
+	to := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	report := buildMoversReport("7d", moversSnapshots(to.Add(-time.Hour), to), to)
+
+	digest := report.Digest()
+
+	assert.Equal(t, "Royal Road movers (7d): 1 new entries", digest.Subject)
+	assert.Contains(t, digest.Body, "NEW #2 Newcomer")
+	assert.Contains(t, digest.Body, "▲4 #1 Climber (was #5)")
+	assert.Contains(t, digest.Body, "▼2 #3 Faller (was #1)")
+	assert.Contains(t, digest.Body, "OUT Leaver (was #2)")
+	assert.Contains(t, digest.Body, "+300 Climber")
+}
+
+func TestMoversAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	require.NoError(t, memory.SaveSnapshots(ctx, moversSnapshots(now.Add(-2*time.Hour), now.Add(-time.Hour))))
+
+	req := httptest.NewRequest("GET", "/api/movers?window=24h", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	s.moversAPIHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var report MoversReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, "24h", report.Window)
+	require.Equal(t, 1, len(report.Lists))
+	assert.Equal(t, 1, len(report.Lists[0].Entered))
+}
+
+func TestMoversAPIHandler_InvalidWindow(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+
+	req := httptest.NewRequest("GET", "/api/movers?window=forever", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	s.moversAPIHandler(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestMoversHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	require.NoError(t, memory.SaveSnapshots(ctx, moversSnapshots(now.Add(-2*time.Hour), now.Add(-time.Hour))))
+
+	req := httptest.NewRequest("GET", "/movers?window=7d", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	s.moversHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Movers and Shakers (7d)")
+	assert.Contains(t, body, "Newcomer")
+	assert.Contains(t, body, "Fastest follower growth")
+	assert.Contains(t, body, "/movers?window=30d")
+}
+
+func TestMoversDigestHandler(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+
+	req := httptest.NewRequest("GET", "/api/movers/digest?window=30d", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	s.moversDigestHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var digest Digest
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &digest))
+	assert.Equal(t, "30d", digest.Window)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// proxySchemes are the kinds of proxy the crawler can go through
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// proxyCheckTimeout bounds the request checking whether a proxy is back up
+const proxyCheckTimeout = 10 * time.Second
+
+// newNetworkTransport returns the transport the network settings ask for. Without proxies the
+// proxy environment variables apply, as they do for any Go program. Failed proxies are checked
+// again against checkURL
+func newNetworkTransport(cfg NetworkConfig, checkURL string) (http.RoundTripper, *proxyPool, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if cfg.BindAddress != "" {
+		ip := net.ParseIP(cfg.BindAddress)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("invalid bind address %q", cfg.BindAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	transport.DialContext = overrideDNS(dialer.DialContext, cfg.DNSOverride)
+	if cfg.CABundle != "" {
+		roots, err := loadCABundle(cfg.CABundle)
+		if err != nil {
+			return nil, nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	if len(cfg.Proxies) == 0 {
+		return transport, nil, nil
+	}
+	pool, err := newProxyPool(cfg.Proxies, transport, checkURL, time.Duration(cfg.ProxyCheckInterval))
+	if err != nil {
+		return nil, nil, err
+	}
+	return pool, pool, nil
+}
+
+// overrideDNS dials the hosts listed in hosts at the address they are mapped to, such as a fixed IP
+// for RoyalRoad. The TLS server name is still the host of the URL
+func overrideDNS(dial func(ctx context.Context, network, addr string) (net.Conn, error), hosts map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(hosts) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if target, ok := hosts[host]; ok {
+				addr = net.JoinHostPort(target, port)
+			}
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// loadCABundle returns the system certificates with those of a PEM file added, for proxies and
+// gateways that re-sign TLS traffic with their own CA
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return roots, nil
+}
+
+// proxyPool sends each request through the next healthy proxy in turn. A proxy that fails is taken
+// out of the rotation and the request is tried through the next one; once checkInterval has
+// passed, the proxy is checked with a request to checkURL and put back when it answers
+type proxyPool struct {
+	proxies       []*poolProxy
+	next          atomic.Uint64
+	checkURL      string
+	checkInterval time.Duration
+}
+
+type poolProxy struct {
+	url       *url.URL
+	transport *http.Transport
+
+	mu       sync.Mutex
+	down     bool
+	retryAt  time.Time
+	checking bool
+}
+
+func newProxyPool(proxies []string, base *http.Transport, checkURL string, checkInterval time.Duration) (*proxyPool, error) {
+	pool := &proxyPool{checkURL: checkURL, checkInterval: checkInterval}
+	for _, proxy := range proxies {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", proxy, err)
+		}
+		transport := base.Clone()
+		transport.Proxy = http.ProxyURL(u)
+		pool.proxies = append(pool.proxies, &poolProxy{url: u, transport: transport})
+	}
+	crawlProxiesHealthy.Set(float64(len(pool.proxies)))
+	return pool, nil
+}
+
+func (p *poolProxy) String() string {
+	return p.url.Redacted()
+}
+
+func (p *proxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	var errs []error
+	for _, proxy := range p.rotation() {
+		resp, err := proxy.transport.RoundTrip(req)
+		if err == nil && resp.StatusCode != http.StatusProxyAuthRequired {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		err = fmt.Errorf("proxy %s: %w", proxy, err)
+		if req.Context().Err() != nil || req.Body != nil {
+			// A cancelled request says nothing about the proxy, and a body can't be sent twice
+			return nil, err
+		}
+		p.markDown(proxy, err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no healthy proxy to reach %s", req.URL.Host)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// rotation returns the healthy proxies, starting from the next one in turn. Proxies that were
+// down long enough are checked in the background
+func (p *proxyPool) rotation() []*poolProxy {
+	start := int(p.next.Add(1) - 1)
+	var healthy []*poolProxy
+	for i := range p.proxies {
+		proxy := p.proxies[(start+i)%len(p.proxies)]
+		proxy.mu.Lock()
+		down, check := proxy.down, proxy.down && !proxy.checking && time.Now().After(proxy.retryAt)
+		if check {
+			proxy.checking = true
+		}
+		proxy.mu.Unlock()
+		if check {
+			go p.check(proxy)
+		}
+		if !down {
+			healthy = append(healthy, proxy)
+		}
+	}
+	return healthy
+}
+
+func (p *proxyPool) markDown(proxy *poolProxy, err error) {
+	proxy.mu.Lock()
+	if !proxy.down {
+		slog.Warn("Proxy is down", "proxy", proxy.String(), "error", err)
+		crawlProxyFailures.WithLabelValues(proxy.String()).Inc()
+	}
+	proxy.down = true
+	proxy.retryAt = time.Now().Add(p.checkInterval)
+	proxy.mu.Unlock()
+	crawlProxiesHealthy.Set(float64(p.healthy()))
+}
+
+// check requests checkURL through a proxy that was down, putting it back in the rotation when any
+// response arrives
+func (p *proxyPool) check(proxy *poolProxy) {
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCheckTimeout)
+	defer cancel()
+	err := p.probe(ctx, proxy)
+
+	proxy.mu.Lock()
+	proxy.checking = false
+	if err != nil {
+		proxy.retryAt = time.Now().Add(p.checkInterval)
+		proxy.mu.Unlock()
+		slog.Debug("Proxy is still down", "proxy", proxy.String(), "error", err)
+		return
+	}
+	proxy.down = false
+	proxy.mu.Unlock()
+	slog.Info("Proxy is back up", "proxy", proxy.String())
+	crawlProxiesHealthy.Set(float64(p.healthy()))
+}
+
+func (p *proxyPool) probe(ctx context.Context, proxy *poolProxy) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.checkURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := proxy.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// healthy counts the proxies in the rotation
+func (p *proxyPool) healthy() int {
+	healthy := 0
+	for _, proxy := range p.proxies {
+		proxy.mu.Lock()
+		if !proxy.down {
+			healthy++
+		}
+		proxy.mu.Unlock()
+	}
+	return healthy
+}
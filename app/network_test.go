@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useCrawlNetwork(t *testing.T, c *crawler, cfg NetworkConfig, checkURL string) *proxyPool {
+	network, proxies, err := newNetworkTransport(cfg, checkURL)
+	require.NoError(t, err)
+	useCrawlTransport(c, blockTransport{blocks: c.blocks, next: network})
+	return proxies
+}
+
+// testProxy is a forward HTTP proxy stand-in that tunnels CONNECT requests and forwards the rest.
+// While down it drops every connection
+type testProxy struct {
+	*httptest.Server
+	requests atomic.Int32
+	down     atomic.Bool
+}
+
+func startTestProxy(t *testing.T) *testProxy {
+	proxy := &testProxy{}
+	proxy.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if proxy.down.Load() {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		proxy.requests.Add(1)
+		if r.Method == http.MethodConnect {
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				target.Close()
+				return
+			}
+			tunnel(conn, target)
+			return
+		}
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// startSOCKS5Proxy is a SOCKS5 proxy stand-in without authentication, counting the connections it tunnels
+func startSOCKS5Proxy(t *testing.T, connections *atomic.Int32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				connections.Add(1)
+				tunnel(conn, target)
+			}()
+		}
+	}()
+	return "socks5://" + listener.Addr().String()
+}
+
+// socks5Handshake reads a greeting and a CONNECT request, and dials the target it names
+func socks5Handshake(conn net.Conn) (net.Conn, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		ip := make([]byte, 4)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return nil, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	default:
+		return nil, io.ErrUnexpectedEOF
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return nil, err
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func tunnel(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+func TestNetworkTransport_HTTPProxy(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 4, Fictions: 3, PageSize: 20, Layout: "current"})
+	proxy := startTestProxy(t)
+	useCrawlNetwork(t, s.crawler, NetworkConfig{Proxies: []string{proxy.URL}, ProxyCheckInterval: Duration(time.Minute)}, cfg.BaseURL)
+
+	books, err := s.crawler.scrapeBooks(ctx, "proxy-test", cfg.ListURL(), 10)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+	assert.Equal(t, int32(1), proxy.requests.Load())
+}
+
+func TestNetworkTransport_SOCKS5Proxy(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 4, Fictions: 3, PageSize: 20, Layout: "current"})
+	var connections atomic.Int32
+	proxy := startSOCKS5Proxy(t, &connections)
+	useCrawlNetwork(t, s.crawler, NetworkConfig{Proxies: []string{proxy}, ProxyCheckInterval: Duration(time.Minute)}, cfg.BaseURL)
+
+	books, err := s.crawler.scrapeBooks(ctx, "proxy-test", cfg.ListURL(), 10)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+	assert.Equal(t, int32(1), connections.Load())
+}
+
+func TestNetworkTransport_CABundle(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	site := newFakeSite(fakeSiteOptions{Seed: 4, Fictions: 3, PageSize: 20, Layout: "current"})
+	server := httptest.NewTLSServer(site)
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o644))
+	proxy := startTestProxy(t)
+
+	useCrawlNetwork(t, s.crawler, NetworkConfig{}, server.URL)
+	_, err := s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/fictions/active-popular")
+	require.Error(t, err, "the test server's certificate isn't trusted by default")
+
+	useCrawlNetwork(t, s.crawler, NetworkConfig{CABundle: bundle, Proxies: []string{proxy.URL}, ProxyCheckInterval: Duration(time.Minute)}, server.URL)
+	books, err := s.crawler.scrapeBooks(ctx, "proxy-test", server.URL+"/fictions/active-popular", 10)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+	assert.Equal(t, int32(1), proxy.requests.Load(), "HTTPS is tunnelled through the proxy with CONNECT")
+
+	_, _, err = newNetworkTransport(NetworkConfig{CABundle: filepath.Join(t.TempDir(), "missing.pem")}, server.URL)
+	assert.ErrorContains(t, err, "CA bundle")
+}
+
+func TestProxyPool_Rotation(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 4, Fictions: 3, PageSize: 20, Layout: "current"})
+	first, second := startTestProxy(t), startTestProxy(t)
+	pool := useCrawlNetwork(t, s.crawler, NetworkConfig{Proxies: []string{first.URL, second.URL}, ProxyCheckInterval: Duration(20 * time.Millisecond)}, cfg.BaseURL)
+	failures := testutil.ToFloat64(crawlProxyFailures.WithLabelValues(first.URL))
+
+	for range 4 {
+		_, err := s.crawler.scrapeBooks(ctx, "proxy-test", cfg.ListURL(), 10)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), first.requests.Load(), "requests take turns")
+	assert.Equal(t, int32(2), second.requests.Load())
+
+	// A proxy that fails is skipped, without failing the request
+	first.down.Store(true)
+	for range 4 {
+		_, err := s.crawler.scrapeBooks(ctx, "proxy-test", cfg.ListURL(), 10)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(2), first.requests.Load())
+	assert.Equal(t, int32(6), second.requests.Load())
+	assert.Equal(t, 1, pool.healthy())
+	assert.Equal(t, failures+1, testutil.ToFloat64(crawlProxyFailures.WithLabelValues(first.URL)))
+
+	// Once it answers its check, it is back in the rotation
+	first.down.Store(false)
+	assert.Eventually(t, func() bool {
+		pool.rotation()
+		return pool.healthy() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestProxyPool_AllDown(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	proxy := startTestProxy(t)
+	proxy.down.Store(true)
+	useCrawlNetwork(t, s.crawler, NetworkConfig{Proxies: []string{proxy.URL}, ProxyCheckInterval: Duration(time.Minute)}, proxy.URL)
+
+	_, err := s.crawler.visit(s.crawler.newCollector(ctx), "http://www.royalroad.com/fictions/active-popular")
+	require.Error(t, err)
+	_, err = s.crawler.visit(s.crawler.newCollector(ctx), "http://www.royalroad.com/fictions/active-popular")
+	assert.ErrorContains(t, err, "no healthy proxy")
+}
+
+func TestNetworkTransport_DNSOverride(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	_, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 4, Fictions: 3, PageSize: 20, Layout: "current"})
+	_, port, err := net.SplitHostPort(cfg.BaseURL[len("http://"):])
+	require.NoError(t, err)
+	useCrawlNetwork(t, s.crawler, NetworkConfig{DNSOverride: map[string]string{"royalroad.test": "127.0.0.1"}}, cfg.BaseURL)
+
+	books, err := s.crawler.scrapeBooks(ctx, "dns-test", "http://royalroad.test:"+port+"/fictions/active-popular", 10)
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(books))
+}
+
+func TestNetworkTransport_BindAddress(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	var remote atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote.Store(r.RemoteAddr)
+	}))
+	defer server.Close()
+
+	useCrawlNetwork(t, s.crawler, NetworkConfig{BindAddress: "127.0.0.1"}, server.URL)
+	_, err := s.crawler.visit(s.crawler.newCollector(ctx), server.URL)
+	require.NoError(t, err)
+	host, _, err := net.SplitHostPort(remote.Load().(string))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", host)
+
+	// 192.0.2.1 is reserved for documentation, so no interface has it
+	useCrawlNetwork(t, s.crawler, NetworkConfig{BindAddress: "192.0.2.1"}, server.URL)
+	_, err = s.crawler.visit(s.crawler.newCollector(ctx), server.URL)
+	assert.Error(t, err)
+}
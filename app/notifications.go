@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// notifyUsers builds the same notification for each user
+func notifyUsers(userIDs []string, message, link string, now time.Time) []Notification {
+	notifications := make([]Notification, len(userIDs))
+	for i, userID := range userIDs {
+		notifications[i] = Notification{UserID: userID, Message: message, Link: link, CreatedAt: now}
+	}
+	return notifications
+}
+
+// notificationsHandler returns the current user's notifications, newest first
+func (s *server) notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	notifications, err := s.store.GetNotifications(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load notifications: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if notifications == nil {
+		notifications = []Notification{}
+	}
+	writeJSON(w, notifications)
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// OPDS 1.2 media types
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsAcquisitionRel  = "http://opds-spec.org/acquisition"
+)
+
+// opdsFeed is an Atom feed in the OPDS catalog
+type opdsFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    opdsAuthor  `xml:"author"`
+	Links     []opdsLink  `xml:"link"`
+	Entries   []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Authors    []opdsAuthor   `xml:"author,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Categories []opdsCategory `xml:"category,omitempty"`
+	Summary    string         `xml:"summary,omitempty"`
+	Content    *opdsContent   `xml:"content,omitempty"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type opdsCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+// newOPDSFeed starts a feed with the links every catalog page shares
+func newOPDSFeed(id, title, self, kind string, updated time.Time) opdsFeed {
+	return opdsFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		ID:        "urn:royalroadbot:" + id,
+		Title:     title,
+		Updated:   updated.Format(time.RFC3339),
+		Author:    opdsAuthor{Name: "royalroadbot"},
+		Links: []opdsLink{
+			{Rel: "self", Href: self, Type: kind},
+			{Rel: "start", Href: "/opds", Type: opdsNavigationType},
+			{Rel: "up", Href: "/opds", Type: opdsNavigationType},
+		},
+	}
+}
+
+// navigationEntry links to another feed of the catalog
+func navigationEntry(id, title, summary, href, kind string, updated time.Time) opdsEntry {
+	return opdsEntry{
+		ID:      "urn:royalroadbot:" + id,
+		Title:   title,
+		Updated: updated.Format(time.RFC3339),
+		Content: &opdsContent{Type: "text", Text: summary},
+		Links:   []opdsLink{{Rel: "subsection", Href: href, Type: kind}},
+	}
+}
+
+// bookEntry describes a fiction with the same data as the main page. Archived fictions get an
+// acquisition link to their EPUB export
+func bookEntry(book Book, updated time.Time, archived bool) opdsEntry {
+	entry := opdsEntry{
+		ID:       "urn:royalroad:fiction:" + book.FictionID,
+		Title:    book.Title,
+		Updated:  updated.Format(time.RFC3339),
+		Language: "en",
+		Summary:  book.Synopsis,
+		Links: []opdsLink{
+			{Rel: "alternate", Href: "/fiction/" + book.FictionID, Type: "text/html", Title: "Fiction page"},
+		},
+	}
+	if book.Link != "" {
+		entry.Links = append(entry.Links, opdsLink{Rel: "related", Href: book.Link, Type: "text/html", Title: "Open on Royal Road"})
+	}
+	if entry.Title == "" {
+		entry.Title = "Fiction " + book.FictionID
+	}
+	if book.Author != "" {
+		entry.Authors = []opdsAuthor{{Name: book.Author}}
+	}
+	for _, tag := range book.Tags {
+		entry.Categories = append(entry.Categories, opdsCategory{Term: tag, Label: tag})
+	}
+	if book.Followers > 0 || book.Rating > 0 {
+		entry.Content = &opdsContent{Type: "text", Text: fmt.Sprintf("%d followers, rated %.2f", book.Followers, book.Rating)}
+	}
+	if archived {
+		entry.Links = append(entry.Links, opdsLink{Rel: opdsAcquisitionRel, Href: "/archive/" + book.FictionID + "/epub", Type: "application/epub+zip"})
+	}
+	return entry
+}
+
+// catalogBooks returns the books the main page shows, or the stored books before the first crawl
+func (s *server) catalogBooks(ctx context.Context) ([]Book, error) {
+	if books := s.books.get(); len(books) > 0 {
+		return books, nil
+	}
+	return s.store.GetBooks(ctx)
+}
+
+// archivedIDs returns the fictions the user has archived, so entries can link to their EPUB
+func archivedIDs(ctx context.Context, store BookStore, userID string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	if userID == "" {
+		return ids, nil
+	}
+	archives, err := store.GetArchivedFictions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, archive := range archives {
+		ids[archive.FictionID] = true
+	}
+	return ids, nil
+}
+
+// bookByID finds the stored book for a fiction, falling back to a bare link to its page
+func (s *server) bookByID(books []Book, fictionID, title string) Book {
+	for _, book := range books {
+		if book.FictionID == fictionID {
+			return book
+		}
+	}
+	return Book{FictionID: fictionID, Title: title, Link: s.crawler.fictionLink(fictionID)}
+}
+
+func writeOPDS(w http.ResponseWriter, feed opdsFeed, kind string) {
+	w.Header().Set("Content-Type", kind+";charset=utf-8")
+	w.Write([]byte(xml.Header))
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode feed: %s", err), http.StatusInternalServerError)
+	}
+}
+
+// opdsRootHandler is the start of the catalog: one entry per ranking list, then the per-user
+// follows and archives, which ask the e-reader for basic auth
+func (s *server) opdsRootHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	feed := newOPDSFeed("catalog", "Royal Road Bot", "/opds", opdsNavigationType, now)
+
+	for _, list := range rankingLists {
+		feed.Entries = append(feed.Entries, navigationEntry("list:"+list, "Ranking: "+list,
+			"Fictions on the "+list+" list", "/opds/lists/"+list, opdsAcquisitionType, now))
+	}
+	feed.Entries = append(feed.Entries,
+		navigationEntry("follows", "Followed fictions", "Fictions you follow or favorited (sign in required)",
+			"/opds/follows", opdsAcquisitionType, now),
+		navigationEntry("archives", "Archived fictions", "Your offline archives as EPUB (sign in required)",
+			"/opds/archives", opdsAcquisitionType, now),
+	)
+	writeOPDS(w, feed, opdsNavigationType)
+}
+
+// opdsListHandler lists the fictions on a ranking list. Signed-in users get EPUB links for
+// the fictions they archived
+func (s *server) opdsListHandler(w http.ResponseWriter, r *http.Request) {
+	list := r.PathValue("list")
+	// Only the popular list is crawled so far, and the cached books are that list
+	if list != "popular" {
+		http.Error(w, "List not found", http.StatusNotFound)
+		return
+	}
+	books, err := s.catalogBooks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load books: %s", err), http.StatusInternalServerError)
+		return
+	}
+	userID, _ := s.currentUser(r)
+	archived, err := archivedIDs(r.Context(), s.store, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archives: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	feed := newOPDSFeed("list:"+list, "Ranking: "+list, "/opds/lists/"+list, opdsAcquisitionType, now)
+	for _, book := range books {
+		feed.Entries = append(feed.Entries, bookEntry(book, now, archived[book.FictionID]))
+	}
+	writeOPDS(w, feed, opdsAcquisitionType)
+}
+
+// opdsFollowsHandler lists the fictions the current user follows or favorited
+func (s *server) opdsFollowsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	follows, err := s.store.GetFollows(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load follows: %s", err), http.StatusInternalServerError)
+		return
+	}
+	books, err := s.store.GetBooks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load books: %s", err), http.StatusInternalServerError)
+		return
+	}
+	archived, err := archivedIDs(r.Context(), s.store, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archives: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	feed := newOPDSFeed("follows:"+userID, "Followed fictions", "/opds/follows", opdsAcquisitionType, time.Now().UTC())
+	seen := make(map[string]bool)
+	for _, follow := range follows {
+		if seen[follow.FictionID] {
+			continue
+		}
+		seen[follow.FictionID] = true
+		book := s.bookByID(books, follow.FictionID, "")
+		feed.Entries = append(feed.Entries, bookEntry(book, follow.CreatedAt, archived[follow.FictionID]))
+	}
+	writeOPDS(w, feed, opdsAcquisitionType)
+}
+
+// opdsArchivesHandler lists the current user's archives, each downloadable as an EPUB
+func (s *server) opdsArchivesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	archives, err := s.store.GetArchivedFictions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load archives: %s", err), http.StatusInternalServerError)
+		return
+	}
+	books, err := s.store.GetBooks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load books: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	feed := newOPDSFeed("archives:"+userID, "Archived fictions", "/opds/archives", opdsAcquisitionType, time.Now().UTC())
+	for _, archive := range archives {
+		book := s.bookByID(books, archive.FictionID, archive.Title)
+		feed.Entries = append(feed.Entries, bookEntry(book, archive.CreatedAt, true))
+	}
+	writeOPDS(w, feed, opdsAcquisitionType)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func opdsMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /opds", s.opdsRootHandler)
+	mux.HandleFunc("GET /opds/lists/{list}", s.opdsListHandler)
+	mux.HandleFunc("GET /opds/follows", s.requireUser(s.opdsFollowsHandler))
+	mux.HandleFunc("GET /opds/archives", s.requireUser(s.opdsArchivesHandler))
+	return mux
+}
+
+// getFeed fetches and parses a catalog page
+func getFeed(t *testing.T, mux *http.ServeMux, req *http.Request) opdsFeed {
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Contains(t, rr.Header().Get("Content-Type"), "profile=opds-catalog")
+	var feed opdsFeed
+	require.NoError(t, xml.Unmarshal(rr.Body.Bytes(), &feed))
+	return feed
+}
+
+// acquisitionLink returns the EPUB link of an entry, if any
+func acquisitionLink(entry opdsEntry) string {
+	for _, link := range entry.Links {
+		if link.Rel == opdsAcquisitionRel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+func TestOPDSRoot(t *testing.T) {
+	s, _ := testServer(t)
+	feed := getFeed(t, opdsMux(s), httptest.NewRequest("GET", "/opds", nil))
+	assert.Equal(t, "Royal Road Bot", feed.Title)
+
+	var hrefs []string
+	for _, entry := range feed.Entries {
+		require.Equal(t, 1, len(entry.Links))
+		assert.Equal(t, "subsection", entry.Links[0].Rel)
+		hrefs = append(hrefs, entry.Links[0].Href)
+	}
+	assert.Equal(t, []string{"/opds/lists/popular", "/opds/follows", "/opds/archives"}, hrefs)
+}
+
+func TestOPDSList(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "1", Title: "First"}))
+
+	s.books.set([]Book{
+		{FictionID: "1", Title: "First", Link: "https://www.royalroad.com/fiction/1", Author: "Ann", Tags: []string{"Fantasy"}, Synopsis: "A synopsis.", Followers: 10, Rating: 4.5},
+		{FictionID: "2", Title: "Second", Link: "https://www.royalroad.com/fiction/2"},
+	})
+	mux := opdsMux(s)
+
+	feed := getFeed(t, mux, httptest.NewRequest("GET", "/opds/lists/popular", nil).WithContext(ctx))
+	require.Equal(t, 2, len(feed.Entries))
+	first := feed.Entries[0]
+	assert.Equal(t, "First", first.Title)
+	assert.Equal(t, "urn:royalroad:fiction:1", first.ID)
+	assert.Equal(t, "A synopsis.", first.Summary)
+	require.Equal(t, 1, len(first.Authors))
+	assert.Equal(t, "Ann", first.Authors[0].Name)
+	require.Equal(t, 1, len(first.Categories))
+	assert.Equal(t, "Fantasy", first.Categories[0].Term)
+	assert.Empty(t, acquisitionLink(first), "anonymous readers get no EPUB links")
+
+	feed = getFeed(t, mux, newUserRequest(ctx, "GET", "/opds/lists/popular", "alice", nil))
+	assert.Equal(t, "/archive/1/epub", acquisitionLink(feed.Entries[0]))
+	assert.Empty(t, acquisitionLink(feed.Entries[1]))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/opds/lists/unknown", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestOPDSUserFeeds(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveBooks(ctx, []Book{{FictionID: "1", Title: "First", Link: "https://www.royalroad.com/fiction/1"}}))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "1", Kind: FollowKindFollow, CreatedAt: time.Now()}))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "1", Kind: FollowKindFavorite, CreatedAt: time.Now()}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "alice", FictionID: "9", Title: "Stubbed", CreatedAt: time.Now()}))
+	mux := opdsMux(s)
+
+	for _, target := range []string{"/opds/follows", "/opds/archives"} {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", target, nil).WithContext(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, target)
+		assert.NotEmpty(t, rr.Header().Get("WWW-Authenticate"), target)
+	}
+
+	feed := getFeed(t, mux, newUserRequest(ctx, "GET", "/opds/follows", "alice", nil))
+	require.Equal(t, 1, len(feed.Entries))
+	assert.Equal(t, "First", feed.Entries[0].Title)
+	assert.Empty(t, acquisitionLink(feed.Entries[0]))
+
+	// Archived fictions no longer on any list keep the archived title
+	feed = getFeed(t, mux, newUserRequest(ctx, "GET", "/opds/archives", "alice", nil))
+	require.Equal(t, 1, len(feed.Entries))
+	assert.Equal(t, "Stubbed", feed.Entries[0].Title)
+	assert.Equal(t, "/archive/9/epub", acquisitionLink(feed.Entries[0]))
+
+	feed = getFeed(t, mux, newUserRequest(ctx, "GET", "/opds/archives", "bob", nil))
+	assert.Empty(t, feed.Entries)
+}
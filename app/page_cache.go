@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Page types the cache sets a max-age for
+const (
+	pageTypeList    = "list"
+	pageTypeFiction = "fiction"
+	pageTypeChapter = "chapter"
+	pageTypeProfile = "profile"
+	pageTypeOther   = "other"
+)
+
+var pageTypes = []string{pageTypeList, pageTypeFiction, pageTypeChapter, pageTypeProfile, pageTypeOther}
+
+// Values of the page cache setting besides a directory
+const (
+	pageCacheStore = "store"
+	pageCacheOff   = "off"
+)
+
+// defaultCacheMaxAge is how long a cached page is used without asking the site. Lists move between
+// crawls, fiction pages change with new chapters and reviews, and chapters are rarely edited
+func defaultCacheMaxAge() map[string]Duration {
+	return map[string]Duration{
+		pageTypeList:    Duration(10 * time.Minute),
+		pageTypeFiction: Duration(6 * time.Hour),
+		pageTypeChapter: Duration(30 * 24 * time.Hour),
+		pageTypeProfile: Duration(24 * time.Hour),
+		pageTypeOther:   0,
+	}
+}
+
+// pageTypeOf tells RoyalRoad's pages apart by their path
+func pageTypeOf(u *url.URL) string {
+	switch {
+	case strings.Contains(u.Path, "/chapter/"):
+		return pageTypeChapter
+	case strings.HasPrefix(u.Path, "/fiction/"):
+		return pageTypeFiction
+	case strings.HasPrefix(u.Path, "/fictions/"):
+		return pageTypeList
+	case strings.HasPrefix(u.Path, "/profile/"):
+		return pageTypeProfile
+	}
+	return pageTypeOther
+}
+
+// newCrawlTransport returns the transport the crawl settings ask for: the recorded pages, the HTTP
+// cache in store or on disk, or next to always fetch. Recording and replaying bypass the cache so
+// every page is on disk
+func newCrawlTransport(cfg CrawlConfig, store PageCacheStore, next http.RoundTripper) http.RoundTripper {
+	if fixtures := newFixtureTransport(cfg, next); fixtures != nil {
+		return fixtures
+	}
+	maxAge := make(map[string]time.Duration)
+	for pageType, age := range cfg.CacheMaxAge {
+		maxAge[pageType] = time.Duration(age)
+	}
+	switch cfg.PageCache {
+	case pageCacheOff:
+		return next
+	case pageCacheStore:
+		return cacheTransport{cache: store, maxAge: maxAge, next: next}
+	}
+	return cacheTransport{cache: diskPageCache{dir: cfg.PageCache}, maxAge: maxAge, next: next}
+}
+
+// cacheTransport serves pages from a cache while they are younger than the max-age of their page
+// type. Older pages are revalidated with their ETag and Last-Modified, so an unchanged page costs
+// the site a 304 rather than the whole page
+type cacheTransport struct {
+	cache  PageCacheStore
+	maxAge map[string]time.Duration
+	next   http.RoundTripper
+}
+
+func (t cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.next.RoundTrip(req)
+	}
+	logger := loggerFrom(req.Context())
+	cache := t.cache
+	pageType := pageTypeOf(req.URL)
+	key := req.URL.String()
+
+	cached, err := cache.GetCachedPage(req.Context(), key)
+	if err != nil {
+		logger.Warn("Failed to read the page cache", "url", key, "error", err)
+		cached = nil
+	}
+	if cached != nil && !mustRevalidate(req.Context()) && time.Since(cached.FetchedAt) < t.maxAge[pageType] {
+		crawlCacheLookups.WithLabelValues(pageType, "hit").Inc()
+		return cached.response(req), nil
+	}
+
+	conditional := req
+	if cached != nil && (cached.ETag != "" || cached.LastModified != "") {
+		conditional = req.Clone(req.Context())
+		if cached.ETag != "" {
+			conditional.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			conditional.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := t.next.RoundTrip(conditional)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		crawlCacheLookups.WithLabelValues(pageType, "revalidated").Inc()
+		cached.FetchedAt = time.Now().UTC()
+		if err := cache.SaveCachedPage(req.Context(), *cached); err != nil {
+			logger.Warn("Failed to update the page cache", "url", key, "error", err)
+		}
+		return cached.response(req), nil
+	}
+	crawlCacheLookups.WithLabelValues(pageType, "miss").Inc()
+	if !t.cacheable(pageType, resp) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	page := CachedPage{
+		URL:          key,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Body:         body,
+		FetchedAt:    time.Now().UTC(),
+	}
+	if err := cache.SaveCachedPage(req.Context(), page); err != nil {
+		logger.Warn("Failed to save to the page cache", "url", key, "error", err)
+	}
+	return resp, nil
+}
+
+// revalidating makes the page cache check its copies with the site however fresh they are, for
+// fetches meant to spot changes, such as archive rechecks. Unchanged pages still cost only a 304
+func revalidating(ctx context.Context) context.Context {
+	return context.WithValue(ctx, revalidateKey, true)
+}
+
+// mustRevalidate reports whether ctx asks for cached pages to be revalidated
+func mustRevalidate(ctx context.Context) bool {
+	revalidate, _ := ctx.Value(revalidateKey).(bool)
+	return revalidate
+}
+
+// cacheable reports whether a response is worth keeping: a whole page the site allows storing that
+// can be served for a while or revalidated
+func (t cacheTransport) cacheable(pageType string, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		return false
+	}
+	return t.maxAge[pageType] > 0 || resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// response answers req with the cached page
+func (p CachedPage) response(req *http.Request) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", p.ContentType)
+	if p.ETag != "" {
+		header.Set("ETag", p.ETag)
+	}
+	if p.LastModified != "" {
+		header.Set("Last-Modified", p.LastModified)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(p.Body)),
+		ContentLength: int64(len(p.Body)),
+		Request:       req,
+	}
+}
+
+// diskPageCache keeps cached pages as JSON files named after the hash of their URL
+type diskPageCache struct {
+	dir string
+}
+
+func (d diskPageCache) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(d.dir, name[:2], name+".json")
+}
+
+func (d diskPageCache) GetCachedPage(ctx context.Context, url string) (*CachedPage, error) {
+	data, err := os.ReadFile(d.path(url))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var page CachedPage
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil, fmt.Errorf("invalid cached page %s: %v", d.path(url), err)
+	}
+	return &page, nil
+}
+
+// SaveCachedPage writes the page to a temporary file first so readers never see half a page
+func (d diskPageCache) SaveCachedPage(ctx context.Context, page CachedPage) error {
+	path := d.path(page.URL)
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".page-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
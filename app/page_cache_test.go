@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cachingServer serves a list page with an ETag, answering 304 to requests that already hold it
+func cachingServer(t *testing.T, requests, notModified *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<div class="fiction-list-item"><h2 class="fiction-title"><a href="/fiction/1/cached">Cached</a></h2></div>`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func useCache(c *crawler, cache PageCacheStore, maxAge map[string]time.Duration) {
+	useCrawlTransport(c, cacheTransport{
+		cache:  cache,
+		maxAge: maxAge,
+		next:   blockTransport{blocks: c.blocks, next: http.DefaultTransport},
+	})
+}
+
+func TestPageTypeOf(t *testing.T) {
+	tests := map[string]string{
+		"https://www.royalroad.com/fictions/active-popular?page=2":            pageTypeList,
+		"https://www.royalroad.com/fiction/42/some-book":                      pageTypeFiction,
+		"https://www.royalroad.com/fiction/42/some-book/chapter/1001/chapter": pageTypeChapter,
+		"https://www.royalroad.com/profile/7/fictions":                        pageTypeProfile,
+		"https://www.royalroad.com/":                                          pageTypeOther,
+	}
+	for link, want := range tests {
+		u, err := url.Parse(link)
+		require.NoError(t, err)
+		assert.Equal(t, want, pageTypeOf(u), link)
+	}
+}
+
+func TestCacheTransport_FreshHit(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	var requests, notModified atomic.Int32
+	server := cachingServer(t, &requests, &notModified)
+	memory := newMemoryStore()
+	useCache(s.crawler, memory, map[string]time.Duration{pageTypeOther: time.Hour})
+	hits := testutil.ToFloat64(crawlCacheLookups.WithLabelValues(pageTypeOther, "hit"))
+
+	first, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL, 10)
+	require.NoError(t, err)
+	second, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, int32(1), requests.Load(), "a fresh page is served without asking the site")
+	assert.Equal(t, hits+1, testutil.ToFloat64(crawlCacheLookups.WithLabelValues(pageTypeOther, "hit")))
+	cached, err := memory.GetCachedPage(ctx, server.URL)
+	require.NoError(t, err)
+	require.NotNil(t, cached)
+	assert.Equal(t, `"v1"`, cached.ETag)
+}
+
+func TestCacheTransport_ForcedRevalidation(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	var requests, notModified atomic.Int32
+	server := cachingServer(t, &requests, &notModified)
+	useCache(s.crawler, newMemoryStore(), map[string]time.Duration{pageTypeOther: time.Hour})
+
+	_, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL, 10)
+	require.NoError(t, err)
+	books, err := s.crawler.scrapeBooks(revalidating(ctx), "cache-test", server.URL, 10)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, len(books))
+	assert.Equal(t, int32(2), requests.Load(), "a fresh page is checked with the site anyway")
+	assert.Equal(t, int32(1), notModified.Load())
+}
+
+func TestCacheTransport_Revalidates(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	var requests, notModified atomic.Int32
+	server := cachingServer(t, &requests, &notModified)
+	memory := newMemoryStore()
+	useCache(s.crawler, memory, map[string]time.Duration{})
+	revalidated := testutil.ToFloat64(crawlCacheLookups.WithLabelValues(pageTypeOther, "revalidated"))
+
+	_, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL, 10)
+	require.NoError(t, err)
+	before, err := memory.GetCachedPage(ctx, server.URL)
+	require.NoError(t, err)
+	books, err := s.crawler.scrapeBooks(ctx, "cache-test", server.URL, 10)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(books), "a 304 is answered with the cached page")
+	assert.Equal(t, "Cached", books[0].Title)
+	assert.Equal(t, int32(2), requests.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+	assert.Equal(t, revalidated+1, testutil.ToFloat64(crawlCacheLookups.WithLabelValues(pageTypeOther, "revalidated")))
+	after, err := memory.GetCachedPage(ctx, server.URL)
+	require.NoError(t, err)
+	assert.False(t, after.FetchedAt.Before(before.FetchedAt), "revalidating renews the cached page")
+}
+
+func TestCacheTransport_SkipsErrorsAndNoStore(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, no-store")
+			w.Write([]byte("secret"))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	memory := newMemoryStore()
+	useCache(s.crawler, memory, map[string]time.Duration{pageTypeOther: time.Hour})
+
+	_, err := s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/unavailable")
+	require.Error(t, err)
+	_, err = s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/private")
+	require.NoError(t, err)
+
+	assert.Empty(t, memory.pages)
+}
+
+func TestCacheTransport_FakeSite(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 3, Fictions: 4, PageSize: 20, Layout: "current"})
+	cfg.PageCache = pageCacheStore
+	cfg.CacheMaxAge[pageTypeList] = 0
+	useCrawlTransport(s.crawler, newCrawlTransport(cfg, s.store, http.DefaultTransport))
+
+	_, err := s.crawler.crawlList(ctx, "popular", cfg, true)
+	require.NoError(t, err)
+	crawled := site.requests.Load()
+	_, err = s.crawler.crawlList(ctx, "popular", cfg, true)
+	require.NoError(t, err)
+
+	// Only the list page is past its max-age, and it is revalidated
+	assert.Equal(t, crawled+1, site.requests.Load())
+}
+
+func TestDiskPageCache(t *testing.T) {
+	ctx := context.Background()
+	cache := diskPageCache{dir: t.TempDir()}
+	page := CachedPage{
+		URL:         "https://www.royalroad.com/fiction/42/some-book",
+		ContentType: "text/html",
+		ETag:        `"abc"`,
+		Body:        []byte("<html></html>"),
+		FetchedAt:   time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	missing, err := cache.GetCachedPage(ctx, page.URL)
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	require.NoError(t, cache.SaveCachedPage(ctx, page))
+	page.ETag = `"def"`
+	require.NoError(t, cache.SaveCachedPage(ctx, page))
+	cached, err := cache.GetCachedPage(ctx, page.URL)
+	require.NoError(t, err)
+	assert.Equal(t, &page, cached)
+}
+
+func TestNewCrawlTransport(t *testing.T) {
+	memory := newMemoryStore()
+	cfg := defaultConfig().Crawl
+	transport, ok := newCrawlTransport(cfg, memory, http.DefaultTransport).(cacheTransport)
+	require.True(t, ok)
+	assert.Equal(t, 30*24*time.Hour, transport.maxAge[pageTypeChapter])
+	assert.Equal(t, PageCacheStore(memory), transport.cache)
+
+	cfg.PageCache = t.TempDir()
+	transport, ok = newCrawlTransport(cfg, memory, http.DefaultTransport).(cacheTransport)
+	require.True(t, ok)
+	assert.IsType(t, diskPageCache{}, transport.cache)
+
+	cfg.PageCache = pageCacheOff
+	assert.Equal(t, http.DefaultTransport, newCrawlTransport(cfg, memory, http.DefaultTransport))
+
+	cfg.PageCache, cfg.Offline = pageCacheStore, true
+	assert.IsType(t, fixtureTransport{}, newCrawlTransport(cfg, memory, http.DefaultTransport), "replayed pages bypass the cache")
+}
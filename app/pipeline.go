@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// progressLogInterval is how many fictions are crawled between two progress log lines
+const progressLogInterval = 10
+
+// hostLimiter hands out a fixed number of request slots per host
+type hostLimiter struct {
+	mu    sync.Mutex
+	limit int
+	slots map[string]chan struct{}
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{limit: max(limit, 1), slots: make(map[string]chan struct{})}
+}
+
+func (l *hostLimiter) slotsFor(host string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[host]
+	if !ok {
+		slots = make(chan struct{}, l.limit)
+		l.slots[host] = slots
+	}
+	return slots
+}
+
+// acquire blocks until a slot of host is free or ctx is cancelled
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	select {
+	case l.slotsFor(host) <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	<-l.slotsFor(host)
+}
+
+// limitedTransport sends the requests of one collector with the context of its crawl, so cancelling
+// the crawl aborts them while the client's own timeout still applies, and holds a slot of their
+// host until the response body is closed
+type limitedTransport struct {
+	ctx   context.Context
+	hosts *hostLimiter
+	next  http.RoundTripper
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	stop := context.AfterFunc(req.Context(), cancel)
+	finish := func() {
+		stop()
+		cancel()
+	}
+	req = req.WithContext(ctx)
+	host := req.URL.Host
+	if err := t.hosts.acquire(ctx, host); err != nil {
+		finish()
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.hosts.release(host)
+		finish()
+		return nil, err
+	}
+	resp.Body = releasingBody{ReadCloser: resp.Body, release: sync.OnceFunc(func() {
+		t.hosts.release(host)
+		finish()
+	})}
+	return resp, nil
+}
+
+// releasingBody gives the host slot of a response back once its body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b releasingBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// forEach calls fn with every item received from items, at most workers at a time, and then done
+// with its outcome. Once ctx is cancelled the remaining items are drained without being worked on,
+// so senders never block, and items cut short by the cancellation aren't reported. A panic in fn
+// is recovered as its error, so one bad item doesn't take the rest of the crawl down with it
+func forEach[T any](ctx context.Context, workers int, items <-chan T, fn func(T) error, done func(T, error)) {
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := recovered(func() error { return fn(item) })
+				if err != nil && ctx.Err() != nil {
+					continue
+				}
+				done(item, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// recovered runs fn, turning a panic into an error
+func recovered(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// CrawlProgress is how far the detail crawl of a list has got. Fictions that failed are listed
+// with the reason, the rest of the crawl carries on without them
+type CrawlProgress struct {
+	List           string           `json:"list"`
+	StartedAt      time.Time        `json:"started_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
+	Finished       bool             `json:"finished"`
+	Cancelled      bool             `json:"cancelled"`
+	Fictions       int              `json:"fictions"`
+	FictionsDone   int              `json:"fictions_done"`
+	FictionsFailed int              `json:"fictions_failed"`
+	Chapters       int              `json:"chapters"`
+	ChaptersDone   int              `json:"chapters_done"`
+	ChaptersFailed int              `json:"chapters_failed"`
+	Failed         []FictionFailure `json:"failed,omitempty"`
+}
+
+// FictionFailure is a fiction a detail crawl could not crawl
+type FictionFailure struct {
+	FictionID string `json:"fiction_id"`
+	Error     string `json:"error"`
+}
+
+type progressRegistry struct {
+	mu   sync.RWMutex
+	runs map[string]CrawlProgress
+}
+
+// start records a new detail crawl of list over the given number of fictions
+func (p *progressRegistry) start(ctx context.Context, list string, fictions int) *progressTracker {
+	now := time.Now().UTC()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.runs[list] = CrawlProgress{List: list, StartedAt: now, UpdatedAt: now, Fictions: fictions}
+	return &progressTracker{ctx: ctx, registry: p, list: list}
+}
+
+// list returns the last detail crawl of every list by list name
+func (p *progressRegistry) list() []CrawlProgress {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	runs := make([]CrawlProgress, 0, len(p.runs))
+	for _, run := range p.runs {
+		run.Failed = append([]FictionFailure(nil), run.Failed...)
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].List < runs[j].List
+	})
+	return runs
+}
+
+// progressTracker updates the progress of one detail crawl from its workers
+type progressTracker struct {
+	ctx      context.Context
+	registry *progressRegistry
+	list     string
+}
+
+func (t *progressTracker) update(fn func(progress *CrawlProgress)) CrawlProgress {
+	t.registry.mu.Lock()
+	defer t.registry.mu.Unlock()
+	progress := t.registry.runs[t.list]
+	fn(&progress)
+	progress.UpdatedAt = time.Now().UTC()
+	t.registry.runs[t.list] = progress
+	return progress
+}
+
+// fictionDone counts a crawled fiction page, logging the progress every few fictions
+func (t *progressTracker) fictionDone(fictionID string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	crawlDetailPages.WithLabelValues("fiction", result).Inc()
+	progress := t.update(func(progress *CrawlProgress) {
+		if err != nil {
+			progress.FictionsFailed++
+			progress.Failed = append(progress.Failed, FictionFailure{FictionID: fictionID, Error: err.Error()})
+		} else {
+			progress.FictionsDone++
+		}
+	})
+	if crawled := progress.FictionsDone + progress.FictionsFailed; crawled%progressLogInterval == 0 && crawled < progress.Fictions {
+		loggerFrom(t.ctx).Info("Crawl progress", "fictions", progress.Fictions, "done", progress.FictionsDone, "failed", progress.FictionsFailed)
+	}
+}
+
+// chapterQueued counts a chapter page waiting to be measured
+func (t *progressTracker) chapterQueued() {
+	t.update(func(progress *CrawlProgress) { progress.Chapters++ })
+}
+
+// chapterDone counts a measured chapter page
+func (t *progressTracker) chapterDone(err error) {
+	result := "ok"
+	if err != nil {
+		result = "failed"
+	}
+	crawlDetailPages.WithLabelValues("chapter", result).Inc()
+	t.update(func(progress *CrawlProgress) {
+		if err != nil {
+			progress.ChaptersFailed++
+		} else {
+			progress.ChaptersDone++
+		}
+	})
+}
+
+// finish marks the crawl finished, or cancelled when its context was
+func (t *progressTracker) finish() CrawlProgress {
+	return t.update(func(progress *CrawlProgress) {
+		progress.Finished = true
+		progress.Cancelled = t.ctx.Err() != nil
+	})
+}
+
+// crawlProgressHandler returns the progress of the last detail crawl of every list
+func (s *server) crawlProgressHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.crawler.progress.list())
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useCrawlWorkers(c *crawler, workers, hostConcurrency int) {
+	c.workers, c.hosts = workers, newHostLimiter(hostConcurrency)
+}
+
+// inFlightSite serves the fake site slowly, counting the most requests it had in flight at once
+func inFlightSite(t *testing.T, c *crawler, site *fakeSite, peak *atomic.Int32) string {
+	var inFlight atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		site.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	c.baseURL = server.URL
+	return server.URL
+}
+
+func fakeBooks(baseURL string, site *fakeSite) []Book {
+	var books []Book
+	for _, fiction := range site.fictions {
+		books = append(books, Book{Title: fiction.Title, FictionID: fiction.ID, Link: baseURL + fiction.Link()})
+	}
+	return books
+}
+
+func TestCrawlFictionPages_HostConcurrency(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	useCrawlWorkers(s.crawler, 4, 2)
+	site := newFakeSite(fakeSiteOptions{Seed: 5, Fictions: 6, PageSize: 20, Layout: "current"})
+	var peak atomic.Int32
+	baseURL := inFlightSite(t, s.crawler, site, &peak)
+
+	pages := s.crawler.crawlFictionPages(ctx, fakeBooks(baseURL, site))
+
+	assert.Equal(t, 6, len(pages))
+	assert.Equal(t, int32(2), peak.Load(), "four workers share the two slots of the host")
+}
+
+func TestCrawlFictionPages_PartialResults(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	useCrawlWorkers(s.crawler, 3, 3)
+	site, cfg := startFakeSite(t, s.crawler, fakeSiteOptions{Seed: 9, Fictions: 5, PageSize: 20, Layout: "current"})
+	failed := site.fictions[2]
+	site.options.Removed = []string{failed.ID}
+	ctx = startCrawlRun(ctx, "partial-test")
+
+	pages := s.crawler.crawlFictionPages(ctx, fakeBooks(cfg.BaseURL, site))
+
+	assert.Equal(t, 4, len(pages), "the other fictions are crawled")
+	assert.NotContains(t, pages, failed.ID)
+	for _, fiction := range site.fictions {
+		if fiction.ID == failed.ID {
+			continue
+		}
+		chapters, err := memory.GetChapters(ctx, fiction.ID)
+		require.NoError(t, err)
+		require.Equal(t, len(fiction.Chapters), len(chapters))
+		assert.Greater(t, chapters[0].WordCount, 0, "chapters are measured")
+	}
+
+	var progress CrawlProgress
+	for _, run := range s.crawler.progress.list() {
+		if run.List == "partial-test" {
+			progress = run
+		}
+	}
+	assert.True(t, progress.Finished)
+	assert.False(t, progress.Cancelled)
+	assert.Equal(t, 5, progress.Fictions)
+	assert.Equal(t, 4, progress.FictionsDone)
+	assert.Equal(t, 1, progress.FictionsFailed)
+	require.Equal(t, 1, len(progress.Failed))
+	assert.Equal(t, failed.ID, progress.Failed[0].FictionID)
+	assert.Equal(t, progress.Chapters, progress.ChaptersDone)
+}
+
+func TestCrawlFictionPages_Cancelled(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	useCrawlWorkers(s.crawler, 1, 1)
+	site := newFakeSite(fakeSiteOptions{Seed: 2, Fictions: 5, PageSize: 20, Layout: "current"})
+	ctx, cancel := context.WithCancel(startCrawlRun(ctx, "cancel-test"))
+	defer cancel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first page is served and the crawl is cancelled while it is read
+		cancel()
+		site.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	pages := s.crawler.crawlFictionPages(ctx, fakeBooks(server.URL, site))
+
+	assert.LessOrEqual(t, len(pages), 1)
+	assert.Equal(t, int64(1), site.requests.Load(), "no page is requested after the crawl is cancelled")
+	for _, run := range s.crawler.progress.list() {
+		if run.List == "cancel-test" {
+			assert.True(t, run.Cancelled)
+			assert.Less(t, run.FictionsDone, run.Fictions)
+		}
+	}
+}
+
+func TestForEach_RecoversPanics(t *testing.T) {
+	items := make(chan int, 4)
+	for i := range 4 {
+		items <- i
+	}
+	close(items)
+	var mu sync.Mutex
+	outcomes := make(map[int]error)
+
+	forEach(context.Background(), 2, items, func(i int) error {
+		if i == 2 {
+			panic("bad page")
+		}
+		return nil
+	}, func(i int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		outcomes[i] = err
+	})
+
+	assert.Equal(t, 4, len(outcomes))
+	assert.EqualError(t, outcomes[2], "panic: bad page")
+	assert.NoError(t, outcomes[3])
+}
+
+func TestHostLimiter_Cancelled(t *testing.T) {
+	hosts := newHostLimiter(1)
+	require.NoError(t, hosts.acquire(context.Background(), "www.royalroad.com"))
+	require.NoError(t, hosts.acquire(context.Background(), "other.example"), "hosts have slots of their own")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := hosts.acquire(ctx, "www.royalroad.com")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	hosts.release("www.royalroad.com")
+	assert.NoError(t, hosts.acquire(context.Background(), "www.royalroad.com"))
+}
+
+func TestLimitedTransport_ClientTimeout(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	collector := s.crawler.newCollector(ctx)
+	collector.SetRequestTimeout(50 * time.Millisecond)
+	start := time.Now()
+	_, err := s.crawler.visit(collector, server.URL)
+
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "the collector's timeout still applies under the crawl's context")
+}
+
+func TestCrawlProgressHandler(t *testing.T) {
+	s, _ := testServer(t)
+	progress := s.crawler.progress.start(context.Background(), "handler-test", 3)
+	progress.fictionDone("1", nil)
+	progress.fictionDone("2", errors.New("failed to fetch"))
+	progress.finish()
+
+	rr := httptest.NewRecorder()
+	s.crawlProgressHandler(rr, httptest.NewRequest("GET", "/api/crawl/progress", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"list":"handler-test"`)
+	assert.Contains(t, rr.Body.String(), `"fictions_failed":1`)
+	assert.Contains(t, rr.Body.String(), `{"fiction_id":"2","error":"failed to fetch"}`)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	recommendationLimit = 5
+
+	// Weights of the content signals, summing to 1
+	tagWeight      = 0.5
+	authorWeight   = 0.2
+	synopsisWeight = 0.3
+
+	// collaborativeWeight scales the signal from other users' follows
+	collaborativeWeight = 0.5
+	// favoriteWeight boosts seeds the user favorited over ones they only follow
+	favoriteWeight = 1.5
+)
+
+// stopWords are dropped from synopses before weighting terms
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true, "this": true, "from": true,
+	"his": true, "her": true, "their": true, "they": true, "them": true, "was": true, "are": true,
+	"but": true, "not": true, "you": true, "your": true, "has": true, "have": true, "had": true,
+	"who": true, "what": true, "when": true, "where": true, "will": true, "into": true, "its": true,
+	"all": true, "one": true, "can": true, "out": true, "she": true, "him": true, "there": true,
+	"been": true, "more": true, "than": true, "only": true, "just": true, "about": true, "after": true,
+}
+
+// Recommendation is a suggested fiction with the reasons it was picked
+type Recommendation struct {
+	Book        Book     `json:"book"`
+	Score       float64  `json:"score"`
+	Reasons     []string `json:"reasons"`
+	Explanation string   `json:"explanation"`
+}
+
+// tokenize lowercases text and splits it into words, dropping stop words and short words
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	tokens := words[:0]
+	for _, word := range words {
+		word = strings.Trim(word, "'")
+		if len([]rune(word)) >= 3 && !stopWords[word] {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// tfidfVectors builds a unit-length TF-IDF vector per document
+func tfidfVectors(documents []string) []map[string]float64 {
+	termCounts := make([]map[string]float64, len(documents))
+	documentFrequency := make(map[string]int)
+	for i, document := range documents {
+		termCounts[i] = make(map[string]float64)
+		for _, token := range tokenize(document) {
+			termCounts[i][token]++
+		}
+		for term := range termCounts[i] {
+			documentFrequency[term]++
+		}
+	}
+
+	n := float64(len(documents))
+	for _, counts := range termCounts {
+		var norm float64
+		for term, count := range counts {
+			weight := count * (math.Log((1+n)/(1+float64(documentFrequency[term]))) + 1)
+			counts[term] = weight
+			norm += weight * weight
+		}
+		norm = math.Sqrt(norm)
+		for term := range counts {
+			counts[term] /= norm
+		}
+	}
+	return termCounts
+}
+
+// cosine returns the cosine similarity of two unit-length vectors
+func cosine(a, b map[string]float64) float64 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	var dot float64
+	for term, weight := range a {
+		dot += weight * b[term]
+	}
+	return dot
+}
+
+// sharedTags returns the tags of b that also appear on a, in b's order
+func sharedTags(a, b []string) []string {
+	tags := make(map[string]bool, len(a))
+	for _, tag := range a {
+		tags[tag] = true
+	}
+	var shared []string
+	for _, tag := range b {
+		if tags[tag] {
+			shared = append(shared, tag)
+		}
+	}
+	return shared
+}
+
+// jaccard is the size of the intersection over the size of the union
+func jaccard(intersection, a, b int) float64 {
+	union := a + b - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// recommend ranks the books the user doesn't follow yet by similarity to the ones they do,
+// combined with what other users who follow the same fictions also follow
+func recommend(userID string, books []Book, follows []Follow, limit int) []Recommendation {
+	byID := make(map[string]int)
+	var catalog []Book
+	for _, book := range books {
+		if book.FictionID == "" {
+			continue
+		}
+		byID[book.FictionID] = len(catalog)
+		catalog = append(catalog, book)
+	}
+
+	// Seeds are the user's own follows; favorites count for more than plain follows
+	seeds := make(map[string]float64)
+	followedBy := make(map[string]map[string]bool)
+	for _, follow := range follows {
+		if followedBy[follow.UserID] == nil {
+			followedBy[follow.UserID] = make(map[string]bool)
+		}
+		followedBy[follow.UserID][follow.FictionID] = true
+		if follow.UserID != userID {
+			continue
+		}
+		weight := 1.0
+		if follow.Kind == FollowKindFavorite {
+			weight = favoriteWeight
+		}
+		seeds[follow.FictionID] = math.Max(seeds[follow.FictionID], weight)
+	}
+	if len(seeds) == 0 {
+		return nil
+	}
+
+	synopses := make([]string, len(catalog))
+	for i, book := range catalog {
+		synopses[i] = book.Synopsis
+	}
+	vectors := tfidfVectors(synopses)
+
+	// Users whose follows overlap with ours, weighted by how much they overlap
+	neighbours := make(map[string]float64)
+	for otherID, followed := range followedBy {
+		if otherID == userID {
+			continue
+		}
+		overlap := 0
+		for fictionID := range followed {
+			if _, ok := seeds[fictionID]; ok {
+				overlap++
+			}
+		}
+		if overlap > 0 {
+			neighbours[otherID] = jaccard(overlap, len(followed), len(seeds))
+		}
+	}
+
+	var recommendations []Recommendation
+	for i, candidate := range catalog {
+		if _, followed := seeds[candidate.FictionID]; followed {
+			continue
+		}
+
+		var contentScore, bestSimilarity float64
+		var bestSeed Book
+		var bestReasons []string
+		for seedID, weight := range seeds {
+			j, ok := byID[seedID]
+			if !ok {
+				continue
+			}
+			seed := catalog[j]
+			similarity, reasons := contentSimilarity(seed, candidate, cosine(vectors[i], vectors[j]))
+			contentScore += weight * similarity
+			if similarity > bestSimilarity || (similarity == bestSimilarity && similarity > 0 && seed.FictionID < bestSeed.FictionID) {
+				bestSimilarity, bestSeed, bestReasons = similarity, seed, reasons
+			}
+		}
+
+		var collaborativeScore float64
+		readers := 0
+		for otherID, similarity := range neighbours {
+			if followedBy[otherID][candidate.FictionID] {
+				collaborativeScore += similarity
+				readers++
+			}
+		}
+
+		score := contentScore + collaborativeWeight*collaborativeScore
+		if score <= 0 {
+			continue
+		}
+
+		var reasons []string
+		if bestSimilarity > 0 {
+			reasons = append(reasons, fmt.Sprintf("because you follow %s", bestSeed.Title))
+			reasons = append(reasons, bestReasons...)
+		}
+		if readers > 0 {
+			reasons = append(reasons, fmt.Sprintf("followed by %d %s who share your follows", readers, plural(readers, "reader", "readers")))
+		}
+		recommendations = append(recommendations, Recommendation{
+			Book:        candidate,
+			Score:       math.Round(score*1000) / 1000,
+			Reasons:     reasons,
+			Explanation: strings.Join(reasons, ", "),
+		})
+	}
+
+	sort.Slice(recommendations, func(i, j int) bool {
+		if recommendations[i].Score != recommendations[j].Score {
+			return recommendations[i].Score > recommendations[j].Score
+		}
+		return recommendations[i].Book.FictionID < recommendations[j].Book.FictionID
+	})
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+	return recommendations
+}
+
+// contentSimilarity scores a candidate against one seed over tags, author and synopsis terms
+func contentSimilarity(seed, candidate Book, synopsisSimilarity float64) (float64, []string) {
+	var reasons []string
+
+	shared := sharedTags(seed.Tags, candidate.Tags)
+	tagSimilarity := jaccard(len(shared), len(seed.Tags), len(candidate.Tags))
+	if len(shared) > 0 {
+		reasons = append(reasons, fmt.Sprintf("shares tags %s", strings.Join(shared, ", ")))
+	}
+
+	var authorSimilarity float64
+	if seed.Author != "" && seed.Author == candidate.Author {
+		authorSimilarity = 1
+		reasons = append(reasons, fmt.Sprintf("also by %s", seed.Author))
+	}
+
+	if synopsisSimilarity >= 0.1 {
+		reasons = append(reasons, "similar synopsis")
+	}
+
+	return tagWeight*tagSimilarity + authorWeight*authorSimilarity + synopsisWeight*synopsisSimilarity, reasons
+}
+
+func plural(n int, singular, pluralForm string) string {
+	if n == 1 {
+		return singular
+	}
+	return pluralForm
+}
+
+// loadRecommendations computes the recommendations of a user from the stored books and follows
+func loadRecommendations(ctx context.Context, store BookStore, userID string) ([]Recommendation, error) {
+	books, err := store.GetBooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	follows, err := store.GetAllFollows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	authors, err := store.GetAuthors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// List pages don't show authors, so fill them in from the crawled author profiles
+	for i := range books {
+		if author := authorOfFiction(authors, books[i].FictionID); books[i].Author == "" && author != nil {
+			books[i].Author = author.Name
+		}
+	}
+	return recommend(userID, books, follows, recommendationLimit), nil
+}
+
+// recommendationsAPIHandler returns the current user's recommendations as JSON
+func (s *server) recommendationsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	recommendations, err := loadRecommendations(r.Context(), s.store, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load recommendations: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if recommendations == nil {
+		recommendations = []Recommendation{}
+	}
+	writeJSON(w, recommendations)
+}
+
+// recommendationsHandler renders the "Recommended for you" section loaded by the main page
+func (s *server) recommendationsHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		SignedIn        bool
+		Recommendations []Recommendation
+	}{}
+
+	if userID, ok := s.currentUser(r); ok {
+		recommendations, err := loadRecommendations(r.Context(), s.store, userID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load recommendations: %s", err), http.StatusInternalServerError)
+			return
+		}
+		data.SignedIn = true
+		data.Recommendations = recommendations
+	}
+
+	tmpl, err := renderRecommendations()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to parse template: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to execute template: %s", err), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recommendationCatalog() []Book {
+	return []Book{
+		{FictionID: "1", Link: "https://www.royalroad.com/fiction/1", Title: "Dungeon Delver", Author: "Ann", Tags: []string{"LitRPG", "Fantasy", "Dungeon"}, Synopsis: "A delver descends into the endless dungeon to level up."},
+		{FictionID: "2", Link: "https://www.royalroad.com/fiction/2", Title: "Dungeon Core Rising", Author: "Bob", Tags: []string{"LitRPG", "Dungeon"}, Synopsis: "The dungeon core wakes and must grow its dungeon."},
+		{FictionID: "3", Link: "https://www.royalroad.com/fiction/3", Title: "Quiet Tea Shop", Author: "Cat", Tags: []string{"Slice of Life"}, Synopsis: "A retired hero opens a tea shop in a sleepy village."},
+		{FictionID: "4", Link: "https://www.royalroad.com/fiction/4", Title: "Delver Returns", Author: "Ann", Tags: []string{"Fantasy"}, Synopsis: "The sequel nobody expected."},
+		{FictionID: "5", Link: "https://www.royalroad.com/fiction/5", Title: "Star Fleet", Author: "Dan", Tags: []string{"Sci-fi"}, Synopsis: "Spaceships fight across the galaxy."},
+		{Title: "No ID", Link: "https://example.com/no-id"},
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []string{"dungeon", "core's", "waking", "hero"}, tokenize("The Dungeon core's waking, and a hero!"))
+}
+
+func TestTFIDFCosine(t *testing.T) {
+	vectors := tfidfVectors([]string{
+		"dungeon core dungeon",
+		"dungeon core grows",
+		"spaceships galaxy",
+	})
+
+	assert.InDelta(t, 1.0, cosine(vectors[0], vectors[0]), 0.0001)
+	assert.Greater(t, cosine(vectors[0], vectors[1]), 0.5)
+	assert.Equal(t, 0.0, cosine(vectors[0], vectors[2]))
+}
+
+func TestRecommend_ContentBased(t *testing.T) {
+	follows := []Follow{{UserID: "alice", FictionID: "1", Kind: FollowKindFollow}}
+
+	recommendations := recommend("alice", recommendationCatalog(), follows, 5)
+
+	require.NotEmpty(t, recommendations)
+	ids := make([]string, len(recommendations))
+	for i, r := range recommendations {
+		ids[i] = r.Book.FictionID
+		assert.NotEqual(t, "1", r.Book.FictionID, "followed fictions are not recommended")
+	}
+	// Shared tags and synopsis terms beat a shared author with a single tag
+	assert.Equal(t, "2", ids[0])
+	assert.Contains(t, ids, "4")
+	assert.NotContains(t, ids, "5")
+
+	assert.Equal(t, "because you follow Dungeon Delver, shares tags LitRPG, Dungeon, similar synopsis", recommendations[0].Explanation)
+	assert.Contains(t, recommendations[1].Reasons, "also by Ann")
+}
+
+func TestRecommend_Collaborative(t *testing.T) {
+	follows := []Follow{
+		{UserID: "alice", FictionID: "3", Kind: FollowKindFollow},
+		{UserID: "bob", FictionID: "3", Kind: FollowKindFollow},
+		{UserID: "bob", FictionID: "5", Kind: FollowKindFavorite},
+	}
+
+	recommendations := recommend("alice", recommendationCatalog(), follows, 5)
+
+	// Nothing shares content with the tea shop, but bob follows both
+	require.Equal(t, 1, len(recommendations))
+	assert.Equal(t, "5", recommendations[0].Book.FictionID)
+	assert.Equal(t, "followed by 1 reader who share your follows", recommendations[0].Explanation)
+}
+
+func TestRecommend_NoFollows(t *testing.T) {
+	assert.Empty(t, recommend("alice", recommendationCatalog(), nil, 5))
+}
+
+func TestRecommendationsAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveBooks(ctx, recommendationCatalog()))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "1", Kind: FollowKindFavorite}))
+
+	rr := httptest.NewRecorder()
+	s.recommendationsAPIHandler(rr, newUserRequest(ctx, "GET", "/api/recommendations", "alice", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var recommendations []Recommendation
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &recommendations))
+	require.NotEmpty(t, recommendations)
+	assert.Equal(t, "2", recommendations[0].Book.FictionID)
+}
+
+func TestRecommendationsHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveBooks(ctx, recommendationCatalog()))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "1", Kind: FollowKindFollow}))
+
+	// Anonymous visitors are invited to sign in
+	rr := httptest.NewRecorder()
+	s.recommendationsHandler(rr, httptest.NewRequest("GET", "/recommendations", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Recommended for you")
+	assert.Contains(t, rr.Body.String(), "href=\"/login\"")
+
+	rr = httptest.NewRecorder()
+	s.recommendationsHandler(rr, newUserRequest(ctx, "GET", "/recommendations", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "Dungeon Core Rising")
+	assert.Contains(t, body, "because you follow Dungeon Delver")
+}
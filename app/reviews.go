@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+)
+
+const (
+	reviewKeywordLimit = 8
+	// latestReviewCount is how many reviews the fiction page shows under the summary
+	latestReviewCount = 3
+)
+
+// ReviewSummary condenses a fiction's reviews into scores, sentiment and keywords
+type ReviewSummary struct {
+	FictionID        string             `json:"fiction_id"`
+	Count            int                `json:"count"`
+	AverageScores    map[string]float64 `json:"average_scores"`
+	Sentiment        float64            `json:"sentiment"`
+	SentimentLabel   string             `json:"sentiment_label"`
+	Positive         int                `json:"positive"`
+	Neutral          int                `json:"neutral"`
+	Negative         int                `json:"negative"`
+	Keywords         []string           `json:"keywords"`
+	PositiveKeywords []string           `json:"positive_keywords"`
+	NegativeKeywords []string           `json:"negative_keywords"`
+}
+
+// ScoredReview is a review with its computed sentiment
+type ScoredReview struct {
+	Review
+	Sentiment      float64 `json:"sentiment"`
+	SentimentLabel string  `json:"sentiment_label"`
+}
+
+// summarizeReviews averages sub-scores, rates each review's sentiment and picks the words
+// reviewers use most, overall and within positive and negative reviews
+func summarizeReviews(fictionID string, reviews []Review) (ReviewSummary, []ScoredReview) {
+	summary := ReviewSummary{
+		FictionID:     fictionID,
+		Count:         len(reviews),
+		AverageScores: make(map[string]float64),
+	}
+
+	scoreTotals := make(map[string]float64)
+	scoreCounts := make(map[string]int)
+	allTerms := make(map[string]int)
+	positiveTerms := make(map[string]int)
+	negativeTerms := make(map[string]int)
+	scored := make([]ScoredReview, len(reviews))
+	var sentimentTotal float64
+
+	for i, review := range reviews {
+		for name, value := range review.Scores {
+			scoreTotals[name] += value
+			scoreCounts[name]++
+		}
+
+		sentiment := sentimentScore(review.Title + ". " + review.Text)
+		label := sentimentLabel(sentiment)
+		scored[i] = ScoredReview{Review: review, Sentiment: round2(sentiment), SentimentLabel: label}
+		sentimentTotal += sentiment
+
+		var labelTerms map[string]int
+		switch label {
+		case "positive":
+			summary.Positive++
+			labelTerms = positiveTerms
+		case "negative":
+			summary.Negative++
+			labelTerms = negativeTerms
+		default:
+			summary.Neutral++
+		}
+
+		// Count each term once per review so one long review can't dominate the keywords
+		seen := make(map[string]bool)
+		for _, term := range tokenize(review.Title + " " + review.Text) {
+			if seen[term] {
+				continue
+			}
+			seen[term] = true
+			allTerms[term]++
+			if labelTerms != nil {
+				labelTerms[term]++
+			}
+		}
+	}
+
+	for name, total := range scoreTotals {
+		summary.AverageScores[name] = round2(total / float64(scoreCounts[name]))
+	}
+	if len(reviews) > 0 {
+		summary.Sentiment = round2(sentimentTotal / float64(len(reviews)))
+	}
+	summary.SentimentLabel = sentimentLabel(summary.Sentiment)
+	summary.Keywords = topTerms(allTerms, 2)
+	summary.PositiveKeywords = topTerms(positiveTerms, 1)
+	summary.NegativeKeywords = topTerms(negativeTerms, 1)
+	return summary, scored
+}
+
+// topTerms returns the most frequent terms seen at least minCount times
+func topTerms(counts map[string]int, minCount int) []string {
+	terms := make([]string, 0, len(counts))
+	for term, count := range counts {
+		if count >= minCount {
+			terms = append(terms, term)
+		}
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if counts[terms[i]] != counts[terms[j]] {
+			return counts[terms[i]] > counts[terms[j]]
+		}
+		return terms[i] < terms[j]
+	})
+	if len(terms) > reviewKeywordLimit {
+		terms = terms[:reviewKeywordLimit]
+	}
+	return terms
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// loadReviews reads a fiction's stored reviews and summarizes them
+func loadReviews(ctx context.Context, store BookStore, fictionID string) (ReviewSummary, []ScoredReview, error) {
+	reviews, err := store.GetReviews(ctx, fictionID)
+	if err != nil {
+		return ReviewSummary{}, nil, err
+	}
+	summary, scored := summarizeReviews(fictionID, reviews)
+	return summary, scored, nil
+}
+
+// reviewsAPIHandler returns a fiction's review summary and reviews as JSON
+func (s *server) reviewsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	summary, reviews, err := loadReviews(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load reviews: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if reviews == nil {
+		reviews = []ScoredReview{}
+	}
+	writeJSON(w, struct {
+		Summary ReviewSummary  `json:"summary"`
+		Reviews []ScoredReview `json:"reviews"`
+	}{summary, reviews})
+}
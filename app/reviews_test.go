@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testReviews = []Review{
+	{ID: "1", FictionID: "42", Title: "Brilliant", Text: "Amazing magic system and engaging characters", Scores: map[string]float64{"overall": 5, "style": 4}},
+	{ID: "2", FictionID: "42", Title: "Loved it", Text: "Great magic system, wonderful pacing", Scores: map[string]float64{"overall": 4}},
+	{ID: "3", FictionID: "42", Title: "Dropped", Text: "Boring protagonist and slow pacing", Scores: map[string]float64{"overall": 1.5, "style": 2}},
+	{ID: "4", FictionID: "42", Title: "Chapter one", Text: "The story starts in a village"},
+}
+
+func TestSummarizeReviews(t *testing.T) {
+	summary, scored := summarizeReviews("42", testReviews)
+
+	assert.Equal(t, 4, summary.Count)
+	assert.Equal(t, map[string]float64{"overall": 3.5, "style": 3}, summary.AverageScores)
+	assert.Equal(t, 2, summary.Positive)
+	assert.Equal(t, 1, summary.Negative)
+	assert.Equal(t, 1, summary.Neutral)
+	assert.Equal(t, "positive", summary.SentimentLabel)
+
+	// Keywords need two reviews, label keywords come from reviews of that sentiment only
+	assert.Equal(t, []string{"magic", "pacing", "system"}, summary.Keywords)
+	assert.Contains(t, summary.PositiveKeywords, "magic")
+	assert.Contains(t, summary.NegativeKeywords, "protagonist")
+	assert.NotContains(t, summary.NegativeKeywords, "magic")
+
+	require.Equal(t, 4, len(scored))
+	assert.Equal(t, "negative", scored[2].SentimentLabel)
+}
+
+func TestSummarizeReviews_Empty(t *testing.T) {
+	summary, scored := summarizeReviews("42", nil)
+
+	assert.Equal(t, 0, summary.Count)
+	assert.Equal(t, "neutral", summary.SentimentLabel)
+	assert.Empty(t, summary.AverageScores)
+	assert.Empty(t, scored)
+}
+
+func TestReviewsAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveReviews(ctx, testReviews))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fictions/{id}/reviews", s.reviewsAPIHandler)
+
+	req := httptest.NewRequest("GET", "/api/fictions/42/reviews", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var response struct {
+		Summary ReviewSummary  `json:"summary"`
+		Reviews []ScoredReview `json:"reviews"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, 4, response.Summary.Count)
+	assert.Equal(t, 4, len(response.Reviews))
+
+	// Fictions without reviews return an empty list rather than null
+	req = httptest.NewRequest("GET", "/api/fictions/99/reviews", nil).WithContext(ctx)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"reviews":[]`)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedTags are the elements kept in archived chapters, with the attributes each may keep
+var allowedTags = map[atom.Atom][]string{
+	atom.P: nil, atom.Br: nil, atom.Hr: nil, atom.Div: nil, atom.Span: nil,
+	atom.Em: nil, atom.Strong: nil, atom.I: nil, atom.B: nil, atom.U: nil, atom.S: nil,
+	atom.Strike: nil, atom.Del: nil, atom.Ins: nil, atom.Sub: nil, atom.Sup: nil, atom.Small: nil,
+	atom.Blockquote: nil, atom.Pre: nil, atom.Code: nil,
+	atom.H1: nil, atom.H2: nil, atom.H3: nil, atom.H4: nil, atom.H5: nil, atom.H6: nil,
+	atom.Ul: nil, atom.Ol: nil, atom.Li: nil,
+	atom.Table: nil, atom.Thead: nil, atom.Tbody: nil, atom.Tfoot: nil, atom.Tr: nil,
+	atom.Th: {"colspan", "rowspan"}, atom.Td: {"colspan", "rowspan"},
+	atom.A:   {"href", "title"},
+	atom.Img: {"src", "alt", "title", "width", "height"},
+}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Iframe: true, atom.Object: true, atom.Embed: true,
+	atom.Form: true, atom.Input: true, atom.Button: true, atom.Select: true, atom.Textarea: true,
+	atom.Noscript: true, atom.Template: true, atom.Svg: true, atom.Math: true, atom.Link: true, atom.Meta: true,
+}
+
+// styleRule matches one rule of a stylesheet, capturing its selectors and its declarations
+var styleRule = regexp.MustCompile(`([^{}]+)\{([^}]*)\}`)
+
+// classSelector matches a selector ending in a class, capturing the class
+var classSelector = regexp.MustCompile(`\.([\w-]+)$`)
+
+// displayNone matches a declaration that hides an element
+var displayNone = regexp.MustCompile(`(?i)display\s*:\s*none`)
+
+// hiddenClasses returns the classes a stylesheet hides with display:none. RoyalRoad hides
+// paragraphs in chapters this way to catch copies of them
+func hiddenClasses(stylesheet string) map[string]bool {
+	hidden := make(map[string]bool)
+	for _, rule := range styleRule.FindAllStringSubmatch(stylesheet, -1) {
+		if !displayNone.MatchString(rule[2]) {
+			continue
+		}
+		for _, selector := range strings.Split(rule[1], ",") {
+			if match := classSelector.FindStringSubmatch(strings.TrimSpace(selector)); match != nil {
+				hidden[match[1]] = true
+			}
+		}
+	}
+	return hidden
+}
+
+// sanitizeHTML keeps the formatting of a chapter body and drops scripts, styles, event handlers
+// and links that aren't http(s). Unknown elements are replaced by their content. Elements hidden
+// by an inline display:none or by one of the hidden classes are dropped with their content
+func sanitizeHTML(fragment string, hidden map[string]bool) (string, error) {
+	context := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), context)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for _, node := range nodes {
+		for _, clean := range sanitizeNode(node, hidden) {
+			if err := html.Render(&out, clean); err != nil {
+				return "", err
+			}
+		}
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// sanitizeNode returns the nodes that replace node in the sanitized tree
+func sanitizeNode(node *html.Node, hidden map[string]bool) []*html.Node {
+	switch node.Type {
+	case html.TextNode:
+		return []*html.Node{{Type: html.TextNode, Data: node.Data}}
+	case html.ElementNode:
+	default:
+		// Comments and doctypes are dropped
+		return nil
+	}
+
+	if droppedTags[node.DataAtom] || hiddenNode(node, hidden) {
+		return nil
+	}
+
+	var children []*html.Node
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		children = append(children, sanitizeNode(child, hidden)...)
+	}
+
+	allowed, ok := allowedTags[node.DataAtom]
+	if !ok {
+		return children
+	}
+
+	clean := &html.Node{Type: html.ElementNode, Data: node.Data, DataAtom: node.DataAtom}
+	for _, attr := range node.Attr {
+		if attr.Namespace != "" || !slices.Contains(allowed, attr.Key) {
+			continue
+		}
+		if (attr.Key == "href" || attr.Key == "src") && !safeURL(attr.Val) {
+			continue
+		}
+		clean.Attr = append(clean.Attr, html.Attribute{Key: attr.Key, Val: attr.Val})
+	}
+	for _, child := range children {
+		clean.AppendChild(child)
+	}
+	return []*html.Node{clean}
+}
+
+// hiddenNode reports whether an element is hidden by its style attribute or one of its classes
+func hiddenNode(node *html.Node, hidden map[string]bool) bool {
+	for _, attr := range node.Attr {
+		switch {
+		case attr.Namespace != "":
+		case attr.Key == "style" && displayNone.MatchString(attr.Val):
+			return true
+		case attr.Key == "class" && slices.ContainsFunc(strings.Fields(attr.Val), func(class string) bool { return hidden[class] }):
+			return true
+		}
+	}
+	return false
+}
+
+// safeURL accepts absolute http(s) URLs only, so archived pages can't run javascript: links
+func safeURL(raw string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"keeps formatting", `<p>Some <em>very</em> <strong>bold</strong> text</p>`, `<p>Some <em>very</em> <strong>bold</strong> text</p>`},
+		{"drops scripts and styles", `<p>Text</p><script>alert(1)</script><style>p{}</style>`, `<p>Text</p>`},
+		{"drops attributes", `<p class="chapter" style="color:red" onclick="steal()">Text</p>`, `<p>Text</p>`},
+		{"drops hidden elements", `<p>Text</p><p class="cnWzE">Stolen from Royal Road</p><p style="display: none">Hidden <em>too</em></p>`, `<p>Text</p>`},
+		{"keeps safe links", `<a href="https://example.com" target="_blank">link</a>`, `<a href="https://example.com">link</a>`},
+		{"drops javascript links", `<a href="javascript:alert(1)">link</a>`, `<a>link</a>`},
+		{"unwraps unknown elements", `<font color="red"><p>Text</p></font>`, `<p>Text</p>`},
+		{"drops comments", `<p>Text<!-- hidden --></p>`, `<p>Text</p>`},
+		{"keeps tables", `<table><tr><td colspan="2" bgcolor="red">Cell</td></tr></table>`, `<table><tbody><tr><td colspan="2">Cell</td></tr></tbody></table>`},
+		{"escapes text", `<p>1 &lt; 2</p>`, `<p>1 &lt; 2</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clean, err := sanitizeHTML(tt.input, map[string]bool{"cnWzE": true})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, clean)
+		})
+	}
+}
+
+func TestHiddenClasses(t *testing.T) {
+	stylesheet := `
+		.chapter-inner p { margin: 0; }
+		.cnWzE { display: none; speak: never; }
+		.chapter-content .xQ9a, .shown { DISPLAY:NONE !important }
+		.faded { opacity: 0.5 }`
+
+	assert.Equal(t, map[string]bool{"cnWzE": true, "xQ9a": true, "shown": true}, hiddenClasses(stylesheet))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// schedule runs crawl right away and then every interval until ctx is cancelled. A failed crawl is
+// logged and the next one runs at the following tick. A crawl the site blocked pauses the schedule
+// until the pause of the host is over, rather than coming back at every tick to be refused
+func schedule(ctx context.Context, interval time.Duration, crawl func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		next := ticker.C
+		var blocked *BlockedError
+		if err := crawl(ctx); errors.As(err, &blocked) && time.Until(blocked.Until) > interval {
+			loggerFrom(ctx).Warn("Scheduled crawl paused", "host", blocked.Host, "until", blocked.Until, "reason", blocked.Reason)
+			next = time.After(time.Until(blocked.Until))
+		} else if err != nil {
+			loggerFrom(ctx).Error("Scheduled crawl failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-next:
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// crawlList crawls a ranking list and then the detail pages of its books, returning when both are done
+func (c *crawler) crawlList(ctx context.Context, list string, cfg CrawlConfig, details bool) ([]Book, error) {
+	if !c.active.start(list) {
+		return nil, errCrawlRunning
+	}
+	defer c.active.finish(list)
+	ctx = startCrawlRun(ctx, list)
+	books, err := c.fetchBooks(ctx, list, cfg.ListURL(), cfg.BookLimit)
+	if err != nil {
+		return nil, err
+	}
+	if details {
+		c.crawlDetails(ctx, list, books)
+	}
+	return books, nil
+}
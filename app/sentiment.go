@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// sentimentLexicon scores words from -3 (very negative) to 3 (very positive), tuned to fiction reviews
+var sentimentLexicon = map[string]float64{
+	// Positive
+	"amazing": 3, "awesome": 3, "brilliant": 3, "excellent": 3, "fantastic": 3, "incredible": 3,
+	"masterpiece": 3, "outstanding": 3, "perfect": 3, "superb": 3, "wonderful": 3, "phenomenal": 3,
+	"addictive": 2, "beautiful": 2, "binge": 2, "captivating": 2, "charming": 2, "compelling": 2,
+	"creative": 2, "delightful": 2, "engaging": 2, "enjoyable": 2, "enjoyed": 2, "epic": 2,
+	"favorite": 2, "favourite": 2, "fun": 2, "gripping": 2, "great": 2, "hilarious": 2,
+	"immersive": 2, "impressive": 2, "love": 2, "loved": 2, "loving": 2, "memorable": 2,
+	"original": 2, "polished": 2, "recommend": 2, "recommended": 2, "refreshing": 2, "unique": 2,
+	"well-written": 2, "funny": 2, "likeable": 2, "likable": 2, "satisfying": 2, "thrilling": 2,
+	"clever": 1, "consistent": 1, "cool": 1, "decent": 1, "detailed": 1, "enjoy": 1,
+	"fine": 1, "good": 1, "interesting": 1, "like": 1, "liked": 1, "nice": 1,
+	"promising": 1, "solid": 1, "smooth": 1, "strong": 1, "worth": 1, "fresh": 1,
+	// Negative
+	"abysmal": -3, "awful": -3, "garbage": -3, "horrible": -3, "terrible": -3, "trash": -3,
+	"unreadable": -3, "worst": -3, "hate": -3, "hated": -3, "dropped": -3, "atrocious": -3,
+	"annoying": -2, "bad": -2, "boring": -2, "cringe": -2, "cringey": -2, "disappointing": -2,
+	"disappointed": -2, "dull": -2, "edgy": -2, "frustrating": -2, "hiatus": -2, "mess": -2,
+	"poor": -2, "shallow": -2, "stupid": -2, "tedious": -2, "unlikable": -2, "unlikeable": -2,
+	"weak": -2, "waste": -2, "flat": -2, "forced": -2, "grind": -2, "info-dump": -2,
+	"confusing": -1, "generic": -1, "mediocre": -1, "meh": -1, "overpowered": -1, "predictable": -1,
+	"rushed": -1, "slow": -1, "typos": -1, "errors": -1, "repetitive": -1, "cliche": -1,
+	"cliché": -1, "bland": -1, "dragging": -1, "stubbed": -1, "inconsistent": -1, "lacking": -1,
+}
+
+// sentimentNegations flip the polarity of the next sentiment word within a short window
+var sentimentNegations = map[string]bool{
+	"not": true, "no": true, "never": true, "isn't": true, "wasn't": true, "don't": true,
+	"doesn't": true, "didn't": true, "can't": true, "cannot": true, "hardly": true, "nothing": true,
+	"aren't": true, "won't": true, "couldn't": true, "without": true,
+}
+
+// sentimentBoosters strengthen the next sentiment word
+var sentimentBoosters = map[string]float64{
+	"very": 1.5, "really": 1.3, "extremely": 1.8, "incredibly": 1.8, "so": 1.3, "super": 1.5, "absolutely": 1.8,
+}
+
+// sentimentScore rates text from -1 (negative) to 1 (positive) with a lexicon, handling
+// simple negation ("not good") and boosters ("very good")
+func sentimentScore(text string) float64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\'' && r != '-'
+	})
+
+	var total float64
+	negateWithin, boost := 0, 1.0
+	for _, word := range words {
+		word = strings.Trim(word, "'-")
+		if sentimentNegations[word] {
+			negateWithin = 3
+			continue
+		}
+		if factor, ok := sentimentBoosters[word]; ok {
+			boost = factor
+			continue
+		}
+		if value, ok := sentimentLexicon[word]; ok {
+			value *= boost
+			if negateWithin > 0 {
+				value = -value * 0.75
+			}
+			total += value
+			negateWithin, boost = 0, 1.0
+			continue
+		}
+		if negateWithin > 0 {
+			negateWithin--
+		}
+		boost = 1.0
+	}
+
+	// Normalize into [-1, 1] the way VADER does, so long reviews don't dominate
+	return total / math.Sqrt(total*total+15)
+}
+
+// sentimentLabel buckets a sentiment score
+func sentimentLabel(score float64) string {
+	switch {
+	case score >= 0.05:
+		return "positive"
+	case score <= -0.05:
+		return "negative"
+	}
+	return "neutral"
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSentimentScore(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		label string
+	}{
+		{"positive", "An amazing story with engaging characters", "positive"},
+		{"negative", "Boring, slow and full of typos", "negative"},
+		{"neutral", "The story follows a mage in a tower", "neutral"},
+		{"negation", "The pacing is not good", "negative"},
+		{"negated negative", "Never boring", "positive"},
+		{"mixed leaning positive", "A slow start but the ending was brilliant", "positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.label, sentimentLabel(sentimentScore(tt.text)))
+		})
+	}
+}
+
+func TestSentimentScore_Bounded(t *testing.T) {
+	text := ""
+	for i := 0; i < 100; i++ {
+		text += "amazing "
+	}
+	score := sentimentScore(text)
+	assert.True(t, score > 0.99 && score <= 1, "score %v should approach 1", score)
+	assert.Equal(t, 0.0, sentimentScore(""))
+	assert.Greater(t, sentimentScore("very good"), sentimentScore("good"))
+}
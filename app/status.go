@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// chapterDropRatio flags a fiction as stubbed when fewer than this share of its known chapters remain
+	chapterDropRatio = 0.5
+	// chapterDropMinimum ignores drops on fictions with only a few chapters
+	chapterDropMinimum = 5
+	// stubNoticeLength caps how much of a stub notice is kept as the reason
+	stubNoticeLength = 200
+)
+
+// stubNoticePattern matches the notices authors post when they stub a fiction, such as
+// "This story has been stubbed", without flagging a character who stubbed their toe
+var stubNoticePattern = regexp.MustCompile(`(?i)\b(been|being|was|is|are|now|will be|get|getting) stubbed\b|\bstub(bing)? (notice|date)\b`)
+
+// FictionStatus is the current status of a fiction with the changes that led to it
+type FictionStatus struct {
+	FictionID string        `json:"fiction_id"`
+	Status    string        `json:"status"`
+	Since     *time.Time    `json:"since,omitempty"`
+	Reason    string        `json:"reason,omitempty"`
+	Events    []StatusEvent `json:"events"`
+}
+
+// classifyFiction tells from a crawled fiction page whether the fiction is still there.
+// knownChapters is how many chapters were stored before this crawl. An empty status means
+// the crawl failed for another reason and tells nothing about the fiction
+func classifyFiction(page FictionPage, knownChapters int) (string, string) {
+	switch code := page.Status.StatusCode; {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return FictionStatusRemoved, fmt.Sprintf("Fiction page returned HTTP %d", code)
+	case page.Status.Error != "":
+		return "", ""
+	}
+	if page.StubNotice != "" {
+		return FictionStatusStubbed, "Stub notice: " + page.StubNotice
+	}
+	if knownChapters >= chapterDropMinimum && float64(len(page.Chapters)) < chapterDropRatio*float64(knownChapters) {
+		return FictionStatusStubbed, fmt.Sprintf("Chapters dropped from %d to %d", knownChapters, len(page.Chapters))
+	}
+	return FictionStatusActive, ""
+}
+
+// stubNotice shortens the text of a notice mentioning a stub, or returns "" for other text
+func stubNotice(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if !stubNoticePattern.MatchString(text) {
+		return ""
+	}
+	if runes := []rune(text); len(runes) > stubNoticeLength {
+		text = string(runes[:stubNoticeLength]) + "…"
+	}
+	return text
+}
+
+// checkFictionStatus records a status event when a crawl finds a fiction in a different state
+// than last time, and notifies the users following or archiving it. It must run before the
+// chapters found on the page are saved. Fictions start out active, so only changes are recorded
+func (c *crawler) checkFictionStatus(ctx context.Context, book Book, page FictionPage, now time.Time) error {
+	chapters, err := c.store.GetChapters(ctx, book.FictionID)
+	if err != nil {
+		return err
+	}
+	status, reason := classifyFiction(page, len(chapters))
+	if status == "" {
+		return nil
+	}
+
+	events, err := c.store.GetStatusEvents(ctx, book.FictionID)
+	if err != nil {
+		return err
+	}
+	previous := FictionStatusActive
+	if len(events) > 0 {
+		previous = events[len(events)-1].Status
+	}
+	if status == previous {
+		return nil
+	}
+
+	event := StatusEvent{
+		FictionID:  book.FictionID,
+		Title:      c.fictionTitle(ctx, book, page, events),
+		Status:     status,
+		Previous:   previous,
+		Reason:     reason,
+		StatusCode: page.Status.StatusCode,
+		CreatedAt:  now,
+	}
+	if err := c.store.AddStatusEvent(ctx, event); err != nil {
+		return err
+	}
+
+	userIDs, err := c.trackingUsers(ctx, book.FictionID)
+	if err != nil {
+		return err
+	}
+	return c.store.AddNotifications(ctx, notifyUsers(userIDs, statusMessage(event), "/fiction/"+book.FictionID, now))
+}
+
+// fictionTitle names a fiction even when its page is gone
+func (c *crawler) fictionTitle(ctx context.Context, book Book, page FictionPage, events []StatusEvent) string {
+	switch {
+	case page.Title != "":
+		return page.Title
+	case book.Title != "":
+		return book.Title
+	case len(events) > 0:
+		return events[len(events)-1].Title
+	}
+	if books, err := c.store.GetBooks(ctx); err == nil {
+		for _, stored := range books {
+			if stored.FictionID == book.FictionID && stored.Title != "" {
+				return stored.Title
+			}
+		}
+	}
+	return "Fiction " + book.FictionID
+}
+
+// trackingUsers returns the users who follow or archived a fiction
+func (c *crawler) trackingUsers(ctx context.Context, fictionID string) ([]string, error) {
+	follows, err := c.store.GetAllFollows(ctx)
+	if err != nil {
+		return nil, err
+	}
+	archives, err := c.store.GetAllArchivedFictions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var userIDs []string
+	add := func(userID string) {
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	for _, follow := range follows {
+		if follow.FictionID == fictionID {
+			add(follow.UserID)
+		}
+	}
+	for _, archive := range archives {
+		if archive.FictionID == fictionID {
+			add(archive.UserID)
+		}
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// statusMessage is the notification text for a status change
+func statusMessage(event StatusEvent) string {
+	switch event.Status {
+	case FictionStatusRemoved:
+		return fmt.Sprintf("%s was removed from Royal Road (%s)", event.Title, event.Reason)
+	case FictionStatusStubbed:
+		return fmt.Sprintf("%s was stubbed (%s)", event.Title, event.Reason)
+	default:
+		return fmt.Sprintf("%s is available again", event.Title)
+	}
+}
+
+// buildFictionStatus sums up the status events of a fiction
+func buildFictionStatus(fictionID string, events []StatusEvent) FictionStatus {
+	status := FictionStatus{FictionID: fictionID, Status: FictionStatusActive, Events: events}
+	if status.Events == nil {
+		status.Events = []StatusEvent{}
+	}
+	if len(events) > 0 {
+		last := events[len(events)-1]
+		status.Status = last.Status
+		status.Reason = last.Reason
+		status.Since = &last.CreatedAt
+	}
+	return status
+}
+
+// loadFictionStatus loads the status of a fiction from its stored events
+func loadFictionStatus(ctx context.Context, store BookStore, fictionID string) (FictionStatus, error) {
+	events, err := store.GetStatusEvents(ctx, fictionID)
+	if err != nil {
+		return FictionStatus{}, err
+	}
+	return buildFictionStatus(fictionID, events), nil
+}
+
+// statusAPIHandler returns the current status of a fiction and its status changes as JSON
+func (s *server) statusAPIHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := loadFictionStatus(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load status: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
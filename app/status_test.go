@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pageWithChapters is a successfully crawled fiction page with n chapters
+func pageWithChapters(n int) FictionPage {
+	page := FictionPage{FictionID: "42", Title: "Test Book", Status: FetchStatus{StatusCode: http.StatusOK}}
+	for i := 0; i < n; i++ {
+		page.Chapters = append(page.Chapters, Chapter{FictionID: "42"})
+	}
+	return page
+}
+
+func TestClassifyFiction(t *testing.T) {
+	stubbed := pageWithChapters(3)
+	stubbed.StubNotice = "This story has been stubbed"
+
+	tests := []struct {
+		name   string
+		page   FictionPage
+		known  int
+		status string
+		reason string
+	}{
+		{"active", pageWithChapters(10), 10, FictionStatusActive, ""},
+		{"removed", FictionPage{Status: FetchStatus{StatusCode: 404, Error: "Not Found"}}, 10, FictionStatusRemoved, "Fiction page returned HTTP 404"},
+		{"server error says nothing", FictionPage{Status: FetchStatus{StatusCode: 503, Error: "Service Unavailable"}}, 10, "", ""},
+		{"network error says nothing", FictionPage{Status: FetchStatus{Error: "connection refused"}}, 10, "", ""},
+		{"stub notice", stubbed, 3, FictionStatusStubbed, "Stub notice: This story has been stubbed"},
+		{"chapter drop", pageWithChapters(4), 40, FictionStatusStubbed, "Chapters dropped from 40 to 4"},
+		{"small drop", pageWithChapters(30), 40, FictionStatusActive, ""},
+		{"short fiction", pageWithChapters(1), 4, FictionStatusActive, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason := classifyFiction(tt.page, tt.known)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
+
+func TestStubNotice(t *testing.T) {
+	assert.Equal(t, "This story has been stubbed for publication on Amazon.",
+		stubNotice("  This story has been\n stubbed for publication on Amazon. "))
+	assert.Equal(t, "", stubNotice("She stubbed her toe on the dungeon core."))
+	assert.Equal(t, "", stubNotice("A tale of swords and sorcery."))
+	assert.Equal(t, stubNoticeLength+1, len([]rune(stubNotice("Was stubbed "+strings.Repeat("x", 300)))))
+}
+
+func TestCheckFictionStatus(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	require.NoError(t, memory.SaveChapters(ctx, weeklyChapters(10)))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "42", Kind: FollowKindFollow}))
+	require.NoError(t, memory.SaveArchivedFiction(ctx, ArchivedFiction{UserID: "bob", FictionID: "42"}))
+	book := Book{FictionID: "42"}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// A healthy fiction records nothing
+	require.NoError(t, s.crawler.checkFictionStatus(ctx, book, pageWithChapters(10), now))
+	events, err := memory.GetStatusEvents(ctx, "42")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	require.NoError(t, s.crawler.checkFictionStatus(ctx, book, pageWithChapters(2), now))
+	// The same status on the next crawl is not a new event
+	require.NoError(t, s.crawler.checkFictionStatus(ctx, book, pageWithChapters(2), now.Add(time.Hour)))
+	events, err = memory.GetStatusEvents(ctx, "42")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, StatusEvent{
+		FictionID:  "42",
+		Title:      "Test Book",
+		Status:     FictionStatusStubbed,
+		Previous:   FictionStatusActive,
+		Reason:     "Chapters dropped from 10 to 2",
+		StatusCode: http.StatusOK,
+		CreatedAt:  now,
+	}, events[0])
+
+	for _, userID := range []string{"alice", "bob"} {
+		notifications, err := memory.GetNotifications(ctx, userID)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(notifications), userID)
+		assert.Equal(t, "Test Book was stubbed (Chapters dropped from 10 to 2)", notifications[0].Message)
+		assert.Equal(t, "/fiction/42", notifications[0].Link)
+	}
+
+	// Removed pages keep the title from the earlier event
+	removed := FictionPage{FictionID: "42", Status: FetchStatus{StatusCode: http.StatusNotFound, Error: "Not Found"}}
+	require.NoError(t, s.crawler.checkFictionStatus(ctx, book, removed, now.Add(24*time.Hour)))
+	events, err = memory.GetStatusEvents(ctx, "42")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(events))
+	assert.Equal(t, FictionStatusRemoved, events[1].Status)
+	assert.Equal(t, FictionStatusStubbed, events[1].Previous)
+	assert.Equal(t, "Test Book", events[1].Title)
+
+	notifications, err := memory.GetNotifications(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(notifications))
+	assert.Equal(t, "Test Book was removed from Royal Road (Fiction page returned HTTP 404)", notifications[0].Message)
+}
+
+func TestCrawlFictionPages_RecordsRemoval(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	server := newFakeRoyalRoad(t, twoAuthorFictions)
+	link := server.URL + "/fiction/99/missing"
+
+	s.crawler.crawlFictionPages(ctx, []Book{{Title: "Missing", Link: link, FictionID: "99"}})
+
+	events, err := memory.GetStatusEvents(ctx, "99")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(events))
+	assert.Equal(t, FictionStatusRemoved, events[0].Status)
+	assert.Equal(t, http.StatusNotFound, events[0].StatusCode)
+	assert.Equal(t, "Missing", events[0].Title)
+
+	rr := httptest.NewRecorder()
+	s.crawlStatusHandler(rr, httptest.NewRequest("GET", "/api/crawl/status?failed=true", nil).WithContext(ctx))
+	var statuses []FetchStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	var found *FetchStatus
+	for i := range statuses {
+		if statuses[i].URL == link {
+			found = &statuses[i]
+		}
+	}
+	require.NotNil(t, found)
+	assert.Equal(t, http.StatusNotFound, found.StatusCode)
+	assert.NotEmpty(t, found.Error)
+}
+
+func TestFetchFictionPage_StubNotice(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>
+			<div class="fic-header"><h1>Stubbed Book</h1></div>
+			<div class="description"><p>Book one is out now! The story has been stubbed, thanks for reading.</p></div>
+		</body></html>`))
+	}))
+	t.Cleanup(server.Close)
+
+	page, err := s.crawler.fetchFictionPage(ctx, server.URL+"/fiction/5/stubbed-book")
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, page.Status.StatusCode)
+	assert.Equal(t, "Book one is out now! The story has been stubbed, thanks for reading.", page.StubNotice)
+}
+
+func TestFictionHandler_Status(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+	require.NoError(t, memory.AddStatusEvent(ctx, StatusEvent{
+		FictionID: "42", Title: "Test Book", Status: FictionStatusStubbed, Previous: FictionStatusActive,
+		Reason: "Chapters dropped from 40 to 4", CreatedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+	mux.HandleFunc("GET /api/fictions/{id}/status", s.statusAPIHandler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/fiction/42", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "This fiction was stubbed on Mar 1, 2024: Chapters dropped from 40 to 4")
+	assert.Contains(t, rr.Body.String(), "Status history")
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fictions/42/status", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var status FictionStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, FictionStatusStubbed, status.Status)
+	assert.Equal(t, 1, len(status.Events))
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/api/fictions/7/status", nil).WithContext(ctx))
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &status))
+	assert.Equal(t, FictionStatusActive, status.Status)
+	assert.Empty(t, status.Events)
+}
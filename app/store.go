@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// BookStore persists everything the bot crawls and what its users follow
+type BookStore interface {
+	// SaveBooks stores the books returned by a crawl
+	SaveBooks(ctx context.Context, books []Book) error
+	// GetBooks returns every stored book once, with the most recently saved metadata
+	GetBooks(ctx context.Context) ([]Book, error)
+	// Ping checks that the store can be reached
+	Ping(ctx context.Context) error
+
+	SnapshotStore
+	FollowStore
+	AuthorStore
+	NotificationStore
+	ReviewStore
+	ChapterStore
+	ProgressStore
+	ArchiveStore
+	StatusStore
+	DumpStore
+	MigrationStore
+	PageCacheStore
+}
+
+// SnapshotStore keeps the ranking snapshots taken on each crawl
+type SnapshotStore interface {
+	// SaveSnapshots appends the ranking snapshots taken during a crawl
+	SaveSnapshots(ctx context.Context, snapshots []Snapshot) error
+	// GetSnapshots returns every snapshot of a fiction, oldest first
+	GetSnapshots(ctx context.Context, fictionID string) ([]Snapshot, error)
+	// GetSnapshotsSince returns every snapshot taken at or after since, oldest first
+	GetSnapshotsSince(ctx context.Context, since time.Time) ([]Snapshot, error)
+}
+
+// FollowStore keeps the fictions and authors users follow
+type FollowStore interface {
+	// SaveFollow records a follow, keeping the original creation time if it already exists
+	SaveFollow(ctx context.Context, follow Follow) error
+	// DeleteFollow removes a user's follow of the given kind
+	DeleteFollow(ctx context.Context, userID, fictionID, kind string) error
+	// GetFollows returns the follows of one user
+	GetFollows(ctx context.Context, userID string) ([]Follow, error)
+	// GetAllFollows returns the follows of every user
+	GetAllFollows(ctx context.Context) ([]Follow, error)
+
+	// SaveAuthorFollow records an author follow, keeping the original creation time if it already exists
+	SaveAuthorFollow(ctx context.Context, follow AuthorFollow) error
+	// DeleteAuthorFollow removes a user's follow of an author
+	DeleteAuthorFollow(ctx context.Context, userID, authorID string) error
+	// GetAllAuthorFollows returns the author follows of every user
+	GetAllAuthorFollows(ctx context.Context) ([]AuthorFollow, error)
+}
+
+// AuthorStore keeps the author profiles found while crawling
+type AuthorStore interface {
+	// SaveAuthor inserts or replaces an author
+	SaveAuthor(ctx context.Context, author Author) error
+	// GetAuthor returns an author by ID, or nil if it is unknown
+	GetAuthor(ctx context.Context, id string) (*Author, error)
+	// GetAuthors returns every known author
+	GetAuthors(ctx context.Context) ([]Author, error)
+}
+
+// NotificationStore keeps the notifications sent to users
+type NotificationStore interface {
+	// AddNotifications stores new notifications
+	AddNotifications(ctx context.Context, notifications []Notification) error
+	// GetNotifications returns a user's notifications, newest first
+	GetNotifications(ctx context.Context, userID string) ([]Notification, error)
+}
+
+// ReviewStore keeps the reviews scraped from fiction pages
+type ReviewStore interface {
+	// SaveReviews inserts or replaces reviews by their ID
+	SaveReviews(ctx context.Context, reviews []Review) error
+	// GetReviews returns the reviews of a fiction, newest first
+	GetReviews(ctx context.Context, fictionID string) ([]Review, error)
+}
+
+// ChapterStore keeps the chapter lists scraped from fiction pages
+type ChapterStore interface {
+	// SaveChapters inserts or replaces chapters by their ID
+	SaveChapters(ctx context.Context, chapters []Chapter) error
+	// GetChapters returns the chapters of a fiction, oldest first
+	GetChapters(ctx context.Context, fictionID string) ([]Chapter, error)
+}
+
+// ProgressStore keeps how far users have read
+type ProgressStore interface {
+	// SaveProgress inserts or replaces a user's progress in a fiction
+	SaveProgress(ctx context.Context, progress ReadingProgress) error
+	// GetProgress returns a user's progress in every fiction they started
+	GetProgress(ctx context.Context, userID string) ([]ReadingProgress, error)
+}
+
+// ArchiveStore keeps the offline copies of fictions users archived. Archives are private,
+// so every lookup is by user
+type ArchiveStore interface {
+	// SaveArchivedFiction records an archive, keeping the original creation time if it already exists
+	SaveArchivedFiction(ctx context.Context, archive ArchivedFiction) error
+	// DeleteArchivedFiction removes a user's archive of a fiction and its chapters
+	DeleteArchivedFiction(ctx context.Context, userID, fictionID string) error
+	// GetArchivedFictions returns the archives of one user
+	GetArchivedFictions(ctx context.Context, userID string) ([]ArchivedFiction, error)
+	// GetAllArchivedFictions returns the archives of every user
+	GetAllArchivedFictions(ctx context.Context) ([]ArchivedFiction, error)
+
+	// SaveArchivedChapter inserts or replaces a user's copy of a chapter
+	SaveArchivedChapter(ctx context.Context, chapter ArchivedChapter) error
+	// GetArchivedChapters returns a user's copies of a fiction's chapters, oldest first
+	GetArchivedChapters(ctx context.Context, userID, fictionID string) ([]ArchivedChapter, error)
+	// GetArchivedChapter returns a user's copy of a chapter, or nil if they have none
+	GetArchivedChapter(ctx context.Context, userID, chapterID string) (*ArchivedChapter, error)
+}
+
+// StatusStore keeps the status changes detected on tracked fictions
+type StatusStore interface {
+	// AddStatusEvent records a status change
+	AddStatusEvent(ctx context.Context, event StatusEvent) error
+	// GetStatusEvents returns the status changes of a fiction, oldest first
+	GetStatusEvents(ctx context.Context, fictionID string) ([]StatusEvent, error)
+}
+
+// DumpStore reads whole collections for store dumps
+type DumpStore interface {
+	// ExportRecords calls fn with every record of a collection in dumpCollections, stopping at the first error
+	ExportRecords(ctx context.Context, collection string, fn func(record any) error) error
+}
+
+// MigrationStore tracks which schema migrations were applied
+type MigrationStore interface {
+	// SchemaVersion returns the version of the last migration applied, 0 for a store never migrated
+	SchemaVersion(ctx context.Context) (int, error)
+	// ApplyMigration runs a migration and then records its version
+	ApplyMigration(ctx context.Context, m migration) error
+	// LockSchema takes the migration lock for owner until ttl has passed, returning errSchemaLocked
+	// while another owner holds it
+	LockSchema(ctx context.Context, owner string, ttl time.Duration) error
+	// UnlockSchema releases the migration lock if owner still holds it
+	UnlockSchema(ctx context.Context, owner string) error
+}
+
+// PageCacheStore keeps the pages of the crawler's HTTP cache
+type PageCacheStore interface {
+	// GetCachedPage returns the cached copy of a URL, or nil if there is none
+	GetCachedPage(ctx context.Context, url string) (*CachedPage, error)
+	// SaveCachedPage inserts or replaces the cached copy of a URL
+	SaveCachedPage(ctx context.Context, page CachedPage) error
+}
+
+// latestBooks keeps the last saved copy of each book, in the order books were first seen
+func latestBooks(books []Book) []Book {
+	index := make(map[string]int)
+	var latest []Book
+	for _, book := range books {
+		if i, ok := index[book.Link]; ok {
+			latest[i] = book
+			continue
+		}
+		index[book.Link] = len(latest)
+		latest = append(latest, book)
+	}
+	return latest
+}
+
+// authorOfFiction finds the author whose profile lists the fiction
+func authorOfFiction(authors []Author, fictionID string) *Author {
+	for i := range authors {
+		for _, fiction := range authors[i].Fictions {
+			if fiction.FictionID == fictionID {
+				return &authors[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName  = "github.com/malchun/royalroadbot"
+	serviceName = "royalroadbot"
+	// fetchSpanKey holds the span of a page fetch in its colly request context
+	fetchSpanKey = "span"
+)
+
+// tracer is looked up on every use so tests can install their own provider
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// setupTracing installs a tracer provider sending spans to the given exporters, a comma-separated
+// list of "otlp" and "stdout". Tracing stays disabled when the list is empty. The OTLP exporter is
+// configured with the standard OTEL_EXPORTER_OTLP_* variables. The returned function flushes
+// pending spans on shutdown
+func setupTracing(ctx context.Context, exporters string, stdout io.Writer) (func(context.Context) error, error) {
+	var options []sdktrace.TracerProviderOption
+	for _, name := range strings.Split(exporters, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "none":
+		case "otlp":
+			exporter, err := otlptracehttp.New(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create OTLP exporter: %v", err)
+			}
+			options = append(options, sdktrace.WithBatcher(exporter))
+		case "stdout":
+			exporter, err := stdouttrace.New(stdouttrace.WithWriter(stdout), stdouttrace.WithPrettyPrint())
+			if err != nil {
+				return nil, fmt.Errorf("failed to create stdout exporter: %v", err)
+			}
+			options = append(options, sdktrace.WithSyncer(exporter))
+		default:
+			return nil, fmt.Errorf("invalid trace exporter %q", name)
+		}
+	}
+	if len(options) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(serviceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(append(options, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// failSpan marks a span as failed with err
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// withTracing runs each request in a span named after the route pattern that served it, continuing
+// any trace started by the caller. Like withMetrics it must sit outside the ServeMux to see the pattern
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+		if span.SpanContext().IsValid() {
+			ctx = withLogger(ctx, loggerFrom(ctx).With("trace_id", span.SpanContext().TraceID().String()))
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(recorder, r)
+
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			span.SetAttributes(semconv.HTTPRoute(r.Pattern))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// traceFetches gives every request made by c a client span under ctx
+func traceFetches(ctx context.Context, c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		_, span := tracer().Start(ctx, r.Method+" "+r.URL.Host,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLFull(r.URL.String()),
+			),
+		)
+		r.Ctx.Put(fetchSpanKey, span)
+	})
+	c.OnResponse(func(r *colly.Response) {
+		endFetchSpan(r, nil)
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		endFetchSpan(r, err)
+	})
+}
+
+func endFetchSpan(r *colly.Response, err error) {
+	span, ok := r.Ctx.GetAny(fetchSpanKey).(trace.Span)
+	if !ok {
+		return
+	}
+	if r.StatusCode != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(r.StatusCode))
+	}
+	if err != nil {
+		failSpan(span, err)
+	}
+	span.End()
+}
+
+// traceDB runs an operation on the named database in a span under ctx
+func traceDB(ctx context.Context, database, operation string, fn func(ctx context.Context) error) error {
+	ctx, span := tracer().Start(ctx, "mongodb "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNamespace(database),
+			semconv.DBOperationName(operation),
+		),
+	)
+	defer span.End()
+	err := fn(ctx)
+	if err != nil {
+		failSpan(span, err)
+	}
+	return err
+}
+
+// startTemplateSpan starts the span of one step, "parse" or "execute", of rendering the named template
+func startTemplateSpan(ctx context.Context, step, name string) trace.Span {
+	_, span := tracer().Start(ctx, "template."+step+" "+name, trace.WithAttributes(attribute.String("template.name", name)))
+	return span
+}
+
+// executeTemplate renders tmpl with data to w in a span under ctx
+func executeTemplate(ctx context.Context, w io.Writer, tmpl *template.Template, data interface{}) error {
+	span := startTemplateSpan(ctx, "execute", tmpl.Name())
+	defer span.End()
+	err := tmpl.Execute(w, data)
+	if err != nil {
+		failSpan(span, err)
+	}
+	return err
+}
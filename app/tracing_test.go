@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordSpans sends the spans started during the test to a recorder
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+	return recorder
+}
+
+// spansByName indexes the ended spans by name
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func TestSetupTracing(t *testing.T) {
+	shutdown, err := setupTracing(context.Background(), "", nil)
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = setupTracing(context.Background(), "stdout,zipkin", nil)
+	assert.Error(t, err)
+
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	var buf bytes.Buffer
+	shutdown, err = setupTracing(context.Background(), "stdout", &buf)
+	require.NoError(t, err)
+	_, span := tracer().Start(context.Background(), "test span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name": "test span"`)
+	assert.Contains(t, buf.String(), "royalroadbot")
+}
+
+func TestWithTracing(t *testing.T) {
+	recorder := recordSpans(t)
+	s, _ := testServer(t)
+	setupCachedBooksForTest(s)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.searchHandler)
+	mux.HandleFunc("GET /fiction/{id}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	handler := withTracing(mux)
+
+	req := httptest.NewRequest("GET", "/search?search=book", nil)
+	// The caller's trace is continued
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	spans := spansByName(recorder)
+	server, ok := spans["/search"]
+	require.True(t, ok, "server span is named after the route")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929b0e0e4736", server.SpanContext().TraceID().String())
+	for _, name := range []string{"template.parse book_list.html", "template.execute book_list.html"} {
+		span, ok := spans[name]
+		require.True(t, ok, name)
+		assert.Equal(t, server.SpanContext().SpanID(), span.Parent().SpanID(), name)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fiction/42", nil))
+	failed := spansByName(recorder)["GET /fiction/{id}"]
+	require.NotNil(t, failed)
+	assert.Equal(t, codes.Error, failed.Status().Code)
+}
+
+func TestTraceFetches(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	recorder := recordSpans(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	ctx, parent := tracer().Start(ctx, "crawl")
+	_, err := s.crawler.visit(s.crawler.newCollector(ctx), server.URL+"/fiction/1")
+	parent.End()
+	assert.Error(t, err)
+
+	var fetches []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "GET "+server.Listener.Addr().String() {
+			fetches = append(fetches, span)
+		}
+	}
+	require.Equal(t, 1, len(fetches))
+	assert.Equal(t, parent.SpanContext().SpanID(), fetches[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, fetches[0].Status().Code)
+	var statusCode int64
+	for _, attr := range fetches[0].Attributes() {
+		if attr.Key == "http.response.status_code" {
+			statusCode = attr.Value.AsInt64()
+		}
+	}
+	assert.Equal(t, int64(http.StatusNotFound), statusCode)
+}
+
+func TestTraceDB(t *testing.T) {
+	recorder := recordSpans(t)
+	ctx, parent := tracer().Start(context.Background(), "request")
+
+	failure := errors.New("boom")
+	assert.Equal(t, failure, traceDB(ctx, "royalRoadBooks", "GetBooks", func(context.Context) error { return failure }))
+	// An operation outside a request or crawl starts its own trace
+	assert.NoError(t, traceDB(context.Background(), "royalRoadBooks", "SaveBooks", func(context.Context) error { return nil }))
+	parent.End()
+
+	spans := spansByName(recorder)
+	require.Contains(t, spans, "mongodb GetBooks")
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["mongodb GetBooks"].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans["mongodb GetBooks"].Status().Code)
+	require.Contains(t, spans, "mongodb SaveBooks")
+	assert.False(t, spans["mongodb SaveBooks"].Parent().IsValid())
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+const (
+	// readingWordsPerMinute is a typical silent reading speed for fiction
+	readingWordsPerMinute = 250
+	// outputWindow is how far back the recent words-per-week rate looks
+	outputWindow = 28 * 24 * time.Hour
+)
+
+// WordStats sums up the length of a fiction from its measured chapters
+type WordStats struct {
+	FictionID           string  `json:"fiction_id"`
+	Chapters            int     `json:"chapters"`
+	MeasuredChapters    int     `json:"measured_chapters"`
+	TotalWords          int     `json:"total_words"`
+	AverageChapterWords int     `json:"average_chapter_words"`
+	ReadingMinutes      int     `json:"reading_minutes"`
+	ReadingTime         string  `json:"reading_time"`
+	WordsPerWeek        float64 `json:"words_per_week"`
+	RecentWordsPerWeek  float64 `json:"recent_words_per_week"`
+}
+
+// UnreadContent is what a user has left to read of a fiction they follow
+type UnreadContent struct {
+	FictionID      string `json:"fiction_id"`
+	Title          string `json:"title"`
+	UnreadChapters int    `json:"unread_chapters"`
+	UnreadWords    int    `json:"unread_words"`
+	ReadingMinutes int    `json:"reading_minutes"`
+	// Summary reads like "12 hours of unread content"
+	Summary string `json:"summary"`
+}
+
+// buildWordStats totals the words of a fiction. Chapters that aren't measured yet count
+// at the average of the measured ones, so totals are estimates until every chapter is counted
+func buildWordStats(fictionID string, chapters []Chapter, now time.Time) WordStats {
+	stats := WordStats{FictionID: fictionID, Chapters: len(chapters)}
+
+	measuredWords := 0
+	var first time.Time
+	recentWords := 0
+	for _, chapter := range chapters {
+		if !chapter.PublishedAt.IsZero() && (first.IsZero() || chapter.PublishedAt.Before(first)) {
+			first = chapter.PublishedAt
+		}
+		if chapter.WordCount == 0 {
+			continue
+		}
+		stats.MeasuredChapters++
+		measuredWords += chapter.WordCount
+		if now.Sub(chapter.PublishedAt) <= outputWindow {
+			recentWords += chapter.WordCount
+		}
+	}
+	if stats.MeasuredChapters == 0 {
+		stats.ReadingTime = formatReadingTime(0)
+		return stats
+	}
+
+	stats.AverageChapterWords = measuredWords / stats.MeasuredChapters
+	stats.TotalWords = measuredWords + (stats.Chapters-stats.MeasuredChapters)*stats.AverageChapterWords
+	stats.ReadingMinutes = readingMinutes(stats.TotalWords)
+	stats.ReadingTime = formatReadingTime(stats.ReadingMinutes)
+	stats.RecentWordsPerWeek = round2(float64(recentWords) / (outputWindow.Hours() / (7 * 24)))
+	if weeks := now.Sub(first).Hours() / (7 * 24); !first.IsZero() && weeks >= 1 {
+		stats.WordsPerWeek = round2(float64(stats.TotalWords) / weeks)
+	} else {
+		stats.WordsPerWeek = float64(stats.TotalWords)
+	}
+	return stats
+}
+
+// buildUnreadContent counts the chapters after the last one read, oldest first as stored.
+// Without progress every chapter is unread
+func buildUnreadContent(chapters []Chapter, stats WordStats, progress *ReadingProgress) UnreadContent {
+	unread := UnreadContent{FictionID: stats.FictionID}
+
+	start := 0
+	if progress != nil {
+		for i, chapter := range chapters {
+			if chapter.ID == progress.ChapterID {
+				start = i + 1
+				break
+			}
+		}
+	}
+	for _, chapter := range chapters[start:] {
+		unread.UnreadChapters++
+		if chapter.WordCount > 0 {
+			unread.UnreadWords += chapter.WordCount
+		} else {
+			unread.UnreadWords += stats.AverageChapterWords
+		}
+	}
+	unread.ReadingMinutes = readingMinutes(unread.UnreadWords)
+	unread.Summary = fmt.Sprintf("%s of unread content", formatReadingTime(unread.ReadingMinutes))
+	return unread
+}
+
+func readingMinutes(words int) int {
+	return int(math.Ceil(float64(words) / readingWordsPerMinute))
+}
+
+// formatReadingTime writes minutes as hours once there is more than an hour to read
+func formatReadingTime(minutes int) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%d %s", minutes, plural(minutes, "minute", "minutes"))
+	}
+	hours := int(math.Round(float64(minutes) / 60))
+	return fmt.Sprintf("%d %s", hours, plural(hours, "hour", "hours"))
+}
+
+// loadWordStats computes the word stats of a fiction from its stored chapters
+func loadWordStats(ctx context.Context, store BookStore, fictionID string) (WordStats, []Chapter, error) {
+	chapters, err := store.GetChapters(ctx, fictionID)
+	if err != nil {
+		return WordStats{}, nil, err
+	}
+	return buildWordStats(fictionID, chapters, time.Now().UTC()), chapters, nil
+}
+
+// loadUnreadContent computes what a user has left to read of one fiction
+func loadUnreadContent(ctx context.Context, store BookStore, userID, fictionID string) (UnreadContent, error) {
+	stats, chapters, err := loadWordStats(ctx, store, fictionID)
+	if err != nil {
+		return UnreadContent{}, err
+	}
+	progress, err := store.GetProgress(ctx, userID)
+	if err != nil {
+		return UnreadContent{}, err
+	}
+	return buildUnreadContent(chapters, stats, findProgress(progress, fictionID)), nil
+}
+
+func findProgress(progress []ReadingProgress, fictionID string) *ReadingProgress {
+	for i := range progress {
+		if progress[i].FictionID == fictionID {
+			return &progress[i]
+		}
+	}
+	return nil
+}
+
+// wordsAPIHandler returns the word count, reading time and output rate of a fiction as JSON
+func (s *server) wordsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	stats, _, err := loadWordStats(r.Context(), s.store, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load chapters: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if stats.Chapters == 0 {
+		http.Error(w, "No chapters found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// unreadHandler returns the unread content of every fiction the current user follows
+func (s *server) unreadHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := s.currentUser(r)
+	follows, err := s.store.GetFollows(r.Context(), userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load follows: %s", err), http.StatusInternalServerError)
+		return
+	}
+	books, err := s.store.GetBooks(r.Context())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load books: %s", err), http.StatusInternalServerError)
+		return
+	}
+	titles := make(map[string]string, len(books))
+	for _, book := range books {
+		titles[book.FictionID] = book.Title
+	}
+
+	unread := []UnreadContent{}
+	seen := make(map[string]bool)
+	for _, follow := range follows {
+		if seen[follow.FictionID] {
+			continue
+		}
+		seen[follow.FictionID] = true
+		content, err := loadUnreadContent(r.Context(), s.store, userID, follow.FictionID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load unread content: %s", err), http.StatusInternalServerError)
+			return
+		}
+		content.Title = titles[follow.FictionID]
+		unread = append(unread, content)
+	}
+	writeJSON(w, unread)
+}
+
+// saveProgressHandler records the last chapter the current user read in a fiction
+func (s *server) saveProgressHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Failed to parse form", http.StatusBadRequest)
+		return
+	}
+	chapterID := r.FormValue("chapter_id")
+	if chapterID == "" {
+		http.Error(w, "Missing chapter_id", http.StatusBadRequest)
+		return
+	}
+
+	fictionID := r.PathValue("id")
+	chapters, err := s.store.GetChapters(r.Context(), fictionID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load chapters: %s", err), http.StatusInternalServerError)
+		return
+	}
+	found := false
+	for _, chapter := range chapters {
+		found = found || chapter.ID == chapterID
+	}
+	if !found {
+		http.Error(w, "Chapter not found", http.StatusNotFound)
+		return
+	}
+
+	userID, _ := s.currentUser(r)
+	progress := ReadingProgress{
+		UserID:    userID,
+		FictionID: fictionID,
+		ChapterID: chapterID,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if err := s.store.SaveProgress(r.Context(), progress); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to save progress: %s", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, progress)
+}
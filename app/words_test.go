@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// measuredChapters are four weekly chapters of 3000 words, the last one not measured yet
+func measuredChapters() ([]Chapter, time.Time) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	chapters := []Chapter{
+		{ID: "1", FictionID: "42", PublishedAt: start, WordCount: 3000},
+		{ID: "2", FictionID: "42", PublishedAt: start.Add(7 * 24 * time.Hour), WordCount: 3000},
+		{ID: "3", FictionID: "42", PublishedAt: start.Add(14 * 24 * time.Hour), WordCount: 3000},
+		{ID: "4", FictionID: "42", PublishedAt: start.Add(21 * 24 * time.Hour)},
+	}
+	return chapters, start.Add(28 * 24 * time.Hour)
+}
+
+func TestBuildWordStats(t *testing.T) {
+	chapters, now := measuredChapters()
+
+	stats := buildWordStats("42", chapters, now)
+
+	assert.Equal(t, 4, stats.Chapters)
+	assert.Equal(t, 3, stats.MeasuredChapters)
+	assert.Equal(t, 3000, stats.AverageChapterWords)
+	// The unmeasured chapter is estimated at the average
+	assert.Equal(t, 12000, stats.TotalWords)
+	assert.Equal(t, 48, stats.ReadingMinutes)
+	assert.Equal(t, "48 minutes", stats.ReadingTime)
+	assert.Equal(t, 3000.0, stats.WordsPerWeek)
+	assert.Equal(t, 2250.0, stats.RecentWordsPerWeek)
+}
+
+func TestBuildWordStats_NothingMeasured(t *testing.T) {
+	stats := buildWordStats("42", []Chapter{{ID: "1", FictionID: "42"}}, time.Now())
+
+	assert.Equal(t, 1, stats.Chapters)
+	assert.Equal(t, 0, stats.TotalWords)
+	assert.Equal(t, "0 minutes", stats.ReadingTime)
+}
+
+func TestBuildUnreadContent(t *testing.T) {
+	chapters, now := measuredChapters()
+	stats := buildWordStats("42", chapters, now)
+
+	unread := buildUnreadContent(chapters, stats, nil)
+	assert.Equal(t, 4, unread.UnreadChapters)
+	assert.Equal(t, 12000, unread.UnreadWords)
+
+	unread = buildUnreadContent(chapters, stats, &ReadingProgress{ChapterID: "2"})
+	assert.Equal(t, 2, unread.UnreadChapters)
+	assert.Equal(t, 6000, unread.UnreadWords)
+	assert.Equal(t, "24 minutes of unread content", unread.Summary)
+}
+
+func TestFormatReadingTime(t *testing.T) {
+	assert.Equal(t, "1 minute", formatReadingTime(1))
+	assert.Equal(t, "59 minutes", formatReadingTime(59))
+	assert.Equal(t, "1 hour", formatReadingTime(60))
+	assert.Equal(t, "12 hours", formatReadingTime(12*60+10))
+}
+
+func wordsMux(s *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/fictions/{id}/words", s.wordsAPIHandler)
+	mux.HandleFunc("POST /api/fictions/{id}/progress", s.requireUser(s.saveProgressHandler))
+	mux.HandleFunc("GET /api/unread", s.requireUser(s.unreadHandler))
+	return mux
+}
+
+func TestWordsAPIHandler(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	chapters, _ := measuredChapters()
+	require.NoError(t, memory.SaveChapters(ctx, chapters))
+
+	rr := httptest.NewRecorder()
+	wordsMux(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/fictions/42/words", nil).WithContext(ctx))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var stats WordStats
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	assert.Equal(t, 12000, stats.TotalWords)
+
+	rr = httptest.NewRecorder()
+	wordsMux(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/fictions/99/words", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestProgressAndUnreadHandlers(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	chapters, _ := measuredChapters()
+	require.NoError(t, memory.SaveChapters(ctx, chapters))
+	require.NoError(t, memory.SaveBooks(ctx, []Book{{Title: "Test Book", Link: "/fiction/42", FictionID: "42"}}))
+	require.NoError(t, memory.SaveFollow(ctx, Follow{UserID: "alice", FictionID: "42", Kind: FollowKindFollow}))
+
+	rr := httptest.NewRecorder()
+	wordsMux(s).ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/fictions/42/progress", "alice", url.Values{"chapter_id": {"3"}}))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = httptest.NewRecorder()
+	wordsMux(s).ServeHTTP(rr, newUserRequest(ctx, "GET", "/api/unread", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var unread []UnreadContent
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &unread))
+	require.Equal(t, 1, len(unread))
+	assert.Equal(t, "Test Book", unread[0].Title)
+	assert.Equal(t, 1, unread[0].UnreadChapters)
+	assert.Equal(t, "12 minutes of unread content", unread[0].Summary)
+
+	// Progress can only point at a known chapter
+	rr = httptest.NewRecorder()
+	wordsMux(s).ServeHTTP(rr, newUserRequest(ctx, "POST", "/api/fictions/42/progress", "alice", url.Values{"chapter_id": {"99"}}))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestFictionHandler_Unread(t *testing.T) {
+	s, memory := testServer(t)
+	ctx := context.Background()
+	seedSnapshots(t, memory)
+	chapters, _ := measuredChapters()
+	require.NoError(t, memory.SaveChapters(ctx, chapters))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fiction/{id}", s.fictionHandler)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, newUserRequest(ctx, "GET", "/fiction/42", "alice", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "12000 words, about 48 minutes to read")
+	assert.Contains(t, rr.Body.String(), "48 minutes of unread content in 4 chapters")
+
+	// Anonymous visitors only see the totals
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/fiction/42", nil).WithContext(ctx))
+	assert.NotContains(t, rr.Body.String(), "unread content")
+}